@@ -0,0 +1,76 @@
+package ansibleapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// requireAdmin пропускает только запросы с верным X-Admin-Token, JWT
+// пользователя-администратора или ролью admin на все playbooks (auth.rbac).
+// Без них административные операции запрещены.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	return hasAdminToken(r) || currentPrincipal(r).Admin || (rbacEnabled() && hasRole(r, roleAdmin, ""))
+}
+
+func hasAdminToken(r *http.Request) bool {
+	token := r.Header.Get("X-Admin-Token")
+	return cfg.Server.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Server.AdminToken)) == 1
+}
+
+// requireConfirm проверяет обязательный флаг confirm=true для разрушающих операций
+func requireConfirm(w http.ResponseWriter, r *http.Request) bool {
+	confirm, _ := strconv.ParseBool(r.URL.Query().Get("confirm"))
+	if !confirm {
+		http.Error(w, "confirm=true is required", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func deleteLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireConfirm(w, r) {
+		return
+	}
+
+	// Логи - представление над запусками, поэтому удаляются сами запуски
+	deleted, err := storeOf(r).DeleteLogs(parseLogFilter(r.URL.Query()))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+	})
+}
+
+func deleteRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireConfirm(w, r) {
+		return
+	}
+
+	// Активные запуски не удаляются, даже если попадают под фильтр
+	deleted, err := storeOf(r).DeleteRuns(parseRunFilter(r.URL.Query()))
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+	})
+}
@@ -0,0 +1,481 @@
+package ansibleapi
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ansible-api/config"
+)
+
+const (
+	// Как часто агент отправляет накопленный вывод; отправка служит и heartbeat'ом
+	agentReportInterval = 2 * time.Second
+	// Наибольшая порция вывода в одном запросе
+	agentMaxChunk = 512 << 10
+	// Сколько вывода держать, пока сервер недоступен; более старый отбрасывается
+	agentMaxPending = 16 << 20
+	// Сколько раз пытаться отправить итог запуска
+	agentFinishAttempts = 10
+)
+
+// RunAgent запускает процесс в режиме агента: он регистрируется на сервере из
+// agent.server_url, забирает направленные ему запуски и выполняет их своим
+// ansible, передавая вывод по мере выполнения. БД агенту не нужна.
+// Когда ctx завершается, агент перестает забирать запуски и ждет текущий
+// запуск до server.shutdown_grace, затем отменяет его; итог запуска
+// отправляется на сервер до возврата. Возвращает ошибку, только если агент
+// не настроен.
+func RunAgent(ctx context.Context, c *config.Config) error {
+	if !created.CompareAndSwap(false, true) {
+		return errors.New("ansible-api server or agent already created in this process")
+	}
+	cfg = c
+
+	if cfg.Agent.ServerURL == "" || cfg.Agent.Token == "" {
+		return errors.New("agent.server_url and agent.token are required in agent mode")
+	}
+	if cfg.Agent.Name == "" {
+		cfg.Agent.Name = cfg.Server.NodeID
+	}
+	if !agentNameRe.MatchString(cfg.Agent.Name) {
+		return fmt.Errorf("invalid agent name %q", cfg.Agent.Name)
+	}
+	if err := loadAnsibleInstallations(); err != nil {
+		return err
+	}
+	if err := validatePythonInterpreter(cfg.Ansible.DefaultPython); err != nil {
+		return fmt.Errorf("ansible.default_python: %v", err)
+	}
+
+	baseURL := strings.TrimSuffix(cfg.Agent.ServerURL, "/")
+	a := &agentClient{name: cfg.Agent.Name}
+	switch cfg.Agent.Transport {
+	case "", "http":
+		a.transport = &agentHTTP{baseURL: baseURL, name: a.name, http: &http.Client{Timeout: time.Minute}}
+	case "grpc":
+		a.transport = newAgentGRPC(baseURL, a.name)
+	default:
+		return fmt.Errorf("unknown agent.transport %q", cfg.Agent.Transport)
+	}
+
+	hostname, _ := os.Hostname()
+	for {
+		err := a.transport.register(Agent{
+			Name:           a.name,
+			Hostname:       hostname,
+			AnsibleVersion: defaultAnsible.Version,
+			Labels:         cfg.Agent.Labels,
+		})
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to register agent %s at %s: %v", a.name, baseURL, err)
+		if !sleepCtx(ctx, cfg.Agent.PollInterval) {
+			return nil
+		}
+	}
+	log.Printf("Agent %s registered at %s", a.name, baseURL)
+
+	for ctx.Err() == nil {
+		job, err := a.transport.claim()
+		if err != nil {
+			log.Printf("Failed to claim run: %v", err)
+		}
+		if job == nil {
+			sleepCtx(ctx, cfg.Agent.PollInterval)
+			continue
+		}
+		a.executeUntil(ctx, *job)
+	}
+	log.Printf("Agent %s stopped", a.name)
+	return nil
+}
+
+// executeUntil выполняет запуск; если ctx завершается раньше, ждет запуск до
+// server.shutdown_grace и затем отменяет его. Возвращает после отправки итога.
+func (a *agentClient) executeUntil(ctx context.Context, job AgentJob) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.execute(job)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	log.Printf("Stopping agent, waiting up to %s for run %d", cfg.Server.ShutdownGrace, job.RunID)
+	grace := time.NewTimer(cfg.Server.ShutdownGrace)
+	defer grace.Stop()
+	select {
+	case <-done:
+		return
+	case <-grace.C:
+	}
+	log.Printf("Shutdown grace period expired, cancelling run %d", job.RunID)
+	// Отмена завершает группу процессов ansible-playbook. Пока playbooks
+	// скачиваются, отменять еще нечего, поэтому отмена повторяется.
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		cancelActiveRuns(errServerShutdown)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sleepCtx ждет d; false - раньше завершился ctx
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// agentClient выполняет запуски, полученные от сервера через transport
+type agentClient struct {
+	name      string
+	transport agentTransport
+}
+
+// agentTransport - обращения агента к серверу: HTTP API или gRPC (agent.transport)
+type agentTransport interface {
+	register(agent Agent) error
+	// claim забирает следующий запуск; nil - запусков нет
+	claim() (*AgentJob, error)
+	// output отправляет порцию вывода запуска; true - запрошена его отмена
+	output(job AgentJob, chunk []byte) (bool, error)
+	finish(job AgentJob, result agentResult) error
+	// bundle возвращает каталог playbooks арендатора запуска в tar.gz
+	bundle(job AgentJob) (io.ReadCloser, error)
+}
+
+// agentHTTP - обращения агента по HTTP API /api/agents/...
+type agentHTTP struct {
+	baseURL string
+	name    string
+	http    *http.Client
+}
+
+func (a *agentHTTP) do(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Agent-Token", cfg.Agent.Token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func (a *agentHTTP) postJSON(path string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(http.MethodPost, path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (a *agentHTTP) agentPath() string {
+	return "/api/agents/" + url.PathEscape(a.name)
+}
+
+func (a *agentHTTP) runPath(job AgentJob) string {
+	return fmt.Sprintf("%s/runs/%s/%d", a.agentPath(), url.PathEscape(job.Tenant), job.RunID)
+}
+
+func (a *agentHTTP) register(agent Agent) error {
+	return a.postJSON("/api/agents/register", agent, nil)
+}
+
+func (a *agentHTTP) claim() (*AgentJob, error) {
+	resp, err := a.do(http.MethodPost, a.agentPath()+"/claim", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var job AgentJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (a *agentHTTP) output(job AgentJob, chunk []byte) (bool, error) {
+	resp, err := a.do(http.MethodPost, a.runPath(job)+"/output", "text/plain", bytes.NewReader(chunk))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Cancel bool `json:"cancel"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	return response.Cancel, err
+}
+
+func (a *agentHTTP) finish(job AgentJob, result agentResult) error {
+	return a.postJSON(a.runPath(job)+"/finish", result, nil)
+}
+
+func (a *agentHTTP) bundle(job AgentJob) (io.ReadCloser, error) {
+	resp, err := a.do(http.MethodGet, a.runPath(job)+"/bundle", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// execute выполняет запуск и отправляет его итог. Если сервер так и не принял
+// итог, запуск будет помечен lost по устаревшему heartbeat.
+func (a *agentClient) execute(job AgentJob) {
+	log.Printf("Executing run %d of tenant %s (%s)", job.RunID, job.Tenant, job.Playbook)
+	result := a.run(job)
+
+	for attempt := 1; ; attempt++ {
+		err := a.transport.finish(job, result)
+		if err == nil {
+			log.Printf("Run %d %s", job.RunID, result.Status)
+			return
+		}
+		if attempt == agentFinishAttempts {
+			log.Printf("Giving up reporting result of run %d: %v", job.RunID, err)
+			return
+		}
+		log.Printf("Failed to report result of run %d: %v", job.RunID, err)
+		time.Sleep(time.Duration(attempt) * agentReportInterval)
+	}
+}
+
+func (a *agentClient) run(job AgentJob) agentResult {
+	dir, err := os.MkdirTemp(cfg.Disk.TempDir, "agent-run-*")
+	if err != nil {
+		return agentResult{Status: RunStatusFailed, Error: err.Error()}
+	}
+	defer os.RemoveAll(dir)
+
+	if err := a.downloadPlaybooks(job, dir); err != nil {
+		return agentResult{Status: RunStatusFailed, Error: fmt.Sprintf("failed to download playbooks: %v", err)}
+	}
+	req := PlaybookRequest{Playbook: job.Playbook}
+	installation, err := selectAnsibleInstallation(req)
+	if err != nil {
+		return agentResult{Status: RunStatusFailed, Error: err.Error()}
+	}
+
+	t := &tenant{Name: job.Tenant, PlaybooksDir: dir}
+	ctx, cancel := newRunContext(t, job.RunID)
+	defer cancel()
+
+	reporter := newAgentReporter(a, t, job)
+	var usage *runUsage
+	output, err := runAnsiblePlaybook(ctx, ansibleInvocation{
+		Tenant:           t,
+		Installation:     installation,
+		PlaybookPath:     filepath.Join(dir, job.Playbook),
+		InventoryContent: job.Inventory,
+		ExtraVars:        job.ExtraVars,
+		Localhost:        job.Localhost,
+		Hosts:            job.Hosts,
+		HostVars:         job.HostVars,
+		Limit:            job.Limit,
+		ExtraArgs:        job.ExtraArgs,
+		Output:           reporter,
+		OnExit:           func(u runUsage) { usage = &u },
+	})
+	reporter.Close()
+
+	result := agentResult{Output: output, Usage: usage}
+	result.Status, err = runStatus(ctx, err)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// downloadPlaybooks распаковывает каталог playbooks арендатора запуска в dir
+func (a *agentClient) downloadPlaybooks(job AgentJob, dir string) error {
+	bundle, err := a.transport.bundle(job)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+
+	gz, err := gzip.NewReader(bundle)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path %q in playbooks bundle", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeBundleFile(target, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeBundleFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// agentReporter копит вывод ansible и периодически отправляет его на сервер.
+// Пустая отправка подтверждает, что запуск жив; в ответе сервер сообщает об отмене.
+type agentReporter struct {
+	client *agentClient
+	tenant *tenant
+	job    AgentJob
+
+	mu      sync.Mutex
+	pending []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAgentReporter(a *agentClient, t *tenant, job AgentJob) *agentReporter {
+	rep := &agentReporter{
+		client: a,
+		tenant: t,
+		job:    job,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go rep.reportPeriodically()
+	return rep
+}
+
+// Write не возвращает ошибку: недоступность сервера не должна ломать запуск
+func (rep *agentReporter) Write(p []byte) (int, error) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.pending = append(rep.pending, p...)
+	if over := len(rep.pending) - agentMaxPending; over > 0 {
+		rep.pending = append(rep.pending[:0], rep.pending[over:]...)
+	}
+	return len(p), nil
+}
+
+func (rep *agentReporter) reportPeriodically() {
+	defer close(rep.done)
+	ticker := time.NewTicker(agentReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rep.stop:
+			return
+		case <-ticker.C:
+			rep.flush()
+		}
+	}
+}
+
+// flush отправляет накопленный вывод порциями не больше agentMaxChunk
+func (rep *agentReporter) flush() {
+	for {
+		rep.mu.Lock()
+		chunk := rep.pending
+		if len(chunk) > agentMaxChunk {
+			chunk = chunk[:agentMaxChunk]
+		}
+		chunk = append([]byte(nil), chunk...)
+		rep.mu.Unlock()
+
+		cancelRequested, err := rep.client.transport.output(rep.job, chunk)
+		if err != nil {
+			log.Printf("Failed to send output of run %d: %v", rep.job.RunID, err)
+			return
+		}
+		if cancelRequested {
+			cancelActiveRun(rep.tenant, rep.job.RunID)
+		}
+
+		rep.mu.Lock()
+		// Пока шла отправка, Write мог отбросить начало буфера
+		rep.pending = rep.pending[min(len(chunk), len(rep.pending)):]
+		more := len(rep.pending) > 0
+		rep.mu.Unlock()
+		if !more {
+			return
+		}
+	}
+}
+
+// Close останавливает периодическую отправку и отправляет остаток вывода
+func (rep *agentReporter) Close() {
+	close(rep.stop)
+	<-rep.done
+	rep.flush()
+}
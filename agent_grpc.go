@@ -0,0 +1,376 @@
+package ansibleapi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Сервис агентов по gRPC (agent.transport: grpc) выполняет те же операции,
+// что и /api/agents/..., описание - proto/agent.proto. Токен агента
+// передается в метаданных x-agent-token.
+const agentServiceName = "ansibleapi.agent.v1.AgentService"
+
+// Наибольшая порция playbooks в одном сообщении DownloadPlaybooks
+const agentBundleChunk = 64 << 10
+
+// withAgentGRPC направляет вызовы gRPC сервиса агентов мимо маршрутов API:
+// у агентов своя проверка токена, как у /api/agents/...
+func withAgentGRPC(next http.Handler) http.Handler {
+	prefix := "/" + agentServiceName + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		stream := &grpcServerStream{w: w}
+		stream.finish(serveAgentGRPC(stream, r, method))
+	})
+}
+
+func serveAgentGRPC(stream *grpcServerStream, r *http.Request, method string) error {
+	if r.Method != http.MethodPost {
+		return &grpcError{grpcUnimplemented, "gRPC requires POST"}
+	}
+	if !validAgentToken(r.Header.Get("X-Agent-Token")) {
+		return &grpcError{grpcPermissionDenied, "Agent token required"}
+	}
+	r.Body = http.MaxBytesReader(stream.w, r.Body, cfg.Server.MaxUploadBytes)
+	msg, err := readGRPCMessage(r.Body)
+	if err == io.EOF {
+		return &grpcError{grpcInvalidArgument, "request message required"}
+	}
+	if err != nil {
+		return err
+	}
+	fields, err := parseProtoRequest(msg)
+	if err != nil {
+		return err
+	}
+
+	switch method {
+	case "Register":
+		agent, err := decodeAgent(fields)
+		if err != nil {
+			return err
+		}
+		if err := registerAgent(&agent, clientIP(r)); err != nil {
+			return err
+		}
+		return stream.send(nil)
+
+	case "Claim":
+		var name string
+		for _, field := range fields {
+			if field.num == 1 {
+				name = field.string()
+			}
+		}
+		job, err := claimAgentRun(name)
+		if err != nil {
+			return err
+		}
+		var response []byte
+		if job != nil {
+			response = appendProtoMessage(nil, 1, encodeAgentJob(*job))
+		}
+		return stream.send(response)
+
+	case "ReportOutput":
+		var output []byte
+		for _, field := range fields {
+			if field.num == 4 {
+				output = field.bytes
+			}
+		}
+		run, err := findAgentRun(decodeAgentRunRef(fields))
+		if err != nil {
+			return err
+		}
+		cancelRequested, err := reportAgentOutput(run, bytes.NewReader(output))
+		if err != nil {
+			return err
+		}
+		return stream.send(appendProtoBool(nil, 1, cancelRequested))
+
+	case "Finish":
+		result, err := decodeAgentResult(fields)
+		if err != nil {
+			return err
+		}
+		if err := result.validate(); err != nil {
+			return err
+		}
+		run, err := findAgentRun(decodeAgentRunRef(fields))
+		if err != nil {
+			return err
+		}
+		finishAgentRun(run, result)
+		return stream.send(nil)
+
+	case "DownloadPlaybooks":
+		run, err := findAgentRun(decodeAgentRunRef(fields))
+		if err != nil {
+			return err
+		}
+		chunks := bufio.NewWriterSize(bundleChunkWriter{stream}, agentBundleChunk)
+		if err := writePlaybooksBundle(chunks, run.job.Tenant.PlaybooksDir); err != nil {
+			return fmt.Errorf("failed to send playbooks: %v", err)
+		}
+		return chunks.Flush()
+	}
+	return &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %s", method)}
+}
+
+// bundleChunkWriter отправляет каждую запись сообщением BundleChunk
+type bundleChunkWriter struct {
+	stream *grpcServerStream
+}
+
+func (w bundleChunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.send(appendProtoBytes(nil, 1, p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decodeAgentRunRef разбирает общие поля запросов по запуску: агент, арендатор, id
+func decodeAgentRunRef(fields []protoField) (agent, tenantName string, runID uint) {
+	for _, field := range fields {
+		switch field.num {
+		case 1:
+			agent = field.string()
+		case 2:
+			tenantName = field.string()
+		case 3:
+			runID = uint(field.value)
+		}
+	}
+	return agent, tenantName, runID
+}
+
+func encodeAgentRunRef(name string, job AgentJob) []byte {
+	b := appendProtoString(nil, 1, name)
+	b = appendProtoString(b, 2, job.Tenant)
+	return appendProtoUint(b, 3, uint64(job.RunID))
+}
+
+func encodeAgent(agent Agent) []byte {
+	b := appendProtoString(nil, 1, agent.Name)
+	b = appendProtoString(b, 2, agent.Hostname)
+	b = appendProtoString(b, 3, agent.AnsibleVersion)
+	return appendProtoMap(b, 4, agent.Labels)
+}
+
+func decodeAgent(fields []protoField) (Agent, error) {
+	var agent Agent
+	for _, field := range fields {
+		switch field.num {
+		case 1:
+			agent.Name = field.string()
+		case 2:
+			agent.Hostname = field.string()
+		case 3:
+			agent.AnsibleVersion = field.string()
+		case 4:
+			key, value, err := field.mapEntry()
+			if err != nil {
+				return Agent{}, &grpcError{grpcInvalidArgument, fmt.Sprintf("invalid labels: %v", err)}
+			}
+			if agent.Labels == nil {
+				agent.Labels = make(JSONMap)
+			}
+			agent.Labels[key] = value
+		}
+	}
+	return agent, nil
+}
+
+func encodeAgentJob(job AgentJob) []byte {
+	b := appendProtoString(nil, 1, job.Tenant)
+	b = appendProtoUint(b, 2, uint64(job.RunID))
+	b = appendProtoString(b, 3, job.Playbook)
+	b = appendProtoString(b, 4, job.Inventory)
+	b = appendProtoBool(b, 5, job.Localhost)
+	b = appendProtoStrings(b, 6, job.Hosts)
+	b = appendProtoMap(b, 7, job.HostVars)
+	b = appendProtoString(b, 8, job.Limit)
+	b = appendProtoMap(b, 9, job.ExtraVars)
+	return appendProtoStrings(b, 10, job.ExtraArgs)
+}
+
+func decodeAgentJob(b []byte) (AgentJob, error) {
+	fields, err := parseProto(b)
+	if err != nil {
+		return AgentJob{}, err
+	}
+	var job AgentJob
+	addEntry := func(m *map[string]string, field protoField) error {
+		key, value, err := field.mapEntry()
+		if err != nil {
+			return err
+		}
+		if *m == nil {
+			*m = make(map[string]string)
+		}
+		(*m)[key] = value
+		return nil
+	}
+	for _, field := range fields {
+		switch field.num {
+		case 1:
+			job.Tenant = field.string()
+		case 2:
+			job.RunID = uint(field.value)
+		case 3:
+			job.Playbook = field.string()
+		case 4:
+			job.Inventory = field.string()
+		case 5:
+			job.Localhost = field.value != 0
+		case 6:
+			job.Hosts = append(job.Hosts, field.string())
+		case 7:
+			err = addEntry(&job.HostVars, field)
+		case 8:
+			job.Limit = field.string()
+		case 9:
+			err = addEntry(&job.ExtraVars, field)
+		case 10:
+			job.ExtraArgs = append(job.ExtraArgs, field.string())
+		}
+		if err != nil {
+			return AgentJob{}, err
+		}
+	}
+	return job, nil
+}
+
+func encodeAgentResult(b []byte, result agentResult) []byte {
+	b = appendProtoString(b, 4, string(result.Status))
+	b = appendProtoString(b, 5, result.Error)
+	b = appendProtoString(b, 6, result.Output)
+	if result.Usage != nil {
+		usage := appendProtoDouble(nil, 1, result.Usage.CPUSeconds)
+		usage = appendProtoUint(usage, 2, uint64(result.Usage.MaxRSSKB))
+		b = appendProtoMessage(b, 7, usage)
+	}
+	return b
+}
+
+func decodeAgentResult(fields []protoField) (agentResult, error) {
+	var result agentResult
+	for _, field := range fields {
+		switch field.num {
+		case 4:
+			result.Status = PlaybookRunStatus(field.string())
+		case 5:
+			result.Error = field.string()
+		case 6:
+			result.Output = field.string()
+		case 7:
+			usageFields, err := parseProto(field.bytes)
+			if err != nil {
+				return agentResult{}, &grpcError{grpcInvalidArgument, fmt.Sprintf("invalid usage: %v", err)}
+			}
+			result.Usage = &runUsage{}
+			for _, usage := range usageFields {
+				switch usage.num {
+				case 1:
+					result.Usage.CPUSeconds = usage.double()
+				case 2:
+					result.Usage.MaxRSSKB = int64(usage.value)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// agentGRPC - обращения агента к серверу по gRPC
+type agentGRPC struct {
+	name   string
+	client *grpcClient
+}
+
+func newAgentGRPC(baseURL, name string) *agentGRPC {
+	header := http.Header{"X-Agent-Token": {cfg.Agent.Token}}
+	return &agentGRPC{
+		name:   name,
+		client: newGRPCClient(baseURL, agentServiceName, header, time.Minute),
+	}
+}
+
+func (a *agentGRPC) register(agent Agent) error {
+	return a.client.call("Register", encodeAgent(agent), func([]byte) error { return nil })
+}
+
+func (a *agentGRPC) claim() (*AgentJob, error) {
+	var job *AgentJob
+	err := a.client.call("Claim", appendProtoString(nil, 1, a.name), func(msg []byte) error {
+		fields, err := parseProto(msg)
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			if field.num != 1 {
+				continue
+			}
+			decoded, err := decodeAgentJob(field.bytes)
+			if err != nil {
+				return fmt.Errorf("invalid job: %v", err)
+			}
+			job = &decoded
+		}
+		return nil
+	})
+	return job, err
+}
+
+func (a *agentGRPC) output(job AgentJob, chunk []byte) (bool, error) {
+	request := appendProtoBytes(encodeAgentRunRef(a.name, job), 4, chunk)
+	var cancelRequested bool
+	err := a.client.call("ReportOutput", request, func(msg []byte) error {
+		fields, err := parseProto(msg)
+		for _, field := range fields {
+			if field.num == 1 {
+				cancelRequested = field.value != 0
+			}
+		}
+		return err
+	})
+	return cancelRequested, err
+}
+
+func (a *agentGRPC) finish(job AgentJob, result agentResult) error {
+	request := encodeAgentResult(encodeAgentRunRef(a.name, job), result)
+	return a.client.call("Finish", request, func([]byte) error { return nil })
+}
+
+// bundle читает поток BundleChunk по мере распаковки
+func (a *agentGRPC) bundle(job AgentJob) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		err := a.client.call("DownloadPlaybooks", encodeAgentRunRef(a.name, job), func(msg []byte) error {
+			fields, err := parseProto(msg)
+			if err != nil {
+				return err
+			}
+			for _, field := range fields {
+				if field.num == 1 {
+					if _, err := writer.Write(field.bytes); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		writer.CloseWithError(err)
+	}()
+	return reader, nil
+}
@@ -0,0 +1,88 @@
+package ansibleapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"ansible-api/config"
+)
+
+func TestAgentJobProtoRoundTrip(t *testing.T) {
+	job := AgentJob{
+		Tenant:    "default",
+		RunID:     42,
+		Playbook:  "site.yml",
+		Inventory: "[web]\nweb1\n",
+		Localhost: true,
+		Hosts:     []string{"web1", ""},
+		HostVars:  map[string]string{"ansible_user": "deploy"},
+		Limit:     "web",
+		ExtraVars: map[string]string{"version": "1.2", "empty": ""},
+		ExtraArgs: []string{"--diff"},
+	}
+	got, err := decodeAgentJob(encodeAgentJob(job))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, job) {
+		t.Errorf("got %+v, want %+v", got, job)
+	}
+}
+
+func TestAgentResultProtoRoundTrip(t *testing.T) {
+	result := agentResult{Status: RunStatusFailed, Error: "exit status 2", Output: "PLAY RECAP", Usage: &runUsage{CPUSeconds: 1.5, MaxRSSKB: 2048}}
+	fields, err := parseProto(encodeAgentResult(encodeAgentRunRef("dmz-1", AgentJob{Tenant: "default", RunID: 7}), result))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeAgentResult(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, result) {
+		t.Errorf("got %+v, want %+v", got, result)
+	}
+	if agent, tenantName, runID := decodeAgentRunRef(fields); agent != "dmz-1" || tenantName != "default" || runID != 7 {
+		t.Errorf("run ref %s/%s/%d", agent, tenantName, runID)
+	}
+}
+
+// Вызовы по HTTP/2 без TLS до обращения к БД: отказ по токену и неизвестный метод
+func TestAgentGRPCStatus(t *testing.T) {
+	prevCfg := cfg
+	t.Cleanup(func() { cfg = prevCfg })
+	cfg = &config.Config{}
+	cfg.Agents.Token = "agent-secret"
+	cfg.Server.MaxUploadBytes = 1 << 20
+
+	srv := httptest.NewUnstartedServer(withAgentGRPC(http.NotFoundHandler()))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		code   int
+	}{
+		{"wrong token", "other", "Claim", grpcPermissionDenied},
+		{"unknown method", "agent-secret", "Watch", grpcUnimplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newGRPCClient(srv.URL, agentServiceName, http.Header{"X-Agent-Token": {tt.token}}, 5*time.Second)
+			err := client.call(tt.method, appendProtoString(nil, 1, "dmz-1"), func([]byte) error { return nil })
+			var grpcErr *grpcError
+			if !errors.As(err, &grpcErr) || grpcErr.code != tt.code {
+				t.Fatalf("got %v, want code %d", err, tt.code)
+			}
+		})
+	}
+}
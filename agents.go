@@ -0,0 +1,551 @@
+package ansibleapi
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Agent - агент (ansible-api --agent), выполняющий запуски в изолированной сети.
+// Агенты общие для всех арендаторов и хранятся в схеме арендатора по умолчанию.
+type Agent struct {
+	Name     string `gorm:"type:text;primaryKey" json:"name"`
+	Hostname string `gorm:"type:text" json:"hostname,omitempty"`
+	// Версия ansible-core установки агента по умолчанию
+	AnsibleVersion string    `gorm:"type:text" json:"ansible_version,omitempty"`
+	Labels         JSONMap   `gorm:"type:jsonb" json:"labels,omitempty"`
+	Addr           string    `gorm:"type:text" json:"addr,omitempty"`
+	RegisteredAt   time.Time `gorm:"type:timestamptz;not null" json:"registered_at"`
+	LastSeenAt     time.Time `gorm:"type:timestamptz;not null" json:"last_seen_at"`
+	Online         bool      `gorm:"-" json:"online"`
+}
+
+// AgentJob - запуск, выданный агенту: все, что нужно для выполнения без доступа к БД.
+// Значения sensitive_vars передаются раскрытыми, маскирует вывод сервер.
+type AgentJob struct {
+	Tenant    string            `json:"tenant"`
+	RunID     uint              `json:"run_id"`
+	Playbook  string            `json:"playbook"`
+	Inventory string            `json:"inventory,omitempty"`
+	Localhost bool              `json:"localhost,omitempty"`
+	Hosts     []string          `json:"hosts,omitempty"`
+	HostVars  map[string]string `json:"host_vars,omitempty"`
+	Limit     string            `json:"limit,omitempty"`
+	ExtraVars map[string]string `json:"extra_vars,omitempty"`
+	ExtraArgs []string          `json:"extra_args,omitempty"`
+}
+
+// agentResult - итог запуска, присланный агентом
+type agentResult struct {
+	Status PlaybookRunStatus `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Output string            `json:"output"`
+	// Ресурсы процесса ansible-playbook на агенте; нет, если он не запускался
+	Usage *runUsage `json:"usage,omitempty"`
+}
+
+var agentNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// agentNodeID - node_id запусков агента; не совпадает с узлами сервера, поэтому
+// восстановление после перезапуска сервера их не трогает
+func agentNodeID(name string) string {
+	return "agent:" + name
+}
+
+// agentRun - выполняющийся на агенте запуск: вывод, который присылает агент,
+// записывается так же, как вывод локального запуска
+type agentRun struct {
+	job      runJob
+	agent    string
+	masker   *secretMasker
+	recorder *runRecorder
+}
+
+var agentRuns = struct {
+	sync.Mutex
+	runs map[runKey]*agentRun
+}{runs: make(map[runKey]*agentRun)}
+
+// selectAgent проверяет агента, указанного в запросе. nil - запрос не для агента.
+func selectAgent(req PlaybookRequest) (*Agent, error) {
+	if req.Agent == "" {
+		return nil, nil
+	}
+	if req.Runner != "" || req.Ansible != "" {
+		return nil, fmt.Errorf("agent cannot be combined with runner or ansible")
+	}
+	var agent Agent
+	if err := defaultTenant().db().Where("name = ?", req.Agent).First(&agent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("unknown agent %q", req.Agent)
+		}
+		return nil, err
+	}
+	return &agent, nil
+}
+
+// agentError - отказ в запросе агента со статусом HTTP; по gRPC он
+// передается соответствующим кодом
+type agentError struct {
+	status  int
+	message string
+}
+
+func (e *agentError) Error() string {
+	return e.message
+}
+
+// writeAgentError отвечает агенту по HTTP статусом agentError или ошибки БД
+func writeAgentError(w http.ResponseWriter, err error) {
+	var agentErr *agentError
+	if errors.As(err, &agentErr) {
+		http.Error(w, agentErr.message, agentErr.status)
+		return
+	}
+	writeDBError(w, err)
+}
+
+// validAgentToken проверяет токен агентов из agents.token
+func validAgentToken(token string) bool {
+	return cfg.Agents.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Agents.Token)) == 1
+}
+
+// requireAgent пропускает запросы агентов с верным X-Agent-Token
+func requireAgent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validAgentToken(r.Header.Get("X-Agent-Token")) {
+			http.Error(w, "Agent token required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func registerAgentHandler(w http.ResponseWriter, r *http.Request) {
+	var agent Agent
+	if !decodeJSONBody(w, r, &agent) {
+		return
+	}
+	if err := registerAgent(&agent, clientIP(r)); err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
+// registerAgent сохраняет агента, обратившегося с адреса addr
+func registerAgent(agent *Agent, addr string) error {
+	if !agentNameRe.MatchString(agent.Name) {
+		return &agentError{http.StatusBadRequest, "Invalid agent name"}
+	}
+	now := time.Now()
+	agent.Addr = addr
+	agent.RegisteredAt = now
+	agent.LastSeenAt = now
+
+	err := defaultTenant().db().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hostname", "ansible_version", "labels", "addr", "last_seen_at"}),
+	}).Create(agent).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("Agent %s registered from %s (ansible %s)", agent.Name, agent.Addr, agent.AnsibleVersion)
+	return nil
+}
+
+func listAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	agents := []Agent{}
+	if err := defaultTenant().db().Order("name").Find(&agents).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	onlineSince := time.Now().Add(-cfg.Agents.OfflineAfter)
+	for i := range agents {
+		agents[i].Online = agents[i].LastSeenAt.After(onlineSince)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agents)
+}
+
+// deleteAgentHandler удаляет агента. Запуски, уже поставленные для него,
+// остаются в очереди до повторной регистрации или отмены.
+func deleteAgentHandler(w http.ResponseWriter, r *http.Request) {
+	result := defaultTenant().db().Where("name = ?", mux.Vars(r)["name"]).Delete(&Agent{})
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// touchAgent отмечает обращение агента; false - агент не зарегистрирован
+func touchAgent(name string) (bool, error) {
+	result := defaultTenant().db().Model(&Agent{}).Where("name = ?", name).Update("last_seen_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// claimAgentRunHandler выдает агенту следующий направленный ему запуск; 204 - запусков нет
+func claimAgentRunHandler(w http.ResponseWriter, r *http.Request) {
+	agentJob, err := claimAgentRun(mux.Vars(r)["name"])
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+	if agentJob == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentJob)
+}
+
+// claimAgentRun забирает следующий запуск агента name; nil - запусков нет
+func claimAgentRun(name string) (*AgentJob, error) {
+	registered, err := touchAgent(name)
+	if err != nil {
+		return nil, err
+	}
+	if !registered {
+		return nil, &agentError{http.StatusNotFound, "Agent is not registered"}
+	}
+
+	for {
+		job, err := claimNextRun(name)
+		if err != nil {
+			log.Printf("Failed to claim run for agent %s: %v", name, err)
+			return nil, err
+		}
+		if job == nil {
+			return nil, nil
+		}
+
+		agentJob, err := startAgentRun(*job, name)
+		if err != nil {
+			// Запуск, который нельзя выдать, завершается сразу; агент получит следующий
+			finishRun(*job, RunStatusFailed, err.Error(), "")
+			persist(fmt.Sprintf("complete queue entry of run %d", job.RunID), func() error {
+				return completeQueueEntry(job.Tenant, job.RunID)
+			})
+			continue
+		}
+		log.Printf("Run %d of tenant %s handed to agent %s", job.RunID, job.Tenant.Name, name)
+		return &agentJob, nil
+	}
+}
+
+// startAgentRun готовит запуск к выдаче агенту: pre-хуки, секреты, инвентарь
+func startAgentRun(job runJob, agent string) (AgentJob, error) {
+	if err := preflightAgentRun(job); err != nil {
+		return AgentJob{}, err
+	}
+	agentJob := AgentJob{
+		Tenant:    job.Tenant.Name,
+		RunID:     job.RunID,
+		Playbook:  job.Request.Playbook,
+		Localhost: job.Request.Localhost,
+		Hosts:     job.Request.Hosts,
+		HostVars:  job.Request.HostVars,
+		Limit:     job.Request.Limit,
+		ExtraArgs: job.Request.ExtraArgs,
+	}
+	if len(job.Request.HostTags) > 0 {
+		// Агент не читает инвентари из БД: передаем собранный инвентарь вместо хостов
+		content, err := taggedInventory(job.Tenant, job.Request.Hosts)
+		if err != nil {
+			return AgentJob{}, fmt.Errorf("failed to build inventory of tagged hosts: %v", err)
+		}
+		agentJob.Inventory = content
+		agentJob.Hosts = nil
+	}
+	if job.Request.Inventory != "" {
+		content, err := getInventoryContent(job.Tenant, job.Request.Inventory)
+		if err != nil {
+			return AgentJob{}, fmt.Errorf("failed to get inventory: %v", err)
+		}
+		if content, err = renderInventory(content, job.Request.InventoryParams); err != nil {
+			return AgentJob{}, fmt.Errorf("failed to render inventory: %v", err)
+		}
+		agentJob.Inventory = content
+	}
+	quarantined, err := excludeQuarantinedHosts(job.Tenant, &agentJob)
+	if err != nil {
+		return AgentJob{}, err
+	}
+
+	recordEstimate(job)
+	if err := revealJobVars(&job); err != nil {
+		return AgentJob{}, err
+	}
+	if err := issueArtifactToken(&job); err != nil {
+		return AgentJob{}, err
+	}
+	run, err := newAgentRun(job, agent)
+	if err != nil {
+		return AgentJob{}, err
+	}
+	agentJob.ExtraVars = run.job.Request.ExtraVars
+	if len(quarantined) > 0 {
+		recordQuarantinedHosts(job.Tenant, job.RunID, quarantined, run.recorder)
+	}
+
+	agentRuns.Lock()
+	agentRuns.runs[runKey{job.Tenant.Name, job.RunID}] = run
+	agentRuns.Unlock()
+	return agentJob, nil
+}
+
+// preflightAgentRun выполняет pre-хуки; место на диске агента сервер не проверяет
+func preflightAgentRun(job runJob) error {
+	if len(cfg.Hooks.Pre) == 0 {
+		return nil
+	}
+	var run PlaybookRun
+	if err := job.Tenant.primaryDB().First(&run, job.RunID).Error; err != nil {
+		return err
+	}
+	return runPreHooks(run)
+}
+
+// newAgentRun создает запись вывода запуска с раскрытыми секретами job.
+// seq продолжается с уже сохраненных строк: вывод мог начать писать другой узел.
+func newAgentRun(job runJob, agent string) (*agentRun, error) {
+	var lastSeq int
+	if err := job.Tenant.primaryDB().Model(&RunOutputChunk{}).Where("run_id = ?", job.RunID).
+		Select("COALESCE(MAX(seq), 0)").Scan(&lastSeq).Error; err != nil {
+		return nil, err
+	}
+	masker := newSecretMasker(runSecrets(job.Request))
+	recorder := newRunRecorder(job.Tenant, job.RunID, masker)
+	recorder.seq = lastSeq
+	return &agentRun{job: job, agent: agent, masker: masker, recorder: recorder}, nil
+}
+
+// loadAgentRun находит запуск агента из пути запроса
+func loadAgentRun(w http.ResponseWriter, r *http.Request) (*agentRun, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid tenant or run ID", http.StatusBadRequest)
+		return nil, false
+	}
+	run, err := findAgentRun(vars["name"], vars["tenant"], uint(id))
+	if err != nil {
+		writeAgentError(w, err)
+		return nil, false
+	}
+	return run, true
+}
+
+// findAgentRun находит запуск, выполняющийся на агенте. После перезапуска
+// сервера или на другом узле запись вывода создается заново.
+func findAgentRun(agent, tenantName string, id uint) (*agentRun, error) {
+	t := tenants[tenantName]
+	if t == nil || id == 0 {
+		return nil, &agentError{http.StatusBadRequest, "Invalid tenant or run ID"}
+	}
+	key := runKey{t.Name, id}
+
+	agentRuns.Lock()
+	run := agentRuns.runs[key]
+	agentRuns.Unlock()
+	if run != nil {
+		if run.agent != agent {
+			return nil, &agentError{http.StatusConflict, "Run is not assigned to this agent"}
+		}
+		return run, nil
+	}
+
+	var stored PlaybookRun
+	if err := t.primaryDB().First(&stored, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &agentError{http.StatusNotFound, "Run not found"}
+		}
+		return nil, err
+	}
+	if stored.Status != RunStatusStarted || stored.NodeID != agentNodeID(agent) {
+		return nil, &agentError{http.StatusConflict, "Run is not executing on this agent"}
+	}
+	job := newRunJob(t, stored)
+	err := revealJobVars(&job)
+	if err == nil {
+		run, err = newAgentRun(job, agent)
+	}
+	if err != nil {
+		return nil, &agentError{http.StatusInternalServerError, err.Error()}
+	}
+
+	agentRuns.Lock()
+	if existing := agentRuns.runs[key]; existing != nil {
+		agentRuns.Unlock()
+		run.recorder.Close()
+		return existing, nil
+	}
+	agentRuns.runs[key] = run
+	agentRuns.Unlock()
+	return run, nil
+}
+
+// agentOutputHandler принимает очередную порцию вывода и служит heartbeat'ом
+// запуска. В ответе агент узнает, запрошена ли отмена.
+func agentOutputHandler(w http.ResponseWriter, r *http.Request) {
+	run, ok := loadAgentRun(w, r)
+	if !ok {
+		return
+	}
+	cancelRequested, err := reportAgentOutput(run, r.Body)
+	if err != nil {
+		writeAgentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"cancel": cancelRequested})
+}
+
+// reportAgentOutput записывает порцию вывода запуска агента, продлевает его
+// heartbeat и сообщает, запрошена ли отмена
+func reportAgentOutput(run *agentRun, output io.Reader) (bool, error) {
+	if _, err := io.Copy(run.recorder, output); err != nil {
+		return false, &agentError{http.StatusBadRequest, "Failed to read output"}
+	}
+
+	t, runID := run.job.Tenant, run.job.RunID
+	if err := t.db().Model(&PlaybookRun{}).Where("id = ?", runID).Update("heartbeat_at", time.Now()).Error; err != nil {
+		log.Printf("Failed to update heartbeat for run %d: %v", runID, err)
+	}
+	if _, err := touchAgent(run.agent); err != nil {
+		log.Printf("Failed to update agent %s: %v", run.agent, err)
+	}
+	var cancelRequested bool
+	if err := t.primaryDB().Model(&PlaybookRun{}).Where("id = ?", runID).Pluck("cancel_requested", &cancelRequested).Error; err != nil {
+		return false, err
+	}
+	return cancelRequested, nil
+}
+
+// finishAgentRunHandler сохраняет итог запуска агента
+func finishAgentRunHandler(w http.ResponseWriter, r *http.Request) {
+	var result agentResult
+	if !decodeJSONBody(w, r, &result) {
+		return
+	}
+	if err := result.validate(); err != nil {
+		writeAgentError(w, err)
+		return
+	}
+	run, ok := loadAgentRun(w, r)
+	if !ok {
+		return
+	}
+	finishAgentRun(run, result)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (result agentResult) validate() error {
+	switch result.Status {
+	case RunStatusCompleted, RunStatusFailed, RunStatusTimedOut, RunStatusCancelled:
+		return nil
+	}
+	return &agentError{http.StatusBadRequest, "Invalid run status"}
+}
+
+// finishAgentRun сохраняет итог запуска агента. Post-хуки и интеграции
+// выполняются в фоне, чтобы не задерживать ответ агенту.
+func finishAgentRun(run *agentRun, result agentResult) {
+	agentRuns.Lock()
+	delete(agentRuns.runs, runKey{run.job.Tenant.Name, run.job.RunID})
+	agentRuns.Unlock()
+	run.recorder.Close()
+
+	log.Printf("Run %d %s on agent %s", run.job.RunID, result.Status, run.agent)
+	go func() {
+		if result.Usage != nil {
+			recordRunUsage(run.job.Tenant, run.job.RunID, *result.Usage)
+		}
+		finishRun(run.job, result.Status, run.masker.Mask(result.Error), run.masker.Mask(result.Output))
+		t, runID := run.job.Tenant, run.job.RunID
+		persist(fmt.Sprintf("complete queue entry of run %d", runID), func() error {
+			return completeQueueEntry(t, runID)
+		})
+	}()
+}
+
+// agentBundleHandler отдает агенту каталог playbooks арендатора запуска в tar.gz
+func agentBundleHandler(w http.ResponseWriter, r *http.Request) {
+	run, ok := loadAgentRun(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	if err := writePlaybooksBundle(w, run.job.Tenant.PlaybooksDir); err != nil {
+		log.Printf("Failed to send playbooks to agent %s: %v", run.agent, err)
+	}
+}
+
+// writePlaybooksBundle пишет каталоги и обычные файлы dir в tar.gz
+func writePlaybooksBundle(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." || !(d.IsDir() || d.Type().IsRegular()) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,199 @@
+package ansibleapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Переменные, через которые playbook узнает, куда и с каким токеном загружать артефакты
+const (
+	artifactsURLVar   = "ansible_api_artifacts_url"
+	artifactsTokenVar = "ansible_api_artifacts_token"
+)
+
+var artifactNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,254}$`)
+
+// RunArtifact - файл, загруженный playbook во время запуска
+type RunArtifact struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	RunID       uint      `gorm:"not null;uniqueIndex:idx_run_artifact_name,priority:1" json:"run_id"`
+	Name        string    `gorm:"type:text;not null;uniqueIndex:idx_run_artifact_name,priority:2" json:"name"`
+	ContentType string    `gorm:"type:text" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	Content     []byte    `gorm:"type:bytea" json:"-"`
+	CreatedAt   time.Time `gorm:"type:timestamptz;not null" json:"created_at"`
+}
+
+// issueArtifactToken выдает запуску токен загрузки артефактов и передает его
+// в playbook через extra_vars. Токен действует, пока запуск выполняется; в БД
+// хранится только его хэш, в выводе он маскируется как sensitive_vars.
+func issueArtifactToken(job *runJob) error {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	// Арендатор в токене: загрузка идет без токена API, по которому он определяется
+	token := job.Tenant.Name + "." + hex.EncodeToString(secret)
+	if err := job.Tenant.db().Model(&PlaybookRun{}).Where("id = ?", job.RunID).
+		Update("artifact_token_hash", hashArtifactToken(token)).Error; err != nil {
+		return err
+	}
+
+	baseURL := strings.TrimSuffix(cfg.Server.PublicURL, "/")
+	if baseURL == "" {
+		baseURL = "http://127.0.0.1:" + cfg.Server.Port
+	}
+	vars := make(map[string]string, len(job.Request.ExtraVars)+2)
+	for k, v := range job.Request.ExtraVars {
+		vars[k] = v
+	}
+	vars[artifactsURLVar] = fmt.Sprintf("%s/api/runs/%d/artifacts", baseURL, job.RunID)
+	vars[artifactsTokenVar] = token
+	job.Request.ExtraVars = vars
+	job.Request.SensitiveVars = append(job.Request.SensitiveVars, artifactsTokenVar)
+	return nil
+}
+
+func hashArtifactToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorizeArtifactUpload проверяет токен запуска из Authorization: Bearer или X-Run-Token
+func authorizeArtifactUpload(w http.ResponseWriter, r *http.Request) (*tenant, uint, bool) {
+	token := r.Header.Get("X-Run-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	dot := strings.LastIndex(token, ".")
+	if dot < 0 || tenants[token[:dot]] == nil {
+		http.Error(w, "Invalid run token", http.StatusForbidden)
+		return nil, 0, false
+	}
+	t := tenants[token[:dot]]
+
+	var run PlaybookRun
+	if err := t.primaryDB().Select("id", "status", "artifact_token_hash").First(&run, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Invalid run token", http.StatusForbidden)
+		} else {
+			writeDBError(w, err)
+		}
+		return nil, 0, false
+	}
+	if run.ArtifactTokenHash == "" || subtle.ConstantTimeCompare([]byte(hashArtifactToken(token)), []byte(run.ArtifactTokenHash)) != 1 {
+		http.Error(w, "Invalid run token", http.StatusForbidden)
+		return nil, 0, false
+	}
+	if run.Status != RunStatusStarted {
+		http.Error(w, "Run is not executing", http.StatusConflict)
+		return nil, 0, false
+	}
+	return t, run.ID, true
+}
+
+// uploadArtifactHandler сохраняет тело запроса как артефакт name запуска.
+// Повторная загрузка с тем же именем заменяет файл.
+func uploadArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	t, runID, ok := authorizeArtifactUpload(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if !artifactNameRe.MatchString(name) {
+		http.Error(w, "name must consist of letters, digits and ._-", http.StatusBadRequest)
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Artifact too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Failed to read artifact", http.StatusBadRequest)
+		}
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	artifact := RunArtifact{
+		RunID:       runID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Content:     content,
+		CreatedAt:   time.Now(),
+	}
+	err = t.db().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "run_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content_type", "size", "content", "created_at"}),
+	}).Create(&artifact).Error
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(artifact)
+}
+
+func listArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	artifacts := []RunArtifact{}
+	if err := tenantOf(r).db().Omit("content").Where("run_id = ?", id).Order("name").Find(&artifacts).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifacts)
+}
+
+func downloadArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	var artifact RunArtifact
+	if err := tenantOf(r).db().Where("run_id = ? AND name = ?", id, vars["name"]).First(&artifact).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Artifact not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", artifact.Name))
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Write(artifact.Content)
+}
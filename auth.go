@@ -0,0 +1,117 @@
+package ansibleapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// principal - вызывающая сторона, определенная по токену запроса
+type principal struct {
+	Name      string
+	Team      string
+	Admin     bool
+	Anonymous bool
+	// Арендатор токена, пустой - арендатор по умолчанию
+	Tenant string
+	// Пользователь, вошедший через POST /api/auth/login (JWT)
+	User bool
+	// Ключ подписи запросов токена; с ним POST /api/run требует подпись
+	signingSecret string
+}
+
+// authEnforced сообщает, настроены ли токены клиентов
+func authEnforced() bool {
+	return len(cfg.Auth.Tokens) > 0
+}
+
+func currentPrincipal(r *http.Request) principal {
+	if hasAdminToken(r) {
+		return principal{Name: "admin", Admin: true}
+	}
+
+	token := r.Header.Get("X-API-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if looksLikeJWT(token) && jwtEnabled() {
+		if claims, err := verifyJWT(token); err == nil {
+			return principal{Name: claims.Subject, Team: claims.Team, Tenant: claims.Tenant, Admin: claims.Admin, User: true}
+		}
+		return principal{Anonymous: true}
+	}
+	if token != "" {
+		for _, t := range cfg.Auth.Tokens {
+			if t.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+				return principal{Name: t.Name, Team: t.Team, Tenant: t.Tenant, signingSecret: t.SigningSecret}
+			}
+		}
+	}
+	return principal{Anonymous: true}
+}
+
+// Маршруты, доступные без учетных данных при auth.require_auth: у них своя
+// проверка (ссылки, токены агентов и запусков) или они нужны до входа
+var publicRoutes = map[string]bool{
+	"/readyz":                  true,
+	"/metrics":                 true,
+	"/api/auth/login":          true,
+	"/api/shared/{token}":      true,
+	"/api/agents/register":     true,
+	"/api/agents/{name}/claim": true,
+	"/api/agents/{name}/runs/{tenant}/{id}/output": true,
+	"/api/agents/{name}/runs/{tenant}/{id}/finish": true,
+	"/api/agents/{name}/runs/{tenant}/{id}/bundle": true,
+}
+
+// authMiddleware отклоняет запросы с неверным или просроченным JWT, а при
+// auth.require_auth - и запросы без учетных данных (JWT, токена auth.tokens
+// или X-Admin-Token), кроме публичных маршрутов
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if looksLikeJWT(token) && jwtEnabled() {
+			if _, err := verifyJWT(token); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+		}
+		if cfg.Auth.RequireAuth && !publicRoute(r) && currentPrincipal(r).Anonymous {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func publicRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	// Загрузка артефактов проверяет токен запуска, список и скачивание - нет
+	if template == "/api/runs/{id}/artifacts" && r.Method == http.MethodPost {
+		return true
+	}
+	return publicRoutes[template]
+}
+
+// triggeredBy - кто ставит запуск: имя пользователя, вошедшего по логину,
+// иначе адрес клиента с учетом доверенных прокси
+func triggeredBy(r *http.Request) string {
+	if p := currentPrincipal(r); p.User {
+		return p.Name
+	}
+	return clientIP(r)
+}
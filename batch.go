@@ -0,0 +1,164 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Наибольшее число запусков в одном пакете
+const maxBatchSize = 1000
+
+// RunBatch - запуски, поставленные одним запросом POST /api/runs/batch
+type RunBatch struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	TriggeredBy string    `gorm:"type:text" json:"triggered_by,omitempty"`
+	Size        int       `gorm:"not null" json:"size"`
+	CreatedAt   time.Time `gorm:"type:timestamptz;not null" json:"created_at"`
+}
+
+// RunBatchStatus - состояние пакета: число запусков по статусам и сами запуски
+type RunBatchStatus struct {
+	RunBatch
+	Statuses map[PlaybookRunStatus]int `json:"statuses"`
+	// Все запуски пакета завершились
+	Finished bool          `json:"finished"`
+	Runs     []PlaybookRun `json:"runs"`
+}
+
+// runBatchHandler ставит в очередь массив запросов на запуск, например один
+// playbook по многим инвентарям. Сначала проверяются все запросы: при ошибке
+// в любом не ставится ни один, в ответе index - номер запроса с ошибкой.
+func runBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var requests []PlaybookRequest
+	if !decodeJSONBody(w, r, &requests) {
+		return
+	}
+	if len(requests) == 0 || len(requests) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch must contain from 1 to %d run requests", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	for i := range requests {
+		if requests[i].Rolling != nil {
+			writeBatchError(w, http.StatusBadRequest, "rolling is not supported in batches", i, nil, nil)
+			return
+		}
+		// Ошибку проверки перехватываем, чтобы дополнить ее номером запроса
+		buf := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		if !validateRunRequest(buf, r, &requests[i]) {
+			writeBatchError(w, buf.status, strings.TrimSpace(buf.body.String()), i, nil, nil)
+			return
+		}
+	}
+
+	t := tenantOf(r)
+	triggeredBy := triggeredBy(r)
+	batch := RunBatch{TriggeredBy: triggeredBy, Size: len(requests), CreatedAt: time.Now()}
+	if err := t.db().Create(&batch).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	runIDs := make([]uint, 0, len(requests))
+	warnings := make(map[string]string)
+	for i, req := range requests {
+		req.BatchID = &batch.ID
+		req.Team = currentPrincipal(r).Team
+		run, err := queuePlaybookRun(t, req, triggeredBy, r.RemoteAddr)
+		if err != nil {
+			// Уже поставленные запуски остаются в очереди и в пакете
+			log.Printf("Failed to queue run %d of batch %d: %v", i, batch.ID, err)
+			var frozen *frozenPlaybookError
+			var missing *missingDependenciesError
+			switch {
+			case errors.As(err, &frozen):
+				writeBatchError(w, http.StatusConflict, err.Error(), i, &batch, runIDs)
+			case errors.As(err, &missing):
+				writeBatchError(w, http.StatusFailedDependency, err.Error(), i, &batch, runIDs)
+			case isConnectionError(err):
+				w.Header().Set("Retry-After", "10")
+				writeBatchError(w, http.StatusServiceUnavailable, "Database unavailable", i, &batch, runIDs)
+			default:
+				writeBatchError(w, http.StatusInternalServerError, "Internal server error", i, &batch, runIDs)
+			}
+			return
+		}
+		runIDs = append(runIDs, run.ID)
+		if run.Warning != "" {
+			warnings[run.Playbook] = run.Warning
+		}
+	}
+
+	response := map[string]interface{}{
+		"status":   "accepted",
+		"message":  "playbook executions queued",
+		"batch_id": batch.ID,
+		"run_ids":  runIDs,
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeBatchError отвечает ошибкой запроса index; если пакет уже создан,
+// в ответе его id и запуски, поставленные до ошибки
+func writeBatchError(w http.ResponseWriter, status int, message string, index int, batch *RunBatch, runIDs []uint) {
+	response := map[string]interface{}{
+		"error": message,
+		"index": index,
+	}
+	if batch != nil {
+		response["batch_id"] = batch.ID
+		response["run_ids"] = runIDs
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// getRunBatchHandler отдает состояние пакета. Сами запуски без вывода;
+// детали запуска - GET /api/runs/{id}, список с фильтрами - GET /api/runs?batch=<id>.
+func getRunBatchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return
+	}
+	t := tenantOf(r)
+
+	status := RunBatchStatus{Statuses: make(map[PlaybookRunStatus]int), Runs: []PlaybookRun{}}
+	if err := t.db().First(&status.RunBatch, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Batch not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	if err := t.db().Select("id", "created_at", "updated_at", "playbook", "inventory", "status", "start_time", "end_time", "duration", "error").
+		Where("batch_id = ?", id).Order("id").Find(&status.Runs).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	status.Finished = true
+	for _, run := range status.Runs {
+		status.Statuses[run.Status]++
+		if run.Status == RunStatusQueued || run.Status == RunStatusStarted {
+			status.Finished = false
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
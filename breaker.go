@@ -0,0 +1,265 @@
+package ansibleapi
+
+import (
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+var errDBUnavailable = errors.New("database unavailable")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// dbBreaker размыкается после серии ошибок соединения с БД. В разомкнутом
+// состоянии запросы сразу получают errDBUnavailable, а после cooldown один
+// пробный запрос решает, замкнуть цепь или снова разомкнуть.
+type dbBreaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	lastError string
+	pending   []pendingWrite
+	dropped   int
+}
+
+// pendingWrite - отложенная запись о запуске, повторяемая после восстановления БД
+type pendingWrite struct {
+	Desc  string
+	Apply func() error
+}
+
+var breaker = &dbBreaker{state: breakerClosed}
+
+// isConnectionError отличает недоступность БД от обычных ошибок запросов
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errDBUnavailable) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return pgconn.Timeout(err) || pgconn.SafeToRetry(err)
+}
+
+// allow решает, пропускать ли запрос к БД
+func (b *dbBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < cfg.Database.BreakerCooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Пока идет пробный запрос, остальные не пропускаем
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *dbBreaker) record(err error) {
+	if errors.Is(err, errDBUnavailable) {
+		return
+	}
+
+	b.mu.Lock()
+	if !isConnectionError(err) {
+		recovered := b.state != breakerClosed
+		b.state = breakerClosed
+		b.failures = 0
+		b.mu.Unlock()
+		if recovered {
+			log.Printf("Database connection restored, circuit breaker closed")
+			go b.replay()
+		}
+		return
+	}
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == breakerHalfOpen || b.failures >= cfg.Database.BreakerThreshold {
+		if b.state != breakerOpen {
+			log.Printf("Database unavailable, circuit breaker opened: %v", err)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *dbBreaker) Degraded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed
+}
+
+type BreakerStatus struct {
+	State         breakerState `json:"state"`
+	Failures      int          `json:"failures"`
+	LastError     string       `json:"last_error,omitempty"`
+	PendingWrites int          `json:"pending_writes"`
+	DroppedWrites int          `json:"dropped_writes"`
+}
+
+func (b *dbBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{
+		State:         b.state,
+		Failures:      b.failures,
+		LastError:     b.lastError,
+		PendingWrites: len(b.pending),
+		DroppedWrites: b.dropped,
+	}
+}
+
+// persist выполняет запись о запуске; если БД недоступна, запись откладывается до ее возвращения
+func persist(desc string, apply func() error) {
+	err := apply()
+	if err == nil {
+		return
+	}
+	if !isConnectionError(err) {
+		log.Printf("Failed to %s: %v", desc, err)
+		return
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if len(breaker.pending) >= cfg.Database.MaxPendingWrites {
+		breaker.dropped++
+		log.Printf("Pending write buffer is full, dropping: %s", desc)
+		return
+	}
+	breaker.pending = append(breaker.pending, pendingWrite{Desc: desc, Apply: apply})
+	log.Printf("Database unavailable, buffered: %s", desc)
+}
+
+// replay повторяет отложенные записи по порядку; при новой ошибке соединения останавливается
+func (b *dbBreaker) replay() {
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.mu.Unlock()
+			return
+		}
+		write := b.pending[0]
+		b.mu.Unlock()
+
+		err := write.Apply()
+		if isConnectionError(err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to replay %s: %v", write.Desc, err)
+		}
+
+		b.mu.Lock()
+		b.pending = b.pending[1:]
+		b.mu.Unlock()
+	}
+}
+
+// probe периодически проверяет БД, пока цепь разомкнута, чтобы вернуться
+// в нормальный режим даже без входящих запросов
+func (b *dbBreaker) probe() {
+	ticker := time.NewTicker(cfg.Database.BreakerCooldown)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !b.Degraded() || !b.allow() {
+			continue
+		}
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.Ping()
+		}
+		b.record(err)
+	}
+}
+
+// registerBreaker подключает breaker ко всем операциям gorm
+func registerBreaker(gdb *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		if !breaker.allow() {
+			tx.AddError(errDBUnavailable)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		breaker.record(tx.Error)
+	}
+
+	if err := gdb.Callback().Create().Before("gorm:create").Register("breaker:before_create", before); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Create().After("gorm:create").Register("breaker:after_create", after); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Query().Before("gorm:query").Register("breaker:before_query", before); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Query().After("gorm:query").Register("breaker:after_query", after); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Update().Before("gorm:update").Register("breaker:before_update", before); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Update().After("gorm:update").Register("breaker:after_update", after); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Delete().Before("gorm:delete").Register("breaker:before_delete", before); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Delete().After("gorm:delete").Register("breaker:after_delete", after); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Row().Before("gorm:row").Register("breaker:before_row", before); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Row().After("gorm:row").Register("breaker:after_row", after); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Raw().Before("gorm:raw").Register("breaker:before_raw", before); err != nil {
+		return err
+	}
+	return gdb.Callback().Raw().After("gorm:raw").Register("breaker:after_raw", after)
+}
+
+// writeDBError отвечает 503 при недоступности БД, не раскрывая ошибку драйвера, и 500 в остальных случаях
+func writeDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errQueryTimeout) {
+		http.Error(w, "Database query timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if isConnectionError(err) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
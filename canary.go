@@ -0,0 +1,182 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errRolloutChanged - статус или часть rollout изменились параллельно
+var errRolloutChanged = errors.New("rollout state changed")
+
+// validateCanary проверяет поля canary стратегии
+func (s *RollingStrategy) validateCanary(t *tenant) error {
+	if s.Canary == "" {
+		if s.VerifyPlaybook != "" || s.RequireApproval {
+			return fmt.Errorf("verify_playbook and require_approval require canary")
+		}
+		return nil
+	}
+	if err := validateLimit(s.Canary); err != nil {
+		return fmt.Errorf("invalid canary: %v", err)
+	}
+	if s.VerifyPlaybook != "" {
+		if _, err := os.Stat(filepath.Join(t.PlaybooksDir, s.VerifyPlaybook)); os.IsNotExist(err) {
+			return fmt.Errorf("verify_playbook not found")
+		}
+	}
+	return nil
+}
+
+// orderCanaryHosts выбирает из hosts хосты canary (по --list-hosts с limit
+// canary) и ставит их в начало; возвращает переупорядоченные хосты и число canary
+func orderCanaryHosts(t *tenant, req PlaybookRequest, hosts []string) ([]string, int, error) {
+	canaryReq := req
+	canaryReq.Limit = req.Rolling.Canary
+	if req.Limit != "" {
+		// Пересечение с limit запроса
+		canaryReq.Limit = req.Limit + ",&" + req.Rolling.Canary
+	}
+	matched, err := listPlaybookHosts(t, canaryReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errRolloutHosts, err)
+	}
+	isCanary := make(map[string]bool, len(matched))
+	for _, host := range matched {
+		isCanary[host] = true
+	}
+
+	ordered := make([]string, 0, len(hosts))
+	var rest []string
+	for _, host := range hosts {
+		if isCanary[host] {
+			ordered = append(ordered, host)
+		} else {
+			rest = append(rest, host)
+		}
+	}
+	canaryHosts := len(ordered)
+	if canaryHosts == 0 {
+		return nil, 0, fmt.Errorf("%w: canary matches no hosts of the playbook", errRolloutHosts)
+	}
+	return append(ordered, rest...), canaryHosts, nil
+}
+
+// startVerification переводит rollout в verifying и ставит verify_playbook по
+// хостам canary
+func startVerification(t *tenant, rollout Rollout, canaryRun PlaybookRun) {
+	result := t.db().Model(&Rollout{}).
+		Where("id = ? AND status = ? AND current_batch = ?", rollout.ID, RolloutRunning, 1).
+		Update("status", RolloutVerifying)
+	if result.Error != nil {
+		log.Printf("Failed to start verification of rollout %d: %v", rollout.ID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	verify := repeatRun(canaryRun)
+	verify.Playbook = rollout.VerifyPlaybook
+	verify.Limit = strings.Join(rollout.batchHosts(1), ",")
+	verify.RolloutVerify = true
+	verify.Attempt = 1
+	verify.Warning = ""
+	if err := enqueueRun(t, &verify); err != nil {
+		log.Printf("Failed to queue verification of rollout %d: %v", rollout.ID, err)
+		finishRollout(t, rollout.ID, 1, RolloutAborted, fmt.Sprintf("failed to queue verification: %v", err))
+		return
+	}
+	log.Printf("Rollout %d: canary verification queued as run %d", rollout.ID, verify.ID)
+}
+
+// finishVerification продолжает rollout после успешной проверки canary или
+// останавливает его
+func finishVerification(t *tenant, rollout Rollout, run PlaybookRun, status PlaybookRunStatus) {
+	if rollout.Status != RolloutVerifying {
+		return
+	}
+	switch status {
+	case RunStatusCompleted:
+		canaryRun, err := lastBatchRun(t, rollout.ID, 1)
+		if err != nil {
+			log.Printf("Failed to load canary run of rollout %d: %v", rollout.ID, err)
+			finishRollout(t, rollout.ID, 1, RolloutAborted, fmt.Sprintf("failed to load canary run: %v", err))
+			return
+		}
+		promoteCanary(t, rollout, canaryRun, RolloutVerifying)
+	case RunStatusCancelled:
+		finishRollout(t, rollout.ID, 1, RolloutCancelled, fmt.Sprintf("verification run %d cancelled", run.ID))
+	default:
+		finishRollout(t, rollout.ID, 1, RolloutAborted, fmt.Sprintf("verification run %d %s", run.ID, status))
+	}
+}
+
+// promoteCanary продолжает rollout после canary: ждет подтверждения, если оно
+// требуется, иначе ставит вторую часть
+func promoteCanary(t *tenant, rollout Rollout, canaryRun PlaybookRun, from RolloutStatus) {
+	switch {
+	case rollout.Batches <= 1:
+		finishRollout(t, rollout.ID, 1, RolloutCompleted, "")
+	case rollout.RequireApproval:
+		err := t.db().Model(&Rollout{}).Where("id = ? AND status = ?", rollout.ID, from).
+			Update("status", RolloutAwaitingApproval).Error
+		if err != nil {
+			log.Printf("Failed to update rollout %d: %v", rollout.ID, err)
+			return
+		}
+		log.Printf("Rollout %d: canary succeeded, awaiting approval", rollout.ID)
+	default:
+		queueRolloutBatch(t, rollout, canaryRun, 2, from, true)
+	}
+}
+
+// lastBatchRun - последний запуск части batch rollout (без проверок canary)
+func lastBatchRun(t *tenant, rolloutID uint, batch int) (PlaybookRun, error) {
+	var run PlaybookRun
+	err := t.primaryDB().Where("rollout_id = ? AND rollout_batch = ? AND NOT rollout_verify", rolloutID, batch).
+		Order("id DESC").First(&run).Error
+	return run, err
+}
+
+// approveRolloutHandler подтверждает продолжение rollout после canary и
+// ставит вторую часть без паузы. Отклонение - POST /api/rollouts/{id}/cancel.
+func approveRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	t, rollout, ok := loadRollout(w, r)
+	if !ok || !requirePlaybookRole(w, r, roleOperator, rollout.Playbook) {
+		return
+	}
+	if rollout.Status != RolloutAwaitingApproval {
+		http.Error(w, "Rollout is not awaiting approval", http.StatusConflict)
+		return
+	}
+	canaryRun, err := lastBatchRun(t, rollout.ID, 1)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	next, err := queueRolloutBatch(t, *rollout, canaryRun, 2, RolloutAwaitingApproval, false)
+	switch {
+	case errors.Is(err, errRolloutChanged):
+		http.Error(w, "Rollout is not awaiting approval", http.StatusConflict)
+		return
+	case err != nil:
+		writeDBError(w, err)
+		return
+	}
+	log.Printf("Rollout %d approved by %s", rollout.ID, triggeredBy(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rollout_id": rollout.ID,
+		"status":     RolloutRunning,
+		"batch":      2,
+		"run_id":     next.ID,
+	})
+}
@@ -0,0 +1,199 @@
+package ansibleapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Сколько ждать завершения группы процессов после SIGTERM перед SIGKILL
+const processKillGrace = 10 * time.Second
+
+var errRunCancelled = errors.New("run cancelled by request")
+
+// errServerShutdown - причина отмены запусков, не завершившихся за server.shutdown_grace
+var errServerShutdown = errors.New("run cancelled: server shut down before the run finished")
+
+// runKey - запуск арендатора; id запусков уникальны только внутри схемы
+type runKey struct {
+	tenant string
+	id     uint
+}
+
+// activeRuns хранит функции отмены запусков, выполняющихся на этом узле
+var activeRuns = struct {
+	sync.Mutex
+	cancels map[runKey]context.CancelCauseFunc
+}{cancels: make(map[runKey]context.CancelCauseFunc)}
+
+// newRunContext создает контекст запуска с таймаутом из cfg.Ansible.Timeout
+func newRunContext(t *tenant, runID uint) (context.Context, context.CancelFunc) {
+	key := runKey{t.Name, runID}
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	stopTimeout := func() bool { return false }
+	if cfg.Ansible.Timeout > 0 {
+		timeout := time.Duration(cfg.Ansible.Timeout) * time.Second
+		timer := time.AfterFunc(timeout, func() {
+			cancel(fmt.Errorf("run exceeded timeout of %s", timeout))
+		})
+		stopTimeout = timer.Stop
+	}
+
+	activeRuns.Lock()
+	activeRuns.cancels[key] = cancel
+	activeRuns.Unlock()
+
+	return ctx, func() {
+		activeRuns.Lock()
+		delete(activeRuns.cancels, key)
+		activeRuns.Unlock()
+		stopTimeout()
+		cancel(nil)
+	}
+}
+
+// cancelActiveRun отменяет запуск, если он выполняется на этом узле
+func cancelActiveRun(t *tenant, runID uint) bool {
+	activeRuns.Lock()
+	cancel, ok := activeRuns.cancels[runKey{t.Name, runID}]
+	activeRuns.Unlock()
+	if ok {
+		cancel(errRunCancelled)
+	}
+	return ok
+}
+
+// cancelActiveRuns отменяет все запуски, выполняющиеся на этом узле
+func cancelActiveRuns(cause error) int {
+	activeRuns.Lock()
+	defer activeRuns.Unlock()
+	for _, cancel := range activeRuns.cancels {
+		cancel(cause)
+	}
+	return len(activeRuns.cancels)
+}
+
+// verifyTeardown дожидается исчезновения группы процессов и описывает результат для записи запуска
+func verifyTeardown(pgid int) string {
+	if pgid <= 0 {
+		return "no process was started"
+	}
+
+	deadline := time.Now().Add(processKillGrace + time.Second)
+	for processGroupAlive(pgid) {
+		if time.Now().After(deadline) {
+			if err := killProcessGroup(pgid); err != nil {
+				return fmt.Sprintf("process group %d still alive after SIGKILL: %v", pgid, err)
+			}
+			return fmt.Sprintf("process group %d killed with SIGKILL", pgid)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Sprintf("process group %d terminated", pgid)
+}
+
+func cancelRunHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	var run PlaybookRun
+	if err := t.primaryDB().First(&run, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	if !requirePlaybookRole(w, r, roleOperator, run.Playbook) {
+		return
+	}
+
+	if run.Status != RunStatusQueued && run.Status != RunStatusStarted {
+		http.Error(w, "Run is not active", http.StatusConflict)
+		return
+	}
+	status, err := cancelRun(t, run)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run_id": run.ID,
+		"status": status,
+	})
+}
+
+// cancelRun отменяет ожидающий или выполняющийся запуск и возвращает его
+// состояние: cancelled (снят с очереди) или cancelling (отмена запрошена)
+func cancelRun(t *tenant, run PlaybookRun) (string, error) {
+	status := "cancelling"
+	switch run.Status {
+	case RunStatusQueued:
+		cancelled, err := cancelQueuedRun(t, run)
+		if err != nil {
+			return "", err
+		}
+		if cancelled {
+			status = string(RunStatusCancelled)
+			run.Status = RunStatusCancelled
+			publishRunEvent(t, "run.finished", run, errRunCancelled.Error())
+			// Снятый с очереди запуск не проходит finishRun
+			advanceRollout(t, run.ID, RunStatusCancelled, "")
+			break
+		}
+		// Запуск успели забрать из очереди - отменяем как выполняющийся
+		fallthrough
+	case RunStatusStarted:
+		// Флаг подхватит heartbeat узла, на котором идет выполнение
+		if err := t.db().Model(&PlaybookRun{}).Where("id = ?", run.ID).Update("cancel_requested", true).Error; err != nil {
+			return "", err
+		}
+		cancelActiveRun(t, run.ID)
+	}
+
+	log.Printf("Cancellation requested for run %d", run.ID)
+	return status, nil
+}
+
+// cancelQueuedRun снимает запуск с очереди, если его еще не забрал воркер
+func cancelQueuedRun(t *tenant, run PlaybookRun) (bool, error) {
+	cancelled := false
+	err := t.db().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RunQueueEntry{}).
+			Where("run_id = ? AND state = ?", run.ID, QueueStateQueued).
+			Update("state", QueueStateDone)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		endTime := time.Now()
+		cancelled = true
+		return tx.Model(&PlaybookRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+			"status":           RunStatusCancelled,
+			"error":            errRunCancelled.Error(),
+			"cancel_requested": true,
+			"end_time":         endTime,
+			"duration":         0,
+		}).Error
+	})
+	return cancelled, err
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package ansibleapi
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"ansible-api/config"
+)
+
+// attachCgroup создает дочернюю cgroup v2 с лимитами и помещает в нее процесс
+// прямо при clone через CgroupFD, так что дочерние процессы не успевают ее избежать.
+func attachCgroup(cmd *exec.Cmd, limits config.ResourceLimits) (func() error, error) {
+	dir, err := os.MkdirTemp(limits.CgroupRoot, "run-*")
+	if err != nil {
+		return nil, err
+	}
+	remove := func() error { return os.Remove(dir) }
+
+	settings := map[string]string{
+		"memory.max": limits.MemoryMax,
+		"cpu.max":    limits.CPUMax,
+	}
+	for file, value := range settings {
+		if value == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			remove()
+			return nil, err
+		}
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+
+	return func() error {
+		fd.Close()
+		return remove()
+	}, nil
+}
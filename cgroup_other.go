@@ -0,0 +1,14 @@
+//go:build !linux
+
+package ansibleapi
+
+import (
+	"errors"
+	"os/exec"
+
+	"ansible-api/config"
+)
+
+func attachCgroup(cmd *exec.Cmd, limits config.ResourceLimits) (func() error, error) {
+	return nil, errors.New("cgroup limits are only supported on linux")
+}
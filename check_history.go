@@ -0,0 +1,126 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Допустимые интервалы агрегации (аргумент date_trunc)
+var historyBuckets = map[string]bool{
+	"hour":  true,
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+type AvailabilityPoint struct {
+	Bucket       time.Time `json:"bucket"`
+	Checks       int64     `json:"checks"`
+	Reachable    int64     `json:"reachable"`
+	Availability float64   `json:"availability"`
+}
+
+type CheckHistoryResponse struct {
+	Inventory string                         `json:"inventory"`
+	Bucket    string                         `json:"bucket"`
+	From      time.Time                      `json:"from"`
+	To        time.Time                      `json:"to"`
+	Overall   []AvailabilityPoint            `json:"overall"`
+	Hosts     map[string][]AvailabilityPoint `json:"hosts"`
+}
+
+type hostAvailabilityRow struct {
+	Bucket    time.Time
+	Host      string
+	Checks    int64
+	Reachable int64
+}
+
+// checkHistoryHandler возвращает доступность хостов инвентаря по интервалам
+// времени, посчитанную по результатам завершенных проверок
+func checkHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	inventoryName := mux.Vars(r)["name"]
+	queryParams := r.URL.Query()
+
+	bucket := queryParams.Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if !historyBuckets[bucket] {
+		http.Error(w, "Invalid bucket, expected hour, day, week or month", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -cfg.Logging.RetentionDays)
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := queryParams.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+name+", expected RFC3339", http.StatusBadRequest)
+				return
+			}
+			*target = t
+		}
+	}
+
+	inv, ok := loadInventory(w, r, inventoryName, false)
+	if !ok {
+		return
+	}
+
+	t := tenantOf(r)
+	var rows []hostAvailabilityRow
+	err := t.db().Raw(fmt.Sprintf(`
+		SELECT date_trunc(?, c.started_at) AS bucket,
+			r.key AS host,
+			COUNT(*) AS checks,
+			COUNT(*) FILTER (WHERE r.value = ?) AS reachable
+		FROM %s c
+		CROSS JOIN LATERAL jsonb_each_text(c.results) r
+		WHERE c.inventory_id = ?
+			AND c.deleted_at IS NULL
+			AND c.status = ?
+			AND c.started_at >= ? AND c.started_at < ?
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, t.table("inventory_check")),
+		bucket, HostReachable, inv.ID, CheckStatusCompleted, from, to).
+		Scan(&rows).Error
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	response := CheckHistoryResponse{
+		Inventory: inv.Name,
+		Bucket:    bucket,
+		From:      from,
+		To:        to,
+		Overall:   []AvailabilityPoint{},
+		Hosts:     make(map[string][]AvailabilityPoint),
+	}
+	for _, row := range rows {
+		point := AvailabilityPoint{Bucket: row.Bucket, Checks: row.Checks, Reachable: row.Reachable}
+		point.Availability = float64(row.Reachable) / float64(row.Checks)
+		response.Hosts[row.Host] = append(response.Hosts[row.Host], point)
+
+		// Строки отсортированы по интервалу, поэтому общий итог копится в последней точке
+		last := len(response.Overall) - 1
+		if last < 0 || !response.Overall[last].Bucket.Equal(row.Bucket) {
+			response.Overall = append(response.Overall, AvailabilityPoint{Bucket: row.Bucket})
+			last++
+		}
+		response.Overall[last].Checks += row.Checks
+		response.Overall[last].Reachable += row.Reachable
+	}
+	for i := range response.Overall {
+		response.Overall[i].Availability = float64(response.Overall[i].Reachable) / float64(response.Overall[i].Checks)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,202 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Имя задачи проверочного playbook, по результатам которой определяется доступность
+const connectivityTask = "Test host connectivity"
+
+// Как часто сохранять промежуточные результаты проверки
+const checkSaveInterval = time.Second
+
+const (
+	HostReachable   = "reachable"
+	HostUnreachable = "unreachable"
+	HostFailed      = "failed"
+)
+
+// checkEvent - строка вывода callback ansible.posix.jsonl
+type checkEvent struct {
+	Event string `json:"_event"`
+	Task  struct {
+		Name string `json:"name"`
+	} `json:"task"`
+	Hosts map[string]checkHostResult `json:"hosts"`
+}
+
+type checkHostResult struct {
+	Msg         json.RawMessage        `json:"msg"`
+	Ping        string                 `json:"ping"`
+	Unreachable bool                   `json:"unreachable"`
+	Failed      bool                   `json:"failed"`
+	Facts       map[string]interface{} `json:"ansible_facts"`
+}
+
+// reason возвращает текст ошибки; msg бывает и строкой, и произвольным JSON
+func (res checkHostResult) reason() string {
+	if len(res.Msg) == 0 {
+		return ""
+	}
+	var msg string
+	if err := json.Unmarshal(res.Msg, &msg); err == nil {
+		return msg
+	}
+	return string(res.Msg)
+}
+
+// checkProgress разбирает вывод проверки по мере выполнения: результат
+// каждого хоста сразу публикуется в /api/events и периодически
+// сохраняется в InventoryCheck.Results.
+type checkProgress struct {
+	tenant    *tenant
+	check     InventoryCheck
+	inventory string
+	mode      CheckMode
+
+	mu       sync.Mutex
+	partial  []byte
+	results  JSONMap
+	errors   JSONMap
+	facts    HostFacts
+	dirty    bool
+	lastSave time.Time
+}
+
+func newCheckProgress(t *tenant, check InventoryCheck, inventory string) *checkProgress {
+	return &checkProgress{
+		tenant:    t,
+		check:     check,
+		inventory: inventory,
+		mode:      check.Mode,
+		results:   make(JSONMap),
+		errors:    make(JSONMap),
+		facts:     make(HostFacts),
+	}
+}
+
+func (p *checkProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.observe(p.partial[:i])
+		p.partial = p.partial[i+1:]
+	}
+
+	if p.dirty && time.Since(p.lastSave) >= checkSaveInterval {
+		p.saveLocked()
+	}
+	return len(b), nil
+}
+
+func (p *checkProgress) observe(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		// Предупреждения и прочий текст, не относящийся к результатам
+		return
+	}
+
+	var event checkEvent
+	if err := json.Unmarshal(line, &event); err != nil {
+		log.Printf("Skipping malformed check output of check %d: %v", p.check.ID, err)
+		return
+	}
+	if event.Task.Name != connectivityTask {
+		return
+	}
+
+	for host, res := range event.Hosts {
+		var status string
+		switch event.Event {
+		case "v2_runner_on_ok":
+			status = HostReachable
+			if p.mode == CheckModePing && res.Ping != "pong" {
+				status = HostFailed
+			}
+		case "v2_runner_on_unreachable":
+			status = HostUnreachable
+		case "v2_runner_on_failed":
+			status = HostFailed
+		default:
+			continue
+		}
+
+		p.results[host] = status
+		if status == HostReachable {
+			delete(p.errors, host)
+		} else if reason := res.reason(); reason != "" {
+			p.errors[host] = reason
+		}
+		if p.mode == CheckModeFacts && res.Facts != nil {
+			p.facts[host] = factsSummary(res.Facts)
+		}
+		p.dirty = true
+
+		liveEvents.publish(LiveEvent{
+			Type:      "check.host",
+			Tenant:    p.tenant.Name,
+			CheckID:   p.check.ID,
+			Inventory: p.inventory,
+			Host:      host,
+			Status:    status,
+			Message:   p.errors[host],
+		})
+	}
+}
+
+// factsSummary оставляет из фактов только то, что нужно для отчета о хосте
+func factsSummary(facts map[string]interface{}) map[string]string {
+	str := func(key string) string {
+		v, _ := facts[key].(string)
+		return v
+	}
+	summary := map[string]string{
+		"os":     strings.TrimSpace(str("ansible_distribution") + " " + str("ansible_distribution_version")),
+		"kernel": str("ansible_kernel"),
+	}
+	if ipv4, ok := facts["ansible_default_ipv4"].(map[string]interface{}); ok {
+		summary["ip"], _ = ipv4["address"].(string)
+	}
+	return summary
+}
+
+func (p *checkProgress) saveLocked() {
+	err := p.tenant.store().SaveCheckResults(p.check.ID, p.results)
+	if err != nil {
+		log.Printf("Failed to save progress of inventory check %d: %v", p.check.ID, err)
+		return
+	}
+	p.dirty = false
+	p.lastSave = time.Now()
+}
+
+// Outcome возвращает копии собранных результатов, ошибок и фактов
+func (p *checkProgress) Outcome() (JSONMap, JSONMap, HostFacts) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	results := make(JSONMap, len(p.results))
+	for host, status := range p.results {
+		results[host] = status
+	}
+	errors := make(JSONMap, len(p.errors))
+	for host, reason := range p.errors {
+		errors[host] = reason
+	}
+	facts := make(HostFacts, len(p.facts))
+	for host, summary := range p.facts {
+		facts[host] = summary
+	}
+	return results, errors, facts
+}
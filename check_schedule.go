@@ -0,0 +1,302 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ansible-api/config"
+)
+
+// Интервал повторной проверки недоступных хостов, если он не задан
+const defaultUnreachableInterval = time.Minute
+
+// InventoryCheckSchedule - состояние регулярной проверки инвентаря из
+// checks.schedules. Следующая проверка забирается условным UPDATE, поэтому
+// на нескольких узлах она выполняется один раз.
+type InventoryCheckSchedule struct {
+	InventoryID uint `gorm:"primaryKey" json:"inventory_id"`
+	// Следующая проверка всех хостов
+	NextCheckAt time.Time `gorm:"type:timestamptz;not null" json:"next_check_at"`
+	// Следующая проверка только недоступных хостов; нет, пока все доступны
+	NextRecheckAt *time.Time `gorm:"type:timestamptz" json:"next_recheck_at,omitempty"`
+	// Хосты, недоступные при последней проверке
+	Unreachable JSONList  `gorm:"type:jsonb" json:"unreachable,omitempty"`
+	LastCheckID *uint     `json:"last_check_id,omitempty"`
+	UpdatedAt   time.Time `gorm:"type:timestamptz;not null" json:"updated_at"`
+
+	Inventory *Inventory `gorm:"foreignKey:InventoryID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// HostCheckState - доступность хоста по регулярным проверкам инвентаря.
+// Flaps - сколько раз доступный хост становился недоступным.
+type HostCheckState struct {
+	InventoryID uint      `gorm:"primaryKey" json:"-"`
+	Host        string    `gorm:"type:text;primaryKey" json:"host"`
+	Status      string    `gorm:"type:text;not null" json:"status"`
+	Flaps       int       `gorm:"not null;default:0" json:"flaps"`
+	ChangedAt   time.Time `gorm:"type:timestamptz;not null" json:"changed_at"`
+	CheckedAt   time.Time `gorm:"type:timestamptz;not null" json:"checked_at"`
+
+	Inventory *Inventory `gorm:"foreignKey:InventoryID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// checkSchedule - расписание из checks.schedules с арендатором
+type checkSchedule struct {
+	config.CheckSchedule
+	tenant *tenant
+}
+
+var checkSchedules []checkSchedule
+
+func loadCheckSchedules() error {
+	checkSchedules = nil
+	for i, c := range cfg.Checks.Schedules {
+		if c.Inventory == "" || c.Interval <= 0 {
+			return fmt.Errorf("check schedule %d: inventory and positive interval are required", i+1)
+		}
+		if _, err := path.Match(c.Inventory, ""); err != nil {
+			return fmt.Errorf("check schedule %s: %v", c.Inventory, err)
+		}
+		s := checkSchedule{CheckSchedule: c, tenant: defaultTenant()}
+		if c.Tenant != "" {
+			if s.tenant = tenants[c.Tenant]; s.tenant == nil {
+				return fmt.Errorf("check schedule %s: unknown tenant %q", c.Inventory, c.Tenant)
+			}
+		}
+		if s.UnreachableInterval <= 0 {
+			s.UnreachableInterval = min(defaultUnreachableInterval, s.Interval)
+		}
+		if s.UnreachableInterval > s.Interval {
+			return fmt.Errorf("check schedule %s: unreachable_interval must not exceed interval", c.Inventory)
+		}
+		checkSchedules = append(checkSchedules, s)
+	}
+	return nil
+}
+
+// runScheduledChecks запускает наступившие проверки всех расписаний. Проверка
+// выполняется в фоне; пока она идет, следующая не забирается.
+func runScheduledChecks() {
+	now := time.Now()
+	scheduled := make(map[*tenant]map[uint]bool)
+	for _, s := range checkSchedules {
+		var inventories []Inventory
+		if err := s.tenant.primaryDB().Select("id", "name", "content").Order("name").Find(&inventories).Error; err != nil {
+			log.Printf("Failed to list inventories for scheduled checks of tenant %s: %v", s.tenant.Name, err)
+			continue
+		}
+		if scheduled[s.tenant] == nil {
+			scheduled[s.tenant] = make(map[uint]bool)
+		}
+		for _, inv := range inventories {
+			// Инвентарь проверяется по первому подходящему расписанию
+			if ok, _ := path.Match(s.Inventory, inv.Name); !ok || scheduled[s.tenant][inv.ID] {
+				continue
+			}
+			scheduled[s.tenant][inv.ID] = true
+			// Без значений подстановок инвентарь проверить нельзя
+			if len(missingInventoryParams(inv.Content, nil)) > 0 {
+				continue
+			}
+			s.runIfDue(inv, now)
+		}
+	}
+}
+
+// runIfDue забирает наступившую проверку инвентаря: всех хостов по interval
+// или только недоступных по unreachable_interval
+func (s checkSchedule) runIfDue(inv Inventory, now time.Time) {
+	t := s.tenant
+	state := InventoryCheckSchedule{InventoryID: inv.ID, NextCheckAt: now, UpdatedAt: now}
+	if err := t.db().Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		log.Printf("Failed to create check schedule of inventory %s: %v", inv.Name, err)
+		return
+	}
+	if err := t.primaryDB().First(&state, inv.ID).Error; err != nil {
+		log.Printf("Failed to load check schedule of inventory %s: %v", inv.Name, err)
+		return
+	}
+
+	req := CheckRequest{Mode: CheckModePing}
+	// Пока проверка идет, следующая отложена на ее таймаут
+	busyUntil := now.Add(req.timeout())
+	var claim *gorm.DB
+	switch {
+	case !state.NextCheckAt.After(now):
+		claim = t.db().Model(&InventoryCheckSchedule{}).
+			Where("inventory_id = ? AND next_check_at = ?", inv.ID, state.NextCheckAt).
+			Updates(map[string]interface{}{"next_check_at": busyUntil, "next_recheck_at": nil, "updated_at": now})
+	case state.NextRecheckAt != nil && !state.NextRecheckAt.After(now) && len(state.Unreachable) > 0:
+		req.Limit = strings.Join(state.Unreachable, ",")
+		claim = t.db().Model(&InventoryCheckSchedule{}).
+			Where("inventory_id = ? AND next_recheck_at = ?", inv.ID, *state.NextRecheckAt).
+			Updates(map[string]interface{}{"next_recheck_at": busyUntil, "updated_at": now})
+	default:
+		return
+	}
+	if claim.Error != nil {
+		log.Printf("Failed to claim scheduled check of inventory %s: %v", inv.Name, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		// Проверку забрал другой узел
+		return
+	}
+
+	check := InventoryCheck{InventoryID: inv.ID, Status: CheckStatusPending, Mode: CheckModePing, Limit: req.Limit, StartedAt: now}
+	if err := t.store().CreateCheck(&check); err != nil {
+		log.Printf("Failed to create scheduled check of inventory %s: %v", inv.Name, err)
+		return
+	}
+	publishCheckEvent(t, "check.queued", check, inv.Name, "")
+	go func() {
+		check := runInventoryCheck(t, check, inv.Name, req)
+		s.recordScheduledCheck(inv, state.Unreachable, req.Limit != "", check)
+	}()
+}
+
+// recordScheduledCheck обновляет доступность и флапы хостов и назначает
+// следующие проверки: недоступные хосты перепроверяются чаще, пока не ответят
+func (s checkSchedule) recordScheduledCheck(inv Inventory, previous JSONList, recheck bool, check InventoryCheck) {
+	t := s.tenant
+	now := time.Now()
+	if err := recordHostCheckStates(t, inv.ID, check.Results, now); err != nil {
+		log.Printf("Failed to record host states of inventory %s: %v", inv.Name, err)
+	}
+
+	var unreachable []string
+	if recheck {
+		// Перепроверка видит только прежние недоступные хосты
+		for _, host := range previous {
+			if check.Results[host] == HostUnreachable || (check.Status != CheckStatusCompleted && check.Results[host] == "") {
+				unreachable = append(unreachable, host)
+			}
+		}
+	} else {
+		for host, result := range check.Results {
+			if result == HostUnreachable {
+				unreachable = append(unreachable, host)
+			}
+		}
+	}
+	sort.Strings(unreachable)
+
+	updates := map[string]interface{}{
+		"unreachable":     JSONList(unreachable),
+		"last_check_id":   check.ID,
+		"next_recheck_at": nil,
+		"updated_at":      now,
+	}
+	if !recheck {
+		updates["next_check_at"] = now.Add(s.Interval)
+	}
+	if len(unreachable) > 0 {
+		updates["next_recheck_at"] = now.Add(s.UnreachableInterval)
+	}
+	if !recheck && len(unreachable) > 0 && len(previous) == 0 {
+		log.Printf("Inventory %s: %d host(s) unreachable (%s), re-checking every %s",
+			inv.Name, len(unreachable), strings.Join(unreachable, ", "), s.UnreachableInterval)
+	}
+	if len(unreachable) == 0 && len(previous) > 0 {
+		log.Printf("Inventory %s: all hosts reachable again, back to checks every %s", inv.Name, s.Interval)
+	}
+	if err := t.db().Model(&InventoryCheckSchedule{}).Where("inventory_id = ?", inv.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update check schedule of inventory %s: %v", inv.Name, err)
+	}
+}
+
+// recordHostCheckStates сохраняет итоги проверки по хостам и считает флапы
+func recordHostCheckStates(t *tenant, inventoryID uint, results JSONMap, now time.Time) error {
+	if len(results) == 0 {
+		return nil
+	}
+	hosts := make([]string, 0, len(results))
+	for host := range results {
+		hosts = append(hosts, host)
+	}
+	var known []HostCheckState
+	if err := t.primaryDB().Where("inventory_id = ? AND host IN ?", inventoryID, hosts).Find(&known).Error; err != nil {
+		return err
+	}
+	previous := make(map[string]HostCheckState, len(known))
+	for _, state := range known {
+		previous[state.Host] = state
+	}
+
+	states := make([]HostCheckState, 0, len(results))
+	for host, status := range results {
+		state, ok := previous[host]
+		if !ok {
+			state = HostCheckState{InventoryID: inventoryID, Host: host, Status: status, ChangedAt: now}
+		}
+		if ok && state.Status != status {
+			if state.Status == HostReachable && status == HostUnreachable {
+				state.Flaps++
+			}
+			state.Status, state.ChangedAt = status, now
+		}
+		state.CheckedAt = now
+		states = append(states, state)
+	}
+	return t.db().Clauses(clause.OnConflict{UpdateAll: true}).Create(&states).Error
+}
+
+// InventoryCheckScheduleResponse - состояние регулярной проверки инвентаря
+type InventoryCheckScheduleResponse struct {
+	Inventory string `json:"inventory"`
+	InventoryCheckSchedule
+	Interval            string           `json:"interval"`
+	UnreachableInterval string           `json:"unreachable_interval"`
+	Hosts               []HostCheckState `json:"hosts"`
+}
+
+func checkScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	inv, ok := loadInventory(w, r, mux.Vars(r)["name"], false)
+	if !ok {
+		return
+	}
+	t := tenantOf(r)
+	var schedule *checkSchedule
+	for i := range checkSchedules {
+		s := &checkSchedules[i]
+		if matched, _ := path.Match(s.Inventory, inv.Name); matched && s.tenant == t {
+			schedule = s
+			break
+		}
+	}
+	if schedule == nil {
+		http.Error(w, "Inventory has no check schedule", http.StatusNotFound)
+		return
+	}
+
+	response := InventoryCheckScheduleResponse{
+		Inventory:           inv.Name,
+		Interval:            schedule.Interval.String(),
+		UnreachableInterval: schedule.UnreachableInterval.String(),
+		Hosts:               []HostCheckState{},
+	}
+	var states []InventoryCheckSchedule
+	if err := t.db().Where("inventory_id = ?", inv.ID).Limit(1).Find(&states).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if len(states) > 0 {
+		response.InventoryCheckSchedule = states[0]
+	}
+	if err := t.db().Where("inventory_id = ?", inv.ID).Order("host").Find(&response.Hosts).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,137 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+)
+
+type CheckMode string
+
+const (
+	// Только доступность хоста (ansible.builtin.ping)
+	CheckModePing CheckMode = "ping"
+	// Сбор фактов и сохранение краткой сводки по ОС и адресу
+	CheckModeFacts CheckMode = "facts"
+	// Произвольный модуль из списка checks.allowed_modules
+	CheckModeModule CheckMode = "module"
+)
+
+// CheckRequest - необязательное тело POST /api/inventories/{name}/check
+type CheckRequest struct {
+	Mode       CheckMode              `json:"mode"`
+	Module     string                 `json:"module,omitempty"`
+	ModuleArgs map[string]interface{} `json:"module_args,omitempty"`
+	// Таймаут в секундах, по умолчанию берется из настроек режима
+	Timeout int `json:"timeout,omitempty"`
+	// Значения подстановок {{ .name }} в содержимом инвентаря
+	Params map[string]string `json:"params,omitempty"`
+	// Группа или шаблон хостов для --limit, например db; по умолчанию проверяются все хосты
+	Limit string `json:"limit,omitempty"`
+}
+
+var moduleNameRe = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+func (req *CheckRequest) validate() error {
+	if req.Mode == "" {
+		req.Mode = CheckModePing
+	}
+	switch req.Mode {
+	case CheckModePing, CheckModeFacts:
+		if req.Module != "" || len(req.ModuleArgs) > 0 {
+			return fmt.Errorf("module and module_args are only allowed in %s mode", CheckModeModule)
+		}
+	case CheckModeModule:
+		if !moduleNameRe.MatchString(req.Module) {
+			return fmt.Errorf("invalid module name %q", req.Module)
+		}
+		if !slices.Contains(cfg.Checks.AllowedModules, req.Module) {
+			return fmt.Errorf("module %q is not allowed for checks", req.Module)
+		}
+	default:
+		return fmt.Errorf("unknown check mode %q", req.Mode)
+	}
+	if req.Timeout < 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return validateLimit(req.Limit)
+}
+
+func (req CheckRequest) timeout() time.Duration {
+	if req.Timeout > 0 {
+		return time.Duration(req.Timeout) * time.Second
+	}
+	switch req.Mode {
+	case CheckModeFacts:
+		return cfg.Checks.FactsTimeout
+	case CheckModeModule:
+		return cfg.Checks.ModuleTimeout
+	default:
+		return cfg.Checks.PingTimeout
+	}
+}
+
+// module - модуль, который вызывает проверка
+func (req CheckRequest) module() string {
+	switch req.Mode {
+	case CheckModeFacts:
+		return "ansible.builtin.setup"
+	case CheckModeModule:
+		return req.Module
+	default:
+		return "ansible.builtin.ping"
+	}
+}
+
+// checkPlaybook строит временный playbook проверки из одной задачи
+// connectivityTask; статус хоста определяется по ее результату в JSON-выводе.
+func checkPlaybook(req CheckRequest) (string, error) {
+	var task string
+	switch req.Mode {
+	case CheckModeFacts:
+		task = "ansible.builtin.setup:\n        gather_subset: [\"!all\", \"network\", \"distribution\"]"
+	case CheckModeModule:
+		// JSON - подмножество YAML, поэтому аргументы безопасно подставляются как есть
+		args, err := json.Marshal(req.ModuleArgs)
+		if err != nil {
+			return "", fmt.Errorf("invalid module_args: %v", err)
+		}
+		if req.ModuleArgs == nil {
+			args = []byte("{}")
+		}
+		task = fmt.Sprintf("%s: %s", req.Module, args)
+	default:
+		task = "ansible.builtin.ping:"
+	}
+
+	return fmt.Sprintf(`---
+- hosts: all
+  gather_facts: no
+  tasks:
+    - name: %s
+      %s
+`, connectivityTask, task), nil
+}
+
+// HostFacts - сводка фактов по хостам, собранная в режиме facts
+type HostFacts map[string]map[string]string
+
+func (f *HostFacts) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("value is not []byte")
+	}
+	return json.Unmarshal(b, &f)
+}
+
+func (f HostFacts) Value() (interface{}, error) {
+	if f == nil {
+		return nil, nil
+	}
+	return json.Marshal(f)
+}
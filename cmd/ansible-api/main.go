@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	ansibleapi "ansible-api"
+	"ansible-api/config"
+)
+
+func main() {
+	agent := flag.Bool("agent", false, "run as an agent that executes runs for the server in agent.server_url")
+	profile := flag.String("profile", os.Getenv("CONFIG_PROFILE"), "config profile (config.<profile>.yml) to layer over config.yml, default $CONFIG_PROFILE")
+	doctor := flag.Bool("doctor", false, "check the installation, print a report and exit (status 1 if a check failed)")
+	flag.Parse()
+
+	cfg, err := config.LoadProfile(*profile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if *doctor {
+		if !ansibleapi.RunDoctor(cfg, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+	if *agent {
+		// По SIGINT и SIGTERM агент дожидается текущего запуска
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			// Повторный сигнал завершает процесс сразу
+			stop()
+		}()
+		if err := ansibleapi.RunAgent(ctx, cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	srv, err := ansibleapi.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// По SIGINT и SIGTERM сервер дожидается выполняющихся запусков
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	// Повторный сигнал завершает процесс сразу
+	stop()
+
+	log.Printf("Shutting down, waiting up to %s for active runs", cfg.Server.ShutdownGrace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("Shutdown: %v", err)
+	}
+	log.Printf("Server stopped")
+}
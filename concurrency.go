@@ -0,0 +1,163 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// Сколько точек ряда отдавать по умолчанию и сколько максимум
+	concurrencyDefaultPoints = 200
+	concurrencyMaxPoints     = 2000
+)
+
+// ConcurrencyPoint - число одновременно выполнявшихся запусков на интервале [time, time+step)
+type ConcurrencyPoint struct {
+	Time    time.Time `json:"time"`
+	Max     int       `json:"max"`
+	Average float64   `json:"average"`
+}
+
+type ConcurrencyResponse struct {
+	From   time.Time          `json:"from"`
+	To     time.Time          `json:"to"`
+	Step   string             `json:"step"`
+	Node   string             `json:"node,omitempty"`
+	Peak   int                `json:"peak"`
+	Points []ConcurrencyPoint `json:"points"`
+}
+
+// concurrencyEvent - начало (+1) или конец (-1) выполнения запуска
+type concurrencyEvent struct {
+	at    time.Time
+	delta int
+}
+
+// concurrencyHandler строит ряд числа одновременно выполнявшихся запусков
+// по start_time и end_time для планирования числа узлов-исполнителей
+func concurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	if value := parseTimeParam(query.Get("from")); value != nil {
+		from = *value
+	}
+	if value := parseTimeParam(query.Get("to")); value != nil {
+		to = *value
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	step := concurrencyStep(to.Sub(from))
+	if value := query.Get("step"); value != "" {
+		seconds, ok := parseDurationParam(value)
+		if !ok || seconds <= 0 {
+			http.Error(w, "Invalid step, expected seconds or duration like 5m", http.StatusBadRequest)
+			return
+		}
+		step = time.Duration(seconds * float64(time.Second))
+	}
+	if to.Sub(from)/step > concurrencyMaxPoints {
+		http.Error(w, "Too many points, increase step or narrow the range", http.StatusBadRequest)
+		return
+	}
+
+	// Выполнялись (не стояли в очереди) и пересекаются с [from, to). Запуск,
+	// отмененный в очереди, не забирался узлом и не имеет node_id.
+	runs := tenantOf(r).db().Model(&PlaybookRun{}).
+		Select("start_time, end_time, duration, status").
+		Where("start_time < ? AND (end_time IS NULL OR end_time > ?)", to, from).
+		Where("status <> ? AND NOT (status = ? AND node_id = '')", RunStatusQueued, RunStatusCancelled)
+	node := query.Get("node")
+	if node != "" {
+		runs = runs.Where("node_id = ?", node)
+	}
+	var intervals []PlaybookRun
+	if err := runs.Find(&intervals).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	response := ConcurrencyResponse{From: from, To: to, Step: step.String(), Node: node}
+	response.Points, response.Peak = concurrencySeries(intervals, from, to, step)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// concurrencyStep выбирает шаг не меньше минуты, дающий до concurrencyDefaultPoints точек
+func concurrencyStep(span time.Duration) time.Duration {
+	step := (span / concurrencyDefaultPoints).Round(time.Minute)
+	if step < time.Minute {
+		step = time.Minute
+	}
+	return step
+}
+
+func concurrencySeries(runs []PlaybookRun, from, to time.Time, step time.Duration) ([]ConcurrencyPoint, int) {
+	now := time.Now()
+	events := make([]concurrencyEvent, 0, 2*len(runs))
+	for _, run := range runs {
+		end := now
+		switch {
+		case run.EndTime != nil:
+			end = *run.EndTime
+		case run.Duration != nil:
+			end = run.StartTime.Add(time.Duration(*run.Duration * float64(time.Second)))
+		case run.Status != RunStatusStarted:
+			// Завершен без времени окончания - длительность неизвестна
+			continue
+		}
+		start := run.StartTime
+		if start.Before(from) {
+			start = from
+		}
+		if !start.Before(end) {
+			continue
+		}
+		events = append(events, concurrencyEvent{start, 1}, concurrencyEvent{end, -1})
+	}
+	// При совпадении времени сначала окончания: запуск, сменивший другой, не считается параллельным
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+
+	points := []ConcurrencyPoint{}
+	peak, level, i := 0, 0, 0
+	for start := from; start.Before(to); start = start.Add(step) {
+		end := start.Add(step)
+		if end.After(to) {
+			end = to
+		}
+		// Окончания ровно на границе интервала относятся к предыдущему
+		for i < len(events) && events[i].delta < 0 && !events[i].at.After(start) {
+			level--
+			i++
+		}
+
+		highest, area, at := level, 0.0, start
+		for i < len(events) && events[i].at.Before(end) {
+			area += float64(level) * events[i].at.Sub(at).Seconds()
+			at = events[i].at
+			level += events[i].delta
+			if level > highest {
+				highest = level
+			}
+			i++
+		}
+		area += float64(level) * end.Sub(at).Seconds()
+
+		if highest > peak {
+			peak = highest
+		}
+		points = append(points, ConcurrencyPoint{Time: start, Max: highest, Average: area / end.Sub(start).Seconds()})
+	}
+	return points, peak
+}
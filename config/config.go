@@ -4,16 +4,38 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Server   `yaml:"server"`
-	Database `yaml:"database"`
-	Logging  `yaml:"logging"`
-	Ansible  `yaml:"ansible"`
+	Server        `yaml:"server"`
+	Database      `yaml:"database"`
+	Logging       `yaml:"logging"`
+	Ansible       `yaml:"ansible"`
+	Watchdog      `yaml:"watchdog"`
+	Notifications `yaml:"notifications"`
+	Disk          `yaml:"disk"`
+	Hooks         `yaml:"hooks"`
+	Checks        `yaml:"checks"`
+	Auth          `yaml:"auth"`
+	Secrets       `yaml:"secrets"`
+	Jira          `yaml:"jira"`
+	Policy        `yaml:"policy"`
+	MetricsPush   `yaml:"metrics_push"`
+	Grafana       `yaml:"grafana"`
+	Agents        `yaml:"agents"`
+	Agent         `yaml:"agent"`
+	Retries       []RetryPolicy    `yaml:"retries"`
+	Rollbacks     []RollbackPolicy `yaml:"rollbacks"`
+	RunWindows    []RunWindow      `yaml:"run_windows"`
+	Tenants       []Tenant         `yaml:"tenants"`
+
+	// Профиль, переопределения которого наложены на конфигурацию
+	Profile string `yaml:"-"`
 }
 
 type Server struct {
@@ -21,6 +43,27 @@ type Server struct {
 	PlaybooksDir string        `yaml:"playbooks_dir" env:"PLAYBOOKS_DIR" env-default:"./playbooks"`
 	ReadTimeout  time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT" env-default:"10s"`
 	WriteTimeout time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT" env-default:"10s"`
+	NodeID       string        `yaml:"node_id" env:"NODE_ID"`
+	// Ограничения запросов: обычные маршруты и загрузка inventory
+	MaxBodyBytes   int64         `yaml:"max_body_bytes" env:"MAX_BODY_BYTES" env-default:"1048576"`
+	MaxUploadBytes int64         `yaml:"max_upload_bytes" env:"MAX_UPLOAD_BYTES" env-default:"33554432"`
+	HandlerTimeout time.Duration `yaml:"handler_timeout" env:"HANDLER_TIMEOUT" env-default:"30s"`
+	UploadTimeout  time.Duration `yaml:"upload_timeout" env:"UPLOAD_TIMEOUT" env-default:"2m"`
+	// Наибольшее ожидание завершения запуска в POST /api/run?wait=true
+	MaxWait time.Duration `yaml:"max_wait" env:"MAX_WAIT" env-default:"10m"`
+	// Отдавать вывод запуска потоком (text/plain) при wait=true и Accept: text/plain
+	StreamOutput bool `yaml:"stream_output" env:"STREAM_OUTPUT" env-default:"true"`
+	// Токен для административных операций (массовое удаление и т.п.), передается в X-Admin-Token
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+	// Внешний адрес API для ссылок на запуски в уведомлениях, например https://ansible-api.example.com
+	PublicURL string `yaml:"public_url" env:"PUBLIC_URL"`
+	// Адреса и сети (CIDR) reverse proxy, от которых принимается X-Forwarded-For
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" env-separator:","`
+	// Запуститься в режиме только для чтения, например на время обслуживания БД
+	ReadOnly bool `yaml:"read_only" env:"READ_ONLY" env-default:"false"`
+	// Сколько при остановке (SIGINT, SIGTERM) ждать завершения выполняющихся запусков,
+	// после чего они отменяются
+	ShutdownGrace time.Duration `yaml:"shutdown_grace" env:"SHUTDOWN_GRACE" env-default:"5m"`
 }
 
 type Database struct {
@@ -30,20 +73,197 @@ type Database struct {
 	Password string `yaml:"password" env:"DB_PASSWORD" env-default:"password"`
 	Name     string `yaml:"name" env:"DB_NAME" env-default:"ansible_logs"`
 	SSLMode  string `yaml:"ssl_mode" env:"DB_SSLMODE" env-default:"disable"`
+	// Схема арендатора по умолчанию
+	Schema string `yaml:"schema" env:"DB_SCHEMA" env-default:"ansible_api"`
+	// DSN реплики только для чтения: на нее уходят SELECT из GET-обработчиков и статистики
+	ReplicaDSN string `yaml:"replica_dsn" env:"DB_REPLICA_DSN"`
+	// Circuit breaker: сколько ошибок соединения подряд размыкают цепь и сколько ждать до пробы
+	BreakerThreshold int           `yaml:"breaker_threshold" env:"DB_BREAKER_THRESHOLD" env-default:"5"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown" env:"DB_BREAKER_COOLDOWN" env-default:"10s"`
+	// Сколько обновлений статусов запусков держать в памяти, пока БД недоступна
+	MaxPendingWrites int `yaml:"max_pending_writes" env:"DB_MAX_PENDING_WRITES" env-default:"1000"`
+	// Пул соединений, для основной БД и реплики отдельно
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" env-default:"25"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" env-default:"25"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" env-default:"5m"`
+	// Наибольшая длительность SELECT (отмена через context); 0 - без ограничения
+	QueryTimeout time.Duration `yaml:"query_timeout" env:"DB_QUERY_TIMEOUT" env-default:"1m"`
+	// statement_timeout сессий основной БД для всех запросов, включая миграции и
+	// очистку; 0 - по умолчанию сервера PostgreSQL
+	StatementTimeout time.Duration `yaml:"statement_timeout" env:"DB_STATEMENT_TIMEOUT" env-default:"0"`
 }
 
 type Logging struct {
 	RetentionDays int `yaml:"retention_days" env:"LOG_RETENTION_DAYS" env-default:"30"`
 	PageSize      int `yaml:"page_size" env:"LOG_PAGE_SIZE" env-default:"20"`
+	MaxPageSize   int `yaml:"max_page_size" env:"LOG_MAX_PAGE_SIZE" env-default:"200"`
+	// Сколько дней хранить вывод и ошибки запусков; 0 - столько же, сколько сами запуски
+	OutputRetentionDays int `yaml:"output_retention_days" env:"LOG_OUTPUT_RETENTION_DAYS" env-default:"0"`
+	// Строки вывода и события пишутся в БД пачками
+	OutputBatchSize int `yaml:"output_batch_size" env:"LOG_OUTPUT_BATCH_SIZE" env-default:"200"`
+	// Сколько строк одного запуска держать в памяти, пока БД недоступна
+	OutputBufferLines int `yaml:"output_buffer_lines" env:"LOG_OUTPUT_BUFFER_LINES" env-default:"5000"`
+	// Как часто сбрасывать накопленный вывод незавершенного запуска
+	OutputFlushInterval time.Duration `yaml:"output_flush_interval" env:"LOG_OUTPUT_FLUSH_INTERVAL" env-default:"2s"`
+	// Журнал SQL: silent, error, warn (ошибки и медленные запросы) или info (все запросы)
+	SQLLevel string `yaml:"sql_level" env:"LOG_SQL_LEVEL" env-default:"warn"`
+	// Запросы дольше этого пишутся в журнал как медленные (при sql_level warn и info)
+	SQLSlowThreshold time.Duration `yaml:"sql_slow_threshold" env:"LOG_SQL_SLOW_THRESHOLD" env-default:"1s"`
 }
 
 type Ansible struct {
 	Timeout       int    `yaml:"timeout" env:"ANSIBLE_TIMEOUT" env-default:"3600"`
 	DefaultPython string `yaml:"default_python" env:"ANSIBLE_PYTHON" env-default:"/usr/bin/python3"`
+	// Перезапускать помеченные restart_safe запуски, прерванные падением сервера
+	RelaunchInterrupted bool `yaml:"relaunch_interrupted" env:"ANSIBLE_RELAUNCH_INTERRUPTED" env-default:"true"`
+	// Перед запуском считать задачи через --list-tasks для отображения прогресса
+	CountTasks bool `yaml:"count_tasks" env:"ANSIBLE_COUNT_TASKS" env-default:"true"`
+	// Файл с паролем ansible-vault, передается в --vault-password-file
+	VaultPasswordFile string         `yaml:"vault_password_file" env:"ANSIBLE_VAULT_PASSWORD_FILE"`
+	Limits            ResourceLimits `yaml:"limits"`
+	// Установки ansible разных версий; выбираются полем ansible запроса или по шаблону playbook
+	Installations []AnsibleInstallation `yaml:"installations"`
+	// Установка для остальных запусков; пустая - ansible из PATH сервера
+	DefaultInstallation string `yaml:"default_installation" env:"ANSIBLE_DEFAULT_INSTALLATION"`
+	// Узлы, на которых ansible-playbook выполняется по SSH; выбираются полем runner запроса или по шаблону playbook
+	Runners []RemoteRunner `yaml:"runners"`
+	// Флаги ansible-playbook, разрешенные в extra_args запроса. Флаг со значением
+	// задается с "=" на конце ("--tags=") и принимается только в виде --tags=value.
+	AllowedExtraArgs []string `yaml:"allowed_extra_args" env:"ANSIBLE_ALLOWED_EXTRA_ARGS" env-separator:"," env-default:"--flush-cache,--force-handlers,--diff,-v,-vv,-vvv"`
+
+	// Коллекции и роли, которые использует playbook, но которых нет в каталогах
+	// проекта (collections, roles), этих путях и virtualenv установки: off - не
+	// проверять, warn - ставить запуск с missing_dependencies, refuse - отклонять
+	MissingDependencies string   `yaml:"missing_dependencies" env:"ANSIBLE_MISSING_DEPENDENCIES" env-default:"warn"`
+	CollectionsPaths    []string `yaml:"collections_paths" env:"ANSIBLE_COLLECTIONS_PATHS" env-separator:":" env-default:"~/.ansible/collections:/usr/share/ansible/collections"`
+	RolesPaths          []string `yaml:"roles_paths" env:"ANSIBLE_ROLES_PATH" env-separator:":" env-default:"~/.ansible/roles:/usr/share/ansible/roles:/etc/ansible/roles"`
+}
+
+// AnsibleInstallation - каталог с ansible-playbook или корень virtualenv
+type AnsibleInstallation struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+	// Шаблоны имен playbook (как в path.Match), которые выполняются этой установкой
+	Playbooks []string `yaml:"playbooks"`
+	// Образ execution environment: запуски выполняются через ansible-navigator из path
+	// (или PATH) в контейнере container_engine (auto, podman, docker)
+	ExecutionEnvironment string `yaml:"execution_environment"`
+	ContainerEngine      string `yaml:"container_engine"`
+	// Когда скачивать образ: always, missing (по умолчанию), never, tag
+	PullPolicy string `yaml:"pull_policy"`
+}
+
+// RemoteRunner - узел (например, jump-хост), на который перед запуском копируются
+// playbooks и инвентарь и где выполняется ansible-playbook. Используется системный ssh/scp.
+type RemoteRunner struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	User string `yaml:"user"`
+	// Ключ SSH; пустой - ключи по умолчанию и ssh-agent
+	IdentityFile string `yaml:"identity_file"`
+	// Файл known_hosts для проверки ключа узла; пустой - ~/.ssh/known_hosts
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	// Дополнительные опции ssh (-o), например ProxyJump=bastion
+	SSHOptions []string `yaml:"ssh_options"`
+	// Абсолютный каталог на узле, в котором создаются рабочие каталоги запусков
+	WorkDir string `yaml:"work_dir"`
+	// ansible-playbook на узле; пустой - из PATH
+	AnsiblePlaybook string `yaml:"ansible_playbook"`
+	// Шаблоны имен playbook (как в path.Match), которые выполняются на этом узле
+	Playbooks []string `yaml:"playbooks"`
+	// Метки для выбора узла по runner_selector запроса, например zone: dmz
+	Labels map[string]string `yaml:"labels"`
 }
 
+// ResourceLimits ограничивает процессы ansible, чтобы они не отнимали ресурсы у API
+type ResourceLimits struct {
+	Nice       int    `yaml:"nice" env:"ANSIBLE_NICE" env-default:"0"`
+	IOClass    string `yaml:"io_class" env:"ANSIBLE_IO_CLASS"` // idle, best-effort или realtime
+	IOPriority int    `yaml:"io_priority" env:"ANSIBLE_IO_PRIORITY" env-default:"4"`
+	// Родительская cgroup (v2), в которой для каждого запуска создается дочерняя
+	CgroupRoot string `yaml:"cgroup_root" env:"ANSIBLE_CGROUP_ROOT"`
+	MemoryMax  string `yaml:"memory_max" env:"ANSIBLE_MEMORY_MAX"` // значение memory.max, например "2G"
+	CPUMax     string `yaml:"cpu_max" env:"ANSIBLE_CPU_MAX"`       // значение cpu.max, например "50000 100000"
+}
+
+type Watchdog struct {
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env:"WATCHDOG_HEARTBEAT_INTERVAL" env-default:"15s"`
+	StaleAfter        time.Duration `yaml:"stale_after" env:"WATCHDOG_STALE_AFTER" env-default:"2m"`
+	// Убивать процесс ansible потерянного запуска, если он запущен на этом узле
+	KillOrphans bool `yaml:"kill_orphans" env:"WATCHDOG_KILL_ORPHANS" env-default:"false"`
+	// Критичные запуски по расписанию внешнего планировщика, отсутствие которых нужно заметить
+	Schedules []ExpectedSchedule `yaml:"schedules"`
+}
+
+// ExpectedSchedule - playbook, который должен успешно выполняться по расписанию.
+// Запуск должен быть поставлен в пределах tolerance от ожидаемого времени и
+// успешно завершиться до истечения tolerance после него.
+type ExpectedSchedule struct {
+	Name string `yaml:"name"`
+	// Арендатор; пустой - арендатор по умолчанию
+	Tenant    string `yaml:"tenant"`
+	Playbook  string `yaml:"playbook"`
+	Inventory string `yaml:"inventory"`
+	// Cron-выражение из 5 полей или @daily, @every 1h; часовой пояс - CRON_TZ=Europe/Moscow в начале
+	Schedule  string        `yaml:"schedule"`
+	Tolerance time.Duration `yaml:"tolerance"`
+}
+
+type Notifications struct {
+	WebhookURL string        `yaml:"webhook_url" env:"NOTIFY_WEBHOOK_URL"`
+	Timeout    time.Duration `yaml:"timeout" env:"NOTIFY_TIMEOUT" env-default:"10s"`
+}
+
+type Disk struct {
+	// Каталог для временных inventory и playbook, по умолчанию системный
+	TempDir string `yaml:"temp_dir" env:"TEMP_DIR"`
+	// Дополнительные каталоги, свободное место в которых проверяется перед запуском
+	WatchDirs      []string      `yaml:"watch_dirs" env:"DISK_WATCH_DIRS" env-separator:","`
+	MinFreeMB      uint64        `yaml:"min_free_mb" env:"DISK_MIN_FREE_MB" env-default:"512"`
+	LowSpaceAction string        `yaml:"low_space_action" env:"DISK_LOW_SPACE_ACTION" env-default:"refuse"` // refuse или warn
+	TempFileMaxAge time.Duration `yaml:"temp_file_max_age" env:"DISK_TEMP_FILE_MAX_AGE" env-default:"24h"`
+	// Переиспользовать файл инвентаря запусками с тем же содержимым вместо нового на каждый запуск
+	InventoryCache bool `yaml:"inventory_cache" env:"DISK_INVENTORY_CACHE" env-default:"true"`
+	// Каталог рабочих каталогов запусков (<work_dir>/<арендатор>/<id>); пустой - не создаются
+	WorkDir string `yaml:"work_dir" env:"DISK_WORK_DIR"`
+	// Через сколько после завершения запуска его каталог сжимается в tar.zst
+	WorkDirCompressAfter time.Duration `yaml:"work_dir_compress_after" env:"DISK_WORK_DIR_COMPRESS_AFTER" env-default:"24h"`
+	// Через сколько после завершения запуска удаляются его каталог и архив
+	WorkDirRetention time.Duration `yaml:"work_dir_retention" env:"DISK_WORK_DIR_RETENTION" env-default:"720h"`
+}
+
+// Hooks вызываются до запуска (могут запретить его) и после завершения
+type Hooks struct {
+	Pre  []Hook `yaml:"pre"`
+	Post []Hook `yaml:"post"`
+}
+
+// Hook - внешняя команда (получает JSON запуска в stdin) или HTTP endpoint (получает его в теле POST)
+type Hook struct {
+	Name    string        `yaml:"name"`
+	Command []string      `yaml:"command"`
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Имя профиля становится частью имени файла config.<profile>.yml
+var profileRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Load загружает конфигурацию с профилем из переменной CONFIG_PROFILE
 func Load() (*Config, error) {
+	return LoadProfile(os.Getenv("CONFIG_PROFILE"))
+}
+
+// LoadProfile загружает конфигурацию и накладывает на нее профиль окружения
+// (dev, stage, prod): файл config.<profile>.yml рядом с config.yml. В файле
+// профиля указываются только отличающиеся ключи; списки заменяются целиком.
+// Переменные окружения важнее и конфигурации, и профиля.
+func LoadProfile(profile string) (*Config, error) {
 	cfg := &Config{}
+	if profile != "" && !profileRe.MatchString(profile) {
+		return nil, fmt.Errorf("invalid config profile %q: only letters, digits, _ and - are allowed", profile)
+	}
 
 	configPaths := []string{
 		"./config.yml",
@@ -67,13 +287,302 @@ func Load() (*Config, error) {
 		log.Println("Config file not found, using environment variables only")
 	}
 
+	if profile != "" {
+		profileFile, err := findProfile(configFile, configPaths, profile)
+		if err != nil {
+			return nil, err
+		}
+		if err := cleanenv.ReadConfig(profileFile, cfg); err != nil {
+			return nil, fmt.Errorf("config profile %s error: %v", profile, err)
+		}
+		cfg.Profile = profile
+		log.Printf("Using config profile %s from %s", profile, profileFile)
+	}
+
 	if err := cleanenv.ReadEnv(cfg); err != nil {
 		return nil, err
 	}
 
+	if cfg.Server.NodeID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine node id: %v", err)
+		}
+		cfg.Server.NodeID = hostname
+	}
+
 	if err := os.MkdirAll(cfg.Server.PlaybooksDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create playbooks directory: %v", err)
 	}
 
+	if cfg.Disk.TempDir == "" {
+		cfg.Disk.TempDir = os.TempDir()
+	}
+	if err := os.MkdirAll(cfg.Disk.TempDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	if cfg.Disk.WorkDir != "" {
+		if cfg.Disk.WorkDirRetention < cfg.Disk.WorkDirCompressAfter {
+			return nil, fmt.Errorf("disk.work_dir_retention must not be shorter than disk.work_dir_compress_after")
+		}
+		if err := os.MkdirAll(cfg.Disk.WorkDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create work directory: %v", err)
+		}
+	}
+
 	return cfg, nil
 }
+
+// findProfile ищет config.<profile>.yml в каталоге найденного config.yml, а
+// без него - в тех же каталогах, что и config.yml. Выбранный профиль без
+// файла - ошибка: иначе сервер молча запустится с настройками другого окружения.
+func findProfile(configFile string, configPaths []string, profile string) (string, error) {
+	name := "config." + profile + ".yml"
+	dirs := []string{filepath.Dir(configFile)}
+	if configFile == "" {
+		dirs = dirs[:0]
+		for _, path := range configPaths {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("config profile %s: %s not found", profile, name)
+}
+
+// Checks - проверки доступности хостов инвентаря
+type Checks struct {
+	PingTimeout   time.Duration `yaml:"ping_timeout" env:"CHECK_PING_TIMEOUT" env-default:"2m"`
+	FactsTimeout  time.Duration `yaml:"facts_timeout" env:"CHECK_FACTS_TIMEOUT" env-default:"5m"`
+	ModuleTimeout time.Duration `yaml:"module_timeout" env:"CHECK_MODULE_TIMEOUT" env-default:"10m"`
+	// Callback с построчным JSON-выводом, по которому разбираются результаты
+	Callback string `yaml:"callback" env:"CHECK_CALLBACK" env-default:"ansible.posix.jsonl"`
+	// Модули, разрешенные в режиме module; пустой список отключает режим
+	AllowedModules []string `yaml:"allowed_modules" env:"CHECK_ALLOWED_MODULES" env-separator:","`
+	// Возраст проверки, достаточный для preflight запуска, если max_age не задан в запросе
+	PreflightMaxAge time.Duration `yaml:"preflight_max_age" env:"CHECK_PREFLIGHT_MAX_AGE" env-default:"15m"`
+	// Регулярные проверки инвентарей; недоступные хосты перепроверяются чаще
+	Schedules []CheckSchedule `yaml:"schedules"`
+}
+
+// CheckSchedule - регулярная проверка (ping) инвентарей по шаблону имени
+type CheckSchedule struct {
+	// Арендатор инвентарей; пустой - арендатор по умолчанию
+	Tenant string `yaml:"tenant"`
+	// Шаблон имени инвентаря (как в path.Match)
+	Inventory string        `yaml:"inventory"`
+	Interval  time.Duration `yaml:"interval"`
+	// Интервал перепроверки недоступных хостов, не больше interval; по умолчанию 1m
+	UnreachableInterval time.Duration `yaml:"unreachable_interval"`
+}
+
+// Auth - токены клиентов API. Пока список пуст, права доступа не проверяются.
+type Auth struct {
+	Tokens []APIToken `yaml:"tokens"`
+	// Ключ подписи ссылок на запуски (POST /api/runs/{id}/share), одинаковый на всех
+	// узлах; пустой - ссылки выключены. Смена ключа отзывает все выданные ссылки.
+	ShareSecret string `yaml:"share_secret" env:"AUTH_SHARE_SECRET"`
+	// Срок действия ссылки по умолчанию и наибольший
+	ShareTTL    time.Duration `yaml:"share_ttl" env:"AUTH_SHARE_TTL" env-default:"24h"`
+	ShareMaxTTL time.Duration `yaml:"share_max_ttl" env:"AUTH_SHARE_MAX_TTL" env-default:"168h"`
+	// Наибольшее расхождение X-Signature-Timestamp подписанного запроса с временем сервера
+	SignatureMaxSkew time.Duration `yaml:"signature_max_skew" env:"AUTH_SIGNATURE_MAX_SKEW" env-default:"5m"`
+	// Ключ подписи JWT пользователей (POST /api/auth/login), одинаковый на всех узлах;
+	// пустой - вход выключен. Смена ключа завершает все сеансы.
+	JWTSecret string        `yaml:"jwt_secret" env:"AUTH_JWT_SECRET"`
+	JWTTTL    time.Duration `yaml:"jwt_ttl" env:"AUTH_JWT_TTL" env-default:"12h"`
+	// Отклонять запросы без JWT, токена из tokens или X-Admin-Token
+	RequireAuth bool `yaml:"require_auth" env:"AUTH_REQUIRE"`
+	// Проверять роли (viewer, operator, admin) из /api/role-bindings
+	RBAC bool `yaml:"rbac" env:"AUTH_RBAC"`
+}
+
+// APIToken передается в заголовке Authorization: Bearer <token> или X-API-Token
+type APIToken struct {
+	Name  string `yaml:"name"`
+	Team  string `yaml:"team"`
+	Token string `yaml:"token"`
+	// Арендатор, к данным которого дает доступ токен; пустой - арендатор по умолчанию
+	Tenant string `yaml:"tenant"`
+	// Ключ HMAC: запуски по токену принимаются только с подписью запроса (X-Signature)
+	SigningSecret string `yaml:"signing_secret"`
+}
+
+// Secrets - значения, которые маскируются в сохраненном и транслируемом выводе запусков
+type Secrets struct {
+	// Шаблоны имен extra_vars (как в path.Match, без учета регистра), значения которых секретны
+	SensitiveKeys []string `yaml:"sensitive_keys" env:"SECRETS_SENSITIVE_KEYS" env-separator:"," env-default:"*password*,*passwd*,*secret*,*token*,*api_key*"`
+	// Ключ AES-256 в base64 для хранения sensitive_vars запусков
+	EncryptionKey string `yaml:"encryption_key" env:"SECRETS_ENCRYPTION_KEY"`
+	// Переменные окружения сервера, значения которых нельзя показывать
+	EnvVars []string `yaml:"env_vars" env:"SECRETS_ENV_VARS" env-separator:","`
+}
+
+// Jira - заведение задач при падении критичных playbooks
+type Jira struct {
+	URL string `yaml:"url" env:"JIRA_URL"`
+	// Пользователь и API-токен; без пользователя токен передается как Bearer (personal access token)
+	User      string        `yaml:"user" env:"JIRA_USER"`
+	Token     string        `yaml:"token" env:"JIRA_TOKEN"`
+	Timeout   time.Duration `yaml:"timeout" env:"JIRA_TIMEOUT" env-default:"10s"`
+	Project   string        `yaml:"project" env:"JIRA_PROJECT"`
+	IssueType string        `yaml:"issue_type" env:"JIRA_ISSUE_TYPE" env-default:"Bug"`
+	// Критичные playbooks; задача заводится только при их падении
+	Critical []JiraMapping `yaml:"critical"`
+}
+
+// JiraMapping - шаблон имени playbook (как в path.Match) и куда заводить задачи по нему;
+// пустые project и issue_type берутся из общих настроек
+type JiraMapping struct {
+	Playbook  string `yaml:"playbook"`
+	Project   string `yaml:"project"`
+	IssueType string `yaml:"issue_type"`
+}
+
+// Policy - правила допуска запусков и ad-hoc вызовов модулей. Запрос
+// отклоняется первым правилом, все условия которого выполнены.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+	// Внешний OPA, спрашивается после локальных правил
+	OPA OPA `yaml:"opa"`
+}
+
+// OPA - решение о допуске через Data API: POST {url} с {"input": ...}. Ответ
+// result - bool, {"allow": bool, "message": "..."} или {"deny": ["..."]}.
+type OPA struct {
+	// Например http://opa:8181/v1/data/ansible/admission; пустой - OPA не используется
+	URL     string        `yaml:"url" env:"OPA_URL"`
+	Token   string        `yaml:"token" env:"OPA_TOKEN"`
+	Timeout time.Duration `yaml:"timeout" env:"OPA_TIMEOUT" env-default:"2s"`
+	// Сколько хранить решение для одинаковых запросов (без учета времени запроса)
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"OPA_CACHE_TTL" env-default:"30s"`
+	// Пропускать запросы, если OPA недоступен; по умолчанию отвечать 503
+	FailOpen bool `yaml:"fail_open" env:"OPA_FAIL_OPEN" env-default:"false"`
+}
+
+// MetricsPush - отправка метрик каждого завершенного запуска для окружений,
+// где /metrics не опрашивается
+type MetricsPush struct {
+	// Адрес Pushgateway или, для format: import, URL импорта в текстовом формате,
+	// например http://victoria:8428/api/v1/import/prometheus; пустой - не отправлять
+	URL      string        `yaml:"url" env:"METRICS_PUSH_URL"`
+	Format   string        `yaml:"format" env:"METRICS_PUSH_FORMAT" env-default:"pushgateway"` // pushgateway или import
+	Job      string        `yaml:"job" env:"METRICS_PUSH_JOB" env-default:"ansible_api"`
+	User     string        `yaml:"user" env:"METRICS_PUSH_USER"`
+	Password string        `yaml:"password" env:"METRICS_PUSH_PASSWORD"`
+	Timeout  time.Duration `yaml:"timeout" env:"METRICS_PUSH_TIMEOUT" env-default:"10s"`
+	// Метки, добавляемые ко всем метрикам, например environment: prod
+	Labels map[string]string `yaml:"labels" env:"METRICS_PUSH_LABELS" env-separator:","`
+}
+
+// Grafana - аннотации на дашбордах о завершенных запусках
+type Grafana struct {
+	URL string `yaml:"url" env:"GRAFANA_URL"`
+	// Токен сервисного аккаунта с правом annotations:write
+	Token   string        `yaml:"token" env:"GRAFANA_TOKEN"`
+	Timeout time.Duration `yaml:"timeout" env:"GRAFANA_TIMEOUT" env-default:"10s"`
+	// Аннотировать только запуски этих playbook и инвентарей (шаблоны path.Match); пустой список - все
+	Playbooks   []string `yaml:"playbooks" env:"GRAFANA_PLAYBOOKS" env-separator:","`
+	Inventories []string `yaml:"inventories" env:"GRAFANA_INVENTORIES" env-separator:","`
+	// Дополнительные теги каждой аннотации
+	Tags []string `yaml:"tags" env:"GRAFANA_TAGS" env-separator:","`
+	// Дашборд и панель; без них аннотация организационная и видна на всех дашбордах с запросом по тегам
+	DashboardUID string `yaml:"dashboard_uid" env:"GRAFANA_DASHBOARD_UID"`
+	PanelID      int    `yaml:"panel_id" env:"GRAFANA_PANEL_ID"`
+}
+
+// Agents - прием агентов (ansible-api --agent), выполняющих запуски в изолированных сетях
+type Agents struct {
+	// Общий токен агентов, передается в X-Agent-Token; пустой - агенты не принимаются
+	Token string `yaml:"token" env:"AGENTS_TOKEN"`
+	// Агент считается в сети, если обращался к серверу не раньше этого
+	OfflineAfter time.Duration `yaml:"offline_after" env:"AGENTS_OFFLINE_AFTER" env-default:"1m"`
+}
+
+// Agent - настройки процесса, запущенного в режиме агента
+type Agent struct {
+	// Адрес сервера API, например https://ansible-api.example.com
+	ServerURL string `yaml:"server_url" env:"AGENT_SERVER_URL"`
+	Token     string `yaml:"token" env:"AGENT_TOKEN"`
+	// Имя агента, по которому на него направляются запуски; пустое - server.node_id
+	Name         string        `yaml:"name" env:"AGENT_NAME"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"AGENT_POLL_INTERVAL" env-default:"5s"`
+	// Протокол обмена с сервером: http (API /api/agents) или grpc (сервис агентов по HTTP/2)
+	Transport string `yaml:"transport" env:"AGENT_TRANSPORT" env-default:"http"`
+	// Метки для выбора агента по runner_selector запроса, например zone: dmz, has: vmware-sdk
+	Labels map[string]string `yaml:"labels" env:"AGENT_LABELS" env-separator:","`
+}
+
+// RetryPolicy - автоматический повтор упавших (failed, timed_out) запусков playbook
+type RetryPolicy struct {
+	// Шаблон имени playbook (как в path.Match); применяется первая подходящая политика
+	Playbook string `yaml:"playbook"`
+	// Сколько повторов после первой попытки
+	Count int `yaml:"count"`
+	// Пауза перед первым повтором, дальше она удваивается, но не больше max_backoff
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// Повторять, только если ошибка или вывод запуска подходят под регулярное выражение
+	OnError string `yaml:"on_error"`
+}
+
+// RollbackPolicy - автоматический откат упавших (failed, timed_out) запусков playbook
+type RollbackPolicy struct {
+	// Шаблон имени playbook (как в path.Match); применяется первая подходящая политика
+	Playbook string `yaml:"playbook"`
+	// Playbook отката; выполняется с теми же inventory, limit, хостами и extra_vars
+	Rollback string `yaml:"rollback"`
+	// Откатывать, только если запуск дошел до задачи с именем, подходящим под регулярное выражение
+	AfterTask string `yaml:"after_task"`
+	// Ставить откат только после POST /api/runs/{id}/rollback
+	RequireApproval bool `yaml:"require_approval"`
+}
+
+// RunWindow - когда и как часто можно запускать playbook; проверяется при постановке в очередь
+type RunWindow struct {
+	// Шаблон имени playbook (как в path.Match); применяется первое подходящее ограничение
+	Playbook string `yaml:"playbook"`
+	// Запуск принимается только в этом окне, например from: "22:00", to: "06:00"
+	Window *TimeWindow `yaml:"window"`
+	// Наименьший интервал между запусками playbook
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// PolicyRule - запрещающее правило. Пустые условия не проверяются.
+type PolicyRule struct {
+	Name    string `yaml:"name"`
+	Message string `yaml:"message"`
+	// К чему применяется правило: run (запуск playbook) и/или check (проверка инвентаря); по умолчанию run
+	Actions []string `yaml:"actions"`
+	// Шаблоны имен (как в path.Match)
+	Playbooks   []string `yaml:"playbooks"`
+	Inventories []string `yaml:"inventories"`
+	// Модули проверок, например ansible.builtin.shell
+	Modules []string `yaml:"modules"`
+	// Правило срабатывает, если в extra_vars есть переменная с подходящим именем
+	ExtraVars []string `yaml:"extra_vars"`
+	// ...или значение какой-либо переменной подходит под регулярное выражение
+	ExtraVarValues string `yaml:"extra_var_values"`
+	// Правило срабатывает только вне этого окна, например вне рабочего времени
+	OutsideHours *TimeWindow `yaml:"outside_hours"`
+}
+
+// TimeWindow - дни недели (mon..sun) и интервал времени HH:MM-HH:MM в часовом поясе timezone
+type TimeWindow struct {
+	Days     []string `yaml:"days"`
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	Timezone string   `yaml:"timezone"`
+}
+
+// Tenant - изолированный арендатор: своя схема БД и свой каталог playbooks.
+// Пустая schema - <database.schema>_<name>, пустой playbooks_dir - <server.playbooks_dir>/<name>.
+type Tenant struct {
+	Name         string `yaml:"name"`
+	Schema       string `yaml:"schema"`
+	PlaybooksDir string `yaml:"playbooks_dir"`
+}
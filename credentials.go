@@ -0,0 +1,190 @@
+package ansibleapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Credential - сохраненный ключ SSH. Закрытый ключ хранится зашифрованным
+// secrets.encryption_key и из API не возвращается.
+type Credential struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	Name      string `gorm:"type:text;not null;uniqueIndex" json:"name"`
+	User      string `gorm:"type:text;not null" json:"user"`
+	SealedKey string `gorm:"type:text;not null" json:"-"`
+	// Итог последней проверки POST /api/credentials/{id}/validate
+	LastValidatedAt *time.Time `gorm:"type:timestamptz" json:"last_validated_at,omitempty"`
+	LastValid       *bool      `json:"last_valid,omitempty"`
+	LastHost        string     `gorm:"type:text" json:"last_host,omitempty"`
+	LastError       string     `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedBy       string     `gorm:"type:text" json:"created_by,omitempty"`
+	CreatedAt       time.Time  `gorm:"type:timestamptz;not null" json:"created_at"`
+}
+
+// CredentialRequest - тело POST /api/credentials
+type CredentialRequest struct {
+	Name       string `json:"name"`
+	User       string `json:"user"`
+	PrivateKey string `json:"private_key"`
+}
+
+func (req CredentialRequest) validate() error {
+	if !agentNameRe.MatchString(req.Name) {
+		return fmt.Errorf("invalid credential name %q", req.Name)
+	}
+	if req.User == "" || strings.ContainsAny(req.User, " \t\n@") || strings.HasPrefix(req.User, "-") {
+		return fmt.Errorf("invalid user %q", req.User)
+	}
+	if !strings.Contains(req.PrivateKey, "PRIVATE KEY") {
+		return errors.New("private_key must be a PEM or OpenSSH private key")
+	}
+	return nil
+}
+
+// CredentialCheckRequest - тело POST /api/credentials/{id}/validate: хост,
+// на котором проверить вход
+type CredentialCheckRequest struct {
+	Host    string  `json:"host"`
+	Port    int     `json:"port,omitempty"`
+	Timeout float64 `json:"timeout,omitempty"`
+}
+
+// CredentialCheckResult - итог проверки ключа: valid - вход удался
+type CredentialCheckResult struct {
+	Valid bool `json:"valid"`
+	PingResult
+}
+
+func listCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	credentials := []Credential{}
+	if err := tenantOf(r).db().Order("name").Find(&credentials).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+func createCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	var req CredentialRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sealed, err := sealVars(map[string]string{"private_key": strings.TrimSpace(req.PrivateKey) + "\n"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	credential := Credential{
+		Name:      req.Name,
+		User:      req.User,
+		SealedKey: sealed,
+		CreatedBy: triggeredBy(r),
+		CreatedAt: time.Now(),
+	}
+	result := tenantOf(r).db().Where("name = ?", credential.Name).FirstOrCreate(&credential)
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Credential already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("Credential %s created by %s", credential.Name, credential.CreatedBy)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+func deleteCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid credential ID", http.StatusBadRequest)
+		return
+	}
+	result := tenantOf(r).db().Delete(&Credential{}, id)
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Credential %d deleted by %s", id, triggeredBy(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateCredentialHandler проверяет вход по SSH сохраненным ключом, чтобы
+// неработающий ключ нашелся до запуска. Ключ расшифровывается только на
+// время проверки; итог сохраняется в учетных данных.
+func validateCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid credential ID", http.StatusBadRequest)
+		return
+	}
+	var body CredentialCheckRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	t := tenantOf(r)
+	var credential Credential
+	if err := t.primaryDB().First(&credential, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Credential not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	secret, err := openVars(credential.SealedKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	req := PingRequest{
+		Hosts:   []PingTarget{{Host: body.Host, Port: body.Port, User: credential.User, PrivateKey: secret["private_key"]}},
+		Timeout: body.Timeout,
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), req.timeout())
+	defer cancel()
+	result := CredentialCheckResult{PingResult: pingHost(ctx, req.Hosts[0])}
+	result.Valid = result.Auth != nil && *result.Auth
+
+	// В режиме только для чтения проверка выполняется, но не записывается
+	if !readOnly.Load() {
+		if err := t.db().Model(&Credential{}).Where("id = ?", credential.ID).Updates(map[string]interface{}{
+			"last_validated_at": time.Now(),
+			"last_valid":        result.Valid,
+			"last_host":         result.Host,
+			"last_error":        result.Error,
+		}).Error; err != nil {
+			log.Printf("Failed to record validation of credential %s: %v", credential.Name, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
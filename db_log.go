@@ -0,0 +1,32 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm/logger"
+)
+
+var sqlLogLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// sqlLogger пишет журнал gorm в общий журнал приложения (пакет log), а не
+// отдельно в stdout, с уровнем и порогом медленных запросов из logging
+func sqlLogger() (logger.Interface, error) {
+	level, ok := sqlLogLevels[cfg.Logging.SQLLevel]
+	if !ok {
+		return nil, fmt.Errorf("logging.sql_level: unknown level %q, expected silent, error, warn or info", cfg.Logging.SQLLevel)
+	}
+	return logger.New(log.Default(), logger.Config{
+		SlowThreshold: cfg.Logging.SQLSlowThreshold,
+		LogLevel:      level,
+		// Отсутствие записи - обычный ответ 404, а не ошибка БД
+		IgnoreRecordNotFoundError: true,
+		// Значения параметров не попадают в журнал: в них бывают extra_vars и содержимое инвентарей
+		ParameterizedQueries: true,
+	}), nil
+}
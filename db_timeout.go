@@ -0,0 +1,60 @@
+package ansibleapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// errQueryTimeout - SELECT отменен по database.query_timeout. Не считается
+// ошибкой соединения: долгий запрос не должен размыкать circuit breaker.
+var errQueryTimeout = errors.New("database query timed out")
+
+const queryTimeoutKey = "timeout:query"
+
+// queryDeadline - context запроса до ограничения и отмена ограничения
+type queryDeadline struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// registerQueryTimeout ограничивает SELECT через context, чтобы тяжелый запрос
+// статистики не занимал соединение пула бесконечно. Запросы, у которых уже есть
+// свой срок, не трогаются. Row/Rows не ограничиваются: их результат читается
+// после callbacks, и отмена context оборвала бы чтение.
+func registerQueryTimeout(gdb *gorm.DB) error {
+	if cfg.Database.QueryTimeout <= 0 {
+		return nil
+	}
+	before := func(tx *gorm.DB) {
+		if _, ok := tx.Statement.Context.Deadline(); ok {
+			return
+		}
+		parent := tx.Statement.Context
+		ctx, cancel := context.WithTimeout(parent, cfg.Database.QueryTimeout)
+		tx.Statement.Context = ctx
+		tx.InstanceSet(queryTimeoutKey, queryDeadline{parent: parent, cancel: cancel})
+	}
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(queryTimeoutKey)
+		if !ok {
+			return
+		}
+		deadline := value.(queryDeadline)
+		if tx.Error != nil && errors.Is(tx.Statement.Context.Err(), context.DeadlineExceeded) {
+			tx.Error = fmt.Errorf("%w after %s", errQueryTimeout, cfg.Database.QueryTimeout)
+		}
+		deadline.cancel()
+		// Statement бывает общим для нескольких запросов (Count, затем Find):
+		// следующий получит свой срок, а не уже отмененный context
+		tx.Statement.Context = deadline.parent
+	}
+
+	if err := gdb.Callback().Query().Before("gorm:query").Register("timeout:before_query", before); err != nil {
+		return err
+	}
+	// До breaker:after_query, чтобы отмена не засчитывалась как недоступность БД
+	return gdb.Callback().Query().After("gorm:query").Before("breaker:after_query").Register("timeout:after_query", after)
+}
@@ -0,0 +1,222 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"ansible-api/config"
+)
+
+// Допуск по умолчанию для ожидаемых запусков
+const defaultScheduleTolerance = 15 * time.Minute
+
+// Итоги проверки ожидаемого запуска
+const (
+	OccurrenceSucceeded = "succeeded"
+	OccurrenceMissed    = "missed"
+	OccurrenceFailed    = "failed"
+)
+
+// ScheduleOccurrence - проверенный ожидаемый запуск. Уникальность по
+// (schedule, due_at) не дает узлам отправить одно уведомление несколько раз.
+type ScheduleOccurrence struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Schedule  string    `gorm:"type:text;not null;uniqueIndex:idx_schedule_occurrence_due,priority:1" json:"schedule"`
+	DueAt     time.Time `gorm:"type:timestamptz;not null;uniqueIndex:idx_schedule_occurrence_due,priority:2" json:"due_at"`
+	Status    string    `gorm:"type:text;not null" json:"status"`
+	RunID     *uint     `json:"run_id,omitempty"`
+	CheckedAt time.Time `gorm:"type:timestamptz;not null" json:"checked_at"`
+}
+
+// expectedSchedule - ожидаемое расписание с разобранным cron-выражением
+type expectedSchedule struct {
+	config.ExpectedSchedule
+	tenant   *tenant
+	schedule cron.Schedule
+
+	mu sync.Mutex
+	// Ближайший еще не проверенный ожидаемый запуск
+	nextDue time.Time
+}
+
+var expectedSchedules []*expectedSchedule
+
+// loadExpectedSchedules разбирает watchdog.schedules. Проверяются только
+// запуски, ожидаемые после старта сервера.
+func loadExpectedSchedules() error {
+	expectedSchedules = nil
+	names := make(map[string]bool)
+	now := time.Now()
+	for _, c := range cfg.Watchdog.Schedules {
+		if c.Name == "" || c.Playbook == "" {
+			return fmt.Errorf("watchdog schedule %q: name and playbook are required", c.Name)
+		}
+		key := c.Tenant + "/" + c.Name
+		if names[key] {
+			return fmt.Errorf("watchdog schedule %s: duplicate name", c.Name)
+		}
+		names[key] = true
+
+		s := &expectedSchedule{ExpectedSchedule: c, tenant: defaultTenant()}
+		if c.Tenant != "" {
+			if s.tenant = tenants[c.Tenant]; s.tenant == nil {
+				return fmt.Errorf("watchdog schedule %s: unknown tenant %q", c.Name, c.Tenant)
+			}
+		}
+		schedule, err := cron.ParseStandard(c.Schedule)
+		if err != nil {
+			return fmt.Errorf("watchdog schedule %s: %v", c.Name, err)
+		}
+		s.schedule = schedule
+		if s.Tolerance <= 0 {
+			s.Tolerance = defaultScheduleTolerance
+		}
+		s.nextDue = schedule.Next(now.Add(-s.Tolerance))
+		expectedSchedules = append(expectedSchedules, s)
+	}
+	return nil
+}
+
+// checkExpectedSchedules проверяет ожидаемые запуски, для которых истек допуск
+func checkExpectedSchedules() {
+	now := time.Now()
+	for _, s := range expectedSchedules {
+		s.mu.Lock()
+		for !now.Before(s.nextDue.Add(s.Tolerance)) {
+			if err := s.check(s.nextDue); err != nil {
+				// Повторим на следующей проверке
+				log.Printf("Failed to check schedule %s due at %s: %v", s.Name, s.nextDue.Format(time.RFC3339), err)
+				break
+			}
+			s.nextDue = s.schedule.Next(s.nextDue)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// check ищет успешный запуск, поставленный в пределах допуска от due
+func (s *expectedSchedule) check(due time.Time) error {
+	query := s.tenant.primaryDB().
+		Where("playbook = ? AND created_at BETWEEN ? AND ?", s.Playbook, due.Add(-s.Tolerance), due.Add(s.Tolerance))
+	if s.Inventory != "" {
+		query = query.Where("inventory = ?", s.Inventory)
+	}
+	var runs []PlaybookRun
+	if err := query.Order("created_at DESC, id DESC").Find(&runs).Error; err != nil {
+		return err
+	}
+
+	occurrence := ScheduleOccurrence{Schedule: s.Name, DueAt: due, Status: OccurrenceMissed, CheckedAt: time.Now()}
+	var last *PlaybookRun
+	for i := range runs {
+		if last == nil {
+			last = &runs[i]
+		}
+		if runs[i].Status == RunStatusCompleted {
+			last = &runs[i]
+			occurrence.Status = OccurrenceSucceeded
+			break
+		}
+	}
+	if last != nil {
+		occurrence.RunID = &last.ID
+		if occurrence.Status != OccurrenceSucceeded {
+			occurrence.Status = OccurrenceFailed
+		}
+	}
+
+	result := s.tenant.db().Clauses(clause.OnConflict{DoNothing: true}).Create(&occurrence)
+	if result.Error != nil {
+		return result.Error
+	}
+	// Проверку уже записал другой узел
+	if result.RowsAffected == 0 || occurrence.Status == OccurrenceSucceeded {
+		return nil
+	}
+
+	message := fmt.Sprintf("scheduled run %s of %s expected at %s did not occur within %s",
+		s.Name, s.Playbook, due.Format(time.RFC3339), s.Tolerance)
+	event := "schedule.missed"
+	if last != nil {
+		message = fmt.Sprintf("scheduled run %s of %s expected at %s did not succeed within %s: run %d is %s",
+			s.Name, s.Playbook, due.Format(time.RFC3339), s.Tolerance, last.ID, last.Status)
+		event = "schedule.failed"
+	}
+	n := Notification{Event: event, Tenant: s.tenant.Name, Playbook: s.Playbook, Message: message}
+	if last != nil {
+		n.RunID, n.Status = last.ID, last.Status
+	}
+	notify(n)
+	return nil
+}
+
+// ScheduleStatus - ожидаемое расписание и итоги его последних проверок
+type ScheduleStatus struct {
+	Name      string              `json:"name"`
+	Playbook  string              `json:"playbook"`
+	Inventory string              `json:"inventory,omitempty"`
+	Schedule  string              `json:"schedule"`
+	Tolerance string              `json:"tolerance"`
+	NextDue   time.Time           `json:"next_due"`
+	Last      *ScheduleOccurrence `json:"last,omitempty"`
+	// Последний ожидаемый запуск, завершившийся успешно
+	LastSuccess *ScheduleOccurrence `json:"last_success,omitempty"`
+}
+
+// listSchedulesHandler отдает ожидаемые расписания арендатора и итоги их проверок
+func listSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r)
+	response := []ScheduleStatus{}
+	for _, s := range expectedSchedules {
+		if s.tenant != t {
+			continue
+		}
+		s.mu.Lock()
+		status := ScheduleStatus{
+			Name:      s.Name,
+			Playbook:  s.Playbook,
+			Inventory: s.Inventory,
+			Schedule:  s.ExpectedSchedule.Schedule,
+			Tolerance: s.Tolerance.String(),
+			NextDue:   s.nextDue,
+		}
+		s.mu.Unlock()
+
+		var err error
+		if status.Last, err = lastOccurrence(t, s.Name, ""); err == nil {
+			status.LastSuccess, err = lastOccurrence(t, s.Name, OccurrenceSucceeded)
+		}
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		response = append(response, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func lastOccurrence(t *tenant, schedule, status string) (*ScheduleOccurrence, error) {
+	query := t.db().Where("schedule = ?", schedule)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var occurrence ScheduleOccurrence
+	if err := query.Order("due_at DESC").First(&occurrence).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &occurrence, nil
+}
@@ -0,0 +1,372 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// Коллекции, входящие в ansible-core
+var builtinCollections = map[string]bool{"ansible.builtin": true, "ansible.legacy": true}
+
+// Списки задач play, блока и роли
+var taskListKeys = []string{"tasks", "pre_tasks", "post_tasks", "handlers", "block", "rescue", "always"}
+
+// PlaybookDependencies - коллекции и роли, которые использует playbook, и те
+// из них, что не найдены в путях установки и проекта
+type PlaybookDependencies struct {
+	Collections        []string `json:"collections"`
+	Roles              []string `json:"roles"`
+	MissingCollections []string `json:"missing_collections,omitempty"`
+	MissingRoles       []string `json:"missing_roles,omitempty"`
+	// Коллекции находятся в образе execution environment и не проверяются
+	CollectionsUnchecked bool `json:"collections_unchecked,omitempty"`
+}
+
+// missing - недостающие зависимости в виде "collection x.y", "role z"
+func (d PlaybookDependencies) missing() []string {
+	var result []string
+	for _, name := range d.MissingCollections {
+		result = append(result, "collection "+name)
+	}
+	for _, name := range d.MissingRoles {
+		result = append(result, "role "+name)
+	}
+	return result
+}
+
+// PlaybookMeta - сведения о playbook для GET /api/playbooks/{name}/meta
+type PlaybookMeta struct {
+	Playbook     string               `json:"playbook"`
+	Ansible      string               `json:"ansible"`
+	Dependencies PlaybookDependencies `json:"dependencies"`
+}
+
+// missingDependenciesError - запуск отклонен при ansible.missing_dependencies = refuse
+type missingDependenciesError struct {
+	missing []string
+}
+
+func (e *missingDependenciesError) Error() string {
+	return "playbook dependencies are not installed: " + strings.Join(e.missing, ", ")
+}
+
+// dependencyScan обходит playbook, импортированные playbooks и локальные роли
+type dependencyScan struct {
+	root        string
+	collections map[string]bool
+	roles       map[string]bool
+	// Роли, найденные в проекте, и их каталоги (каждый разбирается один раз)
+	foundRoles map[string]bool
+	roleDirs   map[string]bool
+	visited    map[string]bool
+}
+
+// scanPlaybookDependencies разбирает playbook: ключевое слово collections, имена
+// модулей и ролей в виде FQCN (namespace.collection.name), roles, include_role,
+// import_role и import_playbook. Задачи и meta/main.yml найденных в проекте ролей
+// тоже разбираются. Зависимости из шаблонов Jinja и переменных не определяются.
+func scanPlaybookDependencies(t *tenant, playbook string) (*dependencyScan, error) {
+	s := &dependencyScan{
+		root:        t.PlaybooksDir,
+		collections: make(map[string]bool),
+		roles:       make(map[string]bool),
+		foundRoles:  make(map[string]bool),
+		roleDirs:    make(map[string]bool),
+		visited:     make(map[string]bool),
+	}
+	// Ошибки в самом playbook возвращаются, в импортированных файлах и ролях -
+	// пропускаются: их покажет ansible-playbook
+	data, err := os.ReadFile(filepath.Join(t.PlaybooksDir, playbook))
+	if err != nil {
+		return nil, err
+	}
+	var plays []map[string]interface{}
+	if err := yaml.Unmarshal(data, &plays); err != nil {
+		return nil, fmt.Errorf("failed to parse playbook %s: %v", playbook, err)
+	}
+	s.visited[filepath.Join(t.PlaybooksDir, playbook)] = true
+	s.plays(plays, filepath.Join(t.PlaybooksDir, playbook))
+	return s, nil
+}
+
+func (s *dependencyScan) plays(plays []map[string]interface{}, path string) {
+	for _, play := range plays {
+		if imported, ok := play["import_playbook"].(string); ok {
+			var importedPlays []map[string]interface{}
+			if !strings.Contains(imported, "{{") && s.load(filepath.Join(filepath.Dir(path), imported), &importedPlays) {
+				s.plays(importedPlays, filepath.Join(filepath.Dir(path), imported))
+			}
+			continue
+		}
+		s.collectionList(play["collections"])
+		if roles, ok := play["roles"].([]interface{}); ok {
+			for _, role := range roles {
+				switch role := role.(type) {
+				case string:
+					s.role(role, filepath.Dir(path))
+				case map[string]interface{}:
+					s.roleRef(role, filepath.Dir(path))
+				}
+			}
+		}
+		s.tasks(play, filepath.Dir(path))
+	}
+}
+
+// load читает YAML файла один раз; false - файл уже разобран или не читается
+func (s *dependencyScan) load(path string, v interface{}) bool {
+	if s.visited[path] {
+		return false
+	}
+	s.visited[path] = true
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return yaml.Unmarshal(data, v) == nil
+}
+
+func (s *dependencyScan) collectionList(value interface{}) {
+	list, _ := value.([]interface{})
+	for _, item := range list {
+		if name, ok := item.(string); ok && strings.Count(name, ".") == 1 {
+			s.collection(name)
+		}
+	}
+}
+
+func (s *dependencyScan) collection(name string) {
+	if !builtinCollections[name] {
+		s.collections[name] = true
+	}
+}
+
+// tasks обходит списки задач объекта (play, блока или задачи с блоком)
+func (s *dependencyScan) tasks(owner map[string]interface{}, dir string) {
+	for _, key := range taskListKeys {
+		list, _ := owner[key].([]interface{})
+		for _, item := range list {
+			if task, ok := item.(map[string]interface{}); ok {
+				s.task(task, dir)
+			}
+		}
+	}
+}
+
+func (s *dependencyScan) task(task map[string]interface{}, dir string) {
+	for key, value := range task {
+		module := key
+		// Ключевые слова задач не содержат точек, модули - FQCN
+		if parts := strings.Split(key, "."); len(parts) >= 3 {
+			collection := parts[0] + "." + parts[1]
+			s.collection(collection)
+			if !builtinCollections[collection] {
+				continue
+			}
+			module = parts[len(parts)-1]
+		}
+		if module == "include_role" || module == "import_role" {
+			if args, ok := value.(map[string]interface{}); ok {
+				s.roleRef(args, dir)
+			}
+		}
+	}
+	s.tasks(task, dir)
+}
+
+// roleRef - роль в виде {role: name} или {name: name}
+func (s *dependencyScan) roleRef(ref map[string]interface{}, dir string) {
+	name, _ := ref["role"].(string)
+	if name == "" {
+		name, _ = ref["name"].(string)
+	}
+	s.role(name, dir)
+}
+
+// role учитывает роль: FQCN - зависимость от коллекции, остальные ищутся
+// в каталогах ролей. Найденная в проекте роль разбирается дальше.
+func (s *dependencyScan) role(name, dir string) {
+	if name == "" || strings.Contains(name, "{{") {
+		return
+	}
+	if !strings.Contains(name, "/") && strings.Count(name, ".") >= 2 {
+		parts := strings.SplitN(name, ".", 3)
+		s.collection(parts[0] + "." + parts[1])
+		return
+	}
+	s.roles[name] = true
+
+	candidates := []string{filepath.Join(dir, "roles", name), filepath.Join(s.root, "roles", name)}
+	if filepath.IsAbs(name) {
+		candidates = []string{name}
+	} else if strings.Contains(name, "/") {
+		candidates = []string{filepath.Join(dir, name)}
+	}
+	for _, roleDir := range candidates {
+		if info, err := os.Stat(roleDir); err != nil || !info.IsDir() || s.roleDirs[roleDir] {
+			continue
+		}
+		s.roleDirs[roleDir] = true
+		s.foundRoles[name] = true
+		for _, file := range []string{"tasks/main.yml", "tasks/main.yaml", "handlers/main.yml", "handlers/main.yaml"} {
+			var tasks []interface{}
+			if s.load(filepath.Join(roleDir, file), &tasks) {
+				s.tasks(map[string]interface{}{"tasks": tasks}, roleDir)
+			}
+		}
+		var meta map[string]interface{}
+		if s.load(filepath.Join(roleDir, "meta", "main.yml"), &meta) {
+			s.collectionList(meta["collections"])
+			deps, _ := meta["dependencies"].([]interface{})
+			for _, dep := range deps {
+				switch dep := dep.(type) {
+				case string:
+					s.role(dep, roleDir)
+				case map[string]interface{}:
+					s.roleRef(dep, roleDir)
+				}
+			}
+		}
+		return
+	}
+}
+
+// collectionsPaths - каталоги, в которых ищутся ansible_collections: проекта,
+// ansible.collections_paths и site-packages virtualenv установки
+func collectionsPaths(t *tenant, inst *ansibleInstallation) []string {
+	paths := []string{filepath.Join(t.PlaybooksDir, "collections")}
+	paths = append(paths, expandHome(cfg.Ansible.CollectionsPaths)...)
+	if inst != nil && inst.VirtualEnv != "" {
+		sitePackages, _ := filepath.Glob(filepath.Join(inst.VirtualEnv, "lib", "python*", "site-packages"))
+		paths = append(paths, sitePackages...)
+	}
+	return paths
+}
+
+func expandHome(paths []string) []string {
+	home, _ := os.UserHomeDir()
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if rest, ok := strings.CutPrefix(path, "~/"); ok && home != "" {
+			path = filepath.Join(home, rest)
+		}
+		result = append(result, path)
+	}
+	return result
+}
+
+// playbookDependencies находит зависимости playbook и проверяет, что они
+// установлены для установки ansible inst
+func playbookDependencies(t *tenant, playbook string, inst *ansibleInstallation) (PlaybookDependencies, error) {
+	scan, err := scanPlaybookDependencies(t, playbook)
+	if err != nil {
+		return PlaybookDependencies{}, err
+	}
+	deps := PlaybookDependencies{Collections: sortedKeys(scan.collections), Roles: sortedKeys(scan.roles)}
+
+	if inst != nil && inst.Image != "" {
+		deps.CollectionsUnchecked = true
+	} else {
+		paths := collectionsPaths(t, inst)
+		for _, name := range deps.Collections {
+			namespace, collection, _ := strings.Cut(name, ".")
+			if !anyDirExists(paths, filepath.Join("ansible_collections", namespace, collection)) {
+				deps.MissingCollections = append(deps.MissingCollections, name)
+			}
+		}
+	}
+
+	rolesPaths := append([]string{filepath.Join(t.PlaybooksDir, "roles")}, expandHome(cfg.Ansible.RolesPaths)...)
+	for _, name := range deps.Roles {
+		if !scan.foundRoles[name] && (strings.Contains(name, "/") || !anyDirExists(rolesPaths, name)) {
+			deps.MissingRoles = append(deps.MissingRoles, name)
+		}
+	}
+	return deps, nil
+}
+
+func anyDirExists(roots []string, rel string) bool {
+	for _, root := range roots {
+		if info, err := os.Stat(filepath.Join(root, rel)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkPlaybookDependencies проверяет зависимости перед постановкой запуска в
+// очередь: при ansible.missing_dependencies = refuse недостающие зависимости -
+// ошибка, при warn - список для поля missing_dependencies запуска
+func checkPlaybookDependencies(t *tenant, playbook string, inst *ansibleInstallation) ([]string, error) {
+	if cfg.Ansible.MissingDependencies == "off" {
+		return nil, nil
+	}
+	deps, err := playbookDependencies(t, playbook, inst)
+	if err != nil {
+		// Ошибку в playbook покажет сам ansible-playbook
+		log.Printf("Failed to detect dependencies of playbook %s: %v", playbook, err)
+		return nil, nil
+	}
+	missing := deps.missing()
+	if len(missing) > 0 && cfg.Ansible.MissingDependencies == "refuse" {
+		return nil, &missingDependenciesError{missing: missing}
+	}
+	return missing, nil
+}
+
+// playbookMetaHandler показывает зависимости playbook для установки ansible,
+// которая его выполнит (или указанной в параметре ansible)
+func playbookMetaHandler(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r)
+	name := mux.Vars(r)["name"]
+	if _, err := os.Stat(filepath.Join(t.PlaybooksDir, name)); err != nil {
+		http.Error(w, "Playbook not found", http.StatusNotFound)
+		return
+	}
+	inst, err := selectAnsibleInstallation(PlaybookRequest{Playbook: name, Ansible: r.URL.Query().Get("ansible")})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deps, err := playbookDependencies(t, name, inst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if deps.Collections == nil {
+		deps.Collections = []string{}
+	}
+	if deps.Roles == nil {
+		deps.Roles = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlaybookMeta{Playbook: name, Ansible: inst.Name, Dependencies: deps})
+}
+
+// validateMissingDependencies проверяет значение ansible.missing_dependencies
+func validateMissingDependencies() error {
+	switch cfg.Ansible.MissingDependencies {
+	case "off", "warn", "refuse":
+		return nil
+	}
+	return fmt.Errorf("ansible.missing_dependencies must be off, warn or refuse, got %q", cfg.Ansible.MissingDependencies)
+}
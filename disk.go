@@ -0,0 +1,134 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Шаблоны временных файлов, которые могут остаться после упавших запусков
+var tempFilePatterns = []string{"inventory-*.ini", "check-hosts-*.yml", "ping-key-*"}
+
+type DiskUsage struct {
+	Dir    string `json:"dir"`
+	FreeMB uint64 `json:"free_mb"`
+	Low    bool   `json:"low"`
+}
+
+func guardedDirs() []string {
+	dirs := append([]string{cfg.Disk.TempDir}, cfg.Disk.WatchDirs...)
+	if cfg.Disk.WorkDir != "" {
+		dirs = append(dirs, cfg.Disk.WorkDir)
+	}
+	return dirs
+}
+
+func diskUsage() []DiskUsage {
+	var usage []DiskUsage
+	for _, dir := range guardedDirs() {
+		free, err := freeSpace(dir)
+		if err != nil {
+			log.Printf("Failed to check free space in %s: %v", dir, err)
+			continue
+		}
+		freeMB := free / (1024 * 1024)
+		usage = append(usage, DiskUsage{
+			Dir:    dir,
+			FreeMB: freeMB,
+			Low:    freeMB < cfg.Disk.MinFreeMB,
+		})
+	}
+	return usage
+}
+
+// checkDiskSpace возвращает ошибку, если места мало и low_space_action = refuse.
+// В режиме warn нехватка места только логируется.
+func checkDiskSpace() error {
+	for _, usage := range diskUsage() {
+		if !usage.Low {
+			continue
+		}
+		msg := fmt.Sprintf("low disk space in %s: %d MB free, %d MB required", usage.Dir, usage.FreeMB, cfg.Disk.MinFreeMB)
+		if cfg.Disk.LowSpaceAction == "warn" {
+			log.Printf("Warning: %s", msg)
+			continue
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// cleanupTempFiles удаляет временные inventory/playbook, оставшиеся от упавших запусков
+func cleanupTempFiles() {
+	threshold := time.Now().Add(-cfg.Disk.TempFileMaxAge)
+	removed := 0
+
+	for _, pattern := range tempFilePatterns {
+		matches, err := filepath.Glob(filepath.Join(cfg.Disk.TempDir, pattern))
+		if err != nil {
+			log.Printf("Failed to list temp files %s: %v", pattern, err)
+			continue
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().After(threshold) {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("Failed to remove leaked temp file %s: %v", path, err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d leaked temp files from %s", removed, cfg.Disk.TempDir)
+	}
+}
+
+var (
+	tempFilesDesc = prometheus.NewDesc("ansible_api_temp_files",
+		"Server temp files in disk.temp_dir: inventories, including the inventory cache, and check playbooks.", nil, nil)
+	tempFilesBytesDesc = prometheus.NewDesc("ansible_api_temp_files_bytes",
+		"Total size of server temp files in disk.temp_dir.", nil, nil)
+	diskFreeBytesDesc = prometheus.NewDesc("ansible_api_disk_free_bytes",
+		"Free space in disk.temp_dir, disk.watch_dirs and disk.work_dir.", []string{"dir"}, nil)
+)
+
+// tempDirCollector считает временные файлы и свободное место при опросе
+// /metrics; до New (встраивание без конфигурации) метрик нет
+type tempDirCollector struct{}
+
+func (tempDirCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tempFilesDesc
+	ch <- tempFilesBytesDesc
+	ch <- diskFreeBytesDesc
+}
+
+func (tempDirCollector) Collect(ch chan<- prometheus.Metric) {
+	if cfg == nil {
+		return
+	}
+	var files, size int64
+	for _, pattern := range tempFilePatterns {
+		matches, _ := filepath.Glob(filepath.Join(cfg.Disk.TempDir, pattern))
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				files++
+				size += info.Size()
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(tempFilesDesc, prometheus.GaugeValue, float64(files))
+	ch <- prometheus.MustNewConstMetric(tempFilesBytesDesc, prometheus.GaugeValue, float64(size))
+	for _, dir := range guardedDirs() {
+		if free, err := freeSpace(dir); err == nil {
+			ch <- prometheus.MustNewConstMetric(diskFreeBytesDesc, prometheus.GaugeValue, float64(free), dir)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package ansibleapi
+
+import "errors"
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space check is not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package ansibleapi
+
+import "syscall"
+
+// freeSpace возвращает количество байт, доступных непривилегированному пользователю
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
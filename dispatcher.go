@@ -0,0 +1,153 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Интервал опроса очереди на случай, если запуск поставлен другим узлом
+const queuePollInterval = 2 * time.Second
+
+// runJob - запуск, забранный воркером из очереди
+type runJob struct {
+	Tenant       *tenant
+	RunID        uint
+	Request      PlaybookRequest
+	PlaybookPath string
+	// Зашифрованные sensitive_vars, раскрываются непосредственно перед выполнением
+	SealedVars string
+}
+
+// dispatcher забирает запуски из очереди в БД и выдает их воркеру по одному.
+// В режиме drain новые запуски остаются в очереди, а уже выполняющиеся
+// доводятся до конца.
+type dispatcher struct {
+	mu       sync.Mutex
+	draining bool
+	// Воркер забирает запуск из очереди
+	claiming bool
+	active   int
+	since    *time.Time
+	wake     chan struct{}
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{wake: make(chan struct{}, 1)}
+}
+
+// Notify будит воркер после постановки запуска в очередь
+func (d *dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dispatcher) Drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining {
+		now := time.Now()
+		d.draining = true
+		d.since = &now
+	}
+}
+
+func (d *dispatcher) Resume() {
+	d.mu.Lock()
+	d.draining = false
+	d.since = nil
+	d.mu.Unlock()
+	d.Notify()
+}
+
+type dispatcherStatus struct {
+	Draining      bool       `json:"draining"`
+	DrainingSince *time.Time `json:"draining_since,omitempty"`
+	Queued        int        `json:"queued"`
+	Active        int        `json:"active"`
+}
+
+func (d *dispatcher) Status() dispatcherStatus {
+	queued, err := countQueuedRuns()
+	if err != nil {
+		log.Printf("Failed to count queued runs: %v", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return dispatcherStatus{
+		Draining:      d.draining,
+		DrainingSince: d.since,
+		Queued:        int(queued),
+		Active:        d.active,
+	}
+}
+
+func (d *dispatcher) setActive(delta int) {
+	d.mu.Lock()
+	d.active += delta
+	d.mu.Unlock()
+}
+
+// startClaim отмечает выборку запуска, если узел не в режиме drain
+func (d *dispatcher) startClaim() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.claiming = !d.draining
+	return d.claiming
+}
+
+// finishClaim снимает отметку выборки; забранный запуск становится активным
+func (d *dispatcher) finishClaim(claimed bool) {
+	d.mu.Lock()
+	d.claiming = false
+	if claimed {
+		d.active++
+	}
+	d.mu.Unlock()
+}
+
+// idle сообщает, что запуски не выполняются и не забираются из очереди
+func (d *dispatcher) idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.claiming && d.active == 0
+}
+
+func (d *dispatcher) wait() {
+	select {
+	case <-d.wake:
+	case <-time.After(queuePollInterval):
+	}
+}
+
+func (d *dispatcher) Run(execute func(runJob)) {
+	for {
+		if !d.startClaim() {
+			d.wait()
+			continue
+		}
+
+		job, err := claimNextRun("")
+		d.finishClaim(job != nil)
+		if err != nil {
+			log.Printf("Failed to claim queued run: %v", err)
+			d.wait()
+			continue
+		}
+		if job == nil {
+			d.wait()
+			continue
+		}
+
+		execute(*job)
+		t, runID := job.Tenant, job.RunID
+		persist(fmt.Sprintf("complete queue entry of run %d", runID), func() error {
+			return completeQueueEntry(t, runID)
+		})
+		d.setActive(-1)
+	}
+}
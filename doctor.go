@@ -0,0 +1,341 @@
+package ansibleapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ansible-api/config"
+)
+
+// Сколько ждать соединения с БД и внешними адресами при проверке
+const doctorTimeout = 5 * time.Second
+
+// doctorReport печатает результаты проверок: OK, WARN (работать будет, но
+// стоит посмотреть) и FAIL (сервер не запустится или функция не работает)
+type doctorReport struct {
+	w      io.Writer
+	failed bool
+}
+
+func (d *doctorReport) line(status, check, format string, args ...interface{}) {
+	fmt.Fprintf(d.w, "%-4s  %-10s  %s\n", status, check, fmt.Sprintf(format, args...))
+}
+
+func (d *doctorReport) ok(check, format string, args ...interface{}) {
+	d.line("OK", check, format, args...)
+}
+
+func (d *doctorReport) warn(check, format string, args ...interface{}) {
+	d.line("WARN", check, format, args...)
+}
+
+func (d *doctorReport) fail(check, format string, args ...interface{}) {
+	d.failed = true
+	d.line("FAIL", check, format, args...)
+}
+
+// RunDoctor проверяет установку и печатает отчет в w: конфигурацию, БД и
+// схемы арендаторов, установки ansible, каталоги playbooks, временный каталог
+// и доступность внешних адресов. Ничего не меняет: миграции не применяются,
+// запросы к внешним адресам не отправляются. false - есть ошибки (FAIL).
+func RunDoctor(c *config.Config, w io.Writer) bool {
+	if !created.CompareAndSwap(false, true) {
+		fmt.Fprintln(w, "ansible-api server or agent already created in this process")
+		return false
+	}
+	cfg = c
+	d := &doctorReport{w: w}
+
+	doctorConfig(d)
+	doctorAnsible(d)
+	if doctorDatabase(d) {
+		doctorSchemas(d)
+	}
+	doctorPlaybooks(d)
+	doctorTempDir(d)
+	doctorEndpoints(d)
+
+	if d.failed {
+		fmt.Fprintln(w, "\nSome checks failed")
+	} else {
+		fmt.Fprintln(w, "\nAll checks passed")
+	}
+	return !d.failed
+}
+
+func doctorConfig(d *doctorReport) {
+	checks := []struct {
+		name string
+		load func() error
+	}{
+		{"tenants", loadTenants},
+		{"server.trusted_proxies", loadTrustedProxies},
+		{"ansible.runners", loadRemoteRunners},
+		{"ansible.default_python", func() error { return validatePythonInterpreter(cfg.Ansible.DefaultPython) }},
+		{"policy", loadPolicy},
+		{"metrics_push", validateMetricsPush},
+		{"watchdog.schedules", loadExpectedSchedules},
+		{"retries", loadRetryPolicies},
+		{"rollbacks", loadRollbackPolicies},
+		{"run_windows", loadRunWindows},
+		{"checks.schedules", loadCheckSchedules},
+		{"ansible.missing_dependencies", validateMissingDependencies},
+		{"logging.sql_level", func() error { _, err := sqlLogger(); return err }},
+	}
+	valid := true
+	for _, check := range checks {
+		if err := check.load(); err != nil {
+			d.fail("config", "%s: %v", check.name, err)
+			valid = false
+		}
+	}
+	if valid {
+		profile := ""
+		if cfg.Profile != "" {
+			profile = ", profile " + cfg.Profile
+		}
+		d.ok("config", "configuration is valid, %d tenant(s)%s", len(tenantList), profile)
+	}
+}
+
+func doctorAnsible(d *doctorReport) {
+	if err := loadAnsibleInstallations(); err != nil {
+		d.fail("ansible", "%v", err)
+		return
+	}
+	names := make([]string, 0, len(ansibleInstallations))
+	for name := range ansibleInstallations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		inst := ansibleInstallations[name]
+		binary, err := exec.LookPath(inst.binary(inst.executable()))
+		switch {
+		case err != nil:
+			status := d.fail
+			if name == systemAnsibleName && len(ansibleInstallationList) > 0 && defaultAnsible != inst {
+				// Без ansible в PATH работают запуски настроенных установок
+				status = d.warn
+			}
+			status("ansible", "%s: %s not found: %v", name, inst.executable(), err)
+		case inst.Version == "":
+			d.fail("ansible", "%s: %s --version failed", name, binary)
+		case inst.Image != "":
+			d.ok("ansible", "%s: ansible-core %s (%s, execution environment %s)", name, inst.Version, binary, inst.Image)
+		default:
+			d.ok("ansible", "%s: ansible-core %s (%s)", name, inst.Version, binary)
+		}
+	}
+}
+
+// doctorDatabase подключается к БД без миграций; false - БД недоступна
+func doctorDatabase(d *doctorReport) bool {
+	target := fmt.Sprintf("%s:%d/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.Name)
+	if err := initDB(nil); err != nil {
+		d.fail("database", "%s: %v", target, err)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	var version string
+	if err := db.WithContext(ctx).Raw("SELECT current_setting('server_version')").Scan(&version).Error; err != nil {
+		d.fail("database", "%s: %v", target, err)
+		return false
+	}
+	d.ok("database", "%s: PostgreSQL %s", target, version)
+
+	if cfg.Database.ReplicaDSN != "" {
+		if err := useReadReplica(); err != nil {
+			d.fail("database", "read replica: %v", err)
+		} else {
+			var replicaVersion string
+			if err := db.WithContext(ctx).Raw("SELECT current_setting('server_version')").Scan(&replicaVersion).Error; err != nil {
+				d.fail("database", "read replica: %v", err)
+			} else {
+				d.ok("database", "read replica: PostgreSQL %s", replicaVersion)
+			}
+		}
+	}
+	return true
+}
+
+// doctorSchemas сравнивает примененные миграции схем арендаторов с файлами migrations
+func doctorSchemas(d *doctorReport) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		d.fail("schema", "%v", err)
+		return
+	}
+	for _, t := range tenantList {
+		var exists int64
+		if err := primaryDB().Raw("SELECT count(*) FROM information_schema.schemata WHERE schema_name = ?", t.Schema).
+			Scan(&exists).Error; err != nil {
+			d.fail("schema", "%s: %v", t.Schema, err)
+			continue
+		}
+		if exists == 0 {
+			d.warn("schema", "%s (tenant %s) does not exist yet, it is created on server start", t.Schema, t.Name)
+			continue
+		}
+
+		var applied []string
+		if db.Migrator().HasTable(t.table("migrations")) {
+			if err := t.primaryDB().Model(&Migration{}).Pluck("name", &applied).Error; err != nil {
+				d.fail("schema", "%s: %v", t.Schema, err)
+				continue
+			}
+		}
+		done := make(map[string]bool, len(applied))
+		for _, name := range applied {
+			done[name] = true
+		}
+		var pending []string
+		for _, file := range files {
+			if name := path.Base(file); !done[name] {
+				pending = append(pending, name)
+			}
+		}
+		sort.Strings(pending)
+		if len(pending) > 0 {
+			d.warn("schema", "%s (tenant %s): %d pending migration(s) %s, applied on server start",
+				t.Schema, t.Name, len(pending), strings.Join(pending, ", "))
+			continue
+		}
+		d.ok("schema", "%s (tenant %s): up to date, %d migrations applied", t.Schema, t.Name, len(applied))
+	}
+}
+
+func doctorPlaybooks(d *doctorReport) {
+	for _, t := range tenantList {
+		info, err := os.Stat(t.PlaybooksDir)
+		if err != nil {
+			d.fail("playbooks", "%s (tenant %s): %v", t.PlaybooksDir, t.Name, err)
+			continue
+		}
+		if !info.IsDir() {
+			d.fail("playbooks", "%s (tenant %s): not a directory", t.PlaybooksDir, t.Name)
+			continue
+		}
+		entries, err := os.ReadDir(t.PlaybooksDir)
+		if err != nil {
+			d.fail("playbooks", "%s (tenant %s): %v", t.PlaybooksDir, t.Name, err)
+			continue
+		}
+		var playbooks int
+		for _, entry := range entries {
+			if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				playbooks++
+			}
+		}
+		if info.Mode().Perm()&0o002 != 0 {
+			d.warn("playbooks", "%s (tenant %s): writable by all users (%s), anyone on the host can change playbooks",
+				t.PlaybooksDir, t.Name, info.Mode().Perm())
+			continue
+		}
+		d.ok("playbooks", "%s (tenant %s): readable, %d playbook(s)", t.PlaybooksDir, t.Name, playbooks)
+	}
+}
+
+func doctorTempDir(d *doctorReport) {
+	dir := cfg.Disk.TempDir
+	f, err := os.CreateTemp(dir, "doctor-*")
+	if err == nil {
+		_, err = f.WriteString("ansible-api doctor\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		d.fail("temp dir", "%s: not writable: %v", dir, err)
+		return
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		d.warn("temp dir", "%s: writable, free space unknown: %v", dir, err)
+		return
+	}
+	if freeMB := free / (1024 * 1024); freeMB < cfg.Disk.MinFreeMB {
+		d.warn("temp dir", "%s: writable, %d MB free, less than disk.min_free_mb (%d MB)", dir, freeMB, cfg.Disk.MinFreeMB)
+		return
+	}
+	d.ok("temp dir", "%s: writable, %d MB free", dir, free/(1024*1024))
+}
+
+// doctorEndpoints проверяет TCP-соединение с внешними адресами из конфигурации.
+// Сами запросы не отправляются, чтобы не создать уведомлений и задач.
+func doctorEndpoints(d *doctorReport) {
+	type endpoint struct{ name, url string }
+	endpoints := []endpoint{
+		{"notifications.webhook_url", cfg.Notifications.WebhookURL},
+		{"jira.url", cfg.Jira.URL},
+		{"grafana.url", cfg.Grafana.URL},
+		{"metrics_push.url", cfg.MetricsPush.URL},
+		{"policy.opa.url", cfg.Policy.OPA.URL},
+	}
+	for _, hook := range cfg.Hooks.Pre {
+		endpoints = append(endpoints, endpoint{"hooks.pre " + hook.Name, hook.URL})
+	}
+	for _, hook := range cfg.Hooks.Post {
+		endpoints = append(endpoints, endpoint{"hooks.post " + hook.Name, hook.URL})
+	}
+
+	var checked int
+	for _, e := range endpoints {
+		if e.url == "" {
+			continue
+		}
+		checked++
+		address, err := endpointAddress(e.url)
+		if err != nil {
+			d.fail("endpoint", "%s: %v", e.name, err)
+			continue
+		}
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", address, doctorTimeout)
+		if err != nil {
+			d.fail("endpoint", "%s: %s unreachable: %v", e.name, address, err)
+			continue
+		}
+		conn.Close()
+		d.ok("endpoint", "%s: %s reachable in %s", e.name, address, time.Since(start).Round(time.Millisecond))
+	}
+	if checked == 0 {
+		d.ok("endpoint", "no external endpoints configured")
+	}
+}
+
+// endpointAddress - host:port адреса URL с портом схемы по умолчанию
+func endpointAddress(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("URL has no host")
+	}
+	port := u.Port()
+	switch {
+	case port != "":
+	case u.Scheme == "https":
+		port = "443"
+	case u.Scheme == "http":
+		port = "80"
+	default:
+		return "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
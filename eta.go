@@ -0,0 +1,108 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Сколько последних успешных запусков учитывать при оценке длительности
+const etaHistorySize = 20
+
+// estimateDuration возвращает медианную длительность последних успешных
+// запусков того же playbook с тем же инвентарем, а если таких нет - с любым.
+// Возвращает nil, если истории нет.
+func estimateDuration(t *tenant, playbook, inventory string) (*float64, error) {
+	median := func(withInventory bool) (*float64, error) {
+		recent := t.db().Model(&PlaybookRun{}).
+			Select("duration").
+			Where("playbook = ? AND status = ? AND duration IS NOT NULL", playbook, RunStatusCompleted)
+		if withInventory {
+			recent = recent.Where("inventory = ?", inventory)
+		}
+		recent = recent.Order("start_time DESC, id DESC").Limit(etaHistorySize)
+
+		var estimate *float64
+		err := t.db().Table("(?) AS recent", recent).
+			Select("percentile_cont(0.5) WITHIN GROUP (ORDER BY duration)").
+			Row().Scan(&estimate)
+		return estimate, err
+	}
+
+	estimate, err := median(true)
+	if err != nil || estimate != nil {
+		return estimate, err
+	}
+	return median(false)
+}
+
+// recordEstimate сохраняет оценку длительности при старте запуска, чтобы
+// потом сравнить ее с фактической
+func recordEstimate(job runJob) {
+	estimate, err := estimateDuration(job.Tenant, job.Request.Playbook, job.Request.Inventory)
+	if err != nil {
+		log.Printf("Failed to estimate duration of run %d: %v", job.RunID, err)
+		return
+	}
+	if estimate == nil {
+		return
+	}
+	if err := job.Tenant.db().Model(&PlaybookRun{}).Where("id = ?", job.RunID).Update("estimated_duration", *estimate).Error; err != nil {
+		log.Printf("Failed to record duration estimate of run %d: %v", job.RunID, err)
+	}
+}
+
+// fillEstimate вычисляет ожидаемое время завершения выполняющегося запуска
+func (run *PlaybookRun) fillEstimate() {
+	if run.Status != RunStatusStarted || run.EstimatedDuration == nil {
+		return
+	}
+	eta := run.StartTime.Add(time.Duration(*run.EstimatedDuration * float64(time.Second)))
+	// Запуск уже идет дольше оценки: честнее сказать, что он завершится не раньше, чем сейчас
+	if now := time.Now(); eta.Before(now) {
+		eta = now
+	}
+	run.EstimatedCompletionAt = &eta
+}
+
+type ETAAccuracyResponse struct {
+	Samples int64 `json:"samples"`
+	// Средняя абсолютная ошибка оценки в секундах
+	MeanAbsoluteError *float64 `json:"mean_absolute_error"`
+	// Средняя относительная ошибка, доля от фактической длительности
+	MeanRelativeError *float64 `json:"mean_relative_error"`
+	// Доля запусков, завершившихся в пределах 20% от оценки
+	WithinTwentyPercent *float64  `json:"within_20_percent"`
+	Since               time.Time `json:"since"`
+}
+
+// etaAccuracyHandler сравнивает оценки с фактической длительностью завершенных запусков
+func etaAccuracyHandler(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().AddDate(0, 0, -cfg.Logging.RetentionDays)
+	if from := r.URL.Query().Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			http.Error(w, "Invalid from, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	var response ETAAccuracyResponse
+	err := tenantOf(r).db().Model(&PlaybookRun{}).
+		Select(`COUNT(*) AS samples,
+			AVG(ABS(duration - estimated_duration)) AS mean_absolute_error,
+			AVG(ABS(duration - estimated_duration) / NULLIF(duration, 0)) AS mean_relative_error,
+			AVG(CASE WHEN ABS(duration - estimated_duration) <= 0.2 * estimated_duration THEN 1.0 ELSE 0.0 END) AS within_twenty_percent`).
+		Where("status = ? AND estimated_duration IS NOT NULL AND duration IS NOT NULL AND start_time >= ?", RunStatusCompleted, since).
+		Scan(&response).Error
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	response.Since = since
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,86 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Сколько событий читать из БД за один запрос при выгрузке
+const eventExportBatch = 1000
+
+// exportRunEventsHandler выгружает все сохраненные события запуска (play, task,
+// результаты и итоги по хостам) в NDJSON: по объекту RunEvent на строку, по
+// порядку вывода. Фильтры type и host - несколько значений через запятую.
+// Ответ пишется по мере чтения, поэтому ошибка БД посреди выгрузки обрывает
+// поток; полнота проверяется по последнему seq.
+func exportRunEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	t := tenantOf(r)
+	if _, err := t.store().GetRun(uint(id)); err != nil {
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+
+	query := t.db().Where("run_id = ?", id)
+	list := func(name string) []string {
+		var values []string
+		for _, v := range strings.Split(r.URL.Query().Get(name), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	if types := list("type"); len(types) > 0 {
+		query = query.Where("type IN ?", types)
+	}
+	if hosts := list("host"); len(hosts) > 0 {
+		query = query.Where("host IN ?", hosts)
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(cfg.Server.UploadTimeout))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="run-`+strconv.Itoa(id)+`-events.ndjson"`)
+
+	enc := json.NewEncoder(w)
+	seq := 0
+	for {
+		var events []RunEvent
+		if err := query.Session(&gorm.Session{}).Where("seq > ?", seq).Order("seq").Limit(eventExportBatch).Find(&events).Error; err != nil {
+			if seq == 0 {
+				writeDBError(w, err)
+			} else {
+				log.Printf("Failed to export events of run %d after seq %d: %v", id, seq, err)
+			}
+			return
+		}
+		for _, event := range events {
+			if err := enc.Encode(event); err != nil {
+				// Клиент закрыл соединение
+				return
+			}
+			seq = event.Seq
+		}
+		if len(events) < eventExportBatch {
+			return
+		}
+		rc.Flush()
+	}
+}
@@ -0,0 +1,222 @@
+package ansibleapi
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Сколько событий может ждать отправки одному подписчику
+	subscriberBuffer = 64
+	eventsWriteWait  = 10 * time.Second
+	eventsPongWait   = 60 * time.Second
+	eventsPingPeriod = eventsPongWait * 9 / 10
+)
+
+// LiveEvent - событие жизненного цикла запуска или проверки инвентаря,
+// рассылаемое подписчикам /api/events
+type LiveEvent struct {
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant"`
+	RunID     uint      `json:"run_id,omitempty"`
+	CheckID   uint      `json:"check_id,omitempty"`
+	Playbook  string    `json:"playbook,omitempty"`
+	Inventory string    `json:"inventory,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	NodeID    string    `json:"node_id"`
+	Time      time.Time `json:"time"`
+}
+
+// eventFilter - условия подписки; пустой список пропускает все значения.
+// Подписчик получает события только своего арендатора.
+type eventFilter struct {
+	Tenant      string
+	Playbooks   []string
+	Statuses    []string
+	Inventories []string
+}
+
+func parseEventFilter(r *http.Request) eventFilter {
+	list := func(name string) []string {
+		var values []string
+		for _, v := range strings.Split(r.URL.Query().Get(name), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	return eventFilter{
+		Tenant:      tenantOf(r).Name,
+		Playbooks:   list("playbook"),
+		Statuses:    list("status"),
+		Inventories: list("inventory"),
+	}
+}
+
+func (f eventFilter) matches(e LiveEvent) bool {
+	match := func(allowed []string, value string) bool {
+		if len(allowed) == 0 {
+			return true
+		}
+		for _, a := range allowed {
+			if a == value {
+				return true
+			}
+		}
+		return false
+	}
+	return e.Tenant == f.Tenant && match(f.Playbooks, e.Playbook) && match(f.Statuses, e.Status) && match(f.Inventories, e.Inventory)
+}
+
+type subscriber struct {
+	filter eventFilter
+	send   chan LiveEvent
+}
+
+// eventHub рассылает события всем подписчикам этого узла. Медленный
+// подписчик, у которого переполнился буфер, отключается, чтобы не
+// задерживать остальных.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+var liveEvents = &eventHub{subscribers: make(map[*subscriber]struct{})}
+
+func (h *eventHub) subscribe(filter eventFilter) *subscriber {
+	sub := &subscriber{filter: filter, send: make(chan LiveEvent, subscriberBuffer)}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.send)
+	}
+}
+
+func (h *eventHub) publish(e LiveEvent) {
+	e.NodeID = cfg.Server.NodeID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if !sub.filter.matches(e) {
+			continue
+		}
+		select {
+		case sub.send <- e:
+		default:
+			log.Printf("Dropping slow events subscriber")
+			delete(h.subscribers, sub)
+			close(sub.send)
+		}
+	}
+}
+
+func publishRunEvent(t *tenant, eventType string, run PlaybookRun, message string) {
+	liveEvents.publish(LiveEvent{
+		Type:      eventType,
+		Tenant:    t.Name,
+		RunID:     run.ID,
+		Playbook:  run.Playbook,
+		Inventory: run.Inventory,
+		Status:    string(run.Status),
+		Message:   message,
+	})
+}
+
+func publishCheckEvent(t *tenant, eventType string, check InventoryCheck, inventory, message string) {
+	liveEvents.publish(LiveEvent{
+		Type:      eventType,
+		Tenant:    t.Name,
+		CheckID:   check.ID,
+		Inventory: inventory,
+		Status:    string(check.Status),
+		Message:   message,
+	})
+}
+
+var eventsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// eventsHandler - WebSocket /api/events. Фильтры задаются параметрами
+// playbook, status и inventory (несколько значений через запятую).
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := parseEventFilter(r)
+
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту ошибкой
+		return
+	}
+	defer conn.Close()
+
+	sub := liveEvents.subscribe(filter)
+	defer liveEvents.unsubscribe(sub)
+
+	closed := watchClose(conn)
+	ping := time.NewTicker(eventsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-sub.send:
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber too slow"))
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// watchClose читает соединение, чтобы отвечать на ping клиента и заметить
+// закрытие: сообщения от клиента не ожидаются. Канал закрывается, когда
+// соединение оборвалось или клиент перестал отвечать на ping.
+func watchClose(conn *websocket.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(eventsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(eventsPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return closed
+}
@@ -0,0 +1,37 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateExtraArgs пропускает только флаги из ansible.allowed_extra_args.
+// Аргументы передаются ansible-playbook без оболочки, но значение флага
+// допускается только в том же аргументе (--tags=web), чтобы отдельный
+// аргумент не мог стать еще одним флагом или файлом playbook.
+func validateExtraArgs(args []string) error {
+	for _, arg := range args {
+		if !extraArgAllowed(arg) {
+			return fmt.Errorf("extra argument %q is not allowed", arg)
+		}
+	}
+	return nil
+}
+
+func extraArgAllowed(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+	for _, allowed := range cfg.Ansible.AllowedExtraArgs {
+		if name, ok := strings.CutSuffix(allowed, "="); ok {
+			if value, found := strings.CutPrefix(arg, name+"="); found && value != "" {
+				return true
+			}
+			continue
+		}
+		if arg == allowed {
+			return true
+		}
+	}
+	return false
+}
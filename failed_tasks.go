@@ -0,0 +1,82 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Сколько символов сообщения ansible оставлять в сводке
+const failedTaskMessageLimit = 500
+
+// FailedTask - задача, упавшая на хосте
+type FailedTask struct {
+	Play string `json:"play,omitempty"`
+	Task string `json:"task"`
+	Host string `json:"host"`
+	// Имя хоста с владельцем из описания хоста, если оно есть
+	Label       string `json:"label,omitempty"`
+	Unreachable bool   `json:"unreachable,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// parseFailedTasks находит в выводе строки fatal:/failed: и задачи, в которых
+// они появились. Ошибки, проигнорированные ignore_errors (за ними следует
+// "...ignoring"), в сводку не попадают.
+func parseFailedTasks(output string) []FailedTask {
+	var (
+		parser  eventParser
+		failed  []FailedTask
+		pending = -1
+	)
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if pending >= 0 && trimmed == "...ignoring" {
+			failed = append(failed[:pending], failed[pending+1:]...)
+			pending = -1
+			continue
+		}
+		pending = -1
+
+		event := parser.parse(line)
+		if event == nil || (event.Type != EventHostFailed && event.Type != EventHostUnreachable) {
+			continue
+		}
+		failed = append(failed, FailedTask{
+			Play:        event.Play,
+			Task:        event.Task,
+			Host:        event.Host,
+			Unreachable: event.Type == EventHostUnreachable,
+			Message:     failureMessage(event.Message),
+		})
+		pending = len(failed) - 1
+	}
+	return failed
+}
+
+// failureMessage берет msg из JSON-результата "FAILED! => {...}", а если
+// его нет - текст строки целиком
+func failureMessage(message string) string {
+	if _, result, ok := strings.Cut(message, "=> "); ok {
+		var parsed struct {
+			Msg    string `json:"msg"`
+			Stderr string `json:"stderr"`
+		}
+		if json.Unmarshal([]byte(result), &parsed) == nil {
+			switch {
+			case parsed.Msg != "" && parsed.Stderr != "":
+				message = parsed.Msg + ": " + parsed.Stderr
+			case parsed.Msg != "":
+				message = parsed.Msg
+			case parsed.Stderr != "":
+				message = parsed.Stderr
+			}
+		}
+	}
+	if len(message) > failedTaskMessageLimit {
+		message = strings.ToValidUTF8(message[:failedTaskMessageLimit], "") + "..."
+	}
+	return message
+}
@@ -0,0 +1,111 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// grafanaAnnotation - тело POST /api/annotations, время в миллисекундах
+type grafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int      `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd,omitempty"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+// annotateRun отмечает завершенный запуск аннотацией Grafana в фоне;
+// ошибки только логируются
+func annotateRun(t *tenant, runID uint) {
+	if cfg.Grafana.URL == "" {
+		return
+	}
+	go func() {
+		var run PlaybookRun
+		if err := t.primaryDB().First(&run, runID).Error; err != nil {
+			log.Printf("Failed to load run %d for Grafana annotation: %v", runID, err)
+			return
+		}
+		if !shouldAnnotate(run) {
+			return
+		}
+		if err := postGrafanaAnnotation(runAnnotation(t, run)); err != nil {
+			log.Printf("Failed to post Grafana annotation for run %d: %v", runID, err)
+		}
+	}()
+}
+
+func shouldAnnotate(run PlaybookRun) bool {
+	if len(cfg.Grafana.Playbooks) > 0 && !matchAny(cfg.Grafana.Playbooks, run.Playbook) {
+		return false
+	}
+	if len(cfg.Grafana.Inventories) > 0 && !matchAny(cfg.Grafana.Inventories, run.Inventory) {
+		return false
+	}
+	return true
+}
+
+func runAnnotation(t *tenant, run PlaybookRun) grafanaAnnotation {
+	tags := append([]string{}, cfg.Grafana.Tags...)
+	tags = append(tags, "playbook:"+run.Playbook, "status:"+string(run.Status))
+	if run.Inventory != "" {
+		tags = append(tags, "inventory:"+run.Inventory)
+	}
+	if t.Name != defaultTenantName {
+		tags = append(tags, "tenant:"+t.Name)
+	}
+
+	text := fmt.Sprintf("Playbook %s %s (run %d", run.Playbook, run.Status, run.ID)
+	if run.TriggeredBy != "" {
+		text += ", triggered by " + run.TriggeredBy
+	}
+	text += ")"
+	if link := runLink(run.ID); link != "" {
+		text += fmt.Sprintf(` <a href="%s">details</a>`, link)
+	}
+
+	annotation := grafanaAnnotation{
+		DashboardUID: cfg.Grafana.DashboardUID,
+		PanelID:      cfg.Grafana.PanelID,
+		Time:         run.StartTime.UnixMilli(),
+		Tags:         tags,
+		Text:         text,
+	}
+	if run.EndTime != nil {
+		annotation.TimeEnd = run.EndTime.UnixMilli()
+	}
+	return annotation
+}
+
+func postGrafanaAnnotation(annotation grafanaAnnotation) error {
+	payload, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(cfg.Grafana.URL, "/")+"/api/annotations", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Grafana.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Grafana.Token)
+	}
+
+	client := &http.Client{Timeout: cfg.Grafana.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("grafana responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Минимальная реализация gRPC поверх HTTP/2 стандартной библиотеки для
+// сервиса агентов: унарные вызовы и поток ответов, без сжатия сообщений.
+// Сообщения кодируются protobuf вручную по описанию в proto/agent.proto.
+
+const (
+	grpcContentType = "application/grpc"
+	// Наибольшее сообщение, как по умолчанию в grpc-go
+	grpcMaxMessage = 4 << 20
+)
+
+// Коды статусов gRPC
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+)
+
+// grpcError - ошибка вызова со статусом gRPC
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.message)
+}
+
+// grpcStatus переводит ошибку обработчика в статус gRPC так же, как
+// writeAgentError и writeDBError переводят ее в статус HTTP
+func grpcStatus(err error) (int, string) {
+	var grpcErr *grpcError
+	var agentErr *agentError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+		return grpcOK, ""
+	case errors.As(err, &grpcErr):
+		return grpcErr.code, grpcErr.message
+	case errors.As(err, &agentErr):
+		return grpcCode(agentErr.status), agentErr.message
+	case errors.As(err, &maxBytesErr):
+		return grpcResourceExhausted, "Request body too large"
+	case errors.Is(err, errQueryTimeout):
+		return grpcDeadlineExceeded, "Database query timed out"
+	case isConnectionError(err):
+		return grpcUnavailable, "Database unavailable"
+	}
+	return grpcInternal, err.Error()
+}
+
+func grpcCode(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusConflict:
+		return grpcFailedPrecondition
+	case http.StatusRequestEntityTooLarge:
+		return grpcResourceExhausted
+	case http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	}
+	return grpcInternal
+}
+
+// readGRPCMessage читает одно сообщение из потока кадров; io.EOF - сообщений больше нет
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "message compression is not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, &grpcError{grpcResourceExhausted, fmt.Sprintf("message of %d bytes exceeds %d", size, grpcMaxMessage)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// grpcServerStream - ответ на вызов: сообщения в теле, статус в трейлерах
+type grpcServerStream struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *grpcServerStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", grpcContentType)
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *grpcServerStream) send(msg []byte) error {
+	s.start()
+	if err := writeGRPCMessage(s.w, msg); err != nil {
+		return err
+	}
+	http.NewResponseController(s.w).Flush()
+	return nil
+}
+
+// finish завершает вызов статусом ошибки err; nil - успешно
+func (s *grpcServerStream) finish(err error) {
+	s.start()
+	code, message := grpcStatus(err)
+	s.w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		s.w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// encodeGRPCMessage кодирует grpc-message: байты вне печатного ASCII и % - как %XX
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcClient вызывает методы сервиса gRPC: по TLS для https и по HTTP/2 без
+// TLS (prior knowledge) для http
+type grpcClient struct {
+	baseURL string
+	service string
+	header  http.Header
+	http    *http.Client
+}
+
+func newGRPCClient(baseURL, service string, header http.Header, timeout time.Duration) *grpcClient {
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &grpcClient{
+		baseURL: baseURL,
+		service: service,
+		header:  header,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, Protocols: &protocols},
+		},
+	}
+}
+
+// call выполняет вызов method и передает каждое сообщение ответа в onMessage
+func (c *grpcClient) call(method string, request []byte, onMessage func([]byte) error) error {
+	var body bytes.Buffer
+	writeGRPCMessage(&body, request)
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/"+c.service+"/"+method, &body)
+	if err != nil {
+		return err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	for {
+		msg, err := readGRPCMessage(resp.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", method, err)
+		}
+		if err := onMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	// Ответ без сообщений может прийти целиком в заголовках
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("%s: invalid grpc-status %q", method, status)
+	}
+	if code != grpcOK {
+		if decoded, err := url.PathUnescape(message); err == nil {
+			message = decoded
+		}
+		return fmt.Errorf("%s: %w", method, &grpcError{code, message})
+	}
+	return nil
+}
+
+// protoField - поле сообщения protobuf: число для varint и fixed, содержимое для bytes
+type protoField struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+func parseProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		field := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			field.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			field.value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			field.value = uint64(v)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// parseProtoRequest разбирает сообщение запроса; ошибка - INVALID_ARGUMENT
+func parseProtoRequest(b []byte) ([]protoField, error) {
+	fields, err := parseProto(b)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("invalid request message: %v", err)}
+	}
+	return fields, nil
+}
+
+func (f protoField) string() string {
+	return string(f.bytes)
+}
+
+func (f protoField) double() float64 {
+	return math.Float64frombits(f.value)
+}
+
+// mapEntry разбирает элемент map<string, string>
+func (f protoField) mapEntry() (string, string, error) {
+	fields, err := parseProto(f.bytes)
+	if err != nil {
+		return "", "", err
+	}
+	var key, value string
+	for _, field := range fields {
+		switch field.num {
+		case 1:
+			key = field.string()
+		case 2:
+			value = field.string()
+		}
+	}
+	return key, value, nil
+}
+
+// Поля со значением по умолчанию, как в proto3, не записываются
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendProtoUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// appendProtoMessage записывает вложенное сообщение, даже пустое: его наличие значимо
+func appendProtoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendProtoStrings(b []byte, num protowire.Number, values []string) []byte {
+	for _, v := range values {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	return b
+}
+
+// appendProtoMap записывает map<string, string> в порядке ключей
+func appendProtoMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := appendProtoString(nil, 1, k)
+		entry = appendProtoString(entry, 2, m[k])
+		b = appendProtoMessage(b, num, entry)
+	}
+	return b
+}
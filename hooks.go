@@ -0,0 +1,119 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"ansible-api/config"
+)
+
+const (
+	defaultHookTimeout = 30 * time.Second
+	maxHookReason      = 4096
+)
+
+// runPreHooks вызывает pre-хуки по порядку. Первый отказ останавливает запуск,
+// причина отказа - вывод команды или тело ответа хука.
+func runPreHooks(run PlaybookRun) error {
+	for _, hook := range cfg.Hooks.Pre {
+		if err := callHook(hook, "pre", run); err != nil {
+			return fmt.Errorf("vetoed by pre-run hook %s: %v", hook.Name, err)
+		}
+	}
+	return nil
+}
+
+// runPostHooks передает post-хукам итоговую запись запуска. Хуки вызываются
+// в фоне, чтобы не задерживать воркер; ошибки только логируются.
+func runPostHooks(t *tenant, runID uint) {
+	if len(cfg.Hooks.Post) == 0 {
+		return
+	}
+	go func() {
+		var run PlaybookRun
+		if err := t.primaryDB().First(&run, runID).Error; err != nil {
+			log.Printf("Failed to load run %d for post-run hooks: %v", runID, err)
+			return
+		}
+		for _, hook := range cfg.Hooks.Post {
+			if err := callHook(hook, "post", run); err != nil {
+				log.Printf("Post-run hook %s failed for run %d: %v", hook.Name, run.ID, err)
+			}
+		}
+	}()
+}
+
+func callHook(hook config.Hook, stage string, run PlaybookRun) error {
+	payload, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch {
+	case len(hook.Command) > 0:
+		return callCommandHook(ctx, hook, stage, payload)
+	case hook.URL != "":
+		return callHTTPHook(ctx, hook, stage, payload)
+	default:
+		return fmt.Errorf("hook has neither command nor url")
+	}
+}
+
+func callCommandHook(ctx context.Context, hook config.Hook, stage string, payload []byte) error {
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(cmd.Environ(), "ANSIBLE_API_HOOK_STAGE="+stage)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, hookReason(output))
+	}
+	return nil
+}
+
+func callHTTPHook(ctx context.Context, hook config.Hook, stage string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ansible-Api-Hook-Stage", stage)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookReason))
+		return fmt.Errorf("%s: %s", resp.Status, hookReason(body))
+	}
+	return nil
+}
+
+func hookReason(output []byte) string {
+	reason := strings.TrimSpace(string(output))
+	if len(reason) > maxHookReason {
+		reason = reason[:maxHookReason]
+	}
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return reason
+}
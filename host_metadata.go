@@ -0,0 +1,177 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm/clause"
+)
+
+// HostMetadata - описание хоста для людей: понятное имя, владелец, окружение,
+// серийный номер и ссылки. Показывается рядом с хостом в проверках и запусках.
+type HostMetadata struct {
+	// Имя хоста, как в инвентаре и выводе ansible
+	Host        string `gorm:"type:text;primaryKey" json:"host"`
+	DisplayName string `gorm:"type:text" json:"display_name,omitempty"`
+	Owner       string `gorm:"type:text" json:"owner,omitempty"`
+	Environment string `gorm:"type:text" json:"environment,omitempty"`
+	Serial      string `gorm:"type:text" json:"serial,omitempty"`
+	// Ссылки по названию, например {"grafana": "https://..."}
+	Links JSONMap `gorm:"type:jsonb" json:"links,omitempty"`
+	// Метки для выбора хостов запуска по host_tags, например {"role": "web", "env": "staging"}
+	Tags      JSONMap   `gorm:"type:jsonb" json:"tags,omitempty"`
+	UpdatedBy string    `gorm:"type:text" json:"updated_by,omitempty"`
+	UpdatedAt time.Time `gorm:"type:timestamptz;not null" json:"updated_at"`
+}
+
+// label - имя хоста для списков: "db-primary (payments)"
+func (m HostMetadata) label() string {
+	label := m.Host
+	if m.DisplayName != "" {
+		label = m.DisplayName
+	}
+	if m.Owner != "" {
+		label += " (" + m.Owner + ")"
+	}
+	return label
+}
+
+func (m HostMetadata) validate() error {
+	for name, link := range m.Links {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("link %s must be an http or https URL", name)
+		}
+	}
+	return nil
+}
+
+// describeHosts возвращает описания хостов, у которых они есть. Описания
+// только дополняют ответ, поэтому ошибка БД пишется в журнал, а не возвращается.
+func describeHosts(t *tenant, hosts []string) map[string]HostMetadata {
+	if len(hosts) == 0 {
+		return nil
+	}
+	var found []HostMetadata
+	if err := t.db().Where("host IN ?", hosts).Find(&found).Error; err != nil {
+		log.Printf("Failed to load host metadata: %v", err)
+		return nil
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	described := make(map[string]HostMetadata, len(found))
+	for _, m := range found {
+		described[m.Host] = m
+	}
+	return described
+}
+
+// fillHostMetadata дополняет детали запуска описаниями хостов из упавших
+// задач и результатов по хостам
+func (run *PlaybookRun) fillHostMetadata(t *tenant) {
+	var hosts []string
+	for _, task := range run.FailedTasks {
+		hosts = append(hosts, task.Host)
+	}
+	for host := range run.HostResults {
+		hosts = append(hosts, host)
+	}
+	run.HostMetadata = describeHosts(t, hosts)
+	for i, task := range run.FailedTasks {
+		if m, ok := run.HostMetadata[task.Host]; ok {
+			run.FailedTasks[i].Label = m.label()
+		}
+	}
+}
+
+// fillHostMetadata дополняет проверку описаниями проверенных хостов
+func (c *InventoryCheck) fillHostMetadata(t *tenant) {
+	hosts := make([]string, 0, len(c.Results))
+	for host := range c.Results {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	c.HostMetadata = describeHosts(t, hosts)
+}
+
+func listHostMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	query := tenantOf(r).db().Order("host")
+	if owner := r.URL.Query().Get("owner"); owner != "" {
+		query = query.Where("owner = ?", owner)
+	}
+	if environment := r.URL.Query().Get("environment"); environment != "" {
+		query = query.Where("environment = ?", environment)
+	}
+	// tag=role=web, можно несколько: нужны все
+	for _, tag := range r.URL.Query()["tag"] {
+		key, value, _ := strings.Cut(tag, "=")
+		selector, _ := json.Marshal(map[string]string{key: value})
+		query = query.Where("tags @> ?::jsonb", string(selector))
+	}
+	hosts := []HostMetadata{}
+	if err := query.Find(&hosts).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hosts)
+}
+
+func getHostMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	var hosts []HostMetadata
+	if err := tenantOf(r).db().Where("host = ?", mux.Vars(r)["host"]).Limit(1).Find(&hosts).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if len(hosts) == 0 {
+		http.Error(w, "Host has no metadata", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hosts[0])
+}
+
+// setHostMetadataHandler заменяет описание хоста целиком. Наличие хоста в
+// инвентарях не проверяется: описание может появиться раньше хоста.
+func setHostMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	var metadata HostMetadata
+	if !decodeJSONBody(w, r, &metadata) {
+		return
+	}
+	if err := metadata.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metadata.Host = mux.Vars(r)["host"]
+	metadata.UpdatedBy = currentPrincipal(r).Name
+	metadata.UpdatedAt = time.Now()
+
+	err := tenantOf(r).db().Clauses(clause.OnConflict{UpdateAll: true}).Create(&metadata).Error
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+}
+
+func deleteHostMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	result := tenantOf(r).db().Where("host = ?", mux.Vars(r)["host"]).Delete(&HostMetadata{})
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Host has no metadata", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
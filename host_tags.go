@@ -0,0 +1,134 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Группа, в которую попадают хосты, выбранные по меткам
+const taggedHostsGroup = "tagged"
+
+// errNoTaggedHosts - ни один хост доступных инвентарей не подходит под host_tags
+var errNoTaggedHosts = errors.New("no hosts in accessible inventories match host_tags")
+
+func validateHostTags(req PlaybookRequest) error {
+	if len(req.HostTags) == 0 {
+		return nil
+	}
+	if req.Inventory != "" || req.Localhost || len(req.Hosts) > 0 || len(req.HostVars) > 0 {
+		return fmt.Errorf("host_tags cannot be combined with inventory, localhost, hosts or host_vars")
+	}
+	for key := range req.HostTags {
+		if key == "" {
+			return fmt.Errorf("host tag name must not be empty")
+		}
+	}
+	return nil
+}
+
+// resolveHostTags находит хосты, у описаний которых есть все метки tags, среди
+// хостов инвентарей, доступных p. Хосты возвращаются по порядку имен.
+func resolveHostTags(t *tenant, p principal, tags map[string]string) ([]string, error) {
+	selector, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	var tagged []string
+	if err := t.db().Model(&HostMetadata{}).Where("tags @> ?::jsonb", string(selector)).
+		Pluck("host", &tagged).Error; err != nil {
+		return nil, err
+	}
+	if len(tagged) == 0 {
+		return nil, errNoTaggedHosts
+	}
+
+	var inventories []Inventory
+	if err := visibleInventories(t.db().Model(&Inventory{}), p).Order("name").Find(&inventories).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, inv := range inventories {
+		for host := range inventoryHostLines(inv.Content) {
+			known[host] = true
+		}
+	}
+	var hosts []string
+	for _, host := range tagged {
+		if known[host] {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errNoTaggedHosts
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// taggedInventory собирает временный инвентарь из строк хостов hosts (с их
+// переменными) в сохраненных инвентарях. Строка хоста берется из первого по
+// имени инвентаря, где он есть; переменные групп исходных инвентарей не переносятся.
+func taggedInventory(t *tenant, hosts []string) (string, error) {
+	var inventories []Inventory
+	if err := t.primaryDB().Order("name").Find(&inventories).Error; err != nil {
+		return "", err
+	}
+	lines := make(map[string]string)
+	for _, inv := range inventories {
+		for host, line := range inventoryHostLines(inv.Content) {
+			if _, ok := lines[host]; !ok {
+				lines[host] = line
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("[" + taggedHostsGroup + "]\n")
+	found := 0
+	for _, host := range hosts {
+		if line, ok := lines[host]; ok {
+			b.WriteString(line + "\n")
+			found++
+		}
+	}
+	if found == 0 {
+		return "", fmt.Errorf("none of the tagged hosts is left in inventories")
+	}
+	return b.String(), nil
+}
+
+// inventoryHostLines разбирает INI-инвентарь: строки хостов в секциях групп
+// по именам хостов. Инвентари с неподставленными параметрами пропускаются.
+func inventoryHostLines(content string) map[string]string {
+	lines := make(map[string]string)
+	if len(missingInventoryParams(content, nil)) > 0 {
+		return lines
+	}
+	inHosts := true
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			// [group:vars] и [group:children] содержат не хосты
+			inHosts = !strings.Contains(line, ":")
+			continue
+		}
+		if !inHosts {
+			continue
+		}
+		host, _, _ := strings.Cut(line, " ")
+		// Диапазоны вида web[01:10] не разворачиваются
+		if strings.Contains(host, "[") {
+			continue
+		}
+		if _, ok := lines[host]; !ok {
+			lines[host] = line
+		}
+	}
+	return lines
+}
@@ -0,0 +1,75 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Группа, в которую попадают хосты из запроса
+const inlineHostsGroup = "inline"
+
+var (
+	// Имя хоста или IP-адрес (в том числе IPv6); пробелы и скобки изменили бы смысл строки инвентаря
+	inlineHostRe  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+	hostVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// Шаблон --limit без пробелов; @файл не допускается, чтобы не читать файлы сервера
+	limitRe = regexp.MustCompile(`^[^@\s][^\s]*$`)
+)
+
+// validateInlineHosts проверяет хосты и переменные, из которых будет собран инвентарь запуска
+func validateInlineHosts(req PlaybookRequest) error {
+	if len(req.Hosts) == 0 {
+		if len(req.HostVars) > 0 {
+			return fmt.Errorf("host_vars require hosts")
+		}
+		return nil
+	}
+	if req.Inventory != "" || req.Localhost {
+		return fmt.Errorf("hosts cannot be combined with inventory or localhost")
+	}
+	for _, host := range req.Hosts {
+		if !inlineHostRe.MatchString(host) {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+	for name, value := range req.HostVars {
+		if !hostVarNameRe.MatchString(name) {
+			return fmt.Errorf("invalid host variable name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("host variable %s must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+func validateLimit(limit string) error {
+	if limit != "" && !limitRe.MatchString(limit) {
+		return fmt.Errorf("invalid limit %q", limit)
+	}
+	return nil
+}
+
+// inlineInventory собирает INI-инвентарь из хостов запроса. Переменные
+// попадают в [all:vars] и действуют на все хосты.
+func inlineInventory(hosts []string, vars map[string]string) string {
+	var b strings.Builder
+	b.WriteString("[" + inlineHostsGroup + "]\n")
+	for _, host := range hosts {
+		b.WriteString(host + "\n")
+	}
+	if len(vars) > 0 {
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.WriteString("\n[all:vars]\n")
+		for _, name := range names {
+			b.WriteString(name + "=" + vars[name] + "\n")
+		}
+	}
+	return b.String()
+}
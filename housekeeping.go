@@ -0,0 +1,165 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CleanupCounts - сколько записей удалено (или очищено) в каждой таблице
+type CleanupCounts map[string]int64
+
+func (c *CleanupCounts) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("value is not []byte")
+	}
+	return json.Unmarshal(b, &c)
+}
+
+func (c CleanupCounts) Value() (interface{}, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return json.Marshal(c)
+}
+
+// HousekeepingRun - запись о выполнении служебной задачи (очистки)
+type HousekeepingRun struct {
+	ID         uint          `gorm:"primaryKey" json:"id"`
+	Job        string        `gorm:"type:text;not null;index" json:"job"`
+	NodeID     string        `gorm:"type:text" json:"node_id,omitempty"`
+	StartedAt  time.Time     `gorm:"type:timestamptz;not null" json:"started_at"`
+	FinishedAt time.Time     `gorm:"type:timestamptz;not null" json:"finished_at"`
+	Success    bool          `gorm:"not null" json:"success"`
+	Deleted    CleanupCounts `gorm:"type:jsonb" json:"deleted"`
+	Error      string        `gorm:"type:text" json:"error,omitempty"`
+}
+
+const cleanupJob = "cleanup"
+
+// cleanupOldLogs удаляет данные старше logging.retention_days. Ошибка одного
+// шага не прерывает остальные; итог по таблицам сохраняется в housekeeping_run
+// схемы арендатора и отдается в метриках.
+func cleanupOldLogs() {
+	forEachTenant(cleanupTenant)
+}
+
+func cleanupTenant(t *tenant) {
+	log.Printf("Starting cleanup of logs of tenant %s older than %d days", t.Name, cfg.Logging.RetentionDays)
+
+	startedAt := time.Now()
+	retentionPeriod := startedAt.AddDate(0, 0, -cfg.Logging.RetentionDays)
+	deleted := make(CleanupCounts)
+	var errs []error
+
+	step := func(table string, result *gorm.DB) {
+		if result.Error != nil {
+			log.Printf("Error cleaning up %s: %v", table, result.Error)
+			errs = append(errs, fmt.Errorf("%s: %v", table, result.Error))
+			return
+		}
+		deleted[table] += result.RowsAffected
+	}
+
+	// Целиком устаревшие месяцы удаляются вместе с партициями,
+	// построчно чистится только пограничная партиция
+	for _, table := range partitionedTables {
+		dropped, err := dropExpiredPartitions(t, table, retentionPeriod)
+		if err != nil {
+			log.Printf("Error dropping expired partitions of %s: %v", table, err)
+			errs = append(errs, fmt.Errorf("%s partitions: %v", table, err))
+		}
+		deleted[table+"_partitions"] += int64(dropped)
+	}
+
+	// Удаление старых запусков, логи - представление над ними
+	step("playbook_run", t.db().Where("start_time < ?", retentionPeriod).Delete(&PlaybookRun{}))
+
+	// Вывод хранится меньше метаданных: текст очищается, статус и длительности остаются для статистики
+	outputPeriod := retentionPeriod
+	if days := cfg.Logging.OutputRetentionDays; days > 0 && days < cfg.Logging.RetentionDays {
+		outputPeriod = startedAt.AddDate(0, 0, -days)
+		step("playbook_run_output", t.db().Model(&PlaybookRun{}).
+			Where("start_time < ? AND status NOT IN ? AND NOT output_purged", outputPeriod, []PlaybookRunStatus{RunStatusQueued, RunStatusStarted}).
+			Updates(map[string]interface{}{"output": "", "error": "", "output_purged": true}))
+	}
+
+	// Удаление отработанных записей очереди
+	step("run_queue_entry", t.db().Where("state = ? AND updated_at < ?", QueueStateDone, retentionPeriod).Delete(&RunQueueEntry{}))
+
+	// Удаление сохраненного построчного вывода и событий
+	step("run_output_chunk", t.db().Where("created_at < ?", outputPeriod).Delete(&RunOutputChunk{}))
+	step("run_artifact", t.db().Where("created_at < ?", outputPeriod).Delete(&RunArtifact{}))
+	step("run_event", t.db().Where("created_at < ?", retentionPeriod).Delete(&RunEvent{}))
+
+	// Подписи старше допустимого расхождения времени повторить уже нельзя
+	step("request_signature", t.db().Where("created_at < ?", startedAt.Add(-2*cfg.Auth.SignatureMaxSkew)).Delete(&RequestSignature{}))
+
+	// Удаление старых проверок инвентарей
+	step("inventory_check", t.db().Where("started_at < ?", retentionPeriod).Delete(&InventoryCheck{}))
+
+	finishedAt := time.Now()
+	record := HousekeepingRun{
+		Job:        cleanupJob,
+		NodeID:     cfg.Server.NodeID,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Success:    len(errs) == 0,
+		Deleted:    deleted,
+	}
+	if err := errors.Join(errs...); err != nil {
+		record.Error = err.Error()
+	}
+	if err := t.db().Create(&record).Error; err != nil {
+		log.Printf("Failed to record cleanup execution: %v", err)
+	}
+
+	for table, count := range deleted {
+		cleanupDeletedTotal.WithLabelValues(t.Name, table).Add(float64(count))
+	}
+	cleanupDuration.WithLabelValues(t.Name).Set(finishedAt.Sub(startedAt).Seconds())
+	if record.Success {
+		cleanupRunsTotal.WithLabelValues(t.Name, "success").Inc()
+		cleanupLastSuccess.WithLabelValues(t.Name).Set(float64(finishedAt.Unix()))
+	} else {
+		cleanupRunsTotal.WithLabelValues(t.Name, "failure").Inc()
+	}
+
+	log.Printf("Cleanup of tenant %s finished in %s: %s", t.Name, finishedAt.Sub(startedAt).Round(time.Millisecond), deleted)
+}
+
+// String - счетчики в стабильном порядке для логов
+func (c CleanupCounts) String() string {
+	parts := make([]string, 0, len(c))
+	for _, table := range slices.Sorted(maps.Keys(c)) {
+		parts = append(parts, fmt.Sprintf("%s=%d", table, c[table]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// restoreCleanupMetrics поднимает время последней успешной очистки из БД,
+// чтобы после перезапуска алерт не срабатывал до следующей очистки
+func restoreCleanupMetrics() {
+	forEachTenant(func(t *tenant) {
+		var last HousekeepingRun
+		err := t.db().Where("job = ? AND success", cleanupJob).Order("finished_at DESC").Limit(1).Find(&last).Error
+		if err != nil {
+			log.Printf("Failed to load last cleanup execution of tenant %s: %v", t.Name, err)
+			return
+		}
+		if last.ID != 0 {
+			cleanupLastSuccess.WithLabelValues(t.Name).Set(float64(last.FinishedAt.Unix()))
+		}
+	})
+}
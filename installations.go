@@ -0,0 +1,175 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Имя установки ansible из PATH сервера, используется без настроенных установок
+const systemAnsibleName = "system"
+
+// ansibleInstallation - установка ansible (каталог с бинарниками или virtualenv)
+type ansibleInstallation struct {
+	Name string
+	// Каталог с ansible-playbook; пустой - искать в PATH
+	BinDir string
+	// Корень virtualenv, если установка - virtualenv
+	VirtualEnv string
+	// Шаблоны имен playbook (как в path.Match), которые выполняются этой установкой
+	Playbooks []string
+	// Версия ansible-core по ansible-playbook --version
+	Version string
+	// Образ execution environment; с ним ansible-playbook выполняется через ansible-navigator
+	Image           string
+	ContainerEngine string
+	PullPolicy      string
+}
+
+var (
+	ansibleInstallations = make(map[string]*ansibleInstallation)
+	// Установки в порядке конфигурации: первая подходящая по шаблону playbook выигрывает
+	ansibleInstallationList []*ansibleInstallation
+	defaultAnsible          *ansibleInstallation
+)
+
+var ansibleCoreVersionRe = regexp.MustCompile(`\[core ([^\]]+)\]`)
+
+// loadAnsibleInstallations проверяет установки из ansible.installations и определяет их версии
+func loadAnsibleInstallations() error {
+	system := &ansibleInstallation{Name: systemAnsibleName}
+	ansibleInstallations = map[string]*ansibleInstallation{systemAnsibleName: system}
+	ansibleInstallationList = nil
+
+	for _, c := range cfg.Ansible.Installations {
+		if c.Name == "" || ansibleInstallations[c.Name] != nil {
+			return fmt.Errorf("ansible installation %q: empty or duplicate name", c.Name)
+		}
+		inst := &ansibleInstallation{Name: c.Name, BinDir: c.Path, Playbooks: c.Playbooks}
+		if err := inst.useExecutionEnvironment(c); err != nil {
+			return fmt.Errorf("ansible installation %s: %v", c.Name, err)
+		}
+		// virtualenv: бинарники лежат в bin/
+		if _, err := os.Stat(filepath.Join(c.Path, "bin", inst.executable())); c.Path != "" && err == nil {
+			inst.VirtualEnv = c.Path
+			inst.BinDir = filepath.Join(c.Path, "bin")
+		}
+		if _, err := exec.LookPath(inst.binary(inst.executable())); err != nil {
+			return fmt.Errorf("ansible installation %s: %v", c.Name, err)
+		}
+		ansibleInstallations[c.Name] = inst
+		ansibleInstallationList = append(ansibleInstallationList, inst)
+	}
+
+	defaultAnsible = system
+	if name := cfg.Ansible.DefaultInstallation; name != "" {
+		if defaultAnsible = ansibleInstallations[name]; defaultAnsible == nil {
+			return fmt.Errorf("default ansible installation %q is not configured", name)
+		}
+	}
+
+	for _, inst := range ansibleInstallations {
+		inst.Version = detectAnsibleVersion(inst)
+		log.Printf("Ansible installation %s: version %q", inst.Name, inst.Version)
+	}
+	return nil
+}
+
+func detectAnsibleVersion(inst *ansibleInstallation) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	args := []string{inst.binary("ansible-playbook"), "--version"}
+	if inst.Image != "" {
+		args = append(inst.navigatorArgs("exec"), "--", "ansible-playbook", "--version")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = inst.env()
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	if err := cmd.Run(); err != nil {
+		log.Printf("Failed to detect version of ansible installation %s: %v", inst.Name, err)
+		return ""
+	}
+
+	// ansible-playbook [core 2.16.3]; у ansible до 2.11 - ansible-playbook 2.9.27
+	firstLine, _, _ := strings.Cut(output.String(), "\n")
+	if m := ansibleCoreVersionRe.FindStringSubmatch(firstLine); m != nil {
+		return m[1]
+	}
+	if fields := strings.Fields(firstLine); len(fields) > 1 {
+		return fields[1]
+	}
+	return ""
+}
+
+// selectAnsibleInstallation выбирает установку для запуска: указанную в запросе,
+// первую подходящую по шаблону playbook или установку по умолчанию
+func selectAnsibleInstallation(req PlaybookRequest) (*ansibleInstallation, error) {
+	if req.Ansible != "" {
+		inst := ansibleInstallations[req.Ansible]
+		if inst == nil {
+			return nil, fmt.Errorf("unknown ansible installation %q", req.Ansible)
+		}
+		return inst, nil
+	}
+	for _, inst := range ansibleInstallationList {
+		for _, pattern := range inst.Playbooks {
+			if ok, _ := path.Match(pattern, req.Playbook); ok {
+				return inst, nil
+			}
+		}
+	}
+	return defaultAnsible, nil
+}
+
+// ansibleInstallationFor возвращает установку, выбранную при постановке запуска в очередь
+func ansibleInstallationFor(name string) (*ansibleInstallation, error) {
+	if name == "" {
+		return defaultAnsible, nil
+	}
+	inst := ansibleInstallations[name]
+	if inst == nil {
+		return nil, fmt.Errorf("ansible installation %q is no longer configured", name)
+	}
+	return inst, nil
+}
+
+// binary - путь к инструменту ansible этой установки
+func (i *ansibleInstallation) binary(tool string) string {
+	if i == nil || i.BinDir == "" {
+		return tool
+	}
+	return filepath.Join(i.BinDir, tool)
+}
+
+// env - окружение процесса: каталог установки первым в PATH, чтобы вызываемые
+// ansible инструменты и python брались из той же установки
+func (i *ansibleInstallation) env() []string {
+	env := os.Environ()
+	if i == nil || i.BinDir == "" {
+		return env
+	}
+	result := make([]string, 0, len(env)+2)
+	for _, kv := range env {
+		switch {
+		case strings.HasPrefix(kv, "PATH="):
+			kv = "PATH=" + i.BinDir + string(os.PathListSeparator) + strings.TrimPrefix(kv, "PATH=")
+		case strings.HasPrefix(kv, "VIRTUAL_ENV="):
+			continue
+		}
+		result = append(result, kv)
+	}
+	if i.VirtualEnv != "" {
+		result = append(result, "VIRTUAL_ENV="+i.VirtualEnv)
+	}
+	return result
+}
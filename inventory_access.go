@@ -0,0 +1,128 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+type InventoryAccess string
+
+const (
+	// Только владелец
+	AccessPrivate InventoryAccess = "private"
+	// Владелец и его команда
+	AccessTeam InventoryAccess = "team"
+	// Использовать могут все, изменять - владелец и его команда
+	AccessShared InventoryAccess = "shared"
+)
+
+func (a InventoryAccess) valid() bool {
+	switch a {
+	case AccessPrivate, AccessTeam, AccessShared:
+		return true
+	}
+	return false
+}
+
+func (p principal) unrestricted() bool {
+	return !authEnforced() || p.Admin
+}
+
+func (p principal) sameTeam(inv Inventory) bool {
+	return !p.Anonymous && p.Team != "" && p.Team == inv.Team
+}
+
+func (p principal) owns(inv Inventory) bool {
+	return !p.Anonymous && p.Name == inv.Owner
+}
+
+// canUse - чтение инвентаря, запуск по нему и проверка хостов.
+// Инвентари без владельца, созданные до введения прав, доступны всем.
+func (p principal) canUse(inv Inventory) bool {
+	if p.unrestricted() || inv.Owner == "" {
+		return true
+	}
+	switch inv.Access {
+	case AccessShared:
+		return true
+	case AccessTeam:
+		return p.owns(inv) || p.sameTeam(inv)
+	default:
+		return p.owns(inv)
+	}
+}
+
+// canModify - изменение, удаление и восстановление инвентаря
+func (p principal) canModify(inv Inventory) bool {
+	if p.unrestricted() {
+		return true
+	}
+	if inv.Owner == "" {
+		return !p.Anonymous
+	}
+	if inv.Access == AccessPrivate {
+		return p.owns(inv)
+	}
+	return p.owns(inv) || p.sameTeam(inv)
+}
+
+// visibleInventories ограничивает запрос инвентарями, которые principal может использовать
+func visibleInventories(query *gorm.DB, p principal) *gorm.DB {
+	if p.unrestricted() {
+		return query
+	}
+	if p.Anonymous {
+		return query.Where("owner = '' OR access = ?", AccessShared)
+	}
+	return query.Where("owner = '' OR access = ? OR owner = ? OR (access = ? AND team <> '' AND team = ?)",
+		AccessShared, p.Name, AccessTeam, p.Team)
+}
+
+var errInventoryForbidden = errors.New("not allowed to modify this inventory")
+
+// loadInventory находит инвентарь по имени и проверяет права. Недоступный
+// инвентарь выглядит как несуществующий, чтобы не раскрывать его наличие.
+func loadInventory(w http.ResponseWriter, r *http.Request, name string, modify bool) (*Inventory, bool) {
+	inv, err := storeOf(r).GetInventory(name)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Inventory not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return nil, false
+	}
+
+	p := currentPrincipal(r)
+	if !p.canUse(inv) {
+		http.Error(w, "Inventory not found", http.StatusNotFound)
+		return nil, false
+	}
+	if modify && !p.canModify(inv) {
+		http.Error(w, errInventoryForbidden.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	return &inv, true
+}
+
+// applyOwnership заполняет владельца нового инвентаря. Задать чужого
+// владельца или команду может только администратор.
+func applyOwnership(inv *Inventory, p principal) error {
+	if !p.Admin {
+		inv.Owner = p.Name
+		inv.Team = p.Team
+	}
+	if inv.Access == "" {
+		inv.Access = AccessTeam
+		if inv.Owner == "" {
+			inv.Access = AccessShared
+		}
+	}
+	if !inv.Access.valid() {
+		return fmt.Errorf("invalid access %q, expected private, team or shared", inv.Access)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package ansibleapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Префикс файлов кеша; подходит под шаблон inventory-*.ini, поэтому файлы,
+// не использованные дольше disk.temp_file_max_age, удаляет cleanupTempFiles
+const inventoryCachePrefix = "inventory-cache-"
+
+// cachedInventoryFile возвращает файл во временном каталоге с содержимым
+// инвентаря, создавая его при первом использовании. Имя файла - хеш
+// содержимого, поэтому запуски по одной версии инвентаря разделяют файл, а
+// изменение инвентаря или его параметров дает новый файл.
+func cachedInventoryFile(content string) (string, error) {
+	dir := cfg.Disk.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(content))
+	path := filepath.Join(dir, inventoryCachePrefix+hex.EncodeToString(sum[:])+".ini")
+
+	// Обновленное время изменения защищает используемый файл от очистки
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return path, nil
+	}
+
+	// Файл пишется под временным именем и переименовывается, чтобы
+	// параллельный запуск не прочитал его недописанным
+	tmpfile, err := os.CreateTemp(dir, "inventory-*.ini")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp inventory file: %v", err)
+	}
+	if _, err := tmpfile.WriteString(content); err != nil {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+		return "", fmt.Errorf("failed to write inventory content: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		os.Remove(tmpfile.Name())
+		return "", fmt.Errorf("failed to close temp file: %v", err)
+	}
+	if err := os.Rename(tmpfile.Name(), path); err != nil {
+		os.Remove(tmpfile.Name())
+		return "", fmt.Errorf("failed to store inventory in cache: %v", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,54 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Подстановка {{ .name }} в содержимом инвентаря. Точка отличает ее от выражений
+// Jinja ({{ var }}), которые остаются ansible.
+var inventoryParamRe = regexp.MustCompile(`\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// validateInventoryParams проверяет, что для подстановок инвентаря заданы все
+// параметры и значения не добавляют строк в инвентарь
+func validateInventoryParams(content string, params map[string]string) error {
+	for name, value := range params {
+		if !hostVarNameRe.MatchString(name) {
+			return fmt.Errorf("invalid inventory parameter name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("inventory parameter %s must not contain line breaks", name)
+		}
+	}
+	if missing := missingInventoryParams(content, params); len(missing) > 0 {
+		return fmt.Errorf("missing inventory parameters: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// missingInventoryParams - имена подстановок инвентаря, для которых нет параметра
+func missingInventoryParams(content string, params map[string]string) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for _, match := range inventoryParamRe.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		if _, ok := params[name]; !ok && !seen[name] {
+			seen[name] = true
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// renderInventory подставляет параметры запроса в содержимое инвентаря
+func renderInventory(content string, params map[string]string) (string, error) {
+	if err := validateInventoryParams(content, params); err != nil {
+		return "", err
+	}
+	return inventoryParamRe.ReplaceAllStringFunc(content, func(placeholder string) string {
+		return params[inventoryParamRe.FindStringSubmatch(placeholder)[1]]
+	}), nil
+}
@@ -0,0 +1,34 @@
+package ansibleapi
+
+// InventoryReference - объект, который использует инвентарь и сломается после его удаления
+type InventoryReference struct {
+	Type     string `json:"type"`
+	ID       uint   `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Playbook string `json:"playbook,omitempty"`
+}
+
+// inventoryReferences собирает ссылки на инвентарь. Сейчас на инвентарь
+// ссылаются только запуски в очереди и выполняющиеся запуски.
+func inventoryReferences(t *tenant, name string) ([]InventoryReference, error) {
+	var runs []PlaybookRun
+	if err := t.primaryDB().
+		Select("id", "playbook", "status").
+		Where("inventory = ? AND status IN ?", name, []PlaybookRunStatus{RunStatusQueued, RunStatusStarted}).
+		Order("id ASC").
+		Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	references := make([]InventoryReference, 0, len(runs))
+	for _, run := range runs {
+		references = append(references, InventoryReference{
+			Type:     "run",
+			ID:       run.ID,
+			Status:   string(run.Status),
+			Playbook: run.Playbook,
+		})
+	}
+	return references, nil
+}
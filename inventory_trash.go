@@ -0,0 +1,62 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// restoreInventoryHandler возвращает из корзины последний удаленный
+// инвентарь с этим именем вместе с проверками, удаленными вместе с ним
+func restoreInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	p := currentPrincipal(r)
+
+	inv, err := storeOf(r).RestoreInventory(name, func(inv Inventory) error {
+		if !p.canUse(inv) {
+			return errNotFound
+		}
+		if !p.canModify(inv) {
+			return errInventoryForbidden
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errInventoryForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, errInventoryExists):
+		http.Error(w, "Inventory with this name already exists", http.StatusConflict)
+		return
+	case errors.Is(err, errNotFound):
+		http.Error(w, "Deleted inventory not found", http.StatusNotFound)
+		return
+	case err != nil:
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv)
+}
+
+var errInventoryExists = errors.New("inventory already exists")
+
+// purgeInventoryHandler безвозвратно удаляет все версии инвентаря с этим
+// именем, включая удаленные; проверки удаляются каскадом в БД
+func purgeInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Admin access required", http.StatusForbidden)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := storeOf(r).PurgeInventory(name); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
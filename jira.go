@@ -0,0 +1,209 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"ansible-api/config"
+)
+
+// Сколько последних строк вывода попадает в задачу
+const jiraOutputLines = 40
+
+var jiraLabelRe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// criticalMapping ищет playbook в списке jira.critical и подставляет общие
+// project и issue_type, если они не заданы для шаблона
+func criticalMapping(playbook string) (config.JiraMapping, bool) {
+	for _, m := range cfg.Jira.Critical {
+		if ok, _ := path.Match(m.Playbook, playbook); !ok {
+			continue
+		}
+		if m.Project == "" {
+			m.Project = cfg.Jira.Project
+		}
+		if m.IssueType == "" {
+			m.IssueType = cfg.Jira.IssueType
+		}
+		return m, true
+	}
+	return config.JiraMapping{}, false
+}
+
+// reportRunFailure сообщает о падении критичного playbook в Jira. Если запуск
+// привязан к заявке Jira, комментарий добавляется в нее; иначе - в открытую
+// задачу по этому playbook, а если такой нет, заводится новая.
+// Вывод и ошибка должны быть уже замаскированы.
+func reportRunFailure(run PlaybookRun, errorMsg, output string) {
+	if cfg.Jira.URL == "" {
+		return
+	}
+	mapping, ok := criticalMapping(run.Playbook)
+	if !ok {
+		return
+	}
+
+	go func() {
+		report := failureReport(run, errorMsg, output)
+
+		key := ""
+		if run.Ticket != nil && strings.EqualFold(run.Ticket.System, "jira") {
+			key = run.Ticket.ID
+		} else {
+			var err error
+			key, err = findOpenJiraIssue(mapping.Project, playbookLabel(run.Playbook))
+			if err != nil {
+				log.Printf("Failed to search Jira issues for run %d: %v", run.ID, err)
+			}
+		}
+
+		if key != "" {
+			if err := commentJiraIssue(key, report); err != nil {
+				log.Printf("Failed to comment Jira issue %s for run %d: %v", key, run.ID, err)
+				return
+			}
+			log.Printf("Run %d failure reported in Jira issue %s", run.ID, key)
+			return
+		}
+
+		key, err := createJiraIssue(mapping, run, report)
+		if err != nil {
+			log.Printf("Failed to create Jira issue for run %d: %v", run.ID, err)
+			return
+		}
+		log.Printf("Run %d failure reported in new Jira issue %s", run.ID, key)
+	}()
+}
+
+func playbookLabel(playbook string) string {
+	return "ansible-api-" + strings.Trim(jiraLabelRe.ReplaceAllString(playbook, "-"), "-")
+}
+
+// failureReport - текст задачи или комментария в разметке Jira
+func failureReport(run PlaybookRun, errorMsg, output string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Playbook *%s* finished with status *%s* (run %d", run.Playbook, run.Status, run.ID)
+	if run.NodeID != "" {
+		fmt.Fprintf(&b, " on %s", run.NodeID)
+	}
+	b.WriteString(").\n")
+	if run.Inventory != "" {
+		fmt.Fprintf(&b, "Inventory: %s\n", run.Inventory)
+	}
+	if link := runLink(run.ID); link != "" {
+		fmt.Fprintf(&b, "Run: %s\n", link)
+	}
+	if errorMsg != "" {
+		fmt.Fprintf(&b, "\nError:\n{noformat}\n%s\n{noformat}\n", errorMsg)
+	}
+	if excerpt := lastLines(output, jiraOutputLines); excerpt != "" {
+		fmt.Fprintf(&b, "\nLast %d lines of output:\n{noformat}\n%s\n{noformat}\n", jiraOutputLines, excerpt)
+	}
+	return b.String()
+}
+
+func runLink(runID uint) string {
+	if cfg.Server.PublicURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/runs/%d", strings.TrimRight(cfg.Server.PublicURL, "/"), runID)
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func findOpenJiraIssue(project, label string) (string, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done ORDER BY created DESC`, project, label)
+	query := url.Values{"jql": {jql}, "maxResults": {"1"}, "fields": {"key"}}
+
+	var result struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	if err := jiraRequest(http.MethodGet, "/rest/api/2/search?"+query.Encode(), nil, &result); err != nil {
+		return "", err
+	}
+	if len(result.Issues) == 0 {
+		return "", nil
+	}
+	return result.Issues[0].Key, nil
+}
+
+func createJiraIssue(mapping config.JiraMapping, run PlaybookRun, description string) (string, error) {
+	issue := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": mapping.Project},
+			"issuetype":   map[string]string{"name": mapping.IssueType},
+			"summary":     fmt.Sprintf("Playbook %s %s", run.Playbook, run.Status),
+			"description": description,
+			"labels":      []string{"ansible-api", playbookLabel(run.Playbook)},
+		},
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := jiraRequest(http.MethodPost, "/rest/api/2/issue", issue, &created); err != nil {
+		return "", err
+	}
+	return created.Key, nil
+}
+
+func commentJiraIssue(key, body string) error {
+	return jiraRequest(http.MethodPost, "/rest/api/2/issue/"+url.PathEscape(key)+"/comment",
+		map[string]string{"body": body}, nil)
+}
+
+func jiraRequest(method, endpoint string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(cfg.Jira.URL, "/")+endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cfg.Jira.User != "" {
+		req.SetBasicAuth(cfg.Jira.User, cfg.Jira.Token)
+	} else if cfg.Jira.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Jira.Token)
+	}
+
+	client := &http.Client{Timeout: cfg.Jira.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("jira responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package ansibleapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Заголовок JWT: сервер выдает и принимает только HS256
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// Издатель токенов, чтобы не принять JWT другого сервиса с тем же ключом
+const jwtIssuer = "ansible-api"
+
+var errInvalidJWT = errors.New("invalid or expired token")
+
+// userClaims - утверждения JWT пользователя. Права берутся из токена до
+// окончания его срока: изменения пользователя действуют после нового входа.
+type userClaims struct {
+	Subject   string `json:"sub"`
+	Team      string `json:"team,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	Admin     bool   `json:"admin,omitempty"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// jwtEnabled сообщает, настроены ли вход пользователей и прием JWT
+func jwtEnabled() bool {
+	return cfg.Auth.JWTSecret != ""
+}
+
+// looksLikeJWT отличает JWT от токенов auth.tokens и токенов артефактов запусков
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+func signJWT(claims userClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(jwtHeader)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtSignature(unsigned), nil
+}
+
+func jwtSignature(unsigned string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.Auth.JWTSecret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyJWT проверяет алгоритм, подпись, издателя и срок токена
+func verifyJWT(token string) (userClaims, error) {
+	var claims userClaims
+	parts := strings.Split(token, ".")
+	if !jwtEnabled() || len(parts) != 3 {
+		return claims, errInvalidJWT
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, errInvalidJWT
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &h) != nil || h.Alg != "HS256" {
+		return claims, errInvalidJWT
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(jwtSignature(parts[0]+"."+parts[1]))) {
+		return claims, errInvalidJWT
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return claims, errInvalidJWT
+	}
+	if claims.Issuer != jwtIssuer || claims.Subject == "" || time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errInvalidJWT
+	}
+	return claims, nil
+}
@@ -0,0 +1,111 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// errNoMatchingRunner - ни один узел или агент в сети не подходит под runner_selector
+var errNoMatchingRunner = errors.New("no runner or online agent matches runner_selector")
+
+// labelsMatch сообщает, есть ли у labels все метки selector с теми же значениями
+func labelsMatch(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// routeRunnerSelector выбирает для запроса с runner_selector узел из
+// ansible.runners (первый подходящий) или, если таких нет, подходящего агента
+// в сети с наименьшим числом ожидающих и выполняющихся запусков
+func routeRunnerSelector(req *PlaybookRequest) error {
+	if len(req.RunnerSelector) == 0 {
+		return nil
+	}
+	if req.Runner != "" || req.Agent != "" {
+		return fmt.Errorf("runner_selector cannot be combined with runner or agent")
+	}
+
+	for _, runner := range remoteRunnerList {
+		if labelsMatch(runner.Labels, req.RunnerSelector) {
+			req.Runner = runner.Name
+			return nil
+		}
+	}
+
+	var agents []Agent
+	if err := defaultTenant().db().Where("last_seen_at > ?", time.Now().Add(-cfg.Agents.OfflineAfter)).
+		Order("name").Find(&agents).Error; err != nil {
+		return err
+	}
+	best, bestLoad := "", int64(-1)
+	for _, agent := range agents {
+		if !labelsMatch(agent.Labels, req.RunnerSelector) {
+			continue
+		}
+		load, err := agentLoad(agent.Name)
+		if err != nil {
+			return err
+		}
+		if bestLoad < 0 || load < bestLoad {
+			best, bestLoad = agent.Name, load
+		}
+	}
+	if best == "" {
+		return errNoMatchingRunner
+	}
+	req.Agent = best
+	return nil
+}
+
+// agentLoad - число ожидающих и выполняющихся запусков агента у всех арендаторов
+func agentLoad(name string) (int64, error) {
+	var total int64
+	for _, t := range tenantList {
+		var count int64
+		if err := t.db().Model(&RunQueueEntry{}).Where("agent = ? AND state <> ?", name, QueueStateDone).
+			Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// RunnerInfo - узел или агент, на котором можно выполнять запуски
+type RunnerInfo struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"` // ssh или agent
+	Labels map[string]string `json:"labels,omitempty"`
+	// Агент обращался к серверу в пределах agents.offline_after; узлы SSH всегда true
+	Online bool `json:"online"`
+}
+
+// listRunnersHandler отдает узлы SSH и агентов с их метками для runner_selector
+func listRunnersHandler(w http.ResponseWriter, r *http.Request) {
+	var agents []Agent
+	if err := defaultTenant().db().Order("name").Find(&agents).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	runners := []RunnerInfo{}
+	for _, runner := range remoteRunnerList {
+		runners = append(runners, RunnerInfo{Name: runner.Name, Type: "ssh", Labels: runner.Labels, Online: true})
+	}
+	onlineSince := time.Now().Add(-cfg.Agents.OfflineAfter)
+	for _, agent := range agents {
+		runners = append(runners, RunnerInfo{Name: agent.Name, Type: "agent", Labels: agent.Labels, Online: agent.LastSeenAt.After(onlineSince)})
+	}
+	sort.SliceStable(runners, func(i, j int) bool { return runners[i].Name < runners[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runners)
+}
@@ -0,0 +1,150 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PlaybookState string
+
+const (
+	// Запуски выполняются, но ответ и запуск содержат предупреждение
+	PlaybookDeprecated PlaybookState = "deprecated"
+	// Новые запуски отклоняются со ссылкой на замену
+	PlaybookFrozen PlaybookState = "frozen"
+)
+
+// PlaybookLifecycle - отметка об устаревании или заморозке playbook
+type PlaybookLifecycle struct {
+	Playbook    string        `gorm:"type:text;primaryKey" json:"playbook"`
+	State       PlaybookState `gorm:"type:text;not null" json:"state"`
+	Message     string        `gorm:"type:text" json:"message,omitempty"`
+	Replacement string        `gorm:"type:text" json:"replacement,omitempty"`
+	SetBy       string        `gorm:"type:text" json:"set_by,omitempty"`
+	UpdatedAt   time.Time     `gorm:"type:timestamptz;not null" json:"updated_at"`
+}
+
+// frozenPlaybookError - запуск замороженного playbook
+type frozenPlaybookError struct {
+	lifecycle PlaybookLifecycle
+}
+
+func (e *frozenPlaybookError) Error() string {
+	return e.lifecycle.notice()
+}
+
+// notice - текст предупреждения или отказа для пользователя
+func (l PlaybookLifecycle) notice() string {
+	text := fmt.Sprintf("playbook %s is %s", l.Playbook, l.State)
+	if l.Message != "" {
+		text += ": " + l.Message
+	}
+	if l.Replacement != "" {
+		text += "; use " + l.Replacement + " instead"
+	}
+	return text
+}
+
+// playbookLifecycle возвращает отметку playbook или nil, если ее нет
+func playbookLifecycle(t *tenant, playbook string) (*PlaybookLifecycle, error) {
+	var lifecycle PlaybookLifecycle
+	if err := t.db().Where("playbook = ?", playbook).First(&lifecycle).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &lifecycle, nil
+}
+
+// writeFrozenPlaybook отвечает 410 со ссылкой на замену
+func writeFrozenPlaybook(w http.ResponseWriter, err *frozenPlaybookError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       err.Error(),
+		"playbook":    err.lifecycle.Playbook,
+		"message":     err.lifecycle.Message,
+		"replacement": err.lifecycle.Replacement,
+	})
+}
+
+// setDeprecationHeaders сообщает клиенту об устаревшем playbook заголовками
+// Deprecation и Warning (код 299 - постоянное предупреждение)
+func setDeprecationHeaders(w http.ResponseWriter, warning string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Warning", fmt.Sprintf(`299 ansible-api "%s"`, strings.ReplaceAll(warning, `"`, `'`)))
+}
+
+func listPlaybookLifecyclesHandler(w http.ResponseWriter, r *http.Request) {
+	lifecycles := []PlaybookLifecycle{}
+	if err := tenantOf(r).db().Order("playbook").Find(&lifecycles).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lifecycles)
+}
+
+func getPlaybookLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	lifecycle, err := playbookLifecycle(tenantOf(r), mux.Vars(r)["name"])
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if lifecycle == nil {
+		http.Error(w, "Playbook is not deprecated or frozen", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lifecycle)
+}
+
+// setPlaybookLifecycleHandler помечает playbook устаревшим или замороженным.
+// Наличие файла не проверяется: заморозить можно и уже удаленный playbook.
+func setPlaybookLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	var lifecycle PlaybookLifecycle
+	if !decodeJSONBody(w, r, &lifecycle) {
+		return
+	}
+	if lifecycle.State != PlaybookDeprecated && lifecycle.State != PlaybookFrozen {
+		http.Error(w, "state must be deprecated or frozen", http.StatusBadRequest)
+		return
+	}
+	lifecycle.Playbook = mux.Vars(r)["name"]
+	if lifecycle.Replacement == lifecycle.Playbook {
+		http.Error(w, "Playbook cannot replace itself", http.StatusBadRequest)
+		return
+	}
+	lifecycle.SetBy = currentPrincipal(r).Name
+	lifecycle.UpdatedAt = time.Now()
+
+	err := tenantOf(r).db().Clauses(clause.OnConflict{UpdateAll: true}).Create(&lifecycle).Error
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lifecycle)
+}
+
+func deletePlaybookLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	result := tenantOf(r).db().Where("playbook = ?", mux.Vars(r)["name"]).Delete(&PlaybookLifecycle{})
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Playbook is not deprecated or frozen", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
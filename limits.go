@@ -0,0 +1,58 @@
+package ansibleapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+)
+
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// newAnsibleCommand создает команду ansible установки inst с учетом cfg.Ansible.Limits:
+// nice/ionice оборачивают команду (оба делают exec, PID сохраняется),
+// а cgroup назначается при старте процесса. cleanup нужно вызвать после Wait.
+func newAnsibleCommand(ctx context.Context, inst *ansibleInstallation, args []string) (*exec.Cmd, func(), error) {
+	limits := cfg.Ansible.Limits
+	args = inst.command(args)
+
+	if limits.IOClass != "" {
+		class, ok := ioniceClasses[limits.IOClass]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown io_class %q", limits.IOClass)
+		}
+		prefix := []string{"ionice", "-c", class}
+		if class != "3" {
+			prefix = append(prefix, "-n", strconv.Itoa(limits.IOPriority))
+		}
+		args = append(prefix, args...)
+	}
+
+	if limits.Nice != 0 {
+		args = append([]string{"nice", "-n", strconv.Itoa(limits.Nice)}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = inst.env()
+	configureProcessGroup(cmd)
+
+	cleanup := func() {}
+	if limits.CgroupRoot != "" {
+		release, err := attachCgroup(cmd, limits)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up cgroup: %v", err)
+		}
+		cleanup = func() {
+			if err := release(); err != nil {
+				log.Printf("Failed to remove run cgroup: %v", err)
+			}
+		}
+	}
+
+	return cmd, cleanup, nil
+}
@@ -1,38 +1,83 @@
-package main
+package ansibleapi
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/robfig/cron/v3"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
-
-	"ansible-api/config"
+	"gorm.io/plugin/dbresolver"
 )
 
 // Модели для GORM
 type PlaybookRequest struct {
-	Playbook  string            `json:"playbook"`
-	Inventory string            `json:"inventory,omitempty"`
-	ExtraVars map[string]string `json:"extra_vars,omitempty" gorm:"-"`
+	Playbook    string            `json:"playbook"`
+	Inventory   string            `json:"inventory,omitempty"`
+	ExtraVars   map[string]string `json:"extra_vars,omitempty" gorm:"-"`
+	RestartSafe bool              `json:"restart_safe,omitempty"`
+	// Синоним restart_safe: поставить запуск заново, если его прервал перезапуск узла
+	RerunOnRestart bool `json:"rerun_on_restart,omitempty"`
+	// Ключи extra_vars, значения которых не должны попасть в историю запусков
+	SensitiveVars []string `json:"sensitive_vars,omitempty"`
+	// Заявка на изменение, в рамках которой выполняется запуск
+	Ticket *TicketRef `json:"ticket,omitempty"`
+	// Установка ansible из ansible.installations; по умолчанию выбирается по playbook
+	Ansible string `json:"ansible,omitempty"`
+	// ansible_python_interpreter для всех хостов запуска, важнее ansible.default_python и инвентаря
+	PythonInterpreter string `json:"python_interpreter,omitempty"`
+	// Выполнить на сервере API (localhost, connection=local) без инвентаря
+	Localhost bool `json:"localhost,omitempty"`
+	// Разовый список хостов вместо сохраненного инвентаря и переменные для всех них
+	Hosts    []string          `json:"hosts,omitempty"`
+	HostVars map[string]string `json:"host_vars,omitempty"`
+	// Шаблон хостов для --limit, например web:&staging
+	Limit string `json:"limit,omitempty"`
+	// Значения подстановок {{ .name }} в содержимом сохраненного инвентаря
+	InventoryParams map[string]string `json:"inventory_params,omitempty"`
+	// Выполнить на хостах всех доступных инвентарей, у которых есть эти метки
+	HostTags map[string]string `json:"host_tags,omitempty"`
+	// Дополнительные флаги ansible-playbook из ansible.allowed_extra_args
+	ExtraArgs []string `json:"extra_args,omitempty"`
+	// Узел из ansible.runners, на котором выполнить запуск по SSH; по умолчанию выбирается по playbook
+	Runner string `json:"runner,omitempty"`
+	// Зарегистрированный агент, которому передать запуск
+	Agent string `json:"agent,omitempty"`
+	// Метки, которые должны быть у узла или агента запуска; выбирается подходящий
+	RunnerSelector map[string]string `json:"runner_selector,omitempty"`
+	// Пакет POST /api/runs/batch, в который входит запуск; задается сервером
+	BatchID *uint `json:"-"`
+	// Поэтапное выполнение по частям хостов вместо одного запуска
+	Rolling *RollingStrategy `json:"rolling,omitempty"`
+	// Перед стартом потребовать свежую успешную проверку инвентаря
+	Preflight *PreflightGate `json:"preflight,omitempty"`
+	// Rollout и номер его части, которые выполняет запуск; задаются сервером
+	RolloutID    *uint `json:"-"`
+	RolloutBatch int   `json:"-"`
+	// Команда вызывающей стороны; задается сервером
+	Team string `json:"-"`
 }
 
+// PlaybookLog - завершенный запуск в формате /api/logs. Читается из
+// представления playbook_log над playbook_run, отдельно не записывается.
+// id - прежний id лога для перенесенной истории, иначе id запуска.
 type PlaybookLog struct {
 	gorm.Model
+	RunID     uint      `gorm:"->;-:migration" json:"run_id"`
 	Playbook  string    `gorm:"type:text;not null" json:"playbook"`
 	Success   bool      `gorm:"type:boolean;not null" json:"success"`
 	Output    string    `gorm:"type:text" json:"output"`
@@ -45,29 +90,134 @@ type PlaybookLog struct {
 type PlaybookRunStatus string
 
 const (
+	RunStatusQueued    PlaybookRunStatus = "queued"
 	RunStatusStarted   PlaybookRunStatus = "started"
 	RunStatusCompleted PlaybookRunStatus = "completed"
 	RunStatusFailed    PlaybookRunStatus = "failed"
+	// Запуск прерван падением или перезапуском сервера
+	RunStatusInterrupted PlaybookRunStatus = "interrupted"
+	// Heartbeat запуска устарел, воркер считается потерянным
+	RunStatusLost      PlaybookRunStatus = "lost"
+	RunStatusCancelled PlaybookRunStatus = "cancelled"
+	RunStatusTimedOut  PlaybookRunStatus = "timed_out"
 )
 
 type PlaybookRun struct {
 	gorm.Model
 	Playbook    string            `gorm:"type:text;not null" json:"playbook"`
 	Inventory   string            `gorm:"type:text" json:"inventory,omitempty"`
+	Localhost   bool              `gorm:"not null;default:false" json:"localhost,omitempty"`
+	Hosts       JSONList          `gorm:"type:jsonb" json:"hosts,omitempty"`
+	HostVars    JSONMap           `gorm:"type:jsonb" json:"host_vars,omitempty"`
+	Limit       string            `gorm:"type:text" json:"limit,omitempty"`
+	ExtraArgs   JSONList          `gorm:"type:jsonb" json:"extra_args,omitempty"`
 	Status      PlaybookRunStatus `gorm:"type:text;not null" json:"status"`
 	StartTime   time.Time         `gorm:"type:timestamptz;not null" json:"start_time"`
 	EndTime     *time.Time        `gorm:"type:timestamptz" json:"end_time,omitempty"`
 	Duration    *float64          `gorm:"type:decimal" json:"duration,omitempty"`
 	TriggeredBy string            `gorm:"type:text" json:"triggered_by,omitempty"`
-	ExtraVars   JSONMap           `gorm:"type:jsonb" json:"extra_vars,omitempty"`
-	Output      string            `gorm:"type:text" json:"output,omitempty"`
-	Error       string            `gorm:"type:text" json:"error,omitempty"`
+	// Адрес соединения (прокси или сам клиент), TriggeredBy - клиент по X-Forwarded-For
+	PeerAddr  string  `gorm:"type:text" json:"peer_addr,omitempty"`
+	ExtraVars JSONMap `gorm:"type:jsonb" json:"extra_vars,omitempty"`
+	// Параметры, подставленные в инвентарь
+	InventoryParams JSONMap `gorm:"type:jsonb" json:"inventory_params,omitempty"`
+	// Метки, по которым выбраны хосты (в Hosts); инвентарь собирается из их строк
+	HostTags JSONMap `gorm:"type:jsonb" json:"host_tags,omitempty"`
+	// Требование свежей проверки инвентаря (preflight): наибольший возраст проверки в
+	// секундах (0 - без проверки) и допустимое число недоступных хостов; проверка,
+	// по которой допущен или остановлен запуск
+	PreflightMaxAge         int   `gorm:"not null;default:0" json:"preflight_max_age,omitempty"`
+	PreflightMaxUnreachable int   `gorm:"not null;default:0" json:"preflight_max_unreachable,omitempty"`
+	PreflightCheckID        *uint `json:"preflight_check_id,omitempty"`
+	// Зашифрованные значения sensitive_vars, в ExtraVars вместо них [redacted]
+	SealedVars  string     `gorm:"type:text" json:"-"`
+	Ticket      *TicketRef `gorm:"embedded;embeddedPrefix:ticket_" json:"ticket,omitempty"`
+	Output      string     `gorm:"type:text" json:"output,omitempty"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	NodeID      string     `gorm:"type:text;index" json:"node_id,omitempty"`
+	PID         int        `gorm:"column:pid" json:"pid,omitempty"`
+	RestartSafe bool       `gorm:"not null;default:false" json:"restart_safe"`
+	RelaunchOf  *uint      `json:"relaunch_of,omitempty"`
+	// Пакет, в составе которого поставлен запуск
+	BatchID *uint `gorm:"index" json:"batch_id,omitempty"`
+	// Rollout, часть которого выполняет запуск, и номер части начиная с 1
+	RolloutID    *uint `gorm:"index" json:"rollout_id,omitempty"`
+	RolloutBatch int   `gorm:"not null;default:0" json:"rollout_batch,omitempty"`
+	// Запуск verify_playbook по хостам canary, а не сама часть
+	RolloutVerify bool `gorm:"not null;default:false" json:"rollout_verify,omitempty"`
+	// Откат по политике rollbacks: откатываемый запуск; у упавшего - его откат
+	// или отметка, что откат ждет подтверждения
+	RollbackOf      *uint `gorm:"index" json:"rollback_of,omitempty"`
+	RollbackRunID   *uint `json:"rollback_run_id,omitempty"`
+	RollbackPending bool  `gorm:"not null;default:false" json:"rollback_pending,omitempty"`
+	// Повтор по политике retries: предыдущая попытка и номер этой, начиная с 1
+	RetryOf     *uint      `json:"retry_of,omitempty"`
+	Attempt     int        `gorm:"not null;default:1" json:"attempt"`
+	HeartbeatAt *time.Time `gorm:"type:timestamptz" json:"heartbeat_at,omitempty"`
+	PGID        int        `gorm:"column:pgid" json:"pgid,omitempty"`
+	// Время старта процесса ansible-playbook: отличает его от процесса,
+	// получившего тот же PID после перезапуска узла
+	ProcessStartedAt *time.Time `gorm:"type:timestamptz" json:"process_started_at,omitempty"`
+	// Отмена запрошена через API; подхватывается heartbeat'ом узла-исполнителя
+	CancelRequested bool   `gorm:"not null;default:false" json:"cancel_requested"`
+	Teardown        string `gorm:"type:text" json:"teardown,omitempty"`
+	// Вывод и ошибка очищены по logging.output_retention_days
+	OutputPurged bool `gorm:"not null;default:false" json:"output_purged,omitempty"`
+	// Установка ansible, которой выполняется запуск, и ее версия ansible-core
+	Ansible        string `gorm:"type:text" json:"ansible,omitempty"`
+	AnsibleVersion string `gorm:"type:text" json:"ansible_version,omitempty"`
+	// Узел, на котором запуск выполняется по SSH, или агент; оба пустые - сервер API
+	Runner string `gorm:"type:text" json:"runner,omitempty"`
+	Agent  string `gorm:"type:text" json:"agent,omitempty"`
+	// Метки, по которым выбран узел или агент
+	RunnerSelector JSONMap `gorm:"type:jsonb" json:"runner_selector,omitempty"`
+	// Хэш токена загрузки артефактов, выданного при старте
+	ArtifactTokenHash string `gorm:"type:text" json:"-"`
+	// Предупреждение об устаревшем playbook на момент постановки в очередь
+	Warning string `gorm:"type:text" json:"warning,omitempty"`
+	// Коллекции и роли playbook, не найденные при постановке в очередь
+	MissingDependencies JSONList `gorm:"type:jsonb" json:"missing_dependencies,omitempty"`
+	// Хосты на карантине, исключенные из инвентаря при старте
+	QuarantinedHosts JSONList `gorm:"type:jsonb" json:"quarantined_hosts,omitempty"`
+	// Прогресс выполнения: общее число задач по --list-tasks и уже выполненные
+	TasksTotal     int     `gorm:"not null;default:0" json:"tasks_total"`
+	TasksCompleted int     `gorm:"not null;default:0" json:"tasks_completed"`
+	CurrentPlay    string  `gorm:"type:text" json:"current_play,omitempty"`
+	CurrentTask    string  `gorm:"type:text" json:"current_task,omitempty"`
+	Progress       float64 `gorm:"-" json:"progress"`
+	// Оценка длительности по истории и ожидаемое время завершения
+	EstimatedDuration     *float64   `gorm:"type:decimal" json:"estimated_duration,omitempty"`
+	EstimatedCompletionAt *time.Time `gorm:"-" json:"estimated_completion_at,omitempty"`
+	// Упавшие задачи по хостам, разобранные из вывода (только в деталях запуска)
+	FailedTasks []FailedTask `gorm:"-" json:"failed_tasks,omitempty"`
+	// Описания хостов из упавших задач и результатов (только в деталях запуска)
+	HostMetadata map[string]HostMetadata `gorm:"-" json:"host_metadata,omitempty"`
+	// Число хостов с changed > 0 по PLAY RECAP; 0 - запуск ничего не изменил,
+	// nil - итогов по хостам нет
+	ChangedHosts *int `json:"changed_hosts,omitempty"`
+	// Данные set_stats и api_result, разобранные из вывода при завершении
+	Results     JSONObject `gorm:"type:jsonb" json:"results,omitempty"`
+	HostResults JSONObject `gorm:"type:jsonb" json:"host_results,omitempty"`
+	// id лога /api/logs у запусков, существовавших до объединения логов с запусками
+	LegacyLogID *uint `json:"legacy_log_id,omitempty"`
+	// Команда вызывающей стороны, поставившей запуск; по ней считается статистика команд
+	Team string `gorm:"type:text;not null;default:''" json:"team,omitempty"`
+	// Процессорное время и наибольший RSS (КиБ) ansible-playbook с потомками;
+	// нет для запусков на узлах ansible.runners
+	CPUSeconds *float64 `json:"cpu_seconds,omitempty"`
+	MaxRSSKB   *int64   `gorm:"column:max_rss_kb" json:"max_rss_kb,omitempty"`
+	// Размер вывода в байтах
+	OutputBytes int64 `gorm:"not null;default:0" json:"output_bytes"`
 }
 
 type Inventory struct {
 	gorm.Model
-	Name    string `gorm:"type:text;not null;unique" json:"name"`
+	Name    string `gorm:"type:text;not null;uniqueIndex:idx_inventory_name_active,where:deleted_at IS NULL" json:"name"`
 	Content string `gorm:"type:text;not null" json:"content"`
+	// Владелец, его команда и уровень доступа (private, team, shared)
+	Owner  string          `gorm:"type:text;not null;default:''" json:"owner,omitempty"`
+	Team   string          `gorm:"type:text;not null;default:''" json:"team,omitempty"`
+	Access InventoryAccess `gorm:"type:text;not null;default:shared" json:"access"`
 }
 
 type InventoryCheckStatus string
@@ -83,10 +233,38 @@ type InventoryCheck struct {
 	gorm.Model
 	InventoryID uint                 `gorm:"not null" json:"inventory_id"`
 	Status      InventoryCheckStatus `gorm:"type:text" json:"status"`
-	Results     JSONMap              `gorm:"type:jsonb" json:"results"`
-	Error       string               `gorm:"type:text" json:"error"`
-	StartedAt   time.Time            `gorm:"type:timestamptz" json:"started_at"`
-	CompletedAt *time.Time           `gorm:"type:timestamptz" json:"completed_at"`
+	Mode        CheckMode            `gorm:"type:text;not null;default:ping" json:"mode"`
+	Module      string               `gorm:"type:text" json:"module,omitempty"`
+	// Группа или шаблон хостов, которыми ограничена проверка; пустой - все хосты
+	Limit   string    `gorm:"type:text;not null;default:''" json:"limit,omitempty"`
+	Results JSONMap   `gorm:"type:jsonb" json:"results"`
+	Facts   HostFacts `gorm:"type:jsonb" json:"facts,omitempty"`
+	// Причины недоступности или ошибки по хостам
+	HostErrors  JSONMap    `gorm:"type:jsonb" json:"host_errors,omitempty"`
+	Error       string     `gorm:"type:text" json:"error"`
+	StartedAt   time.Time  `gorm:"type:timestamptz" json:"started_at"`
+	CompletedAt *time.Time `gorm:"type:timestamptz" json:"completed_at"`
+
+	// Проверки удаляются вместе с инвентарем
+	Inventory     *Inventory `gorm:"foreignKey:InventoryID;constraint:OnDelete:CASCADE" json:"-"`
+	InventoryName string     `gorm:"-" json:"inventory_name,omitempty"`
+	// Описания проверенных хостов (только в деталях проверки)
+	HostMetadata map[string]HostMetadata `gorm:"-" json:"host_metadata,omitempty"`
+}
+
+// AfterFind заполняет имя инвентаря, если он был загружен через preloadInventory
+func (c *InventoryCheck) AfterFind(tx *gorm.DB) error {
+	if c.Inventory != nil {
+		c.InventoryName = c.Inventory.Name
+	}
+	return nil
+}
+
+// preloadInventory загружает только имя инвентаря, без содержимого
+func preloadInventory(query *gorm.DB) *gorm.DB {
+	return query.Preload("Inventory", func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped().Select("id", "name", "owner", "team", "access")
+	})
 }
 
 // JSONMap для работы с JSONB в PostgreSQL
@@ -110,11 +288,33 @@ func (j JSONMap) Value() (interface{}, error) {
 	return json.Marshal(j)
 }
 
+// JSONList - список строк в JSONB
+type JSONList []string
+
+func (l *JSONList) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("value is not []byte")
+	}
+	return json.Unmarshal(b, &l)
+}
+
+func (l JSONList) Value() (interface{}, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
 type LogsResponse struct {
 	Logs        []PlaybookLog `json:"logs"`
 	TotalCount  int           `json:"total_count"`
 	CurrentPage int           `json:"current_page"`
 	TotalPages  int           `json:"total_pages"`
+	PerPage     int           `json:"per_page"`
 }
 
 type RunsResponse struct {
@@ -122,211 +322,340 @@ type RunsResponse struct {
 	TotalCount  int           `json:"total_count"`
 	CurrentPage int           `json:"current_page"`
 	TotalPages  int           `json:"total_pages"`
+	PerPage     int           `json:"per_page"`
+}
+
+// Ответы keyset-пагинации (?cursor=): без total_count, со ссылкой на следующую страницу
+type LogsCursorResponse struct {
+	Logs       []PlaybookLog `json:"logs"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	PerPage    int           `json:"per_page"`
+}
+
+type RunsCursorResponse struct {
+	Runs       []PlaybookRun `json:"runs"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	PerPage    int           `json:"per_page"`
 }
 
 type InventoriesResponse struct {
 	Inventories []Inventory `json:"inventories"`
 	TotalCount  int         `json:"total_count"`
+	CurrentPage int         `json:"current_page"`
+	TotalPages  int         `json:"total_pages"`
+	PerPage     int         `json:"per_page"`
 }
 
 type InventoryChecksResponse struct {
-	Checks     []InventoryCheck `json:"checks"`
-	TotalCount int              `json:"total_count"`
+	Checks      []InventoryCheck `json:"checks"`
+	TotalCount  int              `json:"total_count"`
+	CurrentPage int              `json:"current_page"`
+	TotalPages  int              `json:"total_pages"`
+	PerPage     int              `json:"per_page"`
 }
 
-var (
-	cfg     *config.Config
-	mutex   = &sync.Mutex{}
-	db      *gorm.DB
-	cronSvc *cron.Cron
-)
+// initDB открывает подключение к БД по database.* или использует conn
+func initDB(conn *sql.DB) error {
+	var dialector gorm.Dialector
+	if conn != nil {
+		dialector = postgres.New(postgres.Config{Conn: conn})
+	} else {
+		dialector = postgres.Open(databaseDSN())
+	}
 
-func init() {
-	var err error
-	cfg, err = config.Load()
+	sqlLog, err := sqlLogger()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return err
 	}
-
-	cronSvc = cron.New()
-	_, err = cronSvc.AddFunc("@daily", cleanupOldLogs)
+	db, err = gorm.Open(dialector, &gorm.Config{
+		Logger: sqlLog,
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix:   cfg.Database.Schema + ".", // Схема арендатора по умолчанию, остальные - через registerTenantCallbacks
+			SingularTable: true,
+		},
+	})
 	if err != nil {
-		log.Fatalf("Failed to schedule log cleanup: %v", err)
+		return err
 	}
-	cronSvc.Start()
-}
 
-func main() {
-	if err := initDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	if err := registerBreaker(db); err != nil {
+		return err
 	}
-
-	// Автомиграции - создание таблиц
-	if err := db.AutoMigrate(&PlaybookRun{}, &PlaybookLog{}, &Inventory{}, &InventoryCheck{}); err != nil {
-		log.Fatalf("Failed to auto-migrate database: %v", err)
+	if err := registerTenantCallbacks(db); err != nil {
+		return err
+	}
+	if err := registerQueryTimeout(db); err != nil {
+		return err
 	}
 
-	r := mux.NewRouter()
-
-	// Playbook endpoints
-	r.HandleFunc("/api/run", runPlaybookHandler).Methods("POST")
-	r.HandleFunc("/api/playbooks", listPlaybooksHandler).Methods("GET")
-
-	// Log endpoints
-	r.HandleFunc("/api/logs", listLogsHandler).Methods("GET")
-	r.HandleFunc("/api/logs/{id}", getLogHandler).Methods("GET")
-
-	// Run endpoints
-	r.HandleFunc("/api/runs", getPlaybookRunsHandler).Methods("GET")
-	r.HandleFunc("/api/runs/{id}", getPlaybookRunDetailsHandler).Methods("GET")
-
-	// Inventory endpoints
-	r.HandleFunc("/api/inventories", listInventoriesHandler).Methods("GET")
-	r.HandleFunc("/api/inventories", createInventoryHandler).Methods("POST")
-	r.HandleFunc("/api/inventories/{name}", getInventoryHandler).Methods("GET")
-	r.HandleFunc("/api/inventories/{name}", updateInventoryHandler).Methods("PUT")
-	r.HandleFunc("/api/inventories/{name}", deleteInventoryHandler).Methods("DELETE")
-	r.HandleFunc("/api/inventories/{name}/check", checkInventoryHandler).Methods("POST")
-
-	// Inventory check endpoints
-	r.HandleFunc("/api/inventory-checks", listInventoryChecksHandler).Methods("GET")
-	r.HandleFunc("/api/inventory-checks/{id}", getInventoryCheckHandler).Methods("GET")
-
-	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      r,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
+	if conn != nil {
+		// Пулом управляет встраивающая программа
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Server started on :%s", cfg.Server.Port)
-	log.Fatal(server.ListenAndServe())
+	sqlDB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+
+	return nil
 }
 
-func initDB() error {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s search_path=ansible_api,public",
+func databaseDSN() string {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s search_path=%s,public",
 		cfg.Database.Host,
 		cfg.Database.Port,
 		cfg.Database.User,
 		cfg.Database.Password,
 		cfg.Database.Name,
 		cfg.Database.SSLMode,
+		cfg.Database.Schema,
 	)
-
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-		NamingStrategy: schema.NamingStrategy{
-			TablePrefix:   "ansible_api.", // Все таблицы будут созданы в схеме ansible_api
-			SingularTable: true,
-		},
-	})
-	if err != nil {
-		return err
+	if cfg.Database.StatementTimeout > 0 {
+		// Неизвестные параметры DSN pgx передает серверу как параметры сессии
+		dsn += fmt.Sprintf(" statement_timeout=%d", cfg.Database.StatementTimeout.Milliseconds())
 	}
+	return dsn
+}
 
-	sqlDB, err := db.DB()
-	if err != nil {
-		return err
+// useReadReplica направляет чтения на реплику через dbresolver; записи,
+// транзакции и SELECT ... FOR UPDATE остаются на основной БД
+func useReadReplica() error {
+	if cfg.Database.ReplicaDSN == "" {
+		return nil
 	}
 
-	sqlDB.SetMaxOpenConns(25)
-	sqlDB.SetMaxIdleConns(25)
-	sqlDB.SetConnMaxLifetime(5 * time.Minute)
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{postgres.Open(cfg.Database.ReplicaDSN)},
+	}).
+		SetMaxOpenConns(cfg.Database.MaxOpenConns).
+		SetMaxIdleConns(cfg.Database.MaxIdleConns).
+		SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
 
-	return nil
+	log.Printf("Read replica enabled for read queries")
+	return db.Use(resolver)
 }
 
-func cleanupOldLogs() {
-	log.Printf("Starting cleanup of logs older than %d days", cfg.Logging.RetentionDays)
-
-	retentionPeriod := time.Now().AddDate(0, 0, -cfg.Logging.RetentionDays)
+// primaryDB читает с основной БД: для данных, только что записанных этим же процессом
+func primaryDB() *gorm.DB {
+	return db.Clauses(dbresolver.Write)
+}
 
-	// Удаление старых логов
-	result := db.Where("start_time < ?", retentionPeriod).Delete(&PlaybookLog{})
-	if result.Error != nil {
-		log.Printf("Error cleaning up old logs: %v", result.Error)
+func runPlaybookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Удаление старых запусков
-	result = db.Where("start_time < ?", retentionPeriod).Delete(&PlaybookRun{})
-	if result.Error != nil {
-		log.Printf("Error cleaning up old runs: %v", result.Error)
+	var req PlaybookRequest
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
-
-	// Удаление старых проверок инвентарей
-	result = db.Where("started_at < ?", retentionPeriod).Delete(&InventoryCheck{})
-	if result.Error != nil {
-		log.Printf("Error cleaning up old inventory checks: %v", result.Error)
+	maxWait, ok := parseMaxWait(r)
+	if !ok {
+		http.Error(w, "max_wait must be a positive number of seconds or a duration", http.StatusBadRequest)
 		return
 	}
-
-	log.Printf("Cleaned up %d old log entries", result.RowsAffected)
-}
-
-func runPlaybookHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if maxWait > 0 && req.Rolling != nil {
+		http.Error(w, "wait cannot be combined with rolling", http.StatusBadRequest)
+		return
+	}
+	if !validateRunRequest(w, r, &req) {
 		return
 	}
+	req.Team = currentPrincipal(r).Team
 
-	var req PlaybookRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	t := tenantOf(r)
+	var (
+		run     PlaybookRun
+		rollout Rollout
+		err     error
+	)
+	if req.Rolling != nil {
+		rollout, run, err = queueRollout(t, req, triggeredBy(r), r.RemoteAddr)
+	} else {
+		run, err = queuePlaybookRun(t, req, triggeredBy(r), r.RemoteAddr)
+	}
+	if errors.Is(err, errRolloutHosts) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	playbookPath := filepath.Join(cfg.Server.PlaybooksDir, req.Playbook)
-	if _, err := os.Stat(playbookPath); os.IsNotExist(err) {
-		http.Error(w, "Playbook not found", http.StatusNotFound)
+	var frozen *frozenPlaybookError
+	if errors.As(err, &frozen) {
+		writeFrozenPlaybook(w, frozen)
 		return
 	}
-
-	remoteAddr := r.RemoteAddr
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		remoteAddr = forwardedFor
+	var missing *missingDependenciesError
+	if errors.As(err, &missing) {
+		http.Error(w, err.Error(), http.StatusFailedDependency)
+		return
 	}
-
-	runID, err := logPlaybookStart(req, remoteAddr)
 	if err != nil {
-		log.Printf("Failed to log playbook start: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		log.Printf("Failed to queue playbook run: %v", err)
+		if isConnectionError(err) {
+			writeDBError(w, err)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	go func() {
-		mutex.Lock()
-		defer mutex.Unlock()
+	if req.Rolling != nil {
+		writeRolloutAccepted(w, rollout, run)
+		return
+	}
+	if maxWait > 0 && wantsOutputStream(r) {
+		streamRunOutput(w, r, t, run, maxWait)
+		return
+	}
+	if maxWait > 0 {
+		waitForRun(w, r, t, run, maxWait)
+		return
+	}
 
-		startTime := time.Now()
-		output, err := runAnsiblePlaybook(playbookPath, req.Inventory, req.ExtraVars)
-		endTime := time.Now()
-		duration := endTime.Sub(startTime).Seconds()
+	response := map[string]interface{}{
+		"status":  "accepted",
+		"message": "playbook execution queued",
+		"run_id":  run.ID,
+	}
+	if run.Warning != "" {
+		setDeprecationHeaders(w, run.Warning)
+		response["warning"] = run.Warning
+	}
+	if len(run.MissingDependencies) > 0 {
+		response["missing_dependencies"] = run.MissingDependencies
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-		// Логирование выполнения
-		success := err == nil
-		errorMsg := ""
-		if err != nil {
-			errorMsg = err.Error()
+// validateRunRequest проверяет запрос на запуск до постановки в очередь и при
+// ошибке сам отвечает клиенту. Выбирает узел или агента по runner_selector.
+func validateRunRequest(w http.ResponseWriter, r *http.Request, req *PlaybookRequest) bool {
+	playbookPath := filepath.Join(tenantOf(r).PlaybooksDir, req.Playbook)
+	if _, err := os.Stat(playbookPath); os.IsNotExist(err) {
+		http.Error(w, "Playbook not found", http.StatusNotFound)
+		return false
+	}
+	if !requirePlaybookRole(w, r, roleOperator, req.Playbook) {
+		return false
+	}
+	if err := checkRunWindow(tenantOf(r), req.Playbook, time.Now()); err != nil {
+		var window *runWindowError
+		if errors.As(err, &window) {
+			writeRunWindowError(w, window)
+		} else {
+			writeDBError(w, err)
 		}
-		_ = logExecution(req.Playbook, success, output, errorMsg, startTime, endTime, duration)
+		return false
+	}
 
-		// Обновление статуса запуска
-		if err != nil {
-			_ = updatePlaybookRun(runID, RunStatusFailed, output, err.Error())
-		} else {
-			_ = updatePlaybookRun(runID, RunStatusCompleted, output, "")
+	if err := validateSensitiveVars(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := req.Ticket.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if _, err := selectAnsibleInstallation(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := routeRunnerSelector(req); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errNoMatchingRunner) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return false
+	}
+	if _, err := selectRemoteRunner(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if _, err := selectAgent(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := validatePythonInterpreter(req.PythonInterpreter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if req.Localhost && req.Inventory != "" {
+		http.Error(w, "localhost and inventory are mutually exclusive", http.StatusBadRequest)
+		return false
+	}
+	if err := validateInlineHosts(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := validateHostTags(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := validateLimit(req.Limit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := req.Rolling.validate(tenantOf(r), *req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := validateExtraArgs(req.ExtraArgs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := req.Preflight.validate(*req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	admission := newPolicyInput(r, policyActionRun)
+	admission.Playbook = req.Playbook
+	admission.Inventory = req.Inventory
+	admission.ExtraVars = withPythonOverride(req.ExtraVars, req.PythonInterpreter)
+	admission.Sensitive = req.SensitiveVars
+	if !admit(w, admission) {
+		return false
+	}
+
+	// Запускать можно только по инвентарю, доступному вызывающей стороне
+	if req.Inventory != "" {
+		inv, ok := loadInventory(w, r, req.Inventory, false)
+		if !ok {
+			return false
+		}
+		if err := validateInventoryParams(inv.Content, req.InventoryParams); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	} else if len(req.InventoryParams) > 0 {
+		http.Error(w, "inventory_params require inventory", http.StatusBadRequest)
+		return false
+	}
+	// Хосты по меткам выбираются только из доступных инвентарей и фиксируются в запуске
+	if len(req.HostTags) > 0 {
+		hosts, err := resolveHostTags(tenantOf(r), currentPrincipal(r), req.HostTags)
+		switch {
+		case errors.Is(err, errNoTaggedHosts):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		case err != nil:
+			writeDBError(w, err)
+			return false
 		}
-	}()
+		req.Hosts = hosts
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "accepted",
-		"message": "playbook execution started",
-		"run_id":  runID,
-	})
+	if err := checkDiskSpace(); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return false
+	}
+	return true
 }
 
 func listPlaybooksHandler(w http.ResponseWriter, r *http.Request) {
@@ -335,7 +664,7 @@ func listPlaybooksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	files, err := os.ReadDir(cfg.Server.PlaybooksDir)
+	files, err := os.ReadDir(tenantOf(r).PlaybooksDir)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -359,71 +688,80 @@ func listLogsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	queryParams := r.URL.Query()
-	page, _ := strconv.Atoi(queryParams.Get("page"))
-	if page < 1 {
-		page = 1
-	}
-
-	successFilter := queryParams.Get("success")
-	playbookFilter := queryParams.Get("playbook")
-	dateFrom := queryParams.Get("from")
-	dateTo := queryParams.Get("to")
+	pager := parsePagination(r)
 
-	query := db.Model(&PlaybookLog{})
+	store := storeOf(r)
+	filter := parseLogFilter(queryParams)
 
-	if successFilter != "" {
-		success, err := strconv.ParseBool(successFilter)
-		if err == nil {
-			query = query.Where("success = ?", success)
+	if queryParams.Has("cursor") {
+		logs, err := store.ListLogsAfter(filter, queryParams.Get("cursor"), pager.PerPage)
+		if err != nil {
+			writeListError(w, err)
+			return
 		}
+		logs, nextCursor := trimPage(logs, pager.PerPage)
+
+		writeCursorHeaders(w, r, pager.PerPage, nextCursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LogsCursorResponse{
+			Logs:       logs,
+			NextCursor: nextCursor,
+			PerPage:    pager.PerPage,
+		})
+		return
 	}
 
-	if playbookFilter != "" {
-		query = query.Where("playbook = ?", playbookFilter)
+	logs, err := store.ListLogs(filter, pager)
+	if err != nil {
+		writeDBError(w, err)
+		return
 	}
 
-	if dateFrom != "" {
-		if fromTime, err := time.Parse(time.RFC3339, dateFrom); err == nil {
-			query = query.Where("start_time >= ?", fromTime)
-		}
+	response := LogsResponse{
+		Logs:        logs,
+		TotalCount:  pager.TotalCount,
+		CurrentPage: pager.Page,
+		TotalPages:  pager.TotalPages,
+		PerPage:     pager.PerPage,
 	}
 
-	if dateTo != "" {
-		if toTime, err := time.Parse(time.RFC3339, dateTo); err == nil {
-			query = query.Where("start_time <= ?", toTime)
-		}
-	}
+	pager.writeHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	var totalCount int64
-	if err := query.Count(&totalCount).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// parseLogFilter читает фильтры списка логов; используется для выборки и массового удаления
+func parseLogFilter(queryParams url.Values) LogFilter {
+	filter := LogFilter{
+		Playbook: queryParams.Get("playbook"),
+		From:     parseTimeParam(queryParams.Get("from")),
+		To:       parseTimeParam(queryParams.Get("to")),
 	}
-
-	totalPages := (int(totalCount) + cfg.Logging.PageSize - 1) / cfg.Logging.PageSize
-	if page > totalPages && totalPages > 0 {
-		page = totalPages
+	if success, err := strconv.ParseBool(queryParams.Get("success")); err == nil {
+		filter.Success = &success
 	}
-	offset := (page - 1) * cfg.Logging.PageSize
+	return filter
+}
 
-	var logs []PlaybookLog
-	if err := query.Order("start_time DESC").
-		Limit(cfg.Logging.PageSize).
-		Offset(offset).
-		Find(&logs).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// parseTimeParam разбирает время в RFC3339; некорректное значение фильтр не применяет
+func parseTimeParam(value string) *time.Time {
+	if value == "" {
+		return nil
 	}
-
-	response := LogsResponse{
-		Logs:        logs,
-		TotalCount:  int(totalCount),
-		CurrentPage: page,
-		TotalPages:  totalPages,
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
 	}
+	return &t
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// writeListError - ошибка выборки страницы: неверный курсор - ошибка клиента
+func writeListError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeDBError(w, err)
 }
 
 func getLogHandler(w http.ResponseWriter, r *http.Request) {
@@ -440,12 +778,12 @@ func getLogHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var logEntry PlaybookLog
-	if err := db.First(&logEntry, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	logEntry, err := storeOf(r).GetLog(uint(id))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
 			http.Error(w, "Log not found", http.StatusNotFound)
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeDBError(w, err)
 		}
 		return
 	}
@@ -461,70 +799,98 @@ func getPlaybookRunsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	queryParams := r.URL.Query()
-	page, _ := strconv.Atoi(queryParams.Get("page"))
-	if page < 1 {
-		page = 1
-	}
-
-	statusFilter := queryParams.Get("status")
-	playbookFilter := queryParams.Get("playbook")
-	dateFrom := queryParams.Get("from")
-	dateTo := queryParams.Get("to")
+	pager := parsePagination(r)
 
-	query := db.Model(&PlaybookRun{})
+	store := storeOf(r)
+	filter := parseRunFilter(queryParams)
 
-	if statusFilter != "" {
-		query = query.Where("status = ?", statusFilter)
-	}
-
-	if playbookFilter != "" {
-		query = query.Where("playbook = ?", playbookFilter)
-	}
-
-	if dateFrom != "" {
-		if fromTime, err := time.Parse(time.RFC3339, dateFrom); err == nil {
-			query = query.Where("start_time >= ?", fromTime)
+	if queryParams.Has("cursor") {
+		runs, err := store.ListRunsAfter(filter, queryParams.Get("cursor"), pager.PerPage)
+		if err != nil {
+			writeListError(w, err)
+			return
 		}
-	}
-
-	if dateTo != "" {
-		if toTime, err := time.Parse(time.RFC3339, dateTo); err == nil {
-			query = query.Where("start_time <= ?", toTime)
+		runs, nextCursor := trimPage(runs, pager.PerPage)
+		for i := range runs {
+			runs[i].fillProgress()
+			runs[i].fillEstimate()
 		}
-	}
 
-	var totalCount int64
-	if err := query.Count(&totalCount).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeCursorHeaders(w, r, pager.PerPage, nextCursor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RunsCursorResponse{
+			Runs:       runs,
+			NextCursor: nextCursor,
+			PerPage:    pager.PerPage,
+		})
 		return
 	}
 
-	totalPages := (int(totalCount) + cfg.Logging.PageSize - 1) / cfg.Logging.PageSize
-	if page > totalPages && totalPages > 0 {
-		page = totalPages
-	}
-	offset := (page - 1) * cfg.Logging.PageSize
-
-	var runs []PlaybookRun
-	if err := query.Order("start_time DESC").
-		Limit(cfg.Logging.PageSize).
-		Offset(offset).
-		Find(&runs).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	runs, err := store.ListRuns(filter, pager)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
+	for i := range runs {
+		runs[i].fillProgress()
+		runs[i].fillEstimate()
+	}
 
 	response := RunsResponse{
 		Runs:        runs,
-		TotalCount:  int(totalCount),
-		CurrentPage: page,
-		TotalPages:  totalPages,
+		TotalCount:  pager.TotalCount,
+		CurrentPage: pager.Page,
+		TotalPages:  pager.TotalPages,
+		PerPage:     pager.PerPage,
 	}
 
+	pager.writeHeaders(w, r)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// parseRunFilter читает фильтры списка запусков; используется для выборки и массового удаления
+func parseRunFilter(queryParams url.Values) RunFilter {
+	filter := RunFilter{
+		Status:      queryParams.Get("status"),
+		Playbook:    queryParams.Get("playbook"),
+		TriggeredBy: queryParams.Get("triggered_by"),
+		// ticket - идентификатор заявки, например CHG-1234
+		Ticket: queryParams.Get("ticket"),
+		From:   parseTimeParam(queryParams.Get("from")),
+		To:     parseTimeParam(queryParams.Get("to")),
+	}
+	if batch, err := strconv.ParseUint(queryParams.Get("batch"), 10, 0); err == nil {
+		batchID := uint(batch)
+		filter.Batch = &batchID
+	}
+	if minDuration, ok := parseDurationParam(queryParams.Get("min_duration")); ok {
+		filter.MinDuration = &minDuration
+	}
+	if maxDuration, ok := parseDurationParam(queryParams.Get("max_duration")); ok {
+		filter.MaxDuration = &maxDuration
+	}
+	// changed=false - запуски, не изменившие ни одного хоста
+	if changed, err := strconv.ParseBool(queryParams.Get("changed")); err == nil {
+		filter.Changed = &changed
+	}
+	return filter
+}
+
+// parseDurationParam принимает секунды (600) или Go duration (10m) и возвращает секунды
+func parseDurationParam(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return seconds, true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.Seconds(), true
+	}
+	return 0, false
+}
+
 func getPlaybookRunDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -539,87 +905,412 @@ func getPlaybookRunDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var run PlaybookRun
-	if err := db.First(&run, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	t := tenantOf(r)
+	run, err := t.store().GetRun(uint(id))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
 			http.Error(w, "Run not found", http.StatusNotFound)
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeDBError(w, err)
 		}
 		return
 	}
 
+	// Итоговый вывод записывается по завершении, до этого отдаем накопленный
+	run.fillProgress()
+	run.fillEstimate()
+	if run.Status == RunStatusStarted && run.Output == "" {
+		output, err := runOutputSoFar(t, run.ID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		run.Output = output
+	}
+	run.FailedTasks = parseFailedTasks(run.Output)
+	run.fillHostMetadata(t)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(run)
 }
 
-func logPlaybookStart(req PlaybookRequest, remoteAddr string) (uint, error) {
+// queuePlaybookRun ставит запуск в очередь. triggeredBy - адрес клиента с учетом
+// доверенных прокси, peerAddr - адрес соединения, с которого пришел запрос.
+// Замороженный playbook не ставится (*frozenPlaybookError), запуск устаревшего
+// получает Warning.
+func queuePlaybookRun(t *tenant, req PlaybookRequest, triggeredBy, peerAddr string) (PlaybookRun, error) {
+	lifecycle, err := playbookLifecycle(t, req.Playbook)
+	if err != nil {
+		return PlaybookRun{}, err
+	}
+	if lifecycle != nil && lifecycle.State == PlaybookFrozen {
+		return PlaybookRun{}, &frozenPlaybookError{lifecycle: *lifecycle}
+	}
+
+	// Интерпретатор сохраняется в extra_vars запуска и переживает перезапуск
+	extraVars, sealedVars, err := splitSensitiveVars(withPythonOverride(req.ExtraVars, req.PythonInterpreter), req.SensitiveVars)
+	if err != nil {
+		return PlaybookRun{}, err
+	}
+	installation, err := selectAnsibleInstallation(req)
+	if err != nil {
+		return PlaybookRun{}, err
+	}
+	runner, err := selectRemoteRunner(req)
+	if err != nil {
+		return PlaybookRun{}, err
+	}
+	agent, err := selectAgent(req)
+	if err != nil {
+		return PlaybookRun{}, err
+	}
+
 	run := PlaybookRun{
 		Playbook:    req.Playbook,
 		Inventory:   req.Inventory,
-		Status:      RunStatusStarted,
-		StartTime:   time.Now(),
-		TriggeredBy: remoteAddr,
-		ExtraVars:   req.ExtraVars,
+		Localhost:   req.Localhost,
+		Hosts:       req.Hosts,
+		HostVars:    req.HostVars,
+		Limit:       req.Limit,
+		ExtraArgs:   req.ExtraArgs,
+		TriggeredBy: triggeredBy,
+		PeerAddr:    peerAddr,
+		ExtraVars:   extraVars,
+		SealedVars:  sealedVars,
+		RestartSafe: req.RestartSafe || req.RerunOnRestart,
+		Ticket:      req.Ticket,
+		BatchID:     req.BatchID,
+
+		InventoryParams: req.InventoryParams,
+		HostTags:        req.HostTags,
+
+		RolloutID:    req.RolloutID,
+		RolloutBatch: req.RolloutBatch,
+		Team:         req.Team,
+
+		Ansible:        installation.Name,
+		AnsibleVersion: installation.Version,
+	}
+	if req.Preflight != nil {
+		run.PreflightMaxAge, run.PreflightMaxUnreachable = req.Preflight.maxAge(), req.Preflight.MaxUnreachable
+	}
+	if runner != nil {
+		// Версия ansible узла неизвестна серверу
+		run.Runner = runner.Name
+		run.Ansible, run.AnsibleVersion = "", ""
+	}
+	if agent != nil {
+		// Агент выбирает установку ansible сам
+		run.Agent = agent.Name
+		run.Ansible, run.AnsibleVersion = "", agent.AnsibleVersion
+	}
+	if len(req.RunnerSelector) > 0 {
+		run.RunnerSelector = req.RunnerSelector
+	}
+	// Узлы и агенты выполняют playbook со своими коллекциями и ролями
+	if runner == nil && agent == nil {
+		if run.MissingDependencies, err = checkPlaybookDependencies(t, req.Playbook, installation); err != nil {
+			return PlaybookRun{}, err
+		}
 	}
+	if lifecycle != nil {
+		run.Warning = lifecycle.notice()
+	}
+
+	if err := enqueueRun(t, &run); err != nil {
+		return PlaybookRun{}, err
+	}
+
+	return run, nil
+}
+
+// errRunNotStarted - запуск уже не выполняется: например, watchdog пометил его lost
+var errRunNotStarted = errors.New("run is no longer started")
+
+func executeRun(job runJob) {
+	ctx, cancel := newRunContext(job.Tenant, job.RunID)
+	defer cancel()
 
-	if err := db.Create(&run).Error; err != nil {
-		return 0, err
+	stopHeartbeat := startHeartbeat(job.Tenant, job.RunID)
+	defer stopHeartbeat()
+
+	var (
+		pgid   int
+		output string
+	)
+	recordEstimate(job)
+	revealErr := revealJobVars(&job)
+	if revealErr == nil {
+		revealErr = issueArtifactToken(&job)
+	}
+	masker := newSecretMasker(runSecrets(job.Request))
+
+	installation, err := ansibleInstallationFor(job.Request.Ansible)
+	var runner *remoteRunner
+	if err == nil {
+		runner, err = remoteRunnerFor(job.Request.Runner)
+	}
+	if err == nil {
+		err = revealErr
+	}
+	if err == nil {
+		err = preflightRun(job)
+	}
+	workDir := ""
+	if err == nil {
+		workDir, err = prepareWorkDir(&job, installation, runner)
+	}
+	if err == nil {
+		invocation := ansibleInvocation{
+			Tenant:       job.Tenant,
+			Installation: installation,
+			Runner:       runner,
+			WorkDir:      workDir,
+			PlaybookPath: job.PlaybookPath,
+			Inventory:    job.Request.Inventory,
+			ExtraVars:    job.Request.ExtraVars,
+			Localhost:    job.Request.Localhost,
+			Hosts:        job.Request.Hosts,
+			HostVars:     job.Request.HostVars,
+			Limit:        job.Request.Limit,
+			ExtraArgs:    job.Request.ExtraArgs,
+
+			InventoryParams: job.Request.InventoryParams,
+			HostTags:        job.Request.HostTags,
+		}
+		if cfg.Ansible.CountTasks {
+			recordTasksTotal(ctx, job.Tenant, job.RunID, invocation)
+		}
+
+		recorder := newRunRecorder(job.Tenant, job.RunID, masker)
+		invocation.Output = recorder
+		invocation.OnQuarantine = func(hosts []string) {
+			recordQuarantinedHosts(job.Tenant, job.RunID, hosts, recorder)
+		}
+		invocation.OnExit = func(usage runUsage) {
+			recordRunUsage(job.Tenant, job.RunID, usage)
+		}
+		invocation.OnStart = func(pid int) {
+			// Процесс запущен с Setpgid, поэтому его PID совпадает с PGID группы
+			pgid = pid
+			var startedAt *time.Time
+			if started, ok := processStartTime(pid); ok {
+				startedAt = &started
+			}
+			if err := job.Tenant.store().RecordRunProcess(job.RunID, pid, startedAt); err != nil {
+				log.Printf("Failed to record pid for run %d: %v", job.RunID, err)
+			}
+		}
+		output, err = runAnsiblePlaybook(ctx, invocation)
+		recorder.Close()
+	}
+	var status PlaybookRunStatus
+	status, err = runStatus(ctx, err)
+
+	// Отмена или таймаут: проверяем, что группа процессов действительно завершилась
+	if ctx.Err() != nil {
+		teardown := verifyTeardown(pgid)
+		log.Printf("Run %d %s: %s", job.RunID, status, teardown)
+		if dbErr := job.Tenant.store().RecordRunTeardown(job.RunID, teardown); dbErr != nil {
+			log.Printf("Failed to record teardown for run %d: %v", job.RunID, dbErr)
+		}
 	}
 
-	return run.ID, nil
+	errorMsg := ""
+	if err != nil {
+		errorMsg = masker.Mask(err.Error())
+	}
+	finishRun(job, status, errorMsg, masker.Mask(output))
 }
 
-func updatePlaybookRun(runID uint, status PlaybookRunStatus, output, errorMsg string) error {
-	updates := map[string]interface{}{
-		"status": status,
-		"output": output,
-		"error":  errorMsg,
+// runStatus определяет итог запуска по ошибке ansible и контексту запуска.
+// При отмене и таймауте ошибкой становится их причина.
+func runStatus(ctx context.Context, err error) (PlaybookRunStatus, error) {
+	if ctx.Err() != nil {
+		cause := context.Cause(ctx)
+		if errors.Is(cause, errRunCancelled) || errors.Is(cause, errServerShutdown) {
+			return RunStatusCancelled, cause
+		}
+		return RunStatusTimedOut, cause
+	}
+	if err != nil {
+		return RunStatusFailed, err
 	}
+	return RunStatusCompleted, nil
+}
 
-	if status != RunStatusStarted {
-		endTime := time.Now()
-		var startTime time.Time
-		if err := db.Model(&PlaybookRun{}).Where("id = ?", runID).Pluck("start_time", &startTime).Error; err != nil {
-			return err
+// finishRun сохраняет итог запуска и выполняет все, что следует за завершением:
+// события, уведомления, post-хуки, повторы и интеграции. Ошибка и вывод
+// должны быть уже замаскированы.
+func finishRun(job runJob, status PlaybookRunStatus, errorMsg, output string) {
+	// Обновление статуса запуска
+	superseded := false
+	persist(fmt.Sprintf("update status of run %d", job.RunID), func() error {
+		err := job.Tenant.store().FinishRun(job.RunID, status, output, errorMsg)
+		if errors.Is(err, errRunNotStarted) {
+			superseded = true
+			return nil
 		}
-		duration := endTime.Sub(startTime).Seconds()
+		return err
+	})
+	if superseded {
+		// Уведомление о потере уже отправлено, rollout и хуки обработали lost
+		log.Printf("Run %d finished with status %s after it was marked lost, result recorded in its error", job.RunID, status)
+		return
+	}
+	finished := PlaybookRun{
+		Model:     gorm.Model{ID: job.RunID},
+		Playbook:  job.Request.Playbook,
+		Inventory: job.Request.Inventory,
+		Status:    status,
+		NodeID:    cfg.Server.NodeID,
+		Ticket:    job.Request.Ticket,
+	}
+	publishRunEvent(job.Tenant, "run.finished", finished, errorMsg)
+	if status == RunStatusFailed || status == RunStatusTimedOut {
+		reportRunFailure(finished, errorMsg, output)
+	}
+
+	runPostHooks(job.Tenant, job.RunID)
+	// Часть rollout продолжается и откат ставится после повтора, если он поставлен
+	if !retryFailedRun(job.Tenant, job.RunID, status, errorMsg, output) {
+		advanceRollout(job.Tenant, job.RunID, status, output)
+		triggerRollback(job.Tenant, job.RunID, status, output)
+	}
+	pushRunMetrics(job.Tenant, job.RunID, output)
+	annotateRun(job.Tenant, job.RunID)
+}
 
-		updates["end_time"] = endTime
-		updates["duration"] = duration
+// preflightRun выполняет проверки непосредственно перед стартом ansible
+func preflightRun(job runJob) error {
+	// Место могло закончиться, пока запуск ждал в очереди
+	if err := checkDiskSpace(); err != nil {
+		return err
+	}
+	if err := checkPreflightGate(job); err != nil {
+		return err
+	}
+
+	if len(cfg.Hooks.Pre) == 0 {
+		return nil
 	}
+	var run PlaybookRun
+	if err := job.Tenant.primaryDB().First(&run, job.RunID).Error; err != nil {
+		return err
+	}
+	return runPreHooks(run)
+}
 
-	return db.Model(&PlaybookRun{}).Where("id = ?", runID).Updates(updates).Error
+// ansibleInvocation описывает один вызов ansible-playbook
+type ansibleInvocation struct {
+	Tenant       *tenant
+	Installation *ansibleInstallation
+	// Узел, на котором выполнить ansible-playbook по SSH; nil - локально
+	Runner *remoteRunner
+	// Рабочий каталог запуска, в него пишется журнал ansible; пустой - нет
+	WorkDir      string
+	PlaybookPath string
+	Inventory    string
+	// Значения подстановок в содержимое Inventory
+	InventoryParams map[string]string
+	// Хосты Hosts выбраны по меткам: их строки берутся из сохраненных инвентарей
+	HostTags map[string]string
+	// Содержимое инвентаря, полученное с сервера (режим агента); важнее Inventory
+	InventoryContent string
+	ExtraVars        map[string]string
+	// Выполнить на localhost или на хостах из запроса вместо инвентаря
+	Localhost bool
+	Hosts     []string
+	HostVars  map[string]string
+	Limit     string
+	ExtraArgs []string
+	// Дополнительный приемник вывода, получает его по мере выполнения
+	Output io.Writer
+	// Вызывается сразу после старта процесса
+	OnStart func(pid int)
+	// Вызывается, если из инвентаря исключены хосты на карантине
+	OnQuarantine func(hosts []string)
+	// Вызывается после завершения локального процесса с израсходованными им ресурсами
+	OnExit func(usage runUsage)
 }
 
-func runAnsiblePlaybook(playbookPath, inventoryName string, extraVars map[string]string) (string, error) {
-	args := []string{"ansible-playbook", playbookPath}
+// Неявный инвентарь режима localhost: python берется тот же, что у ansible-playbook
+// Стандартный callback выводит данные set_stats только с этой настройкой
+const showCustomStatsEnv = "ANSIBLE_SHOW_CUSTOM_STATS=True"
 
-	if inventoryName != "" {
-		inventoryContent, err := getInventoryContent(inventoryName)
+const localhostInventory = "localhost ansible_connection=local ansible_python_interpreter=\"{{ ansible_playbook_python }}\"\n"
+
+// ansibleArgs собирает аргументы ansible-playbook. cleanup удаляет временный
+// файл инвентаря и должен вызываться, когда процесс завершился.
+func ansibleArgs(inv ansibleInvocation) ([]string, func(), error) {
+	args := []string{inv.Installation.binary("ansible-playbook"), inv.PlaybookPath}
+	cleanup := func() {}
+
+	inventoryContent := ""
+	switch {
+	case inv.Localhost:
+		inventoryContent = localhostInventory
+	case len(inv.HostTags) > 0:
+		content, err := taggedInventory(inv.Tenant, inv.Hosts)
 		if err != nil {
-			return "", fmt.Errorf("failed to get inventory: %v", err)
+			return nil, nil, fmt.Errorf("failed to build inventory of tagged hosts: %v", err)
+		}
+		inventoryContent = withDefaultPython(content)
+	case len(inv.Hosts) > 0:
+		inventoryContent = withDefaultPython(inlineInventory(inv.Hosts, inv.HostVars))
+	case inv.InventoryContent != "":
+		inventoryContent = withDefaultPython(inv.InventoryContent)
+	case inv.Inventory != "":
+		content, err := getInventoryContent(inv.Tenant, inv.Inventory)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get inventory: %v", err)
+		}
+		// Инвентарь мог измениться после постановки запуска
+		if content, err = renderInventory(content, inv.InventoryParams); err != nil {
+			return nil, nil, fmt.Errorf("failed to render inventory: %v", err)
+		}
+		inventoryContent = withDefaultPython(content)
+	}
+	// Агент не подключен к БД: хосты на карантине исключает сервер при выдаче
+	if inventoryContent != "" && !inv.Localhost && db != nil {
+		content, skipped, err := withoutQuarantinedHosts(inv.Tenant, inventoryContent)
+		if err != nil {
+			return nil, nil, err
+		}
+		inventoryContent = content
+		if len(skipped) > 0 && inv.OnQuarantine != nil {
+			inv.OnQuarantine(skipped)
 		}
+	}
 
-		tmpfile, err := os.CreateTemp("", "inventory-*.ini")
+	if inventoryContent != "" && cfg.Disk.InventoryCache {
+		path, err := cachedInventoryFile(inventoryContent)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, "-i", path)
+	} else if inventoryContent != "" {
+		tmpfile, err := os.CreateTemp(cfg.Disk.TempDir, "inventory-*.ini")
 		if err != nil {
-			return "", fmt.Errorf("failed to create temp inventory file: %v", err)
+			return nil, nil, fmt.Errorf("failed to create temp inventory file: %v", err)
 		}
-		defer os.Remove(tmpfile.Name())
+		cleanup = func() { os.Remove(tmpfile.Name()) }
 
 		if _, err := tmpfile.WriteString(inventoryContent); err != nil {
-			return "", fmt.Errorf("failed to write inventory content: %v", err)
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to write inventory content: %v", err)
 		}
 		if err := tmpfile.Close(); err != nil {
-			return "", fmt.Errorf("failed to close temp file: %v", err)
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to close temp file: %v", err)
 		}
 
 		args = append(args, "-i", tmpfile.Name())
 	}
 
-	if len(extraVars) > 0 {
+	if len(inv.ExtraVars) > 0 {
 		extraVarsStr := ""
-		for k, v := range extraVars {
+		for k, v := range inv.ExtraVars {
 			if extraVarsStr != "" {
 				extraVarsStr += " "
 			}
@@ -628,70 +1319,94 @@ func runAnsiblePlaybook(playbookPath, inventoryName string, extraVars map[string
 		args = append(args, "--extra-vars", extraVarsStr)
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	output, err := cmd.CombinedOutput()
+	if cfg.Ansible.VaultPasswordFile != "" {
+		args = append(args, "--vault-password-file", cfg.Ansible.VaultPasswordFile)
+	}
+	if inv.Limit != "" {
+		args = append(args, "--limit", inv.Limit)
+	}
+	args = append(args, inv.ExtraArgs...)
 
-	return string(output), err
+	return args, cleanup, nil
 }
 
-func logExecution(playbook string, success bool, output, errorMsg string, startTime, endTime time.Time, duration float64) error {
-	logEntry := PlaybookLog{
-		Playbook:  playbook,
-		Success:   success,
-		Output:    output,
-		Error:     errorMsg,
-		StartTime: startTime,
-		EndTime:   endTime,
-		Duration:  duration,
+func runAnsiblePlaybook(ctx context.Context, inv ansibleInvocation) (string, error) {
+	args, removeInventory, err := ansibleArgs(inv)
+	if err != nil {
+		return "", err
 	}
+	defer removeInventory()
 
-	return db.Create(&logEntry).Error
-}
+	if inv.Runner != nil {
+		return runRemotePlaybook(ctx, inv, args)
+	}
 
-// Inventory handlers
-func listInventoriesHandler(w http.ResponseWriter, r *http.Request) {
-	queryParams := r.URL.Query()
-	page, _ := strconv.Atoi(queryParams.Get("page"))
-	if page < 1 {
-		page = 1
+	cmd, cleanup, err := newAnsibleCommand(ctx, inv.Installation, args)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	cmd.Env = append(cmd.Env, showCustomStatsEnv)
+	if inv.WorkDir != "" {
+		cmd.Env = append(cmd.Env, "ANSIBLE_LOG_PATH="+filepath.Join(inv.WorkDir, "ansible.log"))
 	}
 
-	query := db.Model(&Inventory{})
+	return startAnsibleCommand(cmd, inv)
+}
 
-	var totalCount int64
-	if err := query.Count(&totalCount).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// startAnsibleCommand выполняет команду, собирая вывод и передавая его в inv.Output
+func startAnsibleCommand(cmd *exec.Cmd, inv ansibleInvocation) (string, error) {
+	var output bytes.Buffer
+	var sink io.Writer = &output
+	if inv.Output != nil {
+		sink = io.MultiWriter(&output, inv.Output)
 	}
-
-	totalPages := (int(totalCount) + cfg.Logging.PageSize - 1) / cfg.Logging.PageSize
-	if page > totalPages && totalPages > 0 {
-		page = totalPages
+	cmd.Stdout = sink
+	cmd.Stderr = sink
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	if inv.OnStart != nil {
+		inv.OnStart(cmd.Process.Pid)
+	}
+	err := cmd.Wait()
+	if inv.OnExit != nil && cmd.ProcessState != nil {
+		inv.OnExit(processUsage(cmd.ProcessState))
 	}
-	offset := (page - 1) * cfg.Logging.PageSize
 
-	var inventories []Inventory
-	if err := query.Order("name ASC").
-		Limit(cfg.Logging.PageSize).
-		Offset(offset).
-		Find(&inventories).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	return output.String(), err
+}
+
+// Inventory handlers
+func listInventoriesHandler(w http.ResponseWriter, r *http.Request) {
+	pager := parsePagination(r)
+
+	filter := InventoryFilter{Principal: currentPrincipal(r)}
+	// deleted=true показывает корзину: только удаленные инвентари
+	filter.Deleted, _ = strconv.ParseBool(r.URL.Query().Get("deleted"))
+
+	inventories, err := storeOf(r).ListInventories(filter, pager)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
 
 	response := InventoriesResponse{
 		Inventories: inventories,
-		TotalCount:  int(totalCount),
+		TotalCount:  pager.TotalCount,
+		CurrentPage: pager.Page,
+		TotalPages:  pager.TotalPages,
+		PerPage:     pager.PerPage,
 	}
 
+	pager.writeHeaders(w, r)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func createInventoryHandler(w http.ResponseWriter, r *http.Request) {
 	var inv Inventory
-	if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &inv) {
 		return
 	}
 
@@ -699,9 +1414,13 @@ func createInventoryHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Name and content are required", http.StatusBadRequest)
 		return
 	}
+	if err := applyOwnership(&inv, currentPrincipal(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if err := db.Create(&inv).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := storeOf(r).CreateInventory(&inv); err != nil {
+		writeDBError(w, err)
 		return
 	}
 
@@ -714,13 +1433,8 @@ func getInventoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	var inv Inventory
-	if err := db.Where("name = ?", name).First(&inv).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, "Inventory not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	inv, ok := loadInventory(w, r, name, false)
+	if !ok {
 		return
 	}
 
@@ -732,28 +1446,38 @@ func updateInventoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	var inv Inventory
-	if err := db.Where("name = ?", name).First(&inv).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, "Inventory not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	inv, ok := loadInventory(w, r, name, true)
+	if !ok {
 		return
 	}
 
 	var updateData Inventory
-	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &updateData) {
 		return
 	}
 
 	if updateData.Content != "" {
 		inv.Content = updateData.Content
 	}
+	if updateData.Access != "" {
+		if !updateData.Access.valid() {
+			http.Error(w, "Invalid access, expected private, team or shared", http.StatusBadRequest)
+			return
+		}
+		inv.Access = updateData.Access
+	}
+	// Передать инвентарь другому владельцу или команде может только администратор
+	if currentPrincipal(r).Admin {
+		if updateData.Owner != "" {
+			inv.Owner = updateData.Owner
+		}
+		if updateData.Team != "" {
+			inv.Team = updateData.Team
+		}
+	}
 
-	if err := db.Save(&inv).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := storeOf(r).SaveInventory(inv); err != nil {
+		writeDBError(w, err)
 		return
 	}
 
@@ -765,8 +1489,36 @@ func deleteInventoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
-	if err := db.Where("name = ?", name).Delete(&Inventory{}).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// force=true удаляет инвентарь безвозвратно, в том числе уже лежащий в корзине,
+	// и не проверяет, используется ли он
+	if force, _ := strconv.ParseBool(r.URL.Query().Get("force")); force {
+		purgeInventoryHandler(w, r)
+		return
+	}
+
+	inv, ok := loadInventory(w, r, name, true)
+	if !ok {
+		return
+	}
+
+	t := tenantOf(r)
+	references, err := inventoryReferences(t, name)
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if len(references) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":      "inventory is in use, pass force=true to delete it anyway",
+			"references": references,
+		})
+		return
+	}
+
+	if err := t.store().DeleteInventory(inv); err != nil {
+		writeDBError(w, err)
 		return
 	}
 
@@ -777,48 +1529,49 @@ func checkInventoryHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	inventoryName := vars["name"]
 
-	var inv Inventory
-	if err := db.Where("name = ?", inventoryName).First(&inv).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, "Inventory not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	inv, ok := loadInventory(w, r, inventoryName, false)
+	if !ok {
+		return
+	}
+
+	// Тело необязательно: без него выполняется ping
+	var req CheckRequest
+	if r.ContentLength != 0 {
+		if !decodeJSONBody(w, r, &req) {
+			return
 		}
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	admission := newPolicyInput(r, policyActionCheck)
+	admission.Inventory = inventoryName
+	admission.Module = req.module()
+	if !admit(w, admission) {
 		return
 	}
 
 	// Создаем запись о проверке
+	t := tenantOf(r)
 	check := InventoryCheck{
 		InventoryID: inv.ID,
 		Status:      CheckStatusPending,
+		Mode:        req.Mode,
+		Module:      req.Module,
+		Limit:       req.Limit,
 		StartedAt:   time.Now(),
 	}
-	if err := db.Create(&check).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := t.store().CreateCheck(&check); err != nil {
+		writeDBError(w, err)
 		return
 	}
 
-	// Запускаем проверку в фоне
-	go func() {
-		// Обновляем статус на "running"
-		db.Model(&InventoryCheck{}).Where("id = ?", check.ID).Update("status", CheckStatusRunning)
-
-		results, err := testInventoryHosts(inventoryName)
+	publishCheckEvent(t, "check.queued", check, inventoryName, "")
 
-		updates := map[string]interface{}{
-			"completed_at": time.Now(),
-		}
-
-		if err != nil {
-			updates["status"] = CheckStatusFailed
-			updates["error"] = err.Error()
-		} else {
-			updates["status"] = CheckStatusCompleted
-			updates["results"] = results
-		}
-
-		db.Model(&InventoryCheck{}).Where("id = ?", check.ID).Updates(updates)
-	}()
+	// Запускаем проверку в фоне
+	go runInventoryCheck(t, check, inventoryName, req)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -829,156 +1582,174 @@ func checkInventoryHandler(w http.ResponseWriter, r *http.Request) {
 
 func listInventoryChecksHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
-	page, _ := strconv.Atoi(queryParams.Get("page"))
-	if page < 1 {
-		page = 1
-	}
-
-	inventoryID := queryParams.Get("inventory_id")
-	statusFilter := queryParams.Get("status")
-
-	query := db.Model(&InventoryCheck{})
-
-	if inventoryID != "" {
-		query = query.Where("inventory_id = ?", inventoryID)
-	}
-
-	if statusFilter != "" {
-		query = query.Where("status = ?", statusFilter)
-	}
+	pager := parsePagination(r)
 
-	var totalCount int64
-	if err := query.Count(&totalCount).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	filter := CheckFilter{
+		Inventory: queryParams.Get("inventory"),
+		Status:    queryParams.Get("status"),
+		Principal: currentPrincipal(r),
 	}
-
-	totalPages := (int(totalCount) + cfg.Logging.PageSize - 1) / cfg.Logging.PageSize
-	if page > totalPages && totalPages > 0 {
-		page = totalPages
+	if value := queryParams.Get("inventory_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			http.Error(w, "Invalid inventory_id", http.StatusBadRequest)
+			return
+		}
+		inventoryID := uint(id)
+		filter.InventoryID = &inventoryID
 	}
-	offset := (page - 1) * cfg.Logging.PageSize
 
-	var checks []InventoryCheck
-	if err := query.Preload("Inventory").
-		Order("started_at DESC").
-		Limit(cfg.Logging.PageSize).
-		Offset(offset).
-		Find(&checks).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	checks, err := storeOf(r).ListChecks(filter, pager)
+	if err != nil {
+		writeDBError(w, err)
 		return
 	}
 
 	response := InventoryChecksResponse{
-		Checks:     checks,
-		TotalCount: int(totalCount),
+		Checks:      checks,
+		TotalCount:  pager.TotalCount,
+		CurrentPage: pager.Page,
+		TotalPages:  pager.TotalPages,
+		PerPage:     pager.PerPage,
 	}
 
+	pager.writeHeaders(w, r)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func getInventoryCheckHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	checkID := vars["id"]
+	checkID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		http.Error(w, "Invalid check ID", http.StatusBadRequest)
+		return
+	}
 
-	var check InventoryCheck
-	if err := db.Preload("Inventory").First(&check, checkID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	check, err := storeOf(r).GetCheck(uint(checkID))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
 			http.Error(w, "Check not found", http.StatusNotFound)
 		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeDBError(w, err)
 		}
 		return
 	}
+	if check.Inventory != nil && !currentPrincipal(r).canUse(*check.Inventory) {
+		http.Error(w, "Check not found", http.StatusNotFound)
+		return
+	}
+	check.fillHostMetadata(tenantOf(r))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(check)
 }
 
-func testInventoryHosts(inventoryName string) (map[string]string, error) {
+// runInventoryCheck выполняет созданную проверку и сохраняет ее итог.
+// Возвращает проверку с результатами.
+func runInventoryCheck(t *tenant, check InventoryCheck, inventoryName string, req CheckRequest) InventoryCheck {
+	// Обновляем статус на "running"
+	if err := t.store().StartCheck(check.ID); err != nil {
+		log.Printf("Failed to mark inventory check %d as running: %v", check.ID, err)
+	}
+	check.Status = CheckStatusRunning
+	publishCheckEvent(t, "check.started", check, inventoryName, "")
+
+	progress := newCheckProgress(t, check, inventoryName)
+	ctx, cancel := context.WithTimeout(context.Background(), req.timeout())
+	err := testInventoryHosts(ctx, inventoryName, req, progress)
+	cancel()
+
+	completedAt := time.Now()
+	check.CompletedAt = &completedAt
+	check.Results, check.HostErrors, check.Facts = progress.Outcome()
+	check.Status = CheckStatusCompleted
+	message := ""
+	if err != nil {
+		message = err.Error()
+		check.Status, check.Error = CheckStatusFailed, message
+	}
+	if err := t.store().FinishCheck(check); err != nil {
+		log.Printf("Failed to save result of inventory check %d: %v", check.ID, err)
+	}
+	publishCheckEvent(t, "check.finished", check, inventoryName, message)
+	return check
+}
+
+// testInventoryHosts проверяет доступность хостов. Результаты по хостам
+// собирает progress из JSON-вывода по мере выполнения.
+func testInventoryHosts(ctx context.Context, inventoryName string, req CheckRequest, progress *checkProgress) error {
 	// Получаем содержимое инвентаря
-	inventoryContent, err := getInventoryContent(inventoryName)
+	inventoryContent, err := getInventoryContent(progress.tenant, inventoryName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get inventory: %v", err)
+		return fmt.Errorf("failed to get inventory: %v", err)
+	}
+	if inventoryContent, err = renderInventory(inventoryContent, req.Params); err != nil {
+		return fmt.Errorf("failed to render inventory: %v", err)
 	}
 
 	// Создаем временный playbook для проверки
-	playbookContent := `---
-- hosts: all
-  gather_facts: no
-  tasks:
-    - name: Test host connectivity
-      ansible.builtin.ping:
-      register: ping_result
-      ignore_errors: yes
-
-    - name: Collect results
-      ansible.builtin.set_fact:
-        host_status: "{{ 'reachable' if ping_result.ping == 'pong' else 'unreachable' }}"
-
-    - name: Print results
-      ansible.builtin.debug:
-        msg: "Host {{ inventory_hostname }} is {{ host_status }}"
-    `
+	playbookContent, err := checkPlaybook(req)
+	if err != nil {
+		return err
+	}
 
-	tmpPlaybook, err := os.CreateTemp("", "check-hosts-*.yml")
+	tmpPlaybook, err := os.CreateTemp(cfg.Disk.TempDir, "check-hosts-*.yml")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp playbook: %v", err)
+		return fmt.Errorf("failed to create temp playbook: %v", err)
 	}
 	defer os.Remove(tmpPlaybook.Name())
 
 	if _, err := tmpPlaybook.WriteString(playbookContent); err != nil {
-		return nil, fmt.Errorf("failed to write playbook: %v", err)
+		return fmt.Errorf("failed to write playbook: %v", err)
 	}
 	tmpPlaybook.Close()
 
 	// Создаем временный inventory файл
-	tmpInventory, err := os.CreateTemp("", "inventory-*.ini")
+	tmpInventory, err := os.CreateTemp(cfg.Disk.TempDir, "inventory-*.ini")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp inventory: %v", err)
+		return fmt.Errorf("failed to create temp inventory: %v", err)
 	}
 	defer os.Remove(tmpInventory.Name())
 
-	if _, err := tmpInventory.WriteString(inventoryContent); err != nil {
-		return nil, fmt.Errorf("failed to write inventory: %v", err)
+	if _, err := tmpInventory.WriteString(withDefaultPython(inventoryContent)); err != nil {
+		return fmt.Errorf("failed to write inventory: %v", err)
 	}
 	tmpInventory.Close()
 
 	// Запускаем Ansible
-	cmd := exec.Command("ansible-playbook", tmpPlaybook.Name(), "-i", tmpInventory.Name())
-	output, err := cmd.CombinedOutput()
+	args := []string{defaultAnsible.binary("ansible-playbook"), tmpPlaybook.Name(), "-i", tmpInventory.Name()}
+	if req.Limit != "" {
+		args = append(args, "--limit", req.Limit)
+	}
+	cmd, cleanup, err := newAnsibleCommand(ctx, defaultAnsible, args)
 	if err != nil {
-		return nil, fmt.Errorf("ansible failed: %v\nOutput:\n%s", err, string(output))
+		return err
 	}
+	defer cleanup()
 
-	// Парсим результаты
-	return parsePingResults(string(output)), nil
-}
-
-func parsePingResults(output string) map[string]string {
-	results := make(map[string]string)
-	lines := strings.Split(output, "\n")
+	cmd.Env = append(cmd.Env, "ANSIBLE_STDOUT_CALLBACK="+cfg.Checks.Callback)
+	var stderr bytes.Buffer
+	cmd.Stdout = progress
+	cmd.Stderr = &stderr
+	err = cmd.Run()
 
-	for _, line := range lines {
-		if strings.Contains(line, "Host") && strings.Contains(line, "is") {
-			parts := strings.Fields(line)
-			if len(parts) >= 6 {
-				host := parts[1]
-				status := parts[5]
-				status = strings.TrimRight(status, "'")
-				results[host] = status
-			}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("check timed out\nOutput:\n%s", stderr.String())
+	}
+	// Код 2 - на части хостов задача упала, 4 - часть хостов недоступна.
+	// Это нормальный итог проверки, если результаты удалось разобрать.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 2 || exitErr.ExitCode() == 4) {
+		if results, _, _ := progress.Outcome(); len(results) > 0 {
+			return nil
 		}
 	}
-
-	return results
+	if err != nil {
+		return fmt.Errorf("ansible failed: %v\nOutput:\n%s", err, stderr.String())
+	}
+	return nil
 }
 
-func getInventoryContent(inventoryName string) (string, error) {
-	var inv Inventory
-	if err := db.Where("name = ?", inventoryName).First(&inv).Error; err != nil {
-		return "", err
-	}
-	return inv.Content, nil
+func getInventoryContent(t *tenant, inventoryName string) (string, error) {
+	return t.store().InventoryContent(inventoryName)
 }
@@ -0,0 +1,142 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ansible-api/config"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// useMemoryStore подменяет конфигурацию, арендаторов и хранилище на время теста
+func useMemoryStore(t *testing.T) *memoryStore {
+	t.Helper()
+	prevCfg, prevTenants, prevStore := cfg, tenants, newStore
+	t.Cleanup(func() { cfg, tenants, newStore = prevCfg, prevTenants, prevStore })
+
+	cfg = &config.Config{}
+	cfg.Logging.PageSize = 20
+	cfg.Auth.Tokens = []config.APIToken{
+		{Name: "alice", Team: "ops", Token: "alice-token"},
+		{Name: "bob", Team: "dev", Token: "bob-token"},
+	}
+	tenants = map[string]*tenant{defaultTenantName: {Name: defaultTenantName, Schema: "ansible_api"}}
+	store := newMemoryStore()
+	newStore = func(*tenant) Store { return store }
+	return store
+}
+
+// addCheck создает инвентарь владельца и проверку по нему без хостов
+func addCheck(t *testing.T, store *memoryStore, inventory, owner string, status InventoryCheckStatus, startedAt time.Time) InventoryCheck {
+	t.Helper()
+	inv, err := store.GetInventory(inventory)
+	if err != nil {
+		inv = Inventory{Name: inventory, Owner: owner, Access: AccessPrivate}
+		if err := store.CreateInventory(&inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := InventoryCheck{InventoryID: inv.ID, Status: status, Mode: CheckModePing, StartedAt: startedAt}
+	if err := store.CreateCheck(&check); err != nil {
+		t.Fatal(err)
+	}
+	return check
+}
+
+func serveCheck(handler http.HandlerFunc, target, token string, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if token != "" {
+		req.Header.Set("X-API-Token", token)
+	}
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestGetInventoryCheckHandler(t *testing.T) {
+	store := useMemoryStore(t)
+	check := addCheck(t, store, "web", "alice", CheckStatusCompleted, time.Now())
+	id := map[string]string{"id": "1"}
+
+	rec := serveCheck(getInventoryCheckHandler, "/api/inventory/checks/1", "alice-token", id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner: status %d, body %q", rec.Code, rec.Body)
+	}
+	var got InventoryCheck
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != check.ID || got.InventoryName != "web" || got.Status != CheckStatusCompleted {
+		t.Errorf("got check %d of %q with status %q", got.ID, got.InventoryName, got.Status)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		vars  map[string]string
+		code  int
+	}{
+		{"other user's private inventory", "bob-token", id, http.StatusNotFound},
+		{"unknown check", "alice-token", map[string]string{"id": "42"}, http.StatusNotFound},
+		{"invalid id", "alice-token", map[string]string{"id": "abc"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveCheck(getInventoryCheckHandler, "/api/inventory/checks/x", tt.token, tt.vars); rec.Code != tt.code {
+				t.Errorf("status %d, want %d", rec.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestListInventoryChecksHandler(t *testing.T) {
+	store := useMemoryStore(t)
+	now := time.Now()
+	addCheck(t, store, "web", "alice", CheckStatusCompleted, now.Add(-2*time.Hour))
+	addCheck(t, store, "web", "alice", CheckStatusFailed, now.Add(-time.Hour))
+	addCheck(t, store, "web", "alice", CheckStatusCompleted, now)
+	addCheck(t, store, "db", "bob", CheckStatusCompleted, now)
+
+	rec := serveCheck(listInventoryChecksHandler, "/api/inventory/checks?status=completed&per_page=1", "alice-token", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %q", rec.Code, rec.Body)
+	}
+	var resp InventoryChecksResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	// Чужая проверка по инвентарю bob и проверка со статусом failed не видны
+	if resp.TotalCount != 2 || resp.TotalPages != 2 || len(resp.Checks) != 1 {
+		t.Fatalf("total %d, pages %d, checks %d", resp.TotalCount, resp.TotalPages, len(resp.Checks))
+	}
+	if resp.Checks[0].ID != 3 {
+		t.Errorf("first check %d, want the latest (3)", resp.Checks[0].ID)
+	}
+	if got := rec.Header().Get("X-Total-Count"); got != "2" {
+		t.Errorf("X-Total-Count %q", got)
+	}
+
+	if rec := serveCheck(listInventoryChecksHandler, "/api/inventory/checks?inventory_id=x", "alice-token", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid inventory_id: status %d", rec.Code)
+	}
+}
+
+func TestFinishRunKeepsLostStatus(t *testing.T) {
+	store := useMemoryStore(t)
+	store.runs[1] = PlaybookRun{Model: gorm.Model{ID: 1}, Playbook: "site.yml", Status: RunStatusLost, StartTime: time.Now(), Error: "process lost"}
+
+	if err := store.FinishRun(1, RunStatusCompleted, "ok", ""); err != errRunNotStarted {
+		t.Fatalf("FinishRun on lost run: %v", err)
+	}
+	if run, _ := store.GetRun(1); run.Status != RunStatusLost || run.Output != "ok" {
+		t.Errorf("run status %q, output %q", run.Status, run.Output)
+	}
+}
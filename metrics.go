@@ -0,0 +1,43 @@
+package ansibleapi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Метрики сервиса, отдаются на /metrics
+var metrics = prometheus.NewRegistry()
+
+var (
+	cleanupRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ansible_api_cleanup_runs_total",
+		Help: "Cleanup executions by result.",
+	}, []string{"tenant", "result"})
+	cleanupDeletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ansible_api_cleanup_deleted_total",
+		Help: "Rows (or partitions) removed by cleanup, by table.",
+	}, []string{"tenant", "table"})
+	cleanupLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ansible_api_cleanup_last_success_timestamp_seconds",
+		Help: "Time of the last cleanup that finished without errors.",
+	}, []string{"tenant"})
+	cleanupDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ansible_api_cleanup_duration_seconds",
+		Help: "Duration of the last cleanup.",
+	}, []string{"tenant"})
+)
+
+func init() {
+	metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		cleanupRunsTotal,
+		cleanupDeletedTotal,
+		cleanupLastSuccess,
+		cleanupDuration,
+		workDirsGauge,
+		workDirsBytes,
+		&playbookHealthCollector{},
+		tempDirCollector{},
+	)
+}
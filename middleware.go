@@ -0,0 +1,176 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	compress    bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.wroteHeader {
+		g.wroteHeader = true
+		// У 204 и 304 нет тела, сжимать нечего
+		g.compress = status != http.StatusNoContent && status != http.StatusNotModified
+		if g.compress {
+			g.Header().Del("Content-Length")
+			g.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.compress {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+// Unwrap дает http.ResponseController доступ к исходному ResponseWriter
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.compress {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// gzipMiddleware сжимает ответы для клиентов с Accept-Encoding: gzip.
+// WebSocket-запросы пропускаются без изменений.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		gz.Reset(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		defer func() {
+			if gw.compress {
+				gz.Close()
+			}
+			gzipWriterPool.Put(gz)
+		}()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// withETag буферизует ответ, вычисляет ETag по телу и отвечает 304 на совпадающий If-None-Match
+func withETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(buf, r)
+
+		if buf.status != http.StatusOK {
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(buf.body.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Write(buf.body.Bytes())
+	}
+}
+
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// withLimits ограничивает размер тела запроса и время работы обработчика.
+// Не подходит для потоковых маршрутов: http.TimeoutHandler буферизует ответ.
+func withLimits(next http.HandlerFunc, maxBytes int64, timeout time.Duration) http.HandlerFunc {
+	handler := http.Handler(next)
+	if timeout > 0 {
+		handler = http.TimeoutHandler(next, timeout, "Request timed out")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maxBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+func standardRoute(next http.HandlerFunc) http.HandlerFunc {
+	return withLimits(next, cfg.Server.MaxBodyBytes, cfg.Server.HandlerTimeout)
+}
+
+func uploadRoute(next http.HandlerFunc) http.HandlerFunc {
+	return withLimits(next, cfg.Server.MaxUploadBytes, cfg.Server.UploadTimeout)
+}
+
+// decodeJSONBody разбирает JSON тела запроса; при превышении лимита отвечает 413
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package ansibleapi
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration - запись о примененном SQL-файле из каталога migrations
+type Migration struct {
+	ID        uint      `gorm:"primaryKey"`
+	Name      string    `gorm:"type:text;not null"`
+	AppliedAt time.Time `gorm:"not null;default:now()"`
+}
+
+func (Migration) TableName() string {
+	return cfg.Database.Schema + ".migrations"
+}
+
+// Миграция, заменившая таблицу playbook_log представлением над playbook_run
+const unifiedLogsMigration = "006_unify_runs_logs.sql"
+
+// Имя схемы в файлах миграций, заменяется на схему арендатора
+var migrationSchemaRe = regexp.MustCompile(`\bansible_api\b`)
+
+// migrateTenant создает схему арендатора и приводит ее к текущей версии
+func migrateTenant(t *tenant) error {
+	if err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", t.Schema)).Error; err != nil {
+		return fmt.Errorf("failed to create schema: %v", err)
+	}
+
+	mdb, err := t.migrationDB()
+	if err != nil {
+		return err
+	}
+
+	// Автомиграции - создание таблиц
+	models := []interface{}{&PlaybookRun{}, &Inventory{}, &InventoryCheck{}, &RunQueueEntry{}, &RunOutputChunk{}, &RunEvent{}, &HousekeepingRun{}, &RunDailyStat{}, &RunDailyTeamStat{}, &ScheduleOccurrence{}, &PlaybookLifecycle{}, &Agent{}, &RunArtifact{}, &RunBatch{}, &Rollout{}, &HostMetadata{}, &RequestSignature{}, &User{}, &HostQuarantine{}, &RoleBinding{}, &InventoryCheckSchedule{}, &HostCheckState{}, &Credential{}}
+	// Таблица playbook_log нужна ранним миграциям, пока ее не заменило представление
+	if !migrationApplied(t, unifiedLogsMigration) {
+		models = append(models, &PlaybookLog{})
+	}
+	if err := mdb.AutoMigrate(models...); err != nil {
+		return fmt.Errorf("failed to auto-migrate: %v", err)
+	}
+
+	if err := mdb.Table(t.table("migrations")).AutoMigrate(&Migration{}); err != nil {
+		return err
+	}
+	if err := runMigrations(t); err != nil {
+		return fmt.Errorf("failed to apply migrations: %v", err)
+	}
+	return nil
+}
+
+// migrationDB возвращает gorm со схемой арендатора в TablePrefix: AutoMigrate
+// берет имена таблиц из схемы модели и не проходит через callbacks арендатора
+func (t *tenant) migrationDB() (*gorm.DB, error) {
+	if t.Schema == cfg.Database.Schema {
+		return db, nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: db.Logger,
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix:   t.Schema + ".",
+			SingularTable: true,
+		},
+	})
+}
+
+// migrationApplied сообщает, применена ли миграция; до первого запуска таблицы миграций нет
+func migrationApplied(t *tenant, name string) bool {
+	if !db.Migrator().HasTable(t.table("migrations")) {
+		return false
+	}
+	var applied int64
+	if err := t.db().Model(&Migration{}).Where("name = ?", name).Count(&applied).Error; err != nil {
+		log.Fatalf("Failed to check migration %s of tenant %s: %v", name, t.Name, err)
+	}
+	return applied > 0
+}
+
+// runMigrations применяет еще не примененные файлы migrations/*.sql в порядке имен.
+// Вызывается после AutoMigrate, поэтому миграции могут ссылаться на таблицы моделей.
+func runMigrations(t *tenant) error {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		name := path.Base(file)
+
+		var applied int64
+		if err := t.db().Model(&Migration{}).Where("name = ?", name).Count(&applied).Error; err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sql := migrationSchemaRe.ReplaceAllString(string(content), t.Schema)
+
+		err = t.db().Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+			return tx.Create(&Migration{Name: name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied migration %s to schema %s", name, t.Schema)
+	}
+
+	return nil
+}
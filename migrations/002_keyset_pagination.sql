@@ -0,0 +1,3 @@
+-- Индексы для keyset-пагинации (cursor) по start_time + id
+CREATE INDEX IF NOT EXISTS idx_playbook_run_start_time_id ON ansible_api.playbook_run (start_time DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_playbook_log_start_time_id ON ansible_api.playbook_log (start_time DESC, id DESC);
@@ -0,0 +1,62 @@
+-- Помесячное партиционирование playbook_log и playbook_run по start_time.
+-- Существующие таблицы переименовываются, данные переносятся в партиции.
+
+CREATE OR REPLACE FUNCTION ansible_api.ensure_monthly_partition(parent text, month date)
+RETURNS void LANGUAGE plpgsql AS $$
+DECLARE
+    month_start date := date_trunc('month', month)::date;
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS ansible_api.%I PARTITION OF ansible_api.%I FOR VALUES FROM (%L) TO (%L)',
+        parent || '_p' || to_char(month_start, 'YYYYMM'),
+        parent,
+        month_start,
+        (month_start + interval '1 month')::date
+    );
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION ansible_api.partition_table(parent text)
+RETURNS void LANGUAGE plpgsql AS $$
+DECLARE
+    legacy text := parent || '_legacy';
+    seq text;
+    m date;
+BEGIN
+    EXECUTE format('ALTER TABLE ansible_api.%I RENAME TO %I', parent, legacy);
+    EXECUTE format(
+        'CREATE TABLE ansible_api.%I (LIKE ansible_api.%I INCLUDING DEFAULTS INCLUDING CONSTRAINTS) PARTITION BY RANGE (start_time)',
+        parent, legacy
+    );
+    -- Ключ партиционирования обязан входить в первичный ключ
+    EXECUTE format('ALTER TABLE ansible_api.%I ADD PRIMARY KEY (id, start_time)', parent);
+
+    -- Последовательность id принадлежит старой таблице, переносим владение до ее удаления
+    seq := pg_get_serial_sequence(format('ansible_api.%I', legacy), 'id');
+    IF seq IS NOT NULL THEN
+        EXECUTE format('ALTER SEQUENCE %s OWNED BY ansible_api.%I.id', seq, parent);
+    END IF;
+
+    EXECUTE format('SELECT date_trunc(''month'', min(start_time))::date FROM ansible_api.%I', legacy) INTO m;
+    m := COALESCE(m, date_trunc('month', now())::date);
+    WHILE m <= date_trunc('month', now() + interval '1 month')::date LOOP
+        PERFORM ansible_api.ensure_monthly_partition(parent, m);
+        m := (m + interval '1 month')::date;
+    END LOOP;
+    EXECUTE format('CREATE TABLE ansible_api.%I PARTITION OF ansible_api.%I DEFAULT', parent || '_default', parent);
+
+    EXECUTE format('INSERT INTO ansible_api.%I SELECT * FROM ansible_api.%I', parent, legacy);
+    EXECUTE format('DROP TABLE ansible_api.%I', legacy);
+END;
+$$;
+
+SELECT ansible_api.partition_table('playbook_log');
+SELECT ansible_api.partition_table('playbook_run');
+DROP FUNCTION ansible_api.partition_table(text);
+
+-- Индексы старых таблиц удалены вместе с ними, создаем заново на партиционированных
+CREATE INDEX IF NOT EXISTS idx_playbook_log_deleted_at ON ansible_api.playbook_log (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_playbook_run_deleted_at ON ansible_api.playbook_run (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_playbook_run_node_id ON ansible_api.playbook_run (node_id);
+CREATE INDEX IF NOT EXISTS idx_playbook_log_start_time_id ON ansible_api.playbook_log (start_time DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_playbook_run_start_time_id ON ansible_api.playbook_run (start_time DESC, id DESC);
@@ -0,0 +1,17 @@
+-- Составные индексы под фильтры списков. Порядок колонок совпадает с
+-- ORDER BY обработчиков (start_time DESC, id DESC), а все выборки gorm
+-- содержат deleted_at IS NULL, поэтому индексы частичные.
+CREATE INDEX IF NOT EXISTS idx_playbook_run_playbook_start_time
+    ON ansible_api.playbook_run (playbook, start_time DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_playbook_run_status_start_time
+    ON ansible_api.playbook_run (status, start_time DESC, id DESC) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_playbook_log_playbook_start_time
+    ON ansible_api.playbook_log (playbook, start_time DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_playbook_log_success_start_time
+    ON ansible_api.playbook_log (success, start_time DESC, id DESC) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_inventory_check_inventory_started_at
+    ON ansible_api.inventory_check (inventory_id, started_at DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_inventory_check_status_started_at
+    ON ansible_api.inventory_check (status, started_at DESC, id DESC) WHERE deleted_at IS NULL;
@@ -0,0 +1,22 @@
+-- Имя инвентаря уникально только среди неудаленных записей, чтобы после
+-- мягкого удаления можно было создать инвентарь с тем же именем
+DO $$
+DECLARE
+    con record;
+BEGIN
+    FOR con IN
+        SELECT c.conname
+        FROM pg_constraint c
+        JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+        WHERE c.conrelid = 'ansible_api.inventory'::regclass
+          AND c.contype = 'u'
+          AND array_length(c.conkey, 1) = 1
+          AND a.attname = 'name'
+    LOOP
+        EXECUTE format('ALTER TABLE ansible_api.inventory DROP CONSTRAINT %I', con.conname);
+    END LOOP;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_name_active
+    ON ansible_api.inventory (name)
+    WHERE deleted_at IS NULL;
@@ -0,0 +1,67 @@
+-- playbook_log дублировал playbook_run: каждое выполнение записывалось в обе таблицы.
+-- Теперь история хранится только в playbook_run, а playbook_log становится
+-- представлением над завершенными запусками для совместимости с /api/logs.
+-- id лога в представлении - legacy_log_id запуска, если он есть, иначе id запуска.
+-- legacy_log_id получают все запуски, существующие до миграции: прежний id
+-- их лога или новый id из последовательности, поэтому id в представлении не
+-- совпадают ни между собой, ни с id запусков, созданных после миграции.
+
+ALTER TABLE ansible_api.playbook_run ADD COLUMN IF NOT EXISTS legacy_log_id bigint;
+
+-- Лог - копия запуска: тот же playbook и итог, а записан он непосредственно
+-- перед завершением запуска, поэтому связываем по времени окончания (время
+-- старта у лога - начало процесса, запуск мог долго ждать очереди). Каждому
+-- логу - ближайший запуск, предпочтительно с тем же выводом, каждому запуску -
+-- не больше одного лога.
+UPDATE ansible_api.playbook_run r SET legacy_log_id = m.log_id
+FROM (
+    SELECT DISTINCT ON (c.run_id) c.log_id, c.run_id
+    FROM (
+        SELECT DISTINCT ON (l.id) l.id AS log_id, r.id AS run_id,
+            abs(extract(epoch FROM r.end_time - l.end_time)) AS gap
+        FROM ansible_api.playbook_log l
+        JOIN ansible_api.playbook_run r ON r.playbook = l.playbook
+            AND (r.status = 'completed') = l.success
+            AND r.end_time BETWEEN l.end_time - interval '10 seconds' AND l.end_time + interval '10 minutes'
+        ORDER BY l.id, (r.output IS NOT DISTINCT FROM l.output) DESC, gap, r.id
+    ) c
+    ORDER BY c.run_id, c.gap, c.log_id
+) m
+WHERE r.id = m.run_id;
+
+-- Логи без запуска (записанные до появления playbook_run или не связанные)
+-- переносятся как завершенные запуски со своим id
+INSERT INTO ansible_api.playbook_run
+    (created_at, updated_at, deleted_at, playbook, status, start_time, end_time, duration,
+     triggered_by, output, error, legacy_log_id)
+SELECT l.created_at, l.updated_at, l.deleted_at, l.playbook,
+    CASE WHEN l.success THEN 'completed' ELSE 'failed' END,
+    l.start_time, l.end_time, l.duration, 'legacy-log', l.output, l.error, l.id
+FROM ansible_api.playbook_log l
+WHERE NOT EXISTS (SELECT 1 FROM ansible_api.playbook_run r WHERE r.legacy_log_id = l.id);
+
+-- Последовательность продолжается после всех прежних id запусков и логов
+SELECT setval(pg_get_serial_sequence('ansible_api.playbook_run', 'id'), GREATEST(last_id, 1), last_id > 0)
+FROM (SELECT GREATEST((SELECT COALESCE(max(id), 0) FROM ansible_api.playbook_run),
+                      (SELECT COALESCE(max(id), 0) FROM ansible_api.playbook_log)) AS last_id) ids;
+
+-- Остальные существующие запуски получают id лога из последовательности: их
+-- собственный id мог совпасть с прежним id другого лога
+UPDATE ansible_api.playbook_run
+SET legacy_log_id = nextval(pg_get_serial_sequence('ansible_api.playbook_run', 'id'))
+WHERE legacy_log_id IS NULL;
+
+-- Партиции удаляются вместе с родительской таблицей
+DROP TABLE ansible_api.playbook_log CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_playbook_run_log_id ON ansible_api.playbook_run ((COALESCE(legacy_log_id, id)));
+
+CREATE VIEW ansible_api.playbook_log AS
+SELECT COALESCE(legacy_log_id, id) AS id, id AS run_id,
+    created_at, updated_at, deleted_at, playbook,
+    status = 'completed' AS success,
+    output, error, start_time,
+    COALESCE(end_time, start_time) AS end_time,
+    COALESCE(duration, 0) AS duration
+FROM ansible_api.playbook_run
+WHERE status IN ('completed', 'failed', 'timed_out', 'cancelled');
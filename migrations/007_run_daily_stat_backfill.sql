@@ -0,0 +1,12 @@
+-- Итоги по суткам для уже накопленной истории; дальше таблицу run_daily_stat
+-- пересчитывает ночная задача (stats.go)
+INSERT INTO ansible_api.run_daily_stat (day, playbook, runs, completed, failed, aborted, total_duration, min_duration, max_duration, updated_at)
+SELECT (start_time AT TIME ZONE 'UTC')::date, playbook, COUNT(*),
+    COUNT(*) FILTER (WHERE status = 'completed'),
+    COUNT(*) FILTER (WHERE status = 'failed'),
+    COUNT(*) FILTER (WHERE status NOT IN ('completed', 'failed')),
+    COALESCE(SUM(duration), 0), MIN(duration), MAX(duration), now()
+FROM ansible_api.playbook_run
+WHERE end_time IS NOT NULL AND deleted_at IS NULL
+GROUP BY 1, 2
+ON CONFLICT (day, playbook) DO NOTHING;
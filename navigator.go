@@ -0,0 +1,85 @@
+package ansibleapi
+
+import (
+	"fmt"
+
+	"ansible-api/config"
+)
+
+var (
+	containerEngines = map[string]bool{"auto": true, "podman": true, "docker": true}
+	pullPolicies     = map[string]bool{"always": true, "missing": true, "never": true, "tag": true}
+)
+
+// Переменные окружения процесса, которые передаются в контейнер execution
+// environment: ими сервер настраивает вывод ansible
+var navigatorPassEnv = []string{"ANSIBLE_SHOW_CUSTOM_STATS", "ANSIBLE_STDOUT_CALLBACK"}
+
+// useExecutionEnvironment настраивает установку на образ execution environment
+func (i *ansibleInstallation) useExecutionEnvironment(c config.AnsibleInstallation) error {
+	if c.ExecutionEnvironment == "" {
+		if c.ContainerEngine != "" || c.PullPolicy != "" {
+			return fmt.Errorf("container_engine and pull_policy require execution_environment")
+		}
+		return nil
+	}
+	i.Image, i.ContainerEngine, i.PullPolicy = c.ExecutionEnvironment, c.ContainerEngine, c.PullPolicy
+	if i.ContainerEngine == "" {
+		i.ContainerEngine = "auto"
+	}
+	if i.PullPolicy == "" {
+		i.PullPolicy = "missing"
+	}
+	if !containerEngines[i.ContainerEngine] {
+		return fmt.Errorf("unknown container_engine %q: expected auto, podman or docker", i.ContainerEngine)
+	}
+	if !pullPolicies[i.PullPolicy] {
+		return fmt.Errorf("unknown pull_policy %q: expected always, missing, never or tag", i.PullPolicy)
+	}
+	return nil
+}
+
+// executable - инструмент, который запускает сервер: ansible-navigator для
+// execution environment, иначе ansible-playbook
+func (i *ansibleInstallation) executable() string {
+	if i != nil && i.Image != "" {
+		return "ansible-navigator"
+	}
+	return "ansible-playbook"
+}
+
+// navigatorArgs - команда ansible-navigator subcommand в образе установки с
+// выводом в stdout, как у ansible-playbook, и без файлов артефактов navigator
+func (i *ansibleInstallation) navigatorArgs(subcommand string) []string {
+	args := []string{
+		i.binary("ansible-navigator"), subcommand,
+		"--mode", "stdout",
+		"--execution-environment-image", i.Image,
+		"--pull-policy", i.PullPolicy,
+		"--container-engine", i.ContainerEngine,
+	}
+	if subcommand == "run" {
+		args = append(args, "--playbook-artifact-enable", "false")
+	}
+	for _, name := range navigatorPassEnv {
+		args = append(args, "--pass-environment-variable", name)
+	}
+	return args
+}
+
+// command переводит команду ansible-playbook в ansible-navigator run для установок
+// с execution environment. Каталог playbook и инвентарь (-i) navigator монтирует
+// в контейнер сам, файл пароля vault монтируется явно; остальные аргументы
+// передаются ansible-playbook без изменений.
+func (i *ansibleInstallation) command(args []string) []string {
+	if i == nil || i.Image == "" || len(args) < 2 || args[0] != i.binary("ansible-playbook") {
+		return args
+	}
+	result := append(i.navigatorArgs("run"), args[1])
+	for n := 2; n < len(args)-1; n++ {
+		if args[n] == "--vault-password-file" {
+			result = append(result, "--execution-environment-volume-mounts", args[n+1]+":"+args[n+1])
+		}
+	}
+	return append(result, args[2:]...)
+}
@@ -0,0 +1,73 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notification - событие, отправляемое во внешний webhook
+type Notification struct {
+	Event    string            `json:"event"`
+	Tenant   string            `json:"tenant,omitempty"`
+	RunID    uint              `json:"run_id,omitempty"`
+	Playbook string            `json:"playbook,omitempty"`
+	Status   PlaybookRunStatus `json:"status,omitempty"`
+	Message  string            `json:"message"`
+	Ticket   *TicketRef        `json:"ticket,omitempty"`
+	NodeID   string            `json:"node_id"`
+	Time     time.Time         `json:"time"`
+}
+
+func runNotification(t *tenant, event string, run PlaybookRun, message string) Notification {
+	return Notification{
+		Event:    event,
+		Tenant:   t.Name,
+		RunID:    run.ID,
+		Playbook: run.Playbook,
+		Status:   run.Status,
+		Ticket:   run.Ticket,
+		Message:  message,
+	}
+}
+
+// notify отправляет уведомление в фоне, ошибки доставки только логируются
+func notify(n Notification) {
+	n.NodeID = cfg.Server.NodeID
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	log.Printf("Notification %s: %s", n.Event, n.Message)
+
+	if cfg.Notifications.WebhookURL == "" {
+		return
+	}
+
+	go func() {
+		if err := postNotification(n); err != nil {
+			log.Printf("Failed to deliver notification %s: %v", n.Event, err)
+		}
+	}()
+}
+
+func postNotification(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: cfg.Notifications.Timeout}
+	resp, err := client.Post(cfg.Notifications.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errPolicyUnavailable = errors.New("policy service unavailable")
+
+type opaCacheEntry struct {
+	denial  *policyDenial
+	expires time.Time
+}
+
+// Решения OPA по ключу входных данных без времени запроса
+var opaCache = struct {
+	sync.Mutex
+	entries map[string]opaCacheEntry
+}{entries: make(map[string]opaCacheEntry)}
+
+// Сколько решений хранить, прежде чем вычищать устаревшие
+const opaCacheSize = 1024
+
+// opaDecision спрашивает OPA о допуске. Значения sensitive_vars в OPA не передаются.
+func opaDecision(in policyInput) (*policyDenial, error) {
+	if cfg.Policy.OPA.URL == "" {
+		return nil, nil
+	}
+
+	input := in
+	if len(in.Sensitive) > 0 {
+		input.ExtraVars = make(map[string]string, len(in.ExtraVars))
+		for k, v := range in.ExtraVars {
+			input.ExtraVars[k] = v
+		}
+		for _, key := range in.Sensitive {
+			if _, ok := input.ExtraVars[key]; ok {
+				input.ExtraVars[key] = redactedValue
+			}
+		}
+	}
+
+	key, err := opaCacheKey(input)
+	if err != nil {
+		return nil, err
+	}
+	if denial, ok := cachedOPADecision(key); ok {
+		return denial, nil
+	}
+
+	denial, err := queryOPA(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPolicyUnavailable, err)
+	}
+	storeOPADecision(key, denial)
+	return denial, nil
+}
+
+func opaCacheKey(in policyInput) (string, error) {
+	in.Time = time.Time{}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func cachedOPADecision(key string) (*policyDenial, bool) {
+	opaCache.Lock()
+	defer opaCache.Unlock()
+	entry, ok := opaCache.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.denial, true
+}
+
+func storeOPADecision(key string, denial *policyDenial) {
+	if cfg.Policy.OPA.CacheTTL <= 0 {
+		return
+	}
+	now := time.Now()
+	opaCache.Lock()
+	defer opaCache.Unlock()
+	if len(opaCache.entries) >= opaCacheSize {
+		for k, entry := range opaCache.entries {
+			if now.After(entry.expires) {
+				delete(opaCache.entries, k)
+			}
+		}
+	}
+	if len(opaCache.entries) < opaCacheSize {
+		opaCache.entries[key] = opaCacheEntry{denial: denial, expires: now.Add(cfg.Policy.OPA.CacheTTL)}
+	}
+}
+
+func queryOPA(input policyInput) (*policyDenial, error) {
+	payload, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.Policy.OPA.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Policy.OPA.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Policy.OPA.Token)
+	}
+
+	client := &http.Client{Timeout: cfg.Policy.OPA.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("opa responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var body struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return parseOPAResult(body.Result)
+}
+
+// parseOPAResult понимает result в виде bool, {"allow": ..., "message": ...}
+// или {"deny": [...]}. Отсутствующий result (политика не определена) - запрет.
+func parseOPAResult(result json.RawMessage) (*policyDenial, error) {
+	const rule = "opa"
+	if len(result) == 0 {
+		return &policyDenial{Rule: rule, Message: "no policy decision"}, nil
+	}
+
+	var allow bool
+	if err := json.Unmarshal(result, &allow); err == nil {
+		if allow {
+			return nil, nil
+		}
+		return &policyDenial{Rule: rule, Message: "denied by policy"}, nil
+	}
+
+	var decision struct {
+		Allow   *bool    `json:"allow"`
+		Message string   `json:"message"`
+		Reason  string   `json:"reason"`
+		Deny    []string `json:"deny"`
+	}
+	if err := json.Unmarshal(result, &decision); err != nil {
+		return nil, fmt.Errorf("unexpected opa result: %s", result)
+	}
+	message := decision.Message
+	if message == "" {
+		message = decision.Reason
+	}
+	if len(decision.Deny) > 0 {
+		if message == "" {
+			message = strings.Join(decision.Deny, "; ")
+		}
+		return &policyDenial{Rule: rule, Message: message}, nil
+	}
+	if decision.Allow == nil {
+		if decision.Deny != nil {
+			// Только пустой список deny - разрешено
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected opa result: %s", result)
+	}
+	if *decision.Allow {
+		return nil, nil
+	}
+	if message == "" {
+		message = "denied by policy"
+	}
+	return &policyDenial{Rule: rule, Message: message}, nil
+}
@@ -0,0 +1,250 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunOutputChunk - строка вывода ansible, сохраненная по ходу выполнения
+type RunOutputChunk struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	RunID     uint      `gorm:"not null;index:idx_run_output_chunk_run_seq,priority:1" json:"run_id"`
+	Seq       int       `gorm:"not null;index:idx_run_output_chunk_run_seq,priority:2" json:"seq"`
+	Line      string    `gorm:"type:text" json:"line"`
+	CreatedAt time.Time `gorm:"type:timestamptz" json:"created_at"`
+}
+
+type RunEventType string
+
+const (
+	EventPlayStart       RunEventType = "play_start"
+	EventTaskStart       RunEventType = "task_start"
+	EventHostOK          RunEventType = "host_ok"
+	EventHostChanged     RunEventType = "host_changed"
+	EventHostSkipped     RunEventType = "host_skipped"
+	EventHostFailed      RunEventType = "host_failed"
+	EventHostUnreachable RunEventType = "host_unreachable"
+	EventHostRecap       RunEventType = "host_recap"
+)
+
+// RunEvent - структурированное событие выполнения, разобранное из вывода ansible
+type RunEvent struct {
+	ID        uint         `gorm:"primaryKey" json:"id"`
+	RunID     uint         `gorm:"not null;index:idx_run_event_run_seq,priority:1" json:"run_id"`
+	Seq       int          `gorm:"not null;index:idx_run_event_run_seq,priority:2" json:"seq"`
+	Type      RunEventType `gorm:"type:text;not null" json:"type"`
+	Play      string       `gorm:"type:text" json:"play,omitempty"`
+	Task      string       `gorm:"type:text" json:"task,omitempty"`
+	Host      string       `gorm:"type:text" json:"host,omitempty"`
+	Message   string       `gorm:"type:text" json:"message,omitempty"`
+	CreatedAt time.Time    `gorm:"type:timestamptz" json:"created_at"`
+}
+
+var (
+	playHeaderRe = regexp.MustCompile(`^PLAY \[(.*)\] \**$`)
+	taskHeaderRe = regexp.MustCompile(`^TASK \[(.*)\] \**$`)
+	hostResultRe = regexp.MustCompile(`^(ok|changed|skipping|failed|fatal): \[([^\]]+)\]:?\s*(.*)$`)
+	recapLineRe  = regexp.MustCompile(`^(\S+)\s+:\s+(ok=.*)$`)
+)
+
+// eventParser превращает строки вывода стандартного callback ansible в события
+type eventParser struct {
+	play    string
+	task    string
+	inRecap bool
+}
+
+func (p *eventParser) parse(line string) *RunEvent {
+	line = strings.TrimRight(line, "\r")
+
+	if m := playHeaderRe.FindStringSubmatch(line); m != nil {
+		p.play, p.task, p.inRecap = m[1], "", false
+		return &RunEvent{Type: EventPlayStart, Play: p.play}
+	}
+	if strings.HasPrefix(line, "PLAY RECAP") {
+		p.task, p.inRecap = "", true
+		return nil
+	}
+	if m := taskHeaderRe.FindStringSubmatch(line); m != nil {
+		p.task = m[1]
+		return &RunEvent{Type: EventTaskStart, Play: p.play, Task: p.task}
+	}
+	if p.inRecap {
+		if m := recapLineRe.FindStringSubmatch(line); m != nil {
+			return &RunEvent{Type: EventHostRecap, Play: p.play, Host: m[1], Message: strings.Join(strings.Fields(m[2]), " ")}
+		}
+		return nil
+	}
+	if m := hostResultRe.FindStringSubmatch(line); m != nil {
+		event := &RunEvent{Play: p.play, Task: p.task, Host: m[2], Message: m[3]}
+		switch m[1] {
+		case "ok":
+			event.Type = EventHostOK
+		case "changed":
+			event.Type = EventHostChanged
+		case "skipping":
+			event.Type = EventHostSkipped
+		default:
+			event.Type = EventHostFailed
+			if strings.HasPrefix(m[3], "UNREACHABLE!") {
+				event.Type = EventHostUnreachable
+			}
+		}
+		return event
+	}
+	return nil
+}
+
+// runRecorder принимает вывод ansible, режет его на строки и события и пишет
+// их в БД пачками. Пока БД недоступна, строки копятся в памяти, но не больше
+// cfg.Logging.OutputBufferLines: самые старые из непереданных отбрасываются.
+type runRecorder struct {
+	tenant *tenant
+	runID  uint
+	masker *secretMasker
+
+	mu      sync.Mutex
+	partial []byte
+	seq     int
+	parser  eventParser
+	chunks  []RunOutputChunk
+	events  []RunEvent
+	dropped int
+
+	progress runProgress
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newRunRecorder запускает периодический сброс буфера, чтобы вывод
+// незавершенного запуска был виден через API по ходу выполнения
+func newRunRecorder(t *tenant, runID uint, masker *secretMasker) *runRecorder {
+	rec := &runRecorder{
+		tenant: t,
+		runID:  runID,
+		masker: masker,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go rec.flushPeriodically()
+	return rec
+}
+
+func (rec *runRecorder) flushPeriodically() {
+	defer close(rec.done)
+	ticker := time.NewTicker(cfg.Logging.OutputFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rec.stop:
+			return
+		case <-ticker.C:
+			rec.Flush()
+		}
+	}
+}
+
+// Write никогда не возвращает ошибку, чтобы сбой записи в БД не ломал сам запуск
+func (rec *runRecorder) Write(p []byte) (int, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.partial = append(rec.partial, p...)
+	for {
+		i := bytes.IndexByte(rec.partial, '\n')
+		if i < 0 {
+			break
+		}
+		rec.addLine(string(rec.partial[:i]))
+		rec.partial = rec.partial[i+1:]
+	}
+
+	if len(rec.chunks) >= cfg.Logging.OutputBatchSize {
+		rec.flushLocked()
+	}
+	return len(p), nil
+}
+
+func (rec *runRecorder) addLine(line string) {
+	line = rec.masker.Mask(line)
+	now := time.Now()
+	rec.seq++
+	rec.chunks = append(rec.chunks, RunOutputChunk{RunID: rec.runID, Seq: rec.seq, Line: line, CreatedAt: now})
+	if event := rec.parser.parse(line); event != nil {
+		event.RunID = rec.runID
+		event.Seq = rec.seq
+		event.CreatedAt = now
+		rec.events = append(rec.events, *event)
+		rec.progress.observe(event)
+	}
+}
+
+// Flush сбрасывает накопленные строки и события в БД
+func (rec *runRecorder) Flush() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.flushLocked()
+}
+
+// Close дописывает незавершенную последнюю строку и сбрасывает буфер
+func (rec *runRecorder) Close() {
+	close(rec.stop)
+	<-rec.done
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.partial) > 0 {
+		rec.addLine(string(rec.partial))
+		rec.partial = nil
+	}
+	rec.flushLocked()
+	if rec.dropped > 0 {
+		log.Printf("Dropped %d output lines of run %d while the database was unavailable", rec.dropped, rec.runID)
+	}
+}
+
+func (rec *runRecorder) flushLocked() {
+	rec.progress.save(rec.tenant, rec.runID)
+
+	if len(rec.chunks) > 0 {
+		if err := rec.tenant.db().CreateInBatches(rec.chunks, cfg.Logging.OutputBatchSize).Error; err != nil {
+			log.Printf("Failed to store output of run %d: %v", rec.runID, err)
+		} else {
+			rec.chunks = rec.chunks[:0]
+		}
+	}
+	if len(rec.events) > 0 {
+		if err := rec.tenant.db().CreateInBatches(rec.events, cfg.Logging.OutputBatchSize).Error; err != nil {
+			log.Printf("Failed to store events of run %d: %v", rec.runID, err)
+		} else {
+			rec.events = rec.events[:0]
+		}
+	}
+
+	// Ограничиваем память: при недоступной БД теряем самые старые строки
+	if over := len(rec.chunks) - cfg.Logging.OutputBufferLines; over > 0 {
+		rec.chunks = append(rec.chunks[:0], rec.chunks[over:]...)
+		rec.dropped += over
+	}
+	if over := len(rec.events) - cfg.Logging.OutputBufferLines; over > 0 {
+		rec.events = append(rec.events[:0], rec.events[over:]...)
+	}
+}
+
+// runOutputSoFar собирает уже сохраненный вывод запуска, который еще не завершился
+func runOutputSoFar(t *tenant, runID uint) (string, error) {
+	var lines []string
+	err := t.db().Model(&RunOutputChunk{}).
+		Where("run_id = ?", runID).
+		Order("seq ASC").
+		Pluck("line", &lines).Error
+	if err != nil || len(lines) == 0 {
+		return "", err
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
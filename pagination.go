@@ -0,0 +1,159 @@
+package ansibleapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pagination - параметры страницы из запроса (page, per_page)
+type pagination struct {
+	Page       int
+	PerPage    int
+	TotalCount int
+	TotalPages int
+}
+
+// parsePagination читает page и per_page; per_page ограничен cfg.Logging.MaxPageSize
+func parsePagination(r *http.Request) *pagination {
+	queryParams := r.URL.Query()
+
+	page, _ := strconv.Atoi(queryParams.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	perPage, _ := strconv.Atoi(queryParams.Get("per_page"))
+	if perPage < 1 {
+		perPage = cfg.Logging.PageSize
+	}
+	if cfg.Logging.MaxPageSize > 0 && perPage > cfg.Logging.MaxPageSize {
+		perPage = cfg.Logging.MaxPageSize
+	}
+
+	return &pagination{Page: page, PerPage: perPage}
+}
+
+// setTotal запоминает общее количество записей и возвращает смещение для запроса
+func (p *pagination) setTotal(totalCount int64) int {
+	p.TotalCount = int(totalCount)
+	p.TotalPages = (p.TotalCount + p.PerPage - 1) / p.PerPage
+	if p.Page > p.TotalPages && p.TotalPages > 0 {
+		p.Page = p.TotalPages
+	}
+	return (p.Page - 1) * p.PerPage
+}
+
+// writeHeaders добавляет X-Total-Count и Link (RFC 8288) с first/prev/next/last
+func (p *pagination) writeHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(p.TotalCount))
+	w.Header().Set("X-Page", strconv.Itoa(p.Page))
+	w.Header().Set("X-Per-Page", strconv.Itoa(p.PerPage))
+	w.Header().Set("X-Total-Pages", strconv.Itoa(p.TotalPages))
+
+	if p.TotalPages == 0 {
+		return
+	}
+
+	var links []string
+	addLink := func(rel string, page int) {
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, p.pageURL(r.URL, page), rel))
+	}
+	addLink("first", 1)
+	if p.Page > 1 {
+		addLink("prev", p.Page-1)
+	}
+	if p.Page < p.TotalPages {
+		addLink("next", p.Page+1)
+	}
+	addLink("last", p.TotalPages)
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+func (p *pagination) pageURL(base *url.URL, page int) string {
+	u := *base
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(p.PerPage))
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// keysetItem - запись, по которой можно построить курсор (start_time + id)
+type keysetItem interface {
+	cursorKey() (time.Time, uint)
+}
+
+func (l PlaybookLog) cursorKey() (time.Time, uint) { return l.StartTime, l.ID }
+func (r PlaybookRun) cursorKey() (time.Time, uint) { return r.StartTime, r.ID }
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(startTime time.Time, id uint) string {
+	raw := fmt.Sprintf("%d:%d", startTime.UnixNano(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(token string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	ts, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	return time.Unix(0, ts), uint(parsedID), nil
+}
+
+// applyCursor ограничивает запрос записями после курсора. Пустой курсор - первая страница.
+// Выбирается на одну запись больше, чтобы понять, есть ли следующая страница.
+func applyCursor(query *gorm.DB, token string, perPage int) (*gorm.DB, error) {
+	if token != "" {
+		startTime, id, err := decodeCursor(token)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(start_time, id) < (?, ?)", startTime, id)
+	}
+	return query.Order("start_time DESC, id DESC").Limit(perPage + 1), nil
+}
+
+// trimPage отрезает лишнюю запись и возвращает курсор следующей страницы
+func trimPage[T keysetItem](items []T, perPage int) ([]T, string) {
+	if len(items) <= perPage {
+		return items, ""
+	}
+	items = items[:perPage]
+	startTime, id := items[len(items)-1].cursorKey()
+	return items, encodeCursor(startTime, id)
+}
+
+func writeCursorHeaders(w http.ResponseWriter, r *http.Request, perPage int, nextCursor string) {
+	w.Header().Set("X-Per-Page", strconv.Itoa(perPage))
+	if nextCursor == "" {
+		return
+	}
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", nextCursor)
+	query.Set("per_page", strconv.Itoa(perPage))
+	u.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
@@ -0,0 +1,69 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Таблицы, партиционированные по месяцам (migrations/003_partition_runs_logs.sql);
+// playbook_log с migrations/006_unify_runs_logs.sql - представление над playbook_run
+var partitionedTables = []string{"playbook_run"}
+
+// Сколько месяцев вперед создавать партиции заранее
+const partitionsAhead = 2
+
+// ensurePartitions создает партиции текущего и следующих месяцев во всех схемах арендаторов
+func ensurePartitions() {
+	now := time.Now()
+	forEachTenant(func(t *tenant) {
+		for _, table := range partitionedTables {
+			for i := 0; i <= partitionsAhead; i++ {
+				month := now.AddDate(0, i, 0).Format("2006-01-02")
+				query := fmt.Sprintf("SELECT %s(?, ?::date)", t.table("ensure_monthly_partition"))
+				if err := primaryDB().Exec(query, table, month).Error; err != nil {
+					log.Printf("Failed to create partition of %s for %s: %v", t.table(table), month, err)
+				}
+			}
+		}
+	})
+}
+
+// dropExpiredPartitions удаляет помесячные партиции, целиком лежащие раньше cutoff
+func dropExpiredPartitions(t *tenant, table string, cutoff time.Time) (int, error) {
+	var partitions []string
+	err := primaryDB().Raw(`
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		JOIN pg_namespace n ON n.oid = p.relnamespace
+		WHERE n.nspname = ? AND p.relname = ?`, t.Schema, table).Scan(&partitions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	prefix := table + "_p"
+	for _, name := range partitions {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		month, err := time.Parse("200601", strings.TrimPrefix(name, prefix))
+		if err != nil {
+			continue
+		}
+		if month.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		if err := primaryDB().Exec(fmt.Sprintf(`DROP TABLE %s.%q`, t.Schema, name)).Error; err != nil {
+			return dropped, fmt.Errorf("failed to drop partition %s: %v", name, err)
+		}
+		log.Printf("Dropped expired partition %s", t.table(name))
+		dropped++
+	}
+
+	return dropped, nil
+}
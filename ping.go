@@ -0,0 +1,223 @@
+package ansibleapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Наибольшее число хостов в одном запросе POST /api/ping и сколько проверяется одновременно:
+	// при наибольшем таймауте ответ укладывается в server.handler_timeout по умолчанию
+	maxPingHosts    = 100
+	pingConcurrency = 50
+	// Таймаут проверки одного хоста по умолчанию и наибольший
+	defaultPingTimeout = 5 * time.Second
+	maxPingTimeout     = 10 * time.Second
+	defaultSSHPort     = 22
+)
+
+// PingRequest - тело POST /api/ping
+type PingRequest struct {
+	Hosts []PingTarget `json:"hosts"`
+	// Таймаут проверки одного хоста в секундах
+	Timeout float64 `json:"timeout,omitempty"`
+}
+
+// PingTarget - хост проверки. С user и private_key дополнительно проверяется
+// вход по SSH этим ключом; ключи самого сервера API не предлагаются.
+type PingTarget struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port,omitempty"`
+	User       string `json:"user,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// PingResult - доступность хоста: TCP-соединение, баннер SSH и, если задан user, вход
+type PingResult struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Reachable bool   `json:"reachable"`
+	// Время установки TCP-соединения
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Banner    string  `json:"banner,omitempty"`
+	// Вход по SSH удался; nil - не проверялся
+	Auth  *bool  `json:"auth,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (req *PingRequest) validate() error {
+	if len(req.Hosts) == 0 || len(req.Hosts) > maxPingHosts {
+		return fmt.Errorf("hosts must contain from 1 to %d hosts", maxPingHosts)
+	}
+	for i := range req.Hosts {
+		target := &req.Hosts[i]
+		if target.Host == "" || strings.ContainsAny(target.Host, " \t\n/@") || strings.HasPrefix(target.Host, "-") {
+			return fmt.Errorf("invalid host %q", target.Host)
+		}
+		if target.Port == 0 {
+			target.Port = defaultSSHPort
+		}
+		if target.Port < 0 || target.Port > 65535 {
+			return fmt.Errorf("invalid port %d of host %s", target.Port, target.Host)
+		}
+		if strings.ContainsAny(target.User, " \t\n@") || strings.HasPrefix(target.User, "-") {
+			return fmt.Errorf("invalid user %q", target.User)
+		}
+		if (target.PrivateKey != "") != (target.User != "") {
+			return fmt.Errorf("user and private_key of host %s must be set together", target.Host)
+		}
+	}
+	if req.Timeout < 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+func (req PingRequest) timeout() time.Duration {
+	if req.Timeout <= 0 {
+		return defaultPingTimeout
+	}
+	return min(time.Duration(req.Timeout*float64(time.Second)), maxPingTimeout)
+}
+
+// pingHandler проверяет доступность произвольного списка хостов без инвентаря:
+// ничего не сохраняется, учетные данные не записываются в журнал
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	var req PingRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeout := req.timeout()
+	results := make([]PingResult, len(req.Hosts))
+	sem := make(chan struct{}, pingConcurrency)
+	var wg sync.WaitGroup
+	for i, target := range req.Hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			results[i] = pingHost(ctx, target)
+		}()
+	}
+	wg.Wait()
+
+	reachable := 0
+	for _, result := range results {
+		if result.Reachable {
+			reachable++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":     len(results),
+		"reachable": reachable,
+		"results":   results,
+	})
+}
+
+// pingHost соединяется с портом хоста, читает баннер SSH и, если задан user,
+// проверяет вход командой ssh в режиме BatchMode
+func pingHost(ctx context.Context, target PingTarget) PingResult {
+	result := PingResult{Host: target.Host, Port: target.Port}
+	address := net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Reachable = true
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+	// Сервер SSH первым отправляет строку идентификации "SSH-2.0-..."
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	line, err := bufio.NewReaderSize(conn, 256).ReadString('\n')
+	conn.Close()
+	if strings.HasPrefix(line, "SSH-") {
+		result.Banner = strings.TrimSpace(line)
+	} else if err != nil {
+		result.Error = fmt.Sprintf("no SSH banner: %v", err)
+	} else {
+		result.Error = "no SSH banner"
+	}
+
+	if target.User == "" {
+		return result
+	}
+	ok, err := checkSSHLogin(ctx, target)
+	result.Auth = &ok
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkSSHLogin выполняет true на хосте с ключом target.PrivateKey. Ключ хоста
+// не проверяется и не запоминается: это разовая диагностика, а не соединение
+// для запусков. Конфигурация ssh, ssh-agent и ключи сервера не используются,
+// чтобы не предлагать их произвольным хостам.
+func checkSSHLogin(ctx context.Context, target PingTarget) (bool, error) {
+	if target.PrivateKey == "" {
+		return false, fmt.Errorf("private_key is required for ssh login check")
+	}
+	keyFile, err := os.CreateTemp(cfg.Disk.TempDir, "ping-key-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(keyFile.Name())
+	key := strings.TrimSpace(target.PrivateKey) + "\n"
+	_, err = keyFile.WriteString(key)
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	args := []string{
+		"-F", "/dev/null",
+		"-i", keyFile.Name(),
+		"-o", "IdentitiesOnly=yes",
+		"-o", "IdentityAgent=none",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "LogLevel=ERROR",
+		"-p", strconv.Itoa(target.Port),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", max(int(time.Until(deadline).Seconds()), 1)))
+	}
+	args = append(args, target.User+"@"+target.Host, "true")
+
+	output, err := exec.CommandContext(ctx, "ssh", args...).CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return false, fmt.Errorf("ssh login failed: %s", message)
+		}
+		return false, fmt.Errorf("ssh login failed: %v", err)
+	}
+	return true, nil
+}
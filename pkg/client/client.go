@@ -0,0 +1,201 @@
+// Package client - клиент HTTP API ansible-api для программ на Go.
+//
+//	c, err := client.New("http://ansible-api:8080", client.WithToken(token))
+//	runID, err := c.RunPlaybook(ctx, client.RunRequest{Playbook: "deploy.yml", Inventory: "production"})
+//	run, err := c.WatchRun(ctx, runID, func(r client.Run) { log.Println(r.Status, r.Progress) })
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client - клиент API. Безопасен для использования из нескольких горутин.
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	token        string
+	adminToken   string
+	tenant       string
+	pollInterval time.Duration
+	// Ключ подписи запросов (signing_secret токена)
+	signingSecret string
+}
+
+// Option настраивает клиента
+type Option func(*Client)
+
+// WithToken - токен клиента API (auth.tokens), передается как Authorization: Bearer
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithAdminToken - server.admin_token для административных операций
+func WithAdminToken(token string) Option {
+	return func(c *Client) { c.adminToken = token }
+}
+
+// WithSigningSecret подписывает запросы ключом signing_secret токена:
+// сервер принимает запуски по такому токену только с подписью
+func WithSigningSecret(secret string) Option {
+	return func(c *Client) { c.signingSecret = secret }
+}
+
+// WithTenant выбирает арендатора; сервер учитывает его только вместе с admin token
+func WithTenant(tenant string) Option {
+	return func(c *Client) { c.tenant = tenant }
+}
+
+// WithHTTPClient заменяет http.Client (таймауты, TLS, прокси)
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithPollInterval - как часто WatchRun опрашивает запуск, по умолчанию 2s
+func WithPollInterval(d time.Duration) Option {
+	return func(c *Client) { c.pollInterval = d }
+}
+
+// New создает клиента для сервера по адресу baseURL (например http://localhost:8080)
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %q: expected http(s)", baseURL)
+	}
+
+	c := &Client{
+		baseURL:      u,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		pollInterval: 2 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Login входит пользователем (POST /api/auth/login) и возвращает JWT и срок его
+// действия; токен передается новому клиенту через WithToken
+func (c *Client) Login(ctx context.Context, username, password string) (string, time.Time, error) {
+	var resp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	body := map[string]string{"username": username, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/api/auth/login", nil, body, &resp); err != nil {
+		return "", time.Time{}, err
+	}
+	return resp.Token, resp.ExpiresAt, nil
+}
+
+// APIError - ответ сервера с кодом ошибки
+type APIError struct {
+	StatusCode int
+	Message    string
+	// Тело ответа в JSON, если сервер вернул подробности (например references при 409)
+	Body json.RawMessage
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ansible-api: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound сообщает, что сервер ответил 404
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+func (c *Client) endpoint(path string, query url.Values) string {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (c *Client) setHeaders(h http.Header) {
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.adminToken != "" {
+		h.Set("X-Admin-Token", c.adminToken)
+	}
+	if c.tenant != "" {
+		h.Set("X-Tenant", c.tenant)
+	}
+}
+
+// do выполняет запрос с телом body (JSON) и разбирает ответ в result, если он не nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	var reader io.Reader
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path, query), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.setHeaders(req.Header)
+	if c.signingSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(c.signingSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(payload)
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readAPIError(resp)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func readAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	if json.Valid(data) {
+		apiErr.Body = data
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &body) == nil && body.Error != "" {
+			apiErr.Message = body.Error
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial открывает WebSocket по пути API с заголовками клиента
+func (c *Client) dial(ctx context.Context, path string, query url.Values) (*websocket.Conn, error) {
+	u, err := url.Parse(c.endpoint(path, query))
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+
+	header := http.Header{}
+	c.setHeaders(header)
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 300 {
+			return nil, readAPIError(resp)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Events подписывается на WebSocket /api/events и вызывает handle для каждого
+// события, пока не будет отменен ctx или не оборвется соединение. Подписка
+// видит события только того узла, к которому подключена.
+func (c *Client) Events(ctx context.Context, filter EventFilter, handle func(Event)) error {
+	query := url.Values{}
+	setList := func(name string, values []string) {
+		if len(values) > 0 {
+			query.Set(name, strings.Join(values, ","))
+		}
+	}
+	setList("playbook", filter.Playbooks)
+	setList("status", filter.Statuses)
+	setList("inventory", filter.Inventories)
+
+	conn, err := c.dial(ctx, "/api/events", query)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// ReadJSON блокируется, поэтому отмена ctx закрывает соединение
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		var e Event
+		if err := conn.ReadJSON(&e); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		handle(e)
+	}
+}
+
+// StreamRun читает WebSocket /api/runs/{id}/stream и вызывает handle для
+// каждой строки вывода начиная после строки after (0 - с начала). Возвращает
+// итог запуска, когда он завершился.
+func (c *Client) StreamRun(ctx context.Context, id uint, after int, handle func(OutputLine)) (RunStreamEnd, error) {
+	query := url.Values{}
+	if after > 0 {
+		query.Set("after", strconv.Itoa(after))
+	}
+	conn, err := c.dial(ctx, "/api/runs/"+strconv.FormatUint(uint64(id), 10)+"/stream", query)
+	if err != nil {
+		return RunStreamEnd{}, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		var msg struct {
+			Type string `json:"type"`
+			OutputLine
+			RunStreamEnd
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() != nil {
+				return RunStreamEnd{}, ctx.Err()
+			}
+			return RunStreamEnd{}, err
+		}
+		if msg.Type == "end" {
+			return msg.RunStreamEnd, nil
+		}
+		handle(msg.OutputLine)
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// inventoryBody - поля инвентаря, которые принимают POST и PUT
+type inventoryBody struct {
+	Name    string          `json:"name,omitempty"`
+	Content string          `json:"content"`
+	Owner   string          `json:"owner,omitempty"`
+	Team    string          `json:"team,omitempty"`
+	Access  InventoryAccess `json:"access,omitempty"`
+}
+
+// GetInventory возвращает инвентарь по имени
+func (c *Client) GetInventory(ctx context.Context, name string) (Inventory, error) {
+	var inv Inventory
+	err := c.do(ctx, http.MethodGet, "/api/inventories/"+url.PathEscape(name), nil, nil, &inv)
+	return inv, err
+}
+
+// ListInventories возвращает страницу (с 1) доступных инвентарей, упорядоченных по имени
+func (c *Client) ListInventories(ctx context.Context, page, perPage int) (InventoryPage, error) {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if perPage > 0 {
+		query.Set("per_page", strconv.Itoa(perPage))
+	}
+
+	var result InventoryPage
+	err := c.do(ctx, http.MethodGet, "/api/inventories", query, nil, &result)
+	return result, err
+}
+
+// UpsertInventory обновляет инвентарь с именем inv.Name или создает его, если
+// такого нет. Владелец и команда меняются только с admin token.
+func (c *Client) UpsertInventory(ctx context.Context, inv Inventory) (Inventory, error) {
+	body := inventoryBody{
+		Content: inv.Content,
+		Owner:   inv.Owner,
+		Team:    inv.Team,
+		Access:  inv.Access,
+	}
+
+	var result Inventory
+	err := c.do(ctx, http.MethodPut, "/api/inventories/"+url.PathEscape(inv.Name), nil, body, &result)
+	if !IsNotFound(err) {
+		return result, err
+	}
+
+	body.Name = inv.Name
+	err = c.do(ctx, http.MethodPost, "/api/inventories", nil, body, &result)
+	return result, err
+}
+
+// DeleteInventory переносит инвентарь в корзину. Если инвентарь используют
+// запуски, сервер отвечает 409 со списком ссылок в APIError.Body.
+func (c *Client) DeleteInventory(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/inventories/"+url.PathEscape(name), nil, nil, nil)
+}
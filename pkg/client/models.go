@@ -0,0 +1,276 @@
+package client
+
+import "time"
+
+// RunStatus - состояние запуска playbook
+type RunStatus string
+
+const (
+	RunStatusQueued    RunStatus = "queued"
+	RunStatusStarted   RunStatus = "started"
+	RunStatusCompleted RunStatus = "completed"
+	RunStatusFailed    RunStatus = "failed"
+	RunStatusTimedOut  RunStatus = "timed_out"
+	RunStatusCancelled RunStatus = "cancelled"
+	// Запуск прерван перезапуском сервера; restart_safe запуски перезапускаются новым запуском
+	RunStatusInterrupted RunStatus = "interrupted"
+	RunStatusLost        RunStatus = "lost"
+)
+
+// Finished сообщает, что запуск завершился и его статус больше не изменится
+func (s RunStatus) Finished() bool {
+	return s != RunStatusQueued && s != RunStatusStarted
+}
+
+// Ticket - заявка на изменение, в рамках которой выполняется запуск
+type Ticket struct {
+	System string `json:"system"`
+	ID     string `json:"id"`
+	URL    string `json:"url,omitempty"`
+}
+
+// RunRequest - тело POST /api/run
+type RunRequest struct {
+	Playbook    string            `json:"playbook"`
+	Inventory   string            `json:"inventory,omitempty"`
+	ExtraVars   map[string]string `json:"extra_vars,omitempty"`
+	RestartSafe bool              `json:"restart_safe,omitempty"`
+	// Ключи extra_vars, значения которых не сохраняются в истории запусков
+	SensitiveVars []string `json:"sensitive_vars,omitempty"`
+	Ticket        *Ticket  `json:"ticket,omitempty"`
+	// Установка ansible из ansible.installations сервера
+	Ansible string `json:"ansible,omitempty"`
+	// ansible_python_interpreter для всех хостов запуска
+	PythonInterpreter string `json:"python_interpreter,omitempty"`
+	// Выполнить на сервере API без инвентаря
+	Localhost bool `json:"localhost,omitempty"`
+	// Разовый список хостов вместо инвентаря и переменные для всех них
+	Hosts    []string          `json:"hosts,omitempty"`
+	HostVars map[string]string `json:"host_vars,omitempty"`
+	// Шаблон хостов для --limit
+	Limit string `json:"limit,omitempty"`
+	// Значения подстановок {{ .name }} в содержимом инвентаря
+	InventoryParams map[string]string `json:"inventory_params,omitempty"`
+	// Выполнить на хостах доступных инвентарей с этими метками вместо инвентаря
+	HostTags map[string]string `json:"host_tags,omitempty"`
+	// Флаги ansible-playbook из списка разрешенных на сервере, например --diff
+	ExtraArgs []string `json:"extra_args,omitempty"`
+	// Узел из ansible.runners сервера, на котором выполнить запуск
+	Runner string `json:"runner,omitempty"`
+	// Зарегистрированный агент, которому передать запуск
+	Agent string `json:"agent,omitempty"`
+	// Метки, которые должны быть у узла или агента, например {"zone": "dmz"}
+	RunnerSelector map[string]string `json:"runner_selector,omitempty"`
+	// Перед запуском требовать свежую успешную проверку инвентаря
+	Preflight *Preflight `json:"preflight,omitempty"`
+}
+
+// Preflight - требование проверки доступности хостов инвентаря перед запуском
+type Preflight struct {
+	// Наибольший возраст проверки в секундах, 0 - по умолчанию сервера
+	MaxAge int `json:"max_age,omitempty"`
+	// Сколько хостов может быть недоступно
+	MaxUnreachable int `json:"max_unreachable,omitempty"`
+}
+
+// Run - запуск playbook (GET /api/runs/{id})
+type Run struct {
+	ID          uint              `json:"ID"`
+	CreatedAt   time.Time         `json:"CreatedAt"`
+	UpdatedAt   time.Time         `json:"UpdatedAt"`
+	Playbook    string            `json:"playbook"`
+	Inventory   string            `json:"inventory,omitempty"`
+	Localhost   bool              `json:"localhost,omitempty"`
+	Hosts       []string          `json:"hosts,omitempty"`
+	HostVars    map[string]string `json:"host_vars,omitempty"`
+	Limit       string            `json:"limit,omitempty"`
+	ExtraArgs   []string          `json:"extra_args,omitempty"`
+	Status      RunStatus         `json:"status"`
+	StartTime   time.Time         `json:"start_time"`
+	EndTime     *time.Time        `json:"end_time,omitempty"`
+	Duration    *float64          `json:"duration,omitempty"`
+	TriggeredBy string            `json:"triggered_by,omitempty"`
+	PeerAddr    string            `json:"peer_addr,omitempty"`
+	ExtraVars   map[string]string `json:"extra_vars,omitempty"`
+	Ticket      *Ticket           `json:"ticket,omitempty"`
+	Output      string            `json:"output,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	NodeID      string            `json:"node_id,omitempty"`
+	RestartSafe bool              `json:"restart_safe"`
+	RelaunchOf  *uint             `json:"relaunch_of,omitempty"`
+	RetryOf     *uint             `json:"retry_of,omitempty"`
+	// Параметры, подставленные в инвентарь
+	InventoryParams map[string]string `json:"inventory_params,omitempty"`
+	// Метки, по которым выбраны хосты запуска (в Hosts)
+	HostTags map[string]string `json:"host_tags,omitempty"`
+	// Откат: откатываемый запуск; у упавшего - его откат или ожидание подтверждения
+	RollbackOf      *uint `json:"rollback_of,omitempty"`
+	RollbackRunID   *uint `json:"rollback_run_id,omitempty"`
+	RollbackPending bool  `json:"rollback_pending,omitempty"`
+	Attempt         int   `json:"attempt"`
+	BatchID         *uint `json:"batch_id,omitempty"`
+	RolloutID       *uint `json:"rollout_id,omitempty"`
+	// Номер части rollout, начиная с 1
+	RolloutBatch int `json:"rollout_batch,omitempty"`
+	// Запуск verify_playbook по хостам canary
+	RolloutVerify bool `json:"rollout_verify,omitempty"`
+
+	CancelRequested bool   `json:"cancel_requested"`
+	Teardown        string `json:"teardown,omitempty"`
+	OutputPurged    bool   `json:"output_purged,omitempty"`
+	Ansible         string `json:"ansible,omitempty"`
+	AnsibleVersion  string `json:"ansible_version,omitempty"`
+	Runner          string `json:"runner,omitempty"`
+	Agent           string `json:"agent,omitempty"`
+	Warning         string `json:"warning,omitempty"`
+
+	RunnerSelector map[string]string `json:"runner_selector,omitempty"`
+
+	TasksTotal     int     `json:"tasks_total"`
+	TasksCompleted int     `json:"tasks_completed"`
+	CurrentPlay    string  `json:"current_play,omitempty"`
+	CurrentTask    string  `json:"current_task,omitempty"`
+	Progress       float64 `json:"progress"`
+
+	EstimatedDuration     *float64   `json:"estimated_duration,omitempty"`
+	EstimatedCompletionAt *time.Time `json:"estimated_completion_at,omitempty"`
+
+	// Упавшие задачи по хостам; заполняется только в GetRun
+	FailedTasks []FailedTask `json:"failed_tasks,omitempty"`
+
+	// Число хостов с changed > 0 по PLAY RECAP; 0 - запуск ничего не изменил
+	ChangedHosts *int `json:"changed_hosts,omitempty"`
+
+	// Коллекции и роли playbook, не найденные сервером при постановке в очередь
+	MissingDependencies []string `json:"missing_dependencies,omitempty"`
+
+	// Хосты на карантине, исключенные из инвентаря запуска
+	QuarantinedHosts []string `json:"quarantined_hosts,omitempty"`
+
+	// Проверка инвентаря, по которой пропущен запуск с preflight
+	PreflightCheckID *uint `json:"preflight_check_id,omitempty"`
+
+	// Данные set_stats и api_result завершенного запуска
+	Results     map[string]interface{} `json:"results,omitempty"`
+	HostResults map[string]interface{} `json:"host_results,omitempty"`
+
+	// Описания хостов из упавших задач и результатов; заполняется только в GetRun
+	HostMetadata map[string]HostMetadata `json:"host_metadata,omitempty"`
+
+	// Команда поставившего запуск и израсходованные ресурсы
+	Team        string   `json:"team,omitempty"`
+	CPUSeconds  *float64 `json:"cpu_seconds,omitempty"`
+	MaxRSSKB    *int64   `json:"max_rss_kb,omitempty"`
+	OutputBytes int64    `json:"output_bytes"`
+}
+
+// HostMetadata - описание хоста (GET /api/hosts/{host})
+type HostMetadata struct {
+	Host        string            `json:"host"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Serial      string            `json:"serial,omitempty"`
+	Links       map[string]string `json:"links,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	UpdatedBy   string            `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// FailedTask - задача, упавшая на хосте
+type FailedTask struct {
+	Play string `json:"play,omitempty"`
+	Task string `json:"task"`
+	Host string `json:"host"`
+	// Имя хоста с владельцем из описания хоста
+	Label       string `json:"label,omitempty"`
+	Unreachable bool   `json:"unreachable,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// RunFilter - фильтры GET /api/runs, пустые поля не передаются
+type RunFilter struct {
+	Status      RunStatus
+	Playbook    string
+	TriggeredBy string
+	Ticket      string
+	From        time.Time
+	To          time.Time
+	// Пакет POST /api/runs/batch, 0 - любой
+	Batch uint
+	// true - запуски, изменившие хотя бы один хост, false - ни одного; nil - любые
+	Changed *bool
+	// Размер страницы, 0 - по умолчанию сервера
+	PerPage int
+}
+
+// RunPage - страница истории запусков при keyset-пагинации
+type RunPage struct {
+	Runs       []Run  `json:"runs"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PerPage    int    `json:"per_page"`
+}
+
+// InventoryAccess - кто может использовать и изменять инвентарь
+type InventoryAccess string
+
+const (
+	AccessPrivate InventoryAccess = "private"
+	AccessTeam    InventoryAccess = "team"
+	AccessShared  InventoryAccess = "shared"
+)
+
+// Inventory - инвентарь Ansible
+type Inventory struct {
+	ID        uint            `json:"ID,omitempty"`
+	CreatedAt time.Time       `json:"CreatedAt,omitempty"`
+	UpdatedAt time.Time       `json:"UpdatedAt,omitempty"`
+	Name      string          `json:"name"`
+	Content   string          `json:"content"`
+	Owner     string          `json:"owner,omitempty"`
+	Team      string          `json:"team,omitempty"`
+	Access    InventoryAccess `json:"access,omitempty"`
+}
+
+// InventoryPage - страница списка инвентарей
+type InventoryPage struct {
+	Inventories []Inventory `json:"inventories"`
+	TotalCount  int         `json:"total_count"`
+	CurrentPage int         `json:"current_page"`
+	TotalPages  int         `json:"total_pages"`
+	PerPage     int         `json:"per_page"`
+}
+
+// Event - событие из WebSocket /api/events
+type Event struct {
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant"`
+	RunID     uint      `json:"run_id,omitempty"`
+	CheckID   uint      `json:"check_id,omitempty"`
+	Playbook  string    `json:"playbook,omitempty"`
+	Inventory string    `json:"inventory,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	NodeID    string    `json:"node_id"`
+	Time      time.Time `json:"time"`
+}
+
+// OutputLine - строка вывода из WebSocket /api/runs/{id}/stream
+type OutputLine struct {
+	Seq  int    `json:"seq"`
+	Line string `json:"line"`
+}
+
+// RunStreamEnd - итог запуска, которым завершается поток вывода
+type RunStreamEnd struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// EventFilter - фильтры подписки на события, несколько значений в каждом
+type EventFilter struct {
+	Playbooks   []string
+	Statuses    []string
+	Inventories []string
+}
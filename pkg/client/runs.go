@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RunPlaybook ставит playbook в очередь и возвращает id запуска
+func (c *Client) RunPlaybook(ctx context.Context, req RunRequest) (uint, error) {
+	var resp struct {
+		RunID uint `json:"run_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/run", nil, req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.RunID, nil
+}
+
+// GetRun возвращает запуск; для выполняющегося запуска Output содержит уже полученный вывод
+func (c *Client) GetRun(ctx context.Context, id uint) (Run, error) {
+	var run Run
+	err := c.do(ctx, http.MethodGet, "/api/runs/"+strconv.FormatUint(uint64(id), 10), nil, nil, &run)
+	return run, err
+}
+
+// ListRuns возвращает страницу истории запусков, новые первыми. Пустой cursor -
+// первая страница, следующая - по RunPage.NextCursor.
+func (c *Client) ListRuns(ctx context.Context, filter RunFilter, cursor string) (RunPage, error) {
+	query := url.Values{"cursor": {cursor}}
+	setParam := func(name, value string) {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	setParam("status", string(filter.Status))
+	setParam("playbook", filter.Playbook)
+	setParam("triggered_by", filter.TriggeredBy)
+	setParam("ticket", filter.Ticket)
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	if filter.Batch > 0 {
+		query.Set("batch", strconv.FormatUint(uint64(filter.Batch), 10))
+	}
+	if filter.Changed != nil {
+		query.Set("changed", strconv.FormatBool(*filter.Changed))
+	}
+	if filter.PerPage > 0 {
+		query.Set("per_page", strconv.Itoa(filter.PerPage))
+	}
+
+	var page RunPage
+	err := c.do(ctx, http.MethodGet, "/api/runs", query, nil, &page)
+	return page, err
+}
+
+// CancelRun отменяет запуск в очереди или выполняющийся запуск
+func (c *Client) CancelRun(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodPost, "/api/runs/"+strconv.FormatUint(uint64(id), 10)+"/cancel", nil, nil, nil)
+}
+
+// WatchRun опрашивает запуск, пока он не завершится, и возвращает итоговое
+// состояние. onUpdate (может быть nil) вызывается при каждом изменении статуса
+// или прогресса. Временные ошибки опроса не прерывают ожидание, пока не
+// отменен ctx; 404 и ошибки доступа возвращаются сразу.
+func (c *Client) WatchRun(ctx context.Context, id uint, onUpdate func(Run)) (Run, error) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	var last Run
+	seen := false
+	for {
+		run, err := c.GetRun(ctx, id)
+		switch {
+		case err == nil:
+			if onUpdate != nil && (!seen || runChanged(last, run)) {
+				onUpdate(run)
+			}
+			last, seen = run, true
+			if run.Status.Finished() {
+				return run, nil
+			}
+		case ctx.Err() != nil:
+			return last, ctx.Err()
+		case !retryable(err):
+			return last, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func runChanged(a, b Run) bool {
+	return a.Status != b.Status || a.TasksCompleted != b.TasksCompleted ||
+		a.TasksTotal != b.TasksTotal || a.CurrentTask != b.CurrentTask
+}
+
+// retryable - сетевые ошибки и ответы 5xx (в том числе 503 при недоступной БД)
+func retryable(err error) bool {
+	var apiErr *APIError
+	return !errors.As(err, &apiErr) || apiErr.StatusCode >= 500
+}
@@ -0,0 +1,240 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"ansible-api/config"
+)
+
+const (
+	policyActionRun   = "run"
+	policyActionCheck = "check"
+)
+
+// policyInput - то, о чем принимается решение о допуске
+type policyInput struct {
+	Action    string            `json:"action"`
+	User      string            `json:"user"`
+	Team      string            `json:"team,omitempty"`
+	Tenant    string            `json:"tenant"`
+	Playbook  string            `json:"playbook,omitempty"`
+	Inventory string            `json:"inventory,omitempty"`
+	Module    string            `json:"module,omitempty"`
+	ExtraVars map[string]string `json:"extra_vars,omitempty"`
+	Time      time.Time         `json:"time"`
+	// Ключи extra_vars, значения которых не передаются во внешний OPA
+	Sensitive []string `json:"-"`
+}
+
+// policyDenial - нарушенное правило
+type policyDenial struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// policyRule - правило из policy.rules с разобранными выражениями и окном времени
+type policyRule struct {
+	config.PolicyRule
+	values  *regexp.Regexp
+	outside *timeWindow
+}
+
+type timeWindow struct {
+	days     map[time.Weekday]bool
+	from, to int // минуты от начала суток
+	location *time.Location
+}
+
+var policyRules []policyRule
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// loadPolicy разбирает policy.rules, ошибки в правилах не дают запустить сервер
+func loadPolicy() error {
+	policyRules = nil
+	for i, rule := range cfg.Policy.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("policy rule %d: name is required", i+1)
+		}
+		compiled := policyRule{PolicyRule: rule}
+		if len(compiled.Actions) == 0 {
+			compiled.Actions = []string{policyActionRun}
+		}
+		for _, action := range compiled.Actions {
+			if action != policyActionRun && action != policyActionCheck {
+				return fmt.Errorf("policy rule %s: unknown action %q", rule.Name, action)
+			}
+		}
+		if rule.ExtraVarValues != "" {
+			re, err := regexp.Compile(rule.ExtraVarValues)
+			if err != nil {
+				return fmt.Errorf("policy rule %s: %v", rule.Name, err)
+			}
+			compiled.values = re
+		}
+		if rule.OutsideHours != nil {
+			window, err := parseTimeWindow(*rule.OutsideHours)
+			if err != nil {
+				return fmt.Errorf("policy rule %s: %v", rule.Name, err)
+			}
+			compiled.outside = window
+		}
+		policyRules = append(policyRules, compiled)
+	}
+	return nil
+}
+
+func parseTimeWindow(c config.TimeWindow) (*timeWindow, error) {
+	w := &timeWindow{days: make(map[time.Weekday]bool), location: time.Local}
+	if c.Timezone != "" {
+		location, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		w.location = location
+	}
+	for _, day := range c.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", day)
+		}
+		w.days[weekday] = true
+	}
+
+	var err error
+	if w.from, err = parseClock(c.From, 0); err != nil {
+		return nil, err
+	}
+	if w.to, err = parseClock(c.To, 24*60); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parseClock переводит HH:MM в минуты от начала суток
+func parseClock(value string, empty int) (int, error) {
+	if value == "" {
+		return empty, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains сообщает, попадает ли момент в окно. Окно вида 22:00-06:00 переходит через полночь.
+func (w *timeWindow) contains(at time.Time) bool {
+	at = at.In(w.location)
+	if len(w.days) > 0 && !w.days[at.Weekday()] {
+		return false
+	}
+	minute := at.Hour()*60 + at.Minute()
+	if w.from <= w.to {
+		return minute >= w.from && minute < w.to
+	}
+	return minute >= w.from || minute < w.to
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matches сообщает, выполнены ли все заданные условия правила
+func (rule policyRule) matches(in policyInput) bool {
+	if !slices.Contains(rule.Actions, in.Action) {
+		return false
+	}
+	if len(rule.Playbooks) > 0 && !matchAny(rule.Playbooks, in.Playbook) {
+		return false
+	}
+	if len(rule.Inventories) > 0 && !matchAny(rule.Inventories, in.Inventory) {
+		return false
+	}
+	if len(rule.Modules) > 0 && !matchAny(rule.Modules, in.Module) {
+		return false
+	}
+	if len(rule.ExtraVars) > 0 || rule.values != nil {
+		found := false
+		for name, value := range in.ExtraVars {
+			if matchAny(rule.ExtraVars, name) || (rule.values != nil && rule.values.MatchString(value)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.outside != nil && rule.outside.contains(in.Time) {
+		return false
+	}
+	return true
+}
+
+// evaluatePolicy возвращает первое нарушенное локальное правило, а если их
+// нет - решение OPA. Ошибка означает, что OPA недоступен.
+func evaluatePolicy(in policyInput) (*policyDenial, error) {
+	for _, rule := range policyRules {
+		if rule.matches(in) {
+			message := rule.Message
+			if message == "" {
+				message = "denied by policy"
+			}
+			return &policyDenial{Rule: rule.Name, Message: message}, nil
+		}
+	}
+	return opaDecision(in)
+}
+
+func newPolicyInput(r *http.Request, action string) policyInput {
+	p := currentPrincipal(r)
+	return policyInput{
+		Action: action,
+		User:   p.Name,
+		Team:   p.Team,
+		Tenant: tenantOf(r).Name,
+		Time:   time.Now(),
+	}
+}
+
+// admit проверяет запрос по политике и при нарушении отвечает 403 с правилом
+func admit(w http.ResponseWriter, in policyInput) bool {
+	denial, err := evaluatePolicy(in)
+	if err != nil {
+		if cfg.Policy.OPA.FailOpen {
+			log.Printf("Admitting %s request of %s without policy decision: %v", in.Action, in.User, err)
+			return true
+		}
+		log.Printf("Policy evaluation failed: %v", err)
+		http.Error(w, errPolicyUnavailable.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	if denial == nil {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   fmt.Sprintf("denied by policy rule %s: %s", denial.Rule, denial.Message),
+		"rule":    denial.Rule,
+		"message": denial.Message,
+	})
+	return false
+}
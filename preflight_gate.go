@@ -0,0 +1,120 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PreflightGate - требование свежей успешной проверки инвентаря перед стартом
+// запуска. Если такой проверки нет, сервер выполняет ping-проверку сам и
+// останавливает запуск, когда недоступных хостов больше допустимого, чтобы
+// playbook не применился к части хостов.
+type PreflightGate struct {
+	// Наибольший возраст проверки в секундах, по умолчанию checks.preflight_max_age
+	MaxAge int `json:"max_age,omitempty"`
+	// Сколько хостов инвентаря может быть недоступно, по умолчанию ни одного
+	MaxUnreachable int `json:"max_unreachable,omitempty"`
+}
+
+func (g *PreflightGate) validate(req PlaybookRequest) error {
+	if g == nil {
+		return nil
+	}
+	switch {
+	case g.MaxAge < 0 || g.MaxUnreachable < 0:
+		return fmt.Errorf("preflight max_age and max_unreachable must not be negative")
+	case req.Inventory == "":
+		return fmt.Errorf("preflight requires inventory")
+	case req.Rolling != nil:
+		return fmt.Errorf("preflight cannot be combined with rolling")
+	case req.Runner != "" || req.Agent != "" || len(req.RunnerSelector) > 0:
+		// Проверка выполняется с сервера API и ничего не говорит о сети узла или агента
+		return fmt.Errorf("preflight cannot be combined with runner, agent or runner_selector")
+	}
+	return nil
+}
+
+func (g *PreflightGate) maxAge() int {
+	if g.MaxAge > 0 {
+		return g.MaxAge
+	}
+	return int(cfg.Checks.PreflightMaxAge.Seconds())
+}
+
+// preflightOf восстанавливает требование проверки из сохраненного запуска
+func preflightOf(run PlaybookRun) *PreflightGate {
+	if run.PreflightMaxAge <= 0 {
+		return nil
+	}
+	return &PreflightGate{MaxAge: run.PreflightMaxAge, MaxUnreachable: run.PreflightMaxUnreachable}
+}
+
+// checkPreflightGate находит свежую успешную проверку инвентаря запуска или
+// выполняет новую и сверяет число недоступных хостов с допустимым. Проверка,
+// сделанная до последнего изменения инвентаря, не считается свежей; для
+// инвентаря с inventory_params проверка всегда новая, с параметрами запуска.
+func checkPreflightGate(job runJob) error {
+	gate := job.Request.Preflight
+	if gate == nil {
+		return nil
+	}
+	t := job.Tenant
+	var inv Inventory
+	if err := t.primaryDB().Where("name = ?", job.Request.Inventory).First(&inv).Error; err != nil {
+		return fmt.Errorf("preflight: failed to load inventory: %v", err)
+	}
+
+	var check InventoryCheck
+	if len(job.Request.InventoryParams) == 0 {
+		since := time.Now().Add(-time.Duration(gate.maxAge()) * time.Second)
+		err := t.primaryDB().
+			// Проверка части хостов (limit) не говорит о доступности остальных
+			Where(`inventory_id = ? AND status = ? AND completed_at >= ? AND started_at >= ? AND "limit" = ''`,
+				inv.ID, CheckStatusCompleted, since, inv.UpdatedAt).
+			Order("completed_at DESC").Limit(1).Find(&check).Error
+		if err != nil {
+			return fmt.Errorf("preflight: failed to find inventory check: %v", err)
+		}
+	}
+	if check.ID == 0 {
+		log.Printf("Run %d: no fresh check of inventory %s, running preflight check", job.RunID, inv.Name)
+		check = InventoryCheck{
+			InventoryID: inv.ID,
+			Status:      CheckStatusPending,
+			Mode:        CheckModePing,
+			StartedAt:   time.Now(),
+		}
+		if err := t.db().Create(&check).Error; err != nil {
+			return fmt.Errorf("preflight: failed to create inventory check: %v", err)
+		}
+		publishCheckEvent(t, "check.queued", check, inv.Name, "")
+		check = runInventoryCheck(t, check, inv.Name, CheckRequest{Mode: CheckModePing, Params: job.Request.InventoryParams})
+	}
+
+	if err := t.db().Model(&PlaybookRun{}).Where("id = ?", job.RunID).Update("preflight_check_id", check.ID).Error; err != nil {
+		log.Printf("Failed to record preflight check of run %d: %v", job.RunID, err)
+	}
+	if check.Status != CheckStatusCompleted {
+		return fmt.Errorf("preflight check %d of inventory %s failed: %s", check.ID, inv.Name, check.Error)
+	}
+
+	var unreachable []string
+	for host, result := range check.Results {
+		if result != HostReachable {
+			unreachable = append(unreachable, host)
+		}
+	}
+	if len(check.Results) == 0 {
+		return errors.New("preflight check found no hosts in inventory " + inv.Name)
+	}
+	if len(unreachable) > gate.MaxUnreachable {
+		sort.Strings(unreachable)
+		return fmt.Errorf("preflight check %d: %d of %d hosts of inventory %s are unreachable (%s), at most %d allowed",
+			check.ID, len(unreachable), len(check.Results), inv.Name, strings.Join(unreachable, ", "), gate.MaxUnreachable)
+	}
+	return nil
+}
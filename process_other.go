@@ -0,0 +1,34 @@
+//go:build !unix
+
+package ansibleapi
+
+import (
+	"os"
+	"os/exec"
+)
+
+func processAlive(pid int) bool {
+	return false
+}
+
+func killProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+func configureProcessGroup(cmd *exec.Cmd) {}
+
+func processGroupAlive(pgid int) bool {
+	return processAlive(pgid)
+}
+
+func killProcessGroup(pgid int) error {
+	return killProcess(pgid)
+}
+
+func maxRSSKB(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,74 @@
+//go:build unix
+
+package ansibleapi
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// processAlive проверяет существование процесса сигналом 0
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func killProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGKILL)
+}
+
+// configureProcessGroup запускает команду в собственной группе процессов,
+// чтобы при отмене вместе с ansible-playbook завершались дочерние ssh/python.
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return terminateProcessGroup(cmd.Process.Pid)
+	}
+	cmd.WaitDelay = processKillGrace + 5*time.Second
+}
+
+// terminateProcessGroup посылает группе SIGTERM, а через processKillGrace - SIGKILL
+func terminateProcessGroup(pgid int) error {
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(processKillGrace)
+		if processGroupAlive(pgid) {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}()
+	return nil
+}
+
+func processGroupAlive(pgid int) bool {
+	if pgid <= 0 {
+		return false
+	}
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func killProcessGroup(pgid int) error {
+	return syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+// maxRSSKB - наибольший RSS завершившегося процесса и его потомков в КиБ
+func maxRSSKB(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// На macOS ru_maxrss - в байтах, на остальных системах - в КиБ
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return int64(rusage.Maxrss) / 1024
+	}
+	return int64(rusage.Maxrss)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package ansibleapi
+
+import "time"
+
+func processStartTime(pid int) (time.Time, bool) {
+	return time.Time{}, false
+}
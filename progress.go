@@ -0,0 +1,122 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+)
+
+// countPlaybookTasks возвращает число задач по ansible-playbook --list-tasks.
+// Задачи из динамических include и обработчики сюда не попадают, поэтому
+// итог - оценка, а не точное число.
+func countPlaybookTasks(ctx context.Context, inv ansibleInvocation) (int, error) {
+	args, removeInventory, err := ansibleArgs(inv)
+	if err != nil {
+		return 0, err
+	}
+	defer removeInventory()
+
+	cmd, cleanup, err := newAnsibleCommand(ctx, inv.Installation, append(args, "--list-tasks"))
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return 0, err
+	}
+	return parseListTasks(output.String()), nil
+}
+
+// recordTasksTotal сохраняет число задач запуска; ошибка подсчета не мешает выполнению
+func recordTasksTotal(ctx context.Context, t *tenant, runID uint, inv ansibleInvocation) {
+	total, err := countPlaybookTasks(ctx, inv)
+	if err != nil {
+		log.Printf("Failed to list tasks of run %d: %v", runID, err)
+		return
+	}
+	if err := t.db().Model(&PlaybookRun{}).Where("id = ?", runID).Update("tasks_total", total).Error; err != nil {
+		log.Printf("Failed to record task count of run %d: %v", runID, err)
+	}
+}
+
+func parseListTasks(output string) int {
+	count := 0
+	inTasks := false
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "play #"):
+			inTasks = false
+		case trimmed == "tasks:":
+			inTasks = true
+		case inTasks && trimmed != "":
+			count++
+		}
+	}
+	return count
+}
+
+// runProgress отслеживает ход выполнения по событиям task_start
+type runProgress struct {
+	tasksStarted int
+	currentPlay  string
+	currentTask  string
+	dirty        bool
+}
+
+func (p *runProgress) observe(event *RunEvent) {
+	switch event.Type {
+	case EventPlayStart:
+		p.currentPlay = event.Play
+		p.dirty = true
+	case EventTaskStart:
+		p.tasksStarted++
+		p.currentPlay = event.Play
+		p.currentTask = event.Task
+		p.dirty = true
+	}
+}
+
+// save записывает прогресс в запуск; задача считается выполненной,
+// когда началась следующая
+func (p *runProgress) save(t *tenant, runID uint) {
+	if !p.dirty {
+		return
+	}
+	completed := p.tasksStarted - 1
+	if completed < 0 {
+		completed = 0
+	}
+	err := t.db().Model(&PlaybookRun{}).Where("id = ?", runID).Updates(map[string]interface{}{
+		"tasks_completed": completed,
+		"current_play":    p.currentPlay,
+		"current_task":    p.currentTask,
+	}).Error
+	if err != nil {
+		log.Printf("Failed to update progress of run %d: %v", runID, err)
+		return
+	}
+	p.dirty = false
+}
+
+// fillProgress вычисляет процент выполнения для ответа API
+func (run *PlaybookRun) fillProgress() {
+	if run.TasksTotal <= 0 {
+		return
+	}
+	if run.Status == RunStatusCompleted {
+		run.Progress = 100
+		return
+	}
+	progress := float64(run.TasksCompleted) * 100 / float64(run.TasksTotal)
+	if progress > 99 {
+		// Задач может оказаться больше, чем показал --list-tasks
+		progress = 99
+	}
+	run.Progress = progress
+}
@@ -0,0 +1,79 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Сети прокси из server.trusted_proxies, которым разрешено передавать X-Forwarded-For
+var trustedProxies []*net.IPNet
+
+func loadTrustedProxies() error {
+	trustedProxies = nil
+	for _, entry := range cfg.Server.TrustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Отдельный адрес без маски - сеть из одного адреса
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			trustedProxies = append(trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP возвращает адрес клиента. X-Forwarded-For учитывается, только если
+// соединение пришло от доверенного прокси: цепочка разбирается справа налево
+// до первого адреса не из trusted_proxies, его и считаем клиентом.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !isTrustedProxy(peer) {
+		return host
+	}
+
+	client := host
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			// Испорченная цепочка: дальше доверять нельзя
+			break
+		}
+		client = hop
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,213 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	metricsPushGateway = "pushgateway"
+	metricsPushImport  = "import"
+)
+
+func validateMetricsPush() error {
+	switch cfg.MetricsPush.Format {
+	case metricsPushGateway, metricsPushImport:
+		return nil
+	}
+	return fmt.Errorf("metrics_push.format: unknown format %q, expected pushgateway or import", cfg.MetricsPush.Format)
+}
+
+// hostCounts - число хостов по итогам PLAY RECAP
+type hostCounts struct {
+	Total, OK, Changed, Failed, Unreachable int
+}
+
+// recapHostCounts считает хосты по PLAY RECAP вывода. Хост с failed > 0
+// считается упавшим, с unreachable > 0 - недоступным, остальные - успешными.
+func recapHostCounts(output string) hostCounts {
+	var counts hostCounts
+	for _, stats := range hostRecaps(output) {
+		counts.Total++
+		switch {
+		case stats["unreachable"] > 0:
+			counts.Unreachable++
+		case stats["failed"] > 0:
+			counts.Failed++
+		default:
+			counts.OK++
+		}
+		if stats["changed"] > 0 {
+			counts.Changed++
+		}
+	}
+	return counts
+}
+
+// hostRecaps разбирает строки PLAY RECAP вывода: счетчики ok, changed,
+// failed и т.д. по хостам
+func hostRecaps(output string) map[string]map[string]int {
+	var parser eventParser
+	recaps := make(map[string]map[string]int)
+	for _, line := range strings.Split(output, "\n") {
+		event := parser.parse(line)
+		if event == nil || event.Type != EventHostRecap {
+			continue
+		}
+		// При нескольких PLAY RECAP (например, import_playbook) берется последний
+		stats := make(map[string]int)
+		for _, field := range strings.Fields(event.Message) {
+			name, value, _ := strings.Cut(field, "=")
+			stats[name], _ = strconv.Atoi(value)
+		}
+		recaps[event.Host] = stats
+	}
+	return recaps
+}
+
+// pushRunMetrics отправляет метрики завершенного запуска в фоне; ошибки только логируются
+func pushRunMetrics(t *tenant, runID uint, output string) {
+	if cfg.MetricsPush.URL == "" {
+		return
+	}
+	counts := recapHostCounts(output)
+	go func() {
+		var run PlaybookRun
+		if err := t.primaryDB().First(&run, runID).Error; err != nil {
+			log.Printf("Failed to load run %d for metrics push: %v", runID, err)
+			return
+		}
+		if err := sendRunMetrics(t, run, counts); err != nil {
+			log.Printf("Failed to push metrics of run %d: %v", runID, err)
+		}
+	}()
+}
+
+func sendRunMetrics(t *tenant, run PlaybookRun, counts hostCounts) error {
+	registry := runMetricsRegistry(t, run, counts)
+	client := &http.Client{Timeout: cfg.MetricsPush.Timeout}
+
+	if cfg.MetricsPush.Format == metricsPushImport {
+		return importMetrics(client, registry)
+	}
+
+	// Группа - арендатор и playbook: в Pushgateway остаются метрики последнего запуска
+	pusher := push.New(cfg.MetricsPush.URL, cfg.MetricsPush.Job).
+		Client(client).
+		Gatherer(registry).
+		Grouping("tenant", t.Name).
+		Grouping("playbook", run.Playbook)
+	for name, value := range cfg.MetricsPush.Labels {
+		pusher = pusher.Grouping(name, value)
+	}
+	if cfg.MetricsPush.User != "" {
+		pusher = pusher.BasicAuth(cfg.MetricsPush.User, cfg.MetricsPush.Password)
+	}
+	return pusher.Push()
+}
+
+// importMetrics отправляет метрики в текстовом формате Prometheus
+// (VictoriaMetrics /api/v1/import/prometheus)
+func importMetrics(client *http.Client, registry *prometheus.Registry) error {
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	encoder := expfmt.NewEncoder(&body, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.MetricsPush.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	if cfg.MetricsPush.User != "" {
+		req.SetBasicAuth(cfg.MetricsPush.User, cfg.MetricsPush.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("import endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// runMetricsRegistry собирает метрики одного запуска. Для import метки job,
+// tenant и playbook добавляются к самим метрикам, в Pushgateway это делает группа.
+func runMetricsRegistry(t *tenant, run PlaybookRun, counts hostCounts) *prometheus.Registry {
+	labels := prometheus.Labels{}
+	if cfg.MetricsPush.Format == metricsPushImport {
+		for name, value := range cfg.MetricsPush.Labels {
+			labels[name] = value
+		}
+		labels["job"] = cfg.MetricsPush.Job
+		labels["tenant"] = t.Name
+		labels["playbook"] = run.Playbook
+	}
+
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "ansible_api_run_duration_seconds",
+		Help:        "Duration of the last finished run.",
+		ConstLabels: labels,
+	})
+	status := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "ansible_api_run_status",
+		Help:        "Status of the last finished run (1 for the current status).",
+		ConstLabels: labels,
+	}, []string{"status"})
+	hosts := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "ansible_api_run_hosts",
+		Help:        "Hosts of the last finished run by result in PLAY RECAP.",
+		ConstLabels: labels,
+	}, []string{"result"})
+	finished := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "ansible_api_run_finished_timestamp_seconds",
+		Help:        "Time the last run finished.",
+		ConstLabels: labels,
+	})
+	runID := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "ansible_api_run_id",
+		Help:        "ID of the last finished run.",
+		ConstLabels: labels,
+	})
+
+	if run.Duration != nil {
+		duration.Set(*run.Duration)
+	}
+	for _, s := range []PlaybookRunStatus{RunStatusCompleted, RunStatusFailed, RunStatusCancelled, RunStatusTimedOut} {
+		value := 0.0
+		if run.Status == s {
+			value = 1
+		}
+		status.WithLabelValues(string(s)).Set(value)
+	}
+	hosts.WithLabelValues("total").Set(float64(counts.Total))
+	hosts.WithLabelValues("ok").Set(float64(counts.OK))
+	hosts.WithLabelValues("changed").Set(float64(counts.Changed))
+	hosts.WithLabelValues("failed").Set(float64(counts.Failed))
+	hosts.WithLabelValues("unreachable").Set(float64(counts.Unreachable))
+	if run.EndTime != nil {
+		finished.Set(float64(run.EndTime.Unix()))
+	}
+	runID.Set(float64(run.ID))
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(duration, status, hosts, finished, runID)
+	return registry
+}
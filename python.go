@@ -0,0 +1,49 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const pythonInterpreterVar = "ansible_python_interpreter"
+
+// Абсолютный путь или режим автоопределения ansible. Пробелы недопустимы:
+// extra-vars передаются ansible строкой key=value через пробел.
+var pythonInterpreterRe = regexp.MustCompile(`^(/[A-Za-z0-9_./+-]+|auto|auto_legacy|auto_silent|auto_legacy_silent)$`)
+
+func validatePythonInterpreter(value string) error {
+	if value != "" && !pythonInterpreterRe.MatchString(value) {
+		return fmt.Errorf("invalid python interpreter %q, expected an absolute path or auto mode", value)
+	}
+	return nil
+}
+
+// withPythonOverride добавляет интерпретатор из запроса в extra_vars запуска:
+// extra-vars важнее переменных инвентаря, поэтому он действует на все хосты
+func withPythonOverride(extraVars map[string]string, interpreter string) map[string]string {
+	if interpreter == "" {
+		return extraVars
+	}
+	vars := make(map[string]string, len(extraVars)+1)
+	for k, v := range extraVars {
+		vars[k] = v
+	}
+	vars[pythonInterpreterVar] = interpreter
+	return vars
+}
+
+// withDefaultPython дописывает ansible.default_python в [all:vars] инвентаря.
+// Переменные группы all слабее переменных хостов и групп, поэтому хосты с
+// собственным ansible_python_interpreter его сохраняют. Если инвентарь сам
+// задает интерпретатор, он не меняется: повторная секция [all:vars] перекрыла бы его.
+func withDefaultPython(inventory string) string {
+	python := cfg.Ansible.DefaultPython
+	if python == "" || strings.Contains(inventory, pythonInterpreterVar) {
+		return inventory
+	}
+	if inventory != "" && !strings.HasSuffix(inventory, "\n") {
+		inventory += "\n"
+	}
+	return inventory + "\n[all:vars]\n" + pythonInterpreterVar + "=" + python + "\n"
+}
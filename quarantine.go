@@ -0,0 +1,176 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm/clause"
+)
+
+// HostQuarantine - хост, выведенный из запусков, например на время ремонта.
+// Хранится отдельно от HostMetadata: замена описания не снимает карантин.
+type HostQuarantine struct {
+	Host          string    `gorm:"type:text;primaryKey" json:"host"`
+	Reason        string    `gorm:"type:text" json:"reason,omitempty"`
+	QuarantinedBy string    `gorm:"type:text" json:"quarantined_by,omitempty"`
+	CreatedAt     time.Time `gorm:"type:timestamptz;not null" json:"created_at"`
+}
+
+// withoutQuarantinedHosts убирает из INI-инвентаря строки хостов на карантине
+// и возвращает их имена. Переменные групп и диапазоны вида web[01:10] не
+// меняются.
+func withoutQuarantinedHosts(t *tenant, content string) (string, []string, error) {
+	hosts := make([]string, 0)
+	for host := range inventoryHostLines(content) {
+		hosts = append(hosts, host)
+	}
+	quarantined, err := quarantinedHosts(t, hosts)
+	if err != nil || len(quarantined) == 0 {
+		return content, nil, err
+	}
+
+	var b strings.Builder
+	inHosts := true
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inHosts = !strings.Contains(trimmed, ":")
+		} else if host, _, _ := strings.Cut(trimmed, " "); inHosts && quarantined[host] {
+			continue
+		}
+		b.WriteString(line)
+	}
+
+	skipped := make([]string, 0, len(quarantined))
+	for host := range quarantined {
+		skipped = append(skipped, host)
+	}
+	sort.Strings(skipped)
+	return b.String(), skipped, nil
+}
+
+// quarantinedHosts возвращает хосты из hosts, находящиеся на карантине
+func quarantinedHosts(t *tenant, hosts []string) (map[string]bool, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	var names []string
+	if err := t.db().Model(&HostQuarantine{}).Where("host IN ?", hosts).Pluck("host", &names).Error; err != nil {
+		return nil, fmt.Errorf("failed to load quarantined hosts: %v", err)
+	}
+	quarantined := make(map[string]bool, len(names))
+	for _, name := range names {
+		quarantined[name] = true
+	}
+	return quarantined, nil
+}
+
+// excludeQuarantinedHosts исключает хосты на карантине из задания агента:
+// из переданного инвентаря или из списка хостов
+func excludeQuarantinedHosts(t *tenant, job *AgentJob) ([]string, error) {
+	switch {
+	case job.Localhost:
+		return nil, nil
+	case job.Inventory != "":
+		content, skipped, err := withoutQuarantinedHosts(t, job.Inventory)
+		job.Inventory = content
+		return skipped, err
+	}
+	quarantined, err := quarantinedHosts(t, job.Hosts)
+	if err != nil || len(quarantined) == 0 {
+		return nil, err
+	}
+	var hosts, skipped []string
+	for _, host := range job.Hosts {
+		if quarantined[host] {
+			skipped = append(skipped, host)
+		} else {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		// Без хостов агент запустил бы playbook с инвентарем по умолчанию
+		return nil, fmt.Errorf("all hosts of the run are quarantined: %s", strings.Join(skipped, ", "))
+	}
+	job.Hosts = hosts
+	return skipped, nil
+}
+
+// recordQuarantinedHosts сохраняет в запуске пропущенные хосты и пишет
+// предупреждение в его вывод
+func recordQuarantinedHosts(t *tenant, runID uint, hosts []string, output io.Writer) {
+	log.Printf("Run %d of tenant %s skips quarantined hosts: %s", runID, t.Name, strings.Join(hosts, ", "))
+	if output != nil {
+		fmt.Fprintf(output, "[WARNING]: Quarantined hosts are excluded from the inventory: %s\n", strings.Join(hosts, ", "))
+	}
+	if err := t.db().Model(&PlaybookRun{}).Where("id = ?", runID).
+		Update("quarantined_hosts", JSONList(hosts)).Error; err != nil {
+		log.Printf("Failed to record quarantined hosts of run %d: %v", runID, err)
+	}
+}
+
+func listHostQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	hosts := []HostQuarantine{}
+	if err := tenantOf(r).db().Order("host").Find(&hosts).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hosts)
+}
+
+func getHostQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	var hosts []HostQuarantine
+	if err := tenantOf(r).db().Where("host = ?", mux.Vars(r)["host"]).Limit(1).Find(&hosts).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if len(hosts) == 0 {
+		http.Error(w, "Host is not quarantined", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hosts[0])
+}
+
+// setHostQuarantineHandler помещает хост на карантин; повторный вызов меняет
+// причину и время. Наличие хоста в инвентарях не проверяется.
+func setHostQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	var quarantine HostQuarantine
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &quarantine) {
+		return
+	}
+	quarantine.Host = mux.Vars(r)["host"]
+	quarantine.QuarantinedBy = triggeredBy(r)
+	quarantine.CreatedAt = time.Now()
+
+	err := tenantOf(r).db().Clauses(clause.OnConflict{UpdateAll: true}).Create(&quarantine).Error
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	log.Printf("Host %s of tenant %s quarantined by %s: %s", quarantine.Host, tenantOf(r).Name, quarantine.QuarantinedBy, quarantine.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quarantine)
+}
+
+func deleteHostQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	result := tenantOf(r).db().Where("host = ?", mux.Vars(r)["host"]).Delete(&HostQuarantine{})
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Host is not quarantined", http.StatusNotFound)
+		return
+	}
+	log.Printf("Host %s of tenant %s released from quarantine by %s", mux.Vars(r)["host"], tenantOf(r).Name, triggeredBy(r))
+	w.WriteHeader(http.StatusNoContent)
+}
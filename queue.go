@@ -0,0 +1,186 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type QueueEntryState string
+
+const (
+	QueueStateQueued  QueueEntryState = "queued"
+	QueueStateClaimed QueueEntryState = "claimed"
+	QueueStateDone    QueueEntryState = "done"
+)
+
+// RunQueueEntry - принятый к выполнению запуск. Воркеры забирают записи
+// через SELECT ... FOR UPDATE SKIP LOCKED, поэтому каждый запуск
+// выполняется ровно один раз, даже если сервер перезапускался.
+type RunQueueEntry struct {
+	gorm.Model
+	RunID     uint            `gorm:"not null;uniqueIndex" json:"run_id"`
+	State     QueueEntryState `gorm:"type:text;not null;index" json:"state"`
+	NodeID    string          `gorm:"type:text" json:"node_id,omitempty"`
+	ClaimedAt *time.Time      `gorm:"type:timestamptz" json:"claimed_at,omitempty"`
+	// Запуск не забирается из очереди раньше этого времени (пауза перед повтором)
+	NotBefore *time.Time `gorm:"type:timestamptz" json:"not_before,omitempty"`
+	// Агент, которому предназначен запуск; пустой - запуск выполняют узлы сервера
+	Agent string `gorm:"type:text;not null;default:''" json:"agent,omitempty"`
+}
+
+// enqueueRun сохраняет запуск вместе с записью очереди и будит воркер
+func enqueueRun(t *tenant, run *PlaybookRun) error {
+	return enqueueRunAfter(t, run, time.Time{})
+}
+
+// enqueueRunAfter ставит запуск в очередь, откуда его заберут не раньше notBefore
+func enqueueRunAfter(t *tenant, run *PlaybookRun, notBefore time.Time) error {
+	run.Status = RunStatusQueued
+	if run.StartTime.IsZero() {
+		run.StartTime = time.Now()
+	}
+
+	err := t.db().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		entry := RunQueueEntry{RunID: run.ID, State: QueueStateQueued, Agent: run.Agent}
+		if !notBefore.IsZero() {
+			entry.NotBefore = &notBefore
+		}
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	runQueue.Notify()
+	publishRunEvent(t, "run.queued", *run, "")
+	return nil
+}
+
+// claimNextRun забирает следующий запуск из очередей арендаторов по кругу:
+// для узлов сервера (agent пустой) или для агента agent.
+// Возвращает nil, если все очереди пусты.
+func claimNextRun(agent string) (*runJob, error) {
+	for _, t := range tenantsRoundRobin() {
+		job, err := claimTenantRun(t, agent)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %v", t.Name, err)
+		}
+		if job != nil {
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+// claimTenantRun забирает самый старый запуск из очереди арендатора
+func claimTenantRun(t *tenant, agent string) (*runJob, error) {
+	nodeID := cfg.Server.NodeID
+	if agent != "" {
+		nodeID = agentNodeID(agent)
+	}
+
+	var (
+		job     *runJob
+		claimed PlaybookRun
+	)
+
+	err := t.db().Transaction(func(tx *gorm.DB) error {
+		var entry RunQueueEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("state = ? AND agent = ? AND (not_before IS NULL OR not_before <= ?)", QueueStateQueued, agent, time.Now()).
+			Order("id ASC").
+			First(&entry).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&entry).Updates(map[string]interface{}{
+			"state":      QueueStateClaimed,
+			"node_id":    nodeID,
+			"claimed_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var run PlaybookRun
+		if err := tx.First(&run, entry.RunID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&run).Updates(map[string]interface{}{
+			"status":       RunStatusStarted,
+			"start_time":   now,
+			"heartbeat_at": now,
+			"node_id":      nodeID,
+		}).Error; err != nil {
+			return err
+		}
+		claimed = run
+		claimedJob := newRunJob(t, run)
+		job = &claimedJob
+		return nil
+	})
+
+	if err == nil && job != nil {
+		publishRunEvent(t, "run.started", claimed, "")
+	}
+	return job, err
+}
+
+// newRunJob - задание на выполнение сохраненного запуска
+func newRunJob(t *tenant, run PlaybookRun) runJob {
+	return runJob{
+		Tenant: t,
+		RunID:  run.ID,
+		Request: PlaybookRequest{
+			Playbook:    run.Playbook,
+			Inventory:   run.Inventory,
+			Localhost:   run.Localhost,
+			Hosts:       run.Hosts,
+			HostVars:    run.HostVars,
+			Limit:       run.Limit,
+			ExtraArgs:   run.ExtraArgs,
+			ExtraVars:   run.ExtraVars,
+			RestartSafe: run.RestartSafe,
+			Ticket:      run.Ticket,
+			Ansible:     run.Ansible,
+			Runner:      run.Runner,
+			Agent:       run.Agent,
+
+			InventoryParams: run.InventoryParams,
+			HostTags:        run.HostTags,
+			Preflight:       preflightOf(run),
+		},
+		PlaybookPath: filepath.Join(t.PlaybooksDir, run.Playbook),
+		SealedVars:   run.SealedVars,
+	}
+}
+
+func completeQueueEntry(t *tenant, runID uint) error {
+	return t.db().Model(&RunQueueEntry{}).
+		Where("run_id = ?", runID).
+		Update("state", QueueStateDone).Error
+}
+
+func countQueuedRuns() (int64, error) {
+	var total int64
+	for _, t := range tenantList {
+		var count int64
+		if err := t.db().Model(&RunQueueEntry{}).Where("state = ?", QueueStateQueued).Count(&count).Error; err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
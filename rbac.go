@@ -0,0 +1,255 @@
+package ansibleapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Роли RBAC по возрастанию прав: каждая включает права предыдущих
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+)
+
+var roleLevels = map[string]int{roleViewer: 1, roleOperator: 2, roleAdmin: 3}
+
+// Префикс субъекта, выдающего роль всем токенам и пользователям команды
+const teamSubjectPrefix = "team:"
+
+// RoleBinding - роль, выданная пользователю или токену (по имени) либо
+// команде (team:<команда>) на все playbooks арендатора или по шаблону
+type RoleBinding struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Subject string `gorm:"type:text;not null;index" json:"subject"`
+	Role    string `gorm:"type:text;not null" json:"role"`
+	// Шаблон имени playbook (как в path.Match); пустой - роль на все playbooks
+	Playbook  string    `gorm:"type:text" json:"playbook,omitempty"`
+	CreatedBy string    `gorm:"type:text" json:"created_by,omitempty"`
+	CreatedAt time.Time `gorm:"type:timestamptz;not null" json:"created_at"`
+}
+
+func (b RoleBinding) validate() error {
+	if strings.TrimSpace(b.Subject) == "" || b.Subject == teamSubjectPrefix {
+		return fmt.Errorf("subject is required")
+	}
+	if roleLevels[b.Role] == 0 {
+		return fmt.Errorf("role must be viewer, operator or admin")
+	}
+	if _, err := path.Match(b.Playbook, ""); err != nil {
+		return fmt.Errorf("invalid playbook pattern %q: %v", b.Playbook, err)
+	}
+	return nil
+}
+
+// grants сообщает, дает ли привязка роль не ниже role на playbook;
+// пустой playbook - роль на все playbooks
+func (b RoleBinding) grants(role, playbook string) bool {
+	if roleLevels[b.Role] < roleLevels[role] {
+		return false
+	}
+	if b.Playbook == "" {
+		return true
+	}
+	matched, _ := path.Match(b.Playbook, playbook)
+	return playbook != "" && matched
+}
+
+type rolesKey struct{}
+
+// rbacEnabled сообщает, проверяются ли роли
+func rbacEnabled() bool {
+	return cfg.Auth.RBAC
+}
+
+// Изменяющие маршруты, права на которые зависят от playbook: их обработчики
+// сами проверяют роль operator на playbook запуска
+var playbookRoutes = map[string]bool{
+	"/api/run":                        true,
+	"/api/runs/batch":                 true,
+	"/api/runs/{id}/cancel":           true,
+	"/api/runs/{id}/rollback":         true,
+	"/api/runs/{id}/share":            true,
+	"/api/rollouts/{id}/approve":      true,
+	"/api/rollouts/{id}/cancel":       true,
+	"/api/playbooks/{name}/lifecycle": true,
+}
+
+// rbacMiddleware при auth.rbac пропускает только вызывающих с ролью: любая
+// роль дает чтение, изменения требуют роли operator на все playbooks, кроме
+// маршрутов playbookRoutes. Администраторы (X-Admin-Token, пользователи с
+// admin) проходят без ролей. Привязки сохраняются в контексте запроса.
+func rbacMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rbacEnabled() || publicRoute(r) || hasAdminToken(r) || currentPrincipal(r).Admin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bindings, err := principalRoles(tenantOf(r), currentPrincipal(r))
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if len(bindings) == 0 {
+			http.Error(w, "No role granted", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), rolesKey{}, bindings))
+		if rbacMutating(r) && !hasRole(r, roleOperator, "") {
+			http.Error(w, "Role operator required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rbacMutating - запрос изменяет данные и не относится к playbookRoutes
+func rbacMutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return true
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return true
+	}
+	return !playbookRoutes[template]
+}
+
+// principalRoles загружает роли вызывающей стороны: по имени и по команде
+func principalRoles(t *tenant, p principal) ([]RoleBinding, error) {
+	if p.Anonymous {
+		return nil, nil
+	}
+	subjects := []string{p.Name}
+	if p.Team != "" {
+		subjects = append(subjects, teamSubjectPrefix+p.Team)
+	}
+	var bindings []RoleBinding
+	err := t.db().Where("subject IN ?", subjects).Find(&bindings).Error
+	return bindings, err
+}
+
+// hasRole сообщает, есть ли у запроса роль не ниже role на playbook; пустой
+// playbook - роль на все playbooks. Без auth.rbac и у администраторов - всегда.
+func hasRole(r *http.Request, role, playbook string) bool {
+	if !rbacEnabled() || hasAdminToken(r) || currentPrincipal(r).Admin {
+		return true
+	}
+	bindings, ok := r.Context().Value(rolesKey{}).([]RoleBinding)
+	if !ok {
+		// Маршрут вне rbacMiddleware, например при встраивании обработчиков
+		var err error
+		if bindings, err = principalRoles(tenantOf(r), currentPrincipal(r)); err != nil {
+			log.Printf("Failed to load roles of %s: %v", currentPrincipal(r).Name, err)
+			return false
+		}
+	}
+	for _, b := range bindings {
+		if b.grants(role, playbook) {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePlaybookRole отвечает 403, если у запроса нет роли role на playbook
+func requirePlaybookRole(w http.ResponseWriter, r *http.Request, role, playbook string) bool {
+	if hasRole(r, role, playbook) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("Role %s on playbook %s required", role, playbook), http.StatusForbidden)
+	return false
+}
+
+// requirePlaybookAdmin - requireAdmin для маршрутов /api/playbooks/{name}/...:
+// достаточно роли admin на этот playbook
+func requirePlaybookAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) && !(rbacEnabled() && hasRole(r, roleAdmin, mux.Vars(r)["name"])) {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireOperator пропускает вызывающих с ролью operator на все playbooks при
+// auth.rbac, без RBAC - только администраторов (X-Admin-Token)
+func requireOperator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) && !(rbacEnabled() && hasRole(r, roleOperator, "")) {
+			http.Error(w, "Role operator required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func listRoleBindingsHandler(w http.ResponseWriter, r *http.Request) {
+	query := tenantOf(r).db().Order("subject, id")
+	if subject := r.URL.Query().Get("subject"); subject != "" {
+		query = query.Where("subject = ?", subject)
+	}
+	bindings := []RoleBinding{}
+	if err := query.Find(&bindings).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bindings)
+}
+
+func createRoleBindingHandler(w http.ResponseWriter, r *http.Request) {
+	var binding RoleBinding
+	if !decodeJSONBody(w, r, &binding) {
+		return
+	}
+	if err := binding.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	binding.ID = 0
+	binding.CreatedBy = triggeredBy(r)
+	binding.CreatedAt = time.Now()
+	if err := tenantOf(r).db().Create(&binding).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	log.Printf("Role %s on %q granted to %s by %s", binding.Role, binding.Playbook, binding.Subject, binding.CreatedBy)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(binding)
+}
+
+func deleteRoleBindingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid role binding ID", http.StatusBadRequest)
+		return
+	}
+	result := tenantOf(r).db().Delete(&RoleBinding{}, id)
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Role binding not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Role binding %d revoked by %s", id, triggeredBy(r))
+	w.WriteHeader(http.StatusNoContent)
+}
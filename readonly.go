@@ -0,0 +1,63 @@
+package ansibleapi
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// readOnly - режим только для чтения: изменяющие запросы отклоняются с 503.
+// Начальное значение - server.read_only, дальше переключается через API.
+var readOnly atomic.Bool
+
+// readOnlyMiddleware отклоняет изменяющие запросы в режиме только для чтения.
+// Чтение (GET, HEAD, OPTIONS) доступно всегда.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly.Load() && !readOnlySafe(r) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "API is in read-only mode", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnlySafe - запрос допустим в режиме только для чтения: чтение, проверка
+// доступности без записи, управление сервером (в том числе выход из режима) и
+// обмен с агентами и playbook, без которого не завершатся уже идущие запуски
+func readOnlySafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	path := r.URL.Path
+	switch {
+	case path == "/api/ping", path == "/api/auth/login", strings.HasPrefix(path, "/api/system/"):
+		return true
+	case strings.HasPrefix(path, "/api/credentials/") && strings.HasSuffix(path, "/validate"):
+		// Итог проверки в этом режиме не записывается
+		return true
+	case strings.HasPrefix(path, "/api/agents/") && r.Method == http.MethodPost:
+		return true
+	case strings.HasPrefix(path, "/api/runs/") && strings.HasSuffix(path, "/artifacts") && r.Method == http.MethodPost:
+		return true
+	case strings.HasPrefix(path, "/api/runs/") && strings.HasSuffix(path, "/share"):
+		// Ссылка подписывается ключом и в БД не пишется
+		return true
+	}
+	return false
+}
+
+func readOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	readOnly.Store(true)
+	log.Printf("Read-only mode enabled, mutating requests will be rejected")
+	systemStatusHandler(w, r)
+}
+
+func readWriteHandler(w http.ResponseWriter, r *http.Request) {
+	readOnly.Store(false)
+	log.Printf("Read-only mode disabled")
+	systemStatusHandler(w, r)
+}
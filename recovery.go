@@ -0,0 +1,126 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// recoverInterruptedRuns переводит в interrupted запуски этого узла, оставшиеся
+// в статусе started после падения сервера, и перезапускает помеченные restart_safe.
+func recoverInterruptedRuns() {
+	forEachTenant(recoverTenantRuns)
+}
+
+func recoverTenantRuns(t *tenant) {
+	var runs []PlaybookRun
+	if err := t.primaryDB().Where("status = ? AND (node_id = ? OR node_id IS NULL OR node_id = '')", RunStatusStarted, cfg.Server.NodeID).
+		Find(&runs).Error; err != nil {
+		log.Printf("Failed to look up interrupted runs: %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		if runProcessAlive(run) {
+			log.Printf("Run %d is still owned by live process %d, leaving it as is", run.ID, run.PID)
+			continue
+		}
+
+		endTime := time.Now()
+		duration := endTime.Sub(run.StartTime).Seconds()
+		errorMsg := fmt.Sprintf("run interrupted: node %s restarted while the playbook was executing", cfg.Server.NodeID)
+		if err := t.db().Model(&PlaybookRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+			"status":   RunStatusInterrupted,
+			"error":    errorMsg,
+			"end_time": endTime,
+			"duration": duration,
+		}).Error; err != nil {
+			log.Printf("Failed to mark run %d as interrupted: %v", run.ID, err)
+			continue
+		}
+		if err := completeQueueEntry(t, run.ID); err != nil {
+			log.Printf("Failed to release queue entry of run %d: %v", run.ID, err)
+		}
+		log.Printf("Marked run %d (%s) as interrupted", run.ID, run.Playbook)
+
+		if run.RestartSafe && cfg.Ansible.RelaunchInterrupted {
+			if err := relaunchRun(t, run); err != nil {
+				log.Printf("Failed to relaunch interrupted run %d: %v", run.ID, err)
+			} else {
+				// Часть rollout продолжит перезапуск
+				continue
+			}
+		}
+		advanceRollout(t, run.ID, RunStatusInterrupted, "")
+	}
+}
+
+// runProcessAlive проверяет, что процесс запуска еще жив. После перезапуска
+// его PID мог достаться другому процессу, поэтому живой процесс считается
+// процессом запуска, только если совпадает время его старта, а для записей
+// без него - пока heartbeat запуска не устарел. Группа без лидера остается
+// группой запуска: PGID существующей группы не выдается новым процессам.
+func runProcessAlive(run PlaybookRun) bool {
+	pid := run.PGID
+	if pid <= 0 {
+		pid = run.PID
+	}
+	if !processAlive(pid) {
+		return run.PGID > 0 && processGroupAlive(run.PGID)
+	}
+	if run.ProcessStartedAt != nil {
+		started, ok := processStartTime(pid)
+		return ok && started.Sub(*run.ProcessStartedAt).Abs() < time.Second
+	}
+	return time.Since(lastHeartbeat(run)) < cfg.Watchdog.StaleAfter
+}
+
+func relaunchRun(t *tenant, run PlaybookRun) error {
+	relaunch := repeatRun(run)
+	relaunch.RelaunchOf = &run.ID
+	if err := enqueueRun(t, &relaunch); err != nil {
+		return err
+	}
+
+	log.Printf("Relaunched interrupted run %d as run %d", run.ID, relaunch.ID)
+	return nil
+}
+
+// repeatRun - новый запуск с параметрами run, включая установку ansible
+// и зашифрованные sensitive_vars
+func repeatRun(run PlaybookRun) PlaybookRun {
+	return PlaybookRun{
+		Playbook:    run.Playbook,
+		Inventory:   run.Inventory,
+		Localhost:   run.Localhost,
+		Hosts:       run.Hosts,
+		HostVars:    run.HostVars,
+		Limit:       run.Limit,
+		ExtraArgs:   run.ExtraArgs,
+		TriggeredBy: run.TriggeredBy,
+		Team:        run.Team,
+		PeerAddr:    run.PeerAddr,
+		ExtraVars:   run.ExtraVars,
+		SealedVars:  run.SealedVars,
+		Ticket:      run.Ticket,
+		RestartSafe: run.RestartSafe,
+		Attempt:     run.Attempt,
+
+		InventoryParams: run.InventoryParams,
+		HostTags:        run.HostTags,
+
+		PreflightMaxAge:         run.PreflightMaxAge,
+		PreflightMaxUnreachable: run.PreflightMaxUnreachable,
+
+		Ansible:        run.Ansible,
+		AnsibleVersion: run.AnsibleVersion,
+		Runner:         run.Runner,
+		Agent:          run.Agent,
+		RunnerSelector: run.RunnerSelector,
+		Warning:        run.Warning,
+
+		RolloutID:     run.RolloutID,
+		RolloutBatch:  run.RolloutBatch,
+		RolloutVerify: run.RolloutVerify,
+	}
+}
@@ -0,0 +1,131 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Переменная, которую playbook выводит через debug, чтобы вернуть результат без set_stats
+const apiResultVar = "api_result"
+
+// JSONObject - произвольный JSON-объект в JSONB
+type JSONObject map[string]interface{}
+
+func (o *JSONObject) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("value is not []byte")
+	}
+	return json.Unmarshal(b, &o)
+}
+
+func (o JSONObject) Value() (interface{}, error) {
+	if o == nil {
+		return nil, nil
+	}
+	return json.Marshal(o)
+}
+
+// parseRunResults собирает машиночитаемые результаты запуска из вывода
+// стандартного callback: данные set_stats из секции CUSTOM STATS (ansible
+// выводит ее при ANSIBLE_SHOW_CUSTOM_STATS) и значения api_result из debug.
+// results - данные set_stats без per_host и api_result; hostResults - данные
+// по хостам. Если ничего не найдено, оба результата nil.
+func parseRunResults(output string) (results, hostResults JSONObject) {
+	setHost := func(host, key string, value interface{}) {
+		if hostResults == nil {
+			hostResults = JSONObject{}
+		}
+		data, _ := hostResults[host].(map[string]interface{})
+		if data == nil {
+			data = map[string]interface{}{}
+			hostResults[host] = data
+		}
+		data[key] = value
+	}
+
+	apiResult := func(host, data string) {
+		var value map[string]interface{}
+		if json.Unmarshal([]byte(data), &value) != nil {
+			return
+		}
+		if result, ok := value[apiResultVar]; ok {
+			setHost(host, apiResultVar, result)
+			if results == nil {
+				results = JSONObject{}
+			}
+			// Обычно api_result выводит один хост; при нескольких остается последний
+			results[apiResultVar] = result
+		}
+	}
+
+	var (
+		parser   eventParser
+		inStats  bool
+		dumpHost string
+		dump     []string
+	)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		// Многострочный результат debug: JSON до закрывающей скобки в начале строки
+		if dump != nil {
+			dump = append(dump, line)
+			if line != "}" {
+				continue
+			}
+			apiResult(dumpHost, strings.Join(dump, "\n"))
+			dump = nil
+			continue
+		}
+
+		if strings.HasPrefix(line, "CUSTOM STATS:") {
+			inStats = true
+			continue
+		}
+		if inStats {
+			// Строки секции: "\tRUN: {...}" и "\t<host>: {...}", JSON без переводов строк
+			if !strings.HasPrefix(line, "\t") {
+				if strings.TrimSpace(line) != "" {
+					inStats = false
+				}
+				continue
+			}
+			name, data, ok := strings.Cut(strings.TrimPrefix(line, "\t"), ": ")
+			if !ok {
+				continue
+			}
+			var value map[string]interface{}
+			if json.Unmarshal([]byte(data), &value) != nil {
+				continue
+			}
+			for key, v := range value {
+				if name == "RUN" {
+					if results == nil {
+						results = JSONObject{}
+					}
+					results[key] = v
+				} else {
+					setHost(name, key, v)
+				}
+			}
+			continue
+		}
+
+		event := parser.parse(line)
+		if event == nil || (event.Type != EventHostOK && event.Type != EventHostChanged) || !strings.HasPrefix(event.Message, "=> {") {
+			continue
+		}
+		data := strings.TrimPrefix(event.Message, "=> ")
+		if data == "{" {
+			dumpHost, dump = event.Host, []string{data}
+		} else {
+			apiResult(event.Host, data)
+		}
+	}
+	return results, hostResults
+}
@@ -0,0 +1,107 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"time"
+
+	"ansible-api/config"
+)
+
+// Пауза перед повтором, если в политике она не задана
+const defaultRetryBackoff = time.Minute
+
+// retryPolicy - политика из retries с разобранным on_error
+type retryPolicy struct {
+	config.RetryPolicy
+	onError *regexp.Regexp
+}
+
+var retryPolicies []retryPolicy
+
+func loadRetryPolicies() error {
+	retryPolicies = nil
+	for i, c := range cfg.Retries {
+		if c.Playbook == "" || c.Count <= 0 {
+			return fmt.Errorf("retry policy %d: playbook and positive count are required", i+1)
+		}
+		p := retryPolicy{RetryPolicy: c}
+		if c.OnError != "" {
+			re, err := regexp.Compile(c.OnError)
+			if err != nil {
+				return fmt.Errorf("retry policy %s: %v", c.Playbook, err)
+			}
+			p.onError = re
+		}
+		if p.Backoff <= 0 {
+			p.Backoff = defaultRetryBackoff
+		}
+		if p.MaxBackoff < p.Backoff {
+			p.MaxBackoff = p.Backoff
+		}
+		retryPolicies = append(retryPolicies, p)
+	}
+	return nil
+}
+
+func retryPolicyFor(playbook string) (retryPolicy, bool) {
+	for _, p := range retryPolicies {
+		if ok, _ := path.Match(p.Playbook, playbook); ok {
+			return p, true
+		}
+	}
+	return retryPolicy{}, false
+}
+
+// delay - пауза перед попыткой attempt (вторая попытка - первый повтор)
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 2; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// retryFailedRun ставит повтор упавшего запуска по политике из retries.
+// Отмененные запуски не повторяются. Вывод и ошибка должны быть уже замаскированы.
+// Возвращает true, если повтор поставлен в очередь.
+func retryFailedRun(t *tenant, runID uint, status PlaybookRunStatus, errorMsg, output string) bool {
+	if status != RunStatusFailed && status != RunStatusTimedOut {
+		return false
+	}
+
+	var run PlaybookRun
+	if err := t.primaryDB().First(&run, runID).Error; err != nil {
+		log.Printf("Failed to load run %d for retry: %v", runID, err)
+		return false
+	}
+	policy, ok := retryPolicyFor(run.Playbook)
+	if !ok {
+		return false
+	}
+	attempt := run.Attempt + 1
+	if attempt > policy.Count+1 {
+		log.Printf("Run %d failed on attempt %d, retries of %s exhausted", run.ID, run.Attempt, run.Playbook)
+		return false
+	}
+	if policy.onError != nil && !policy.onError.MatchString(errorMsg) && !policy.onError.MatchString(output) {
+		return false
+	}
+
+	retry := repeatRun(run)
+	retry.RetryOf = &run.ID
+	retry.Attempt = attempt
+	delay := policy.delay(attempt)
+	if err := enqueueRunAfter(t, &retry, time.Now().Add(delay)); err != nil {
+		log.Printf("Failed to queue retry of run %d: %v", run.ID, err)
+		return false
+	}
+	log.Printf("Run %d %s, retrying as run %d (attempt %d of %d) in %s",
+		run.ID, status, retry.ID, attempt, policy.Count+1, delay)
+	return true
+}
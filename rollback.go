@@ -0,0 +1,190 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"ansible-api/config"
+)
+
+// rollbackPolicy - политика из rollbacks с разобранным after_task
+type rollbackPolicy struct {
+	config.RollbackPolicy
+	afterTask *regexp.Regexp
+}
+
+var rollbackPolicies []rollbackPolicy
+
+func loadRollbackPolicies() error {
+	rollbackPolicies = nil
+	for i, c := range cfg.Rollbacks {
+		if c.Playbook == "" || c.Rollback == "" {
+			return fmt.Errorf("rollback policy %d: playbook and rollback are required", i+1)
+		}
+		p := rollbackPolicy{RollbackPolicy: c}
+		if c.AfterTask != "" {
+			re, err := regexp.Compile(c.AfterTask)
+			if err != nil {
+				return fmt.Errorf("rollback policy %s: %v", c.Playbook, err)
+			}
+			p.afterTask = re
+		}
+		rollbackPolicies = append(rollbackPolicies, p)
+	}
+	return nil
+}
+
+func rollbackPolicyFor(playbook string) (rollbackPolicy, bool) {
+	for _, p := range rollbackPolicies {
+		if ok, _ := path.Match(p.Playbook, playbook); ok {
+			return p, true
+		}
+	}
+	return rollbackPolicy{}, false
+}
+
+// pastPointOfNoReturn - запуск дошел до задачи after_task; без after_task - всегда
+func (p rollbackPolicy) pastPointOfNoReturn(output string) bool {
+	if p.afterTask == nil {
+		return true
+	}
+	var parser eventParser
+	for _, line := range strings.Split(output, "\n") {
+		event := parser.parse(strings.TrimRight(line, "\r"))
+		if event != nil && event.Type == EventTaskStart && p.afterTask.MatchString(event.Task) {
+			return true
+		}
+	}
+	return false
+}
+
+// triggerRollback ставит откат упавшего запуска по политике из rollbacks или,
+// при require_approval, отмечает, что откат ждет подтверждения. Откаты сами
+// не откатываются.
+func triggerRollback(t *tenant, runID uint, status PlaybookRunStatus, output string) {
+	if status != RunStatusFailed && status != RunStatusTimedOut {
+		return
+	}
+	var run PlaybookRun
+	if err := t.primaryDB().First(&run, runID).Error; err != nil {
+		log.Printf("Failed to load run %d for rollback: %v", runID, err)
+		return
+	}
+	if run.RollbackOf != nil || run.RolloutVerify {
+		return
+	}
+	policy, ok := rollbackPolicyFor(run.Playbook)
+	if !ok || !policy.pastPointOfNoReturn(output) {
+		return
+	}
+
+	if policy.RequireApproval {
+		if err := t.db().Model(&PlaybookRun{}).Where("id = ?", run.ID).Update("rollback_pending", true).Error; err != nil {
+			log.Printf("Failed to mark rollback of run %d pending: %v", run.ID, err)
+			return
+		}
+		log.Printf("Run %d %s, rollback %s awaits approval", run.ID, status, policy.Rollback)
+		return
+	}
+	if _, err := queueRollback(t, run, policy); err != nil {
+		log.Printf("Failed to queue rollback of run %d: %v", run.ID, err)
+	}
+}
+
+// queueRollback ставит playbook отката с теми же inventory, limit, хостами и
+// переменными, что у запуска run, и связывает запуски
+func queueRollback(t *tenant, run PlaybookRun, policy rollbackPolicy) (PlaybookRun, error) {
+	if _, err := os.Stat(filepath.Join(t.PlaybooksDir, policy.Rollback)); err != nil {
+		return PlaybookRun{}, fmt.Errorf("rollback playbook %s: %v", policy.Rollback, err)
+	}
+
+	rollback := repeatRun(run)
+	rollback.Playbook = policy.Rollback
+	rollback.RollbackOf = &run.ID
+	rollback.Attempt = 1
+	rollback.Warning = ""
+	// Откат не является частью rollout и не продолжает его
+	rollback.RolloutID = nil
+	rollback.RolloutBatch = 0
+	if err := enqueueRun(t, &rollback); err != nil {
+		return PlaybookRun{}, err
+	}
+	if err := t.db().Model(&PlaybookRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"rollback_run_id":  rollback.ID,
+		"rollback_pending": false,
+	}).Error; err != nil {
+		log.Printf("Failed to link rollback %d to run %d: %v", rollback.ID, run.ID, err)
+	}
+	log.Printf("Run %d %s, rollback %s queued as run %d", run.ID, run.Status, policy.Rollback, rollback.ID)
+	return rollback, nil
+}
+
+// approveRollbackHandler подтверждает откат, ожидающий подтверждения по require_approval
+func approveRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	t := tenantOf(r)
+	var run PlaybookRun
+	if err := t.primaryDB().First(&run, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	if !requirePlaybookRole(w, r, roleOperator, run.Playbook) {
+		return
+	}
+	policy, ok := rollbackPolicyFor(run.Playbook)
+	if !run.RollbackPending || !ok {
+		http.Error(w, "Run has no rollback awaiting approval", http.StatusConflict)
+		return
+	}
+
+	// Снимаем отметку условием, чтобы откат не был поставлен дважды
+	result := t.db().Model(&PlaybookRun{}).Where("id = ? AND rollback_pending", run.ID).Update("rollback_pending", false)
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Run has no rollback awaiting approval", http.StatusConflict)
+		return
+	}
+	rollback, err := queueRollback(t, run, policy)
+	if err != nil {
+		log.Printf("Failed to queue rollback of run %d: %v", run.ID, err)
+		t.db().Model(&PlaybookRun{}).Where("id = ?", run.ID).Update("rollback_pending", true)
+		if isConnectionError(err) {
+			writeDBError(w, err)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("Rollback of run %d approved by %s", run.ID, triggeredBy(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run_id":          run.ID,
+		"rollback_run_id": rollback.ID,
+		"playbook":        rollback.Playbook,
+	})
+}
@@ -0,0 +1,494 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Сколько ждать ansible-playbook --list-hosts при создании rollout
+const rolloutListHostsTimeout = time.Minute
+
+type RolloutStatus string
+
+const (
+	RolloutRunning RolloutStatus = "running"
+	// После canary: выполняется verify_playbook или ожидается подтверждение
+	RolloutVerifying        RolloutStatus = "verifying"
+	RolloutAwaitingApproval RolloutStatus = "awaiting_approval"
+	RolloutCompleted        RolloutStatus = "completed"
+	// Упал canary, проверка или хостов больше max_failed_hosts; оставшиеся части не запускались
+	RolloutAborted   RolloutStatus = "aborted"
+	RolloutCancelled RolloutStatus = "cancelled"
+)
+
+// activeRolloutStatuses - rollout еще не завершен
+var activeRolloutStatuses = []RolloutStatus{RolloutRunning, RolloutVerifying, RolloutAwaitingApproval}
+
+// RollingStrategy - поэтапное выполнение: хосты playbook делятся на части,
+// каждая выполняется отдельным запуском с --limit после завершения предыдущей
+type RollingStrategy struct {
+	// Размер части: число хостов или процент от всех; задается одно из двух
+	BatchSize    int `json:"batch_size,omitempty"`
+	BatchPercent int `json:"batch_percent,omitempty"`
+	// Пауза между частями: секунды или формат 5m
+	Pause string `json:"pause,omitempty"`
+	// Сколько упавших и недоступных хостов допускается за весь rollout
+	MaxFailedHosts int `json:"max_failed_hosts,omitempty"`
+	// Шаблон хостов canary - первой части; без batch_size и batch_percent
+	// остальные хосты выполняются одной частью
+	Canary string `json:"canary,omitempty"`
+	// Playbook, проверяющий хосты canary перед продолжением
+	VerifyPlaybook string `json:"verify_playbook,omitempty"`
+	// Продолжать после canary только после POST /api/rollouts/{id}/approve
+	RequireApproval bool `json:"require_approval,omitempty"`
+}
+
+func (s *RollingStrategy) validate(t *tenant, req PlaybookRequest) error {
+	if s == nil {
+		return nil
+	}
+	if s.BatchSize < 0 || s.BatchPercent < 0 || s.BatchPercent > 100 || (s.BatchSize > 0 && s.BatchPercent > 0) ||
+		(s.Canary == "" && s.BatchSize == 0 && s.BatchPercent == 0) {
+		return fmt.Errorf("rolling requires either batch_size or batch_percent from 1 to 100")
+	}
+	if err := s.validateCanary(t); err != nil {
+		return err
+	}
+	if s.Pause != "" {
+		if pause, ok := parseDurationParam(s.Pause); !ok || pause < 0 {
+			return fmt.Errorf("rolling pause must be a number of seconds or a duration")
+		}
+	}
+	if s.MaxFailedHosts < 0 {
+		return fmt.Errorf("max_failed_hosts must not be negative")
+	}
+	if req.Localhost {
+		return fmt.Errorf("rolling cannot be combined with localhost")
+	}
+	return nil
+}
+
+// Rollout - поэтапное выполнение playbook, созданное запросом с rolling
+type Rollout struct {
+	ID        uint     `gorm:"primaryKey" json:"id"`
+	Playbook  string   `gorm:"type:text;not null" json:"playbook"`
+	Inventory string   `gorm:"type:text" json:"inventory,omitempty"`
+	Hosts     JSONList `gorm:"type:jsonb" json:"hosts"`
+	BatchSize int      `gorm:"not null" json:"batch_size"`
+	Batches   int      `gorm:"not null" json:"batches"`
+	// Пауза между частями в секундах
+	Pause          float64       `gorm:"type:decimal;not null;default:0" json:"pause"`
+	MaxFailedHosts int           `gorm:"not null;default:0" json:"max_failed_hosts"`
+	Status         RolloutStatus `gorm:"type:text;not null" json:"status"`
+	// Число хостов canary в начале Hosts, проверка и подтверждение после него
+	CanaryHosts     int    `gorm:"not null;default:0" json:"canary_hosts,omitempty"`
+	VerifyPlaybook  string `gorm:"type:text" json:"verify_playbook,omitempty"`
+	RequireApproval bool   `gorm:"not null;default:false" json:"require_approval,omitempty"`
+	// Выполняемая или последняя выполненная часть, начиная с 1
+	CurrentBatch int    `gorm:"not null;default:0" json:"current_batch"`
+	FailedHosts  int    `gorm:"not null;default:0" json:"failed_hosts"`
+	Error        string `gorm:"type:text" json:"error,omitempty"`
+	TriggeredBy  string `gorm:"type:text" json:"triggered_by,omitempty"`
+
+	CreatedAt  time.Time  `gorm:"type:timestamptz;not null" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"type:timestamptz" json:"updated_at"`
+	FinishedAt *time.Time `gorm:"type:timestamptz" json:"finished_at,omitempty"`
+
+	// Запуски частей (только в деталях rollout)
+	Runs []PlaybookRun `gorm:"-" json:"runs,omitempty"`
+}
+
+type RolloutsResponse struct {
+	Rollouts    []Rollout `json:"rollouts"`
+	TotalCount  int       `json:"total_count"`
+	CurrentPage int       `json:"current_page"`
+	TotalPages  int       `json:"total_pages"`
+	PerPage     int       `json:"per_page"`
+}
+
+// batchHosts - хосты части batch (с 1); при canary первая часть - его хосты
+func (r Rollout) batchHosts(batch int) []string {
+	start, size := (batch-1)*r.BatchSize, r.BatchSize
+	if r.CanaryHosts > 0 {
+		start = r.CanaryHosts + (batch-2)*r.BatchSize
+		if batch == 1 {
+			start, size = 0, r.CanaryHosts
+		}
+	}
+	end := min(start+size, len(r.Hosts))
+	if start < 0 || start >= end {
+		return nil
+	}
+	return r.Hosts[start:end]
+}
+
+// listPlaybookHosts возвращает хосты, на которых выполнится playbook запроса,
+// по ansible-playbook --list-hosts с учетом hosts: в play и limit запроса
+func listPlaybookHosts(t *tenant, req PlaybookRequest) ([]string, error) {
+	installation, err := selectAnsibleInstallation(req)
+	if err != nil {
+		return nil, err
+	}
+	args, removeInventory, err := ansibleArgs(ansibleInvocation{
+		Tenant:       t,
+		Installation: installation,
+		PlaybookPath: filepath.Join(t.PlaybooksDir, req.Playbook),
+		Inventory:    req.Inventory,
+		ExtraVars:    req.ExtraVars,
+		Hosts:        req.Hosts,
+		HostVars:     req.HostVars,
+		Limit:        req.Limit,
+
+		InventoryParams: req.InventoryParams,
+		HostTags:        req.HostTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer removeInventory()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rolloutListHostsTimeout)
+	defer cancel()
+	cmd, cleanup, err := newAnsibleCommand(ctx, installation, append(args, "--list-hosts"))
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %v: %s", err, strings.TrimSpace(output.String()))
+	}
+	return parseListHosts(output.String()), nil
+}
+
+// parseListHosts разбирает вывод --list-hosts: хосты всех play без повторов
+func parseListHosts(output string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	inHosts := false
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "hosts ("):
+			inHosts = true
+		case trimmed == "" || strings.HasPrefix(trimmed, "play #"):
+			inHosts = false
+		case inHosts && !seen[trimmed]:
+			seen[trimmed] = true
+			hosts = append(hosts, trimmed)
+		}
+	}
+	return hosts
+}
+
+// errRolloutHosts - не удалось определить хосты rollout по запросу
+var errRolloutHosts = errors.New("cannot determine rollout hosts")
+
+// queueRollout создает rollout и ставит в очередь запуск его первой части
+func queueRollout(t *tenant, req PlaybookRequest, triggeredBy, peerAddr string) (Rollout, PlaybookRun, error) {
+	hosts, err := listPlaybookHosts(t, req)
+	if err != nil {
+		return Rollout{}, PlaybookRun{}, fmt.Errorf("%w: %v", errRolloutHosts, err)
+	}
+	if len(hosts) == 0 {
+		return Rollout{}, PlaybookRun{}, fmt.Errorf("%w: playbook matches no hosts", errRolloutHosts)
+	}
+
+	strategy := req.Rolling
+	canaryHosts := 0
+	if strategy.Canary != "" {
+		if hosts, canaryHosts, err = orderCanaryHosts(t, req, hosts); err != nil {
+			return Rollout{}, PlaybookRun{}, err
+		}
+	}
+	// Части делят хосты после canary; без размера части они выполняются одной
+	remaining := len(hosts) - canaryHosts
+	batchSize := max(remaining, 1)
+	switch {
+	case strategy.BatchSize > 0:
+		batchSize = strategy.BatchSize
+	case strategy.BatchPercent > 0:
+		// Округление вверх: хотя бы один хост в части
+		batchSize = (remaining*strategy.BatchPercent + 99) / 100
+	}
+	batches := (remaining + batchSize - 1) / batchSize
+	if canaryHosts > 0 {
+		batches++
+	}
+	pause, _ := parseDurationParam(strategy.Pause)
+	rollout := Rollout{
+		Playbook:        req.Playbook,
+		Inventory:       req.Inventory,
+		Hosts:           hosts,
+		BatchSize:       batchSize,
+		Batches:         batches,
+		Pause:           pause,
+		MaxFailedHosts:  strategy.MaxFailedHosts,
+		Status:          RolloutRunning,
+		CanaryHosts:     canaryHosts,
+		VerifyPlaybook:  strategy.VerifyPlaybook,
+		RequireApproval: strategy.RequireApproval,
+		CurrentBatch:    1,
+		TriggeredBy:     triggeredBy,
+	}
+	if err := t.db().Create(&rollout).Error; err != nil {
+		return Rollout{}, PlaybookRun{}, err
+	}
+
+	req.Rolling = nil
+	req.Limit = strings.Join(rollout.batchHosts(1), ",")
+	req.RolloutID = &rollout.ID
+	req.RolloutBatch = 1
+	run, err := queuePlaybookRun(t, req, triggeredBy, peerAddr)
+	if err != nil {
+		finishRollout(t, rollout.ID, 1, RolloutAborted, fmt.Sprintf("failed to queue batch 1: %v", err))
+		return Rollout{}, PlaybookRun{}, err
+	}
+	return rollout, run, nil
+}
+
+// advanceRollout подводит итог части rollout по завершившемуся запуску и
+// ставит следующую часть с паузой или завершает rollout. Хосты, упавшие по
+// PLAY RECAP, суммируются; если запуск не завершился успешно, а упавших хостов
+// в выводе нет, упавшими считаются все хосты части. Любой упавший хост canary
+// останавливает rollout.
+func advanceRollout(t *tenant, runID uint, status PlaybookRunStatus, output string) {
+	var run PlaybookRun
+	if err := t.primaryDB().First(&run, runID).Error; err != nil {
+		log.Printf("Failed to load run %d for rollout: %v", runID, err)
+		return
+	}
+	if run.RolloutID == nil {
+		return
+	}
+	var rollout Rollout
+	if err := t.primaryDB().First(&rollout, *run.RolloutID).Error; err != nil {
+		log.Printf("Failed to load rollout %d: %v", *run.RolloutID, err)
+		return
+	}
+	if run.RolloutVerify {
+		finishVerification(t, rollout, run, status)
+		return
+	}
+	if rollout.Status != RolloutRunning || run.RolloutBatch != rollout.CurrentBatch {
+		return
+	}
+	batch := run.RolloutBatch
+
+	if status == RunStatusCancelled {
+		finishRollout(t, rollout.ID, batch, RolloutCancelled, fmt.Sprintf("run %d of batch %d cancelled", run.ID, batch))
+		return
+	}
+	counts := recapHostCounts(output)
+	failed := counts.Failed + counts.Unreachable
+	if status != RunStatusCompleted && failed == 0 {
+		failed = len(rollout.batchHosts(batch))
+	}
+	if failed > 0 {
+		if err := t.db().Model(&Rollout{}).Where("id = ?", rollout.ID).
+			Update("failed_hosts", gorm.Expr("failed_hosts + ?", failed)).Error; err != nil {
+			log.Printf("Failed to record failed hosts of rollout %d: %v", rollout.ID, err)
+		}
+	}
+
+	canary := batch == 1 && rollout.CanaryHosts > 0
+	switch {
+	case canary && failed > 0:
+		finishRollout(t, rollout.ID, batch, RolloutAborted, fmt.Sprintf("canary failed on %d of %d hosts", failed, rollout.CanaryHosts))
+	case rollout.FailedHosts+failed > rollout.MaxFailedHosts:
+		finishRollout(t, rollout.ID, batch, RolloutAborted, fmt.Sprintf("%d hosts failed by batch %d of %d, max_failed_hosts is %d",
+			rollout.FailedHosts+failed, batch, rollout.Batches, rollout.MaxFailedHosts))
+	case canary && rollout.VerifyPlaybook != "":
+		startVerification(t, rollout, run)
+	case canary:
+		promoteCanary(t, rollout, run, RolloutRunning)
+	case batch >= rollout.Batches:
+		finishRollout(t, rollout.ID, batch, RolloutCompleted, "")
+	default:
+		queueRolloutBatch(t, rollout, run, batch+1, RolloutRunning, true)
+	}
+}
+
+// queueRolloutBatch ставит часть batch, с паузой rollout, если pause. Номер
+// части переключается условием на предыдущий и статус from, чтобы часть не
+// была поставлена дважды; иначе возвращается errRolloutChanged.
+func queueRolloutBatch(t *tenant, rollout Rollout, previous PlaybookRun, batch int, from RolloutStatus, pause bool) (PlaybookRun, error) {
+	result := t.db().Model(&Rollout{}).
+		Where("id = ? AND status = ? AND current_batch = ?", rollout.ID, from, batch-1).
+		Updates(map[string]interface{}{"status": RolloutRunning, "current_batch": batch})
+	if result.Error != nil {
+		log.Printf("Failed to advance rollout %d: %v", rollout.ID, result.Error)
+		return PlaybookRun{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return PlaybookRun{}, errRolloutChanged
+	}
+
+	next := repeatRun(previous)
+	next.Limit = strings.Join(rollout.batchHosts(batch), ",")
+	next.RolloutBatch = batch
+	next.Attempt = 1
+	var notBefore time.Time
+	if pause {
+		notBefore = time.Now().Add(time.Duration(rollout.Pause * float64(time.Second)))
+	}
+	if err := enqueueRunAfter(t, &next, notBefore); err != nil {
+		log.Printf("Failed to queue batch %d of rollout %d: %v", batch, rollout.ID, err)
+		finishRollout(t, rollout.ID, batch, RolloutAborted, fmt.Sprintf("failed to queue batch %d: %v", batch, err))
+		return PlaybookRun{}, err
+	}
+	log.Printf("Rollout %d: batch %d of %d queued as run %d", rollout.ID, batch, rollout.Batches, next.ID)
+	return next, nil
+}
+
+// finishRollout переводит незавершенный rollout в итоговый статус
+func finishRollout(t *tenant, id uint, batch int, status RolloutStatus, errorMsg string) {
+	err := t.db().Model(&Rollout{}).Where("id = ? AND status IN ?", id, activeRolloutStatuses).Updates(map[string]interface{}{
+		"status":        status,
+		"current_batch": batch,
+		"error":         errorMsg,
+		"finished_at":   time.Now(),
+	}).Error
+	if err != nil {
+		log.Printf("Failed to finish rollout %d: %v", id, err)
+		return
+	}
+	log.Printf("Rollout %d %s at batch %d", id, status, batch)
+}
+
+// writeRolloutAccepted отвечает на запрос с rolling
+func writeRolloutAccepted(w http.ResponseWriter, rollout Rollout, run PlaybookRun) {
+	response := map[string]interface{}{
+		"status":     "accepted",
+		"message":    "rollout started",
+		"rollout_id": rollout.ID,
+		"run_id":     run.ID,
+		"batches":    rollout.Batches,
+		"hosts":      len(rollout.Hosts),
+	}
+	if run.Warning != "" {
+		setDeprecationHeaders(w, run.Warning)
+		response["warning"] = run.Warning
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func listRolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	pager := parsePagination(r)
+	query := tenantOf(r).db().Model(&Rollout{})
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	rollouts := []Rollout{}
+	if err := listPage(query, pager, "id DESC", &rollouts); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RolloutsResponse{
+		Rollouts:    rollouts,
+		TotalCount:  pager.TotalCount,
+		CurrentPage: pager.Page,
+		TotalPages:  pager.TotalPages,
+		PerPage:     pager.PerPage,
+	})
+}
+
+// loadRollout загружает rollout из пути запроса; при ошибке отвечает сам
+func loadRollout(w http.ResponseWriter, r *http.Request) (*tenant, *Rollout, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rollout ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	t := tenantOf(r)
+	var rollout Rollout
+	if err := t.primaryDB().First(&rollout, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Rollout not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return nil, nil, false
+	}
+	return t, &rollout, true
+}
+
+// getRolloutHandler отдает rollout с запусками его частей (без вывода)
+func getRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	t, rollout, ok := loadRollout(w, r)
+	if !ok {
+		return
+	}
+	if err := t.db().Select("id", "created_at", "updated_at", "playbook", "inventory", "limit", "status", "start_time", "end_time",
+		"duration", "error", "rollout_id", "rollout_batch", "rollout_verify", "retry_of", "attempt").
+		Where("rollout_id = ?", rollout.ID).Order("id").Find(&rollout.Runs).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rollout)
+}
+
+// cancelRolloutHandler останавливает rollout: следующие части не ставятся,
+// запуск текущей части или проверки отменяется. Для rollout, ожидающего
+// подтверждения, это отклонение.
+func cancelRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	t, rollout, ok := loadRollout(w, r)
+	if !ok || !requirePlaybookRole(w, r, roleOperator, rollout.Playbook) {
+		return
+	}
+	result := t.db().Model(&Rollout{}).Where("id = ? AND status IN ?", rollout.ID, activeRolloutStatuses).Updates(map[string]interface{}{
+		"status":      RolloutCancelled,
+		"error":       "rollout cancelled by request",
+		"finished_at": time.Now(),
+	})
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Rollout is already finished", http.StatusConflict)
+		return
+	}
+
+	var active []PlaybookRun
+	if err := t.primaryDB().Where("rollout_id = ? AND status IN ?", rollout.ID, []PlaybookRunStatus{RunStatusQueued, RunStatusStarted}).
+		Find(&active).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	runIDs := []uint{}
+	for _, run := range active {
+		if _, err := cancelRun(t, run); err != nil {
+			writeDBError(w, err)
+			return
+		}
+		runIDs = append(runIDs, run.ID)
+	}
+	log.Printf("Rollout %d cancelled", rollout.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rollout_id": rollout.ID,
+		"status":     RolloutCancelled,
+		"run_ids":    runIDs,
+	})
+}
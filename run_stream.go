@@ -0,0 +1,135 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// RunStreamMessage - сообщение WebSocket /api/runs/{id}/stream: строка вывода
+// (type output) или итог запуска (type end), после которого соединение закрывается
+type RunStreamMessage struct {
+	Type   string            `json:"type"`
+	Seq    int               `json:"seq,omitempty"`
+	Line   string            `json:"line,omitempty"`
+	Status PlaybookRunStatus `json:"status,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// runStreamHandler - WebSocket /api/runs/{id}/stream: строки вывода запуска по
+// мере выполнения, затем итог. Строки читаются из БД, поэтому поток работает
+// для запусков любого узла и агентов; задержка - logging.output_flush_interval.
+// after=<seq> продолжает поток после уже полученной строки.
+func runStreamHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	var after int
+	if value := r.URL.Query().Get("after"); value != "" {
+		if after, err = strconv.Atoi(value); err != nil || after < 0 {
+			http.Error(w, "after must be a non-negative line number", http.StatusBadRequest)
+			return
+		}
+	}
+	t := tenantOf(r)
+	var run PlaybookRun
+	if err := t.primaryDB().Select("id", "status").First(&run, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту ошибкой
+		return
+	}
+	defer conn.Close()
+	closed := watchClose(conn)
+
+	send := func(msg RunStreamMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(eventsWriteWait))
+		return conn.WriteJSON(msg) == nil
+	}
+	closeWith := func(code int, text string) {
+		conn.SetWriteDeadline(time.Now().Add(eventsWriteWait))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+	}
+
+	seq := after
+	// sendLines отправляет строки, сохраненные после уже отправленных
+	sendLines := func() error {
+		var chunks []RunOutputChunk
+		if err := t.primaryDB().Where("run_id = ? AND seq > ?", run.ID, seq).Order("seq").Find(&chunks).Error; err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			if !send(RunStreamMessage{Type: "output", Seq: chunk.Seq, Line: chunk.Line}) {
+				return errStreamClosed
+			}
+			seq = chunk.Seq
+		}
+		return nil
+	}
+
+	poll := time.NewTicker(waitPollInterval)
+	defer poll.Stop()
+	ping := time.NewTicker(eventsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		if err := sendLines(); err != nil {
+			if !errors.Is(err, errStreamClosed) {
+				closeWith(websocket.CloseInternalServerErr, "failed to read output")
+			}
+			return
+		}
+		var finished PlaybookRun
+		if err := t.primaryDB().Select("id", "status", "error", "output").First(&finished, run.ID).Error; err != nil {
+			closeWith(websocket.CloseInternalServerErr, "failed to read run status")
+			return
+		}
+		if finished.Status != RunStatusQueued && finished.Status != RunStatusStarted {
+			// Строки, сохраненные между чтением вывода и статуса
+			if err := sendLines(); err != nil {
+				return
+			}
+			// Запуск, не дошедший до ansible, вывода по строкам не имеет
+			if seq == 0 && finished.Output != "" {
+				for i, line := range strings.Split(strings.TrimRight(finished.Output, "\n"), "\n") {
+					if !send(RunStreamMessage{Type: "output", Seq: i + 1, Line: line}) {
+						return
+					}
+				}
+			}
+			send(RunStreamMessage{Type: "end", Status: finished.Status, Error: finished.Error})
+			closeWith(websocket.CloseNormalClosure, fmt.Sprintf("run %s", finished.Status))
+			return
+		}
+
+		select {
+		case <-closed:
+			return
+		case <-poll.C:
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+var errStreamClosed = errors.New("stream closed")
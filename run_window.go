@@ -0,0 +1,123 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"ansible-api/config"
+)
+
+// runWindow - ограничение из run_windows с разобранным окном
+type runWindow struct {
+	config.RunWindow
+	window *timeWindow
+}
+
+var runWindows []runWindow
+
+func loadRunWindows() error {
+	runWindows = nil
+	for i, c := range cfg.RunWindows {
+		if c.Playbook == "" {
+			return fmt.Errorf("run window %d: playbook is required", i+1)
+		}
+		if c.Window == nil && c.Cooldown <= 0 {
+			return fmt.Errorf("run window %s: window or positive cooldown is required", c.Playbook)
+		}
+		w := runWindow{RunWindow: c}
+		if c.Window != nil {
+			window, err := parseTimeWindow(*c.Window)
+			if err != nil {
+				return fmt.Errorf("run window %s: %v", c.Playbook, err)
+			}
+			w.window = window
+		}
+		runWindows = append(runWindows, w)
+	}
+	return nil
+}
+
+func runWindowFor(playbook string) (runWindow, bool) {
+	for _, w := range runWindows {
+		if ok, _ := path.Match(w.Playbook, playbook); ok {
+			return w, true
+		}
+	}
+	return runWindow{}, false
+}
+
+// runWindowError - запуск запрещен окном или паузой между запусками
+type runWindowError struct {
+	Playbook  string
+	Reason    string // window или cooldown
+	AllowedAt time.Time
+}
+
+func (e *runWindowError) Error() string {
+	if e.Reason == "cooldown" {
+		return fmt.Sprintf("playbook %s is in cooldown, next run is allowed at %s", e.Playbook, e.AllowedAt.Format(time.RFC3339))
+	}
+	if e.AllowedAt.IsZero() {
+		return fmt.Sprintf("playbook %s may run only within its run window", e.Playbook)
+	}
+	return fmt.Sprintf("playbook %s may run only within its run window, next window opens at %s", e.Playbook, e.AllowedAt.Format(time.RFC3339))
+}
+
+// nextStart - ближайший момент не раньше at, попадающий в окно; нулевое
+// время, если окно не открывается в течение недели (пустой интервал)
+func (w *timeWindow) nextStart(at time.Time) time.Time {
+	at = at.Truncate(time.Minute)
+	for i := 0; i <= 8*24*60; i++ {
+		if w.contains(at) {
+			return at
+		}
+		at = at.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// checkRunWindow проверяет окно и паузу playbook из run_windows на момент now.
+// Пауза отсчитывается от постановки последнего запуска playbook в очередь.
+func checkRunWindow(t *tenant, playbook string, now time.Time) error {
+	w, ok := runWindowFor(playbook)
+	if !ok {
+		return nil
+	}
+	if w.window != nil && !w.window.contains(now) {
+		return &runWindowError{Playbook: playbook, Reason: "window", AllowedAt: w.window.nextStart(now)}
+	}
+	if w.Cooldown <= 0 {
+		return nil
+	}
+	var last *time.Time
+	if err := t.primaryDB().Model(&PlaybookRun{}).Where("playbook = ?", playbook).
+		Select("MAX(start_time)").Scan(&last).Error; err != nil {
+		return err
+	}
+	if last != nil && now.Sub(*last) < w.Cooldown {
+		return &runWindowError{Playbook: playbook, Reason: "cooldown", AllowedAt: last.Add(w.Cooldown)}
+	}
+	return nil
+}
+
+// writeRunWindowError отвечает 409 с моментом, когда запуск станет возможен
+func writeRunWindowError(w http.ResponseWriter, err *runWindowError) {
+	response := map[string]interface{}{
+		"error":    err.Error(),
+		"playbook": err.Playbook,
+		"reason":   err.Reason,
+	}
+	if !err.AllowedAt.IsZero() {
+		response["allowed_at"] = err.AllowedAt.UTC()
+		seconds := math.Ceil(time.Until(err.AllowedAt).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(seconds), 1)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,251 @@
+package ansibleapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ansible-api/config"
+)
+
+// Рабочий каталог на узле по умолчанию
+const defaultRunnerWorkDir = "/var/tmp/ansible-api"
+
+// Сколько ждать подготовки рабочего каталога и его удаления
+const runnerCommandTimeout = 2 * time.Minute
+
+// Путь передается scp без кавычек: современный scp не разбирает его shell'ом, старый - разбирает
+var runnerWorkDirRe = regexp.MustCompile(`^/[A-Za-z0-9._/-]*$`)
+
+// remoteRunner - узел, на котором ansible-playbook выполняется по SSH
+type remoteRunner struct {
+	config.RemoteRunner
+}
+
+var (
+	remoteRunners = make(map[string]*remoteRunner)
+	// Узлы в порядке конфигурации: первый подходящий по шаблону playbook выигрывает
+	remoteRunnerList []*remoteRunner
+)
+
+// loadRemoteRunners проверяет узлы из ansible.runners. Доступность узлов
+// не проверяется: недоступный узел роняет только свои запуски.
+func loadRemoteRunners() error {
+	remoteRunners = make(map[string]*remoteRunner)
+	remoteRunnerList = nil
+
+	for _, c := range cfg.Ansible.Runners {
+		if c.Name == "" || remoteRunners[c.Name] != nil {
+			return fmt.Errorf("runner %q: empty or duplicate name", c.Name)
+		}
+		if c.Host == "" {
+			return fmt.Errorf("runner %s: host is required", c.Name)
+		}
+		r := &remoteRunner{RemoteRunner: c}
+		if r.WorkDir == "" {
+			r.WorkDir = defaultRunnerWorkDir
+		}
+		if !runnerWorkDirRe.MatchString(r.WorkDir) {
+			return fmt.Errorf("runner %s: work_dir must be an absolute path of letters, digits and ._-", c.Name)
+		}
+		if r.AnsiblePlaybook == "" {
+			r.AnsiblePlaybook = "ansible-playbook"
+		}
+		remoteRunners[c.Name] = r
+		remoteRunnerList = append(remoteRunnerList, r)
+	}
+	return nil
+}
+
+// selectRemoteRunner выбирает узел для запуска: указанный в запросе или первый
+// подходящий по шаблону playbook. nil - запуск выполняется на сервере API.
+func selectRemoteRunner(req PlaybookRequest) (*remoteRunner, error) {
+	runner, err := matchRemoteRunner(req)
+	if err != nil || runner == nil {
+		return nil, err
+	}
+	// Установки ansible описывают каталоги сервера API, на узле используется его ansible
+	if req.Ansible != "" {
+		return nil, fmt.Errorf("ansible installation cannot be selected for runs on runner %s", runner.Name)
+	}
+	return runner, nil
+}
+
+func matchRemoteRunner(req PlaybookRequest) (*remoteRunner, error) {
+	// Явно выбранный агент важнее шаблонов узлов
+	if req.Agent != "" && req.Runner == "" {
+		return nil, nil
+	}
+	if req.Runner != "" {
+		runner := remoteRunners[req.Runner]
+		if runner == nil {
+			return nil, fmt.Errorf("unknown runner %q", req.Runner)
+		}
+		return runner, nil
+	}
+	for _, runner := range remoteRunnerList {
+		for _, pattern := range runner.Playbooks {
+			if ok, _ := path.Match(pattern, req.Playbook); ok {
+				return runner, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// remoteRunnerFor возвращает узел, выбранный при постановке запуска в очередь
+func remoteRunnerFor(name string) (*remoteRunner, error) {
+	if name == "" {
+		return nil, nil
+	}
+	runner := remoteRunners[name]
+	if runner == nil {
+		return nil, fmt.Errorf("runner %q is no longer configured", name)
+	}
+	return runner, nil
+}
+
+// target - адрес узла для ssh/scp
+func (r *remoteRunner) target() string {
+	if r.User == "" {
+		return r.Host
+	}
+	return r.User + "@" + r.Host
+}
+
+// options - общие опции ssh и scp. BatchMode не дает ssh ждать ввода пароля.
+func (r *remoteRunner) options(portFlag string) []string {
+	opts := []string{"-o", "BatchMode=yes"}
+	if r.Port != 0 {
+		opts = append(opts, portFlag, strconv.Itoa(r.Port))
+	}
+	if r.IdentityFile != "" {
+		opts = append(opts, "-i", r.IdentityFile)
+	}
+	if r.KnownHostsFile != "" {
+		opts = append(opts, "-o", "UserKnownHostsFile="+r.KnownHostsFile)
+	}
+	for _, opt := range r.SSHOptions {
+		opts = append(opts, "-o", opt)
+	}
+	return opts
+}
+
+// sshCommand - команда ssh, выполняющая script в shell узла
+func (r *remoteRunner) sshCommand(ctx context.Context, script string) *exec.Cmd {
+	args := append(r.options("-p"), r.target(), script)
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// exec выполняет короткую служебную команду на узле
+func (r *remoteRunner) exec(script string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), runnerCommandTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := r.sshCommand(ctx, script)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("runner %s: %v: %s", r.Name, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// copy копирует локальный файл или каталог в remotePath на узле
+func (r *remoteRunner) copy(localPath, remotePath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), runnerCommandTimeout)
+	defer cancel()
+
+	args := append(r.options("-P"), "-q", "-r", "-p", localPath, r.target()+":"+remotePath)
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "scp", args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("runner %s: failed to copy %s: %v: %s", r.Name, filepath.Base(localPath), err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// runRemotePlaybook копирует playbooks арендатора, инвентарь и файл пароля vault
+// в отдельный каталог на узле и выполняет там ansible-playbook, передавая вывод
+// по мере выполнения. args - аргументы локального запуска из ansibleArgs.
+// Каталог удаляется после завершения, вместе с оставшимися процессами запуска.
+func runRemotePlaybook(ctx context.Context, inv ansibleInvocation, args []string) (string, error) {
+	runner := inv.Runner
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	name := "run-" + hex.EncodeToString(suffix)
+	dir := path.Join(runner.WorkDir, name)
+
+	if err := runner.exec("umask 077 && mkdir -p " + shellQuote(dir)); err != nil {
+		return "", err
+	}
+	defer func() {
+		// Имя в скобках, чтобы pkill не нашел собственный shell
+		pattern := path.Join(runner.WorkDir, "["+name[:1]+"]"+name[1:])
+		if err := runner.exec(fmt.Sprintf("pkill -f %s; rm -rf %s", shellQuote(pattern), shellQuote(dir))); err != nil {
+			log.Printf("Failed to clean up remote work directory %s: %v", dir, err)
+		}
+	}()
+
+	playbooksDir := path.Join(dir, "playbooks")
+	if err := runner.copy(inv.Tenant.PlaybooksDir, playbooksDir); err != nil {
+		return "", err
+	}
+
+	remoteArgs := make([]string, len(args))
+	copy(remoteArgs, args)
+	remoteArgs[0] = runner.AnsiblePlaybook
+	rel, err := filepath.Rel(inv.Tenant.PlaybooksDir, inv.PlaybookPath)
+	if err != nil {
+		return "", err
+	}
+	remoteArgs[1] = path.Join(playbooksDir, filepath.ToSlash(rel))
+	for i := 2; i < len(remoteArgs)-1; i++ {
+		var remotePath string
+		switch remoteArgs[i] {
+		case "-i":
+			remotePath = path.Join(dir, "inventory.ini")
+		case "--vault-password-file":
+			remotePath = path.Join(dir, "vault-password")
+		default:
+			continue
+		}
+		if err := runner.copy(remoteArgs[i+1], remotePath); err != nil {
+			return "", err
+		}
+		remoteArgs[i+1] = remotePath
+		i++
+	}
+
+	quoted := make([]string, len(remoteArgs))
+	for i, arg := range remoteArgs {
+		quoted[i] = shellQuote(arg)
+	}
+	// Относительные пути (retry-файлы и т.п.) остаются в каталоге запуска
+	script := "cd " + shellQuote(dir) + " && exec env " + showCustomStatsEnv + " " + strings.Join(quoted, " ")
+	cmd := runner.sshCommand(ctx, script)
+	configureProcessGroup(cmd)
+	// rusage процесса ssh не отражает ресурсы ansible-playbook на узле
+	inv.OnExit = nil
+	return startAnsibleCommand(cmd, inv)
+}
+
+// shellQuote заключает строку в одинарные кавычки для sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
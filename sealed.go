@@ -0,0 +1,166 @@
+package ansibleapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "[redacted]"
+
+var errNoEncryptionKey = errors.New("sensitive extra_vars require secrets.encryption_key")
+
+func sealingKey() ([]byte, error) {
+	if cfg.Secrets.EncryptionKey == "" {
+		return nil, errNoEncryptionKey
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.Secrets.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets.encryption_key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets.encryption_key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func newSealingAEAD() (cipher.AEAD, error) {
+	key, err := sealingKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealVars шифрует значения AES-256-GCM; результат - base64(nonce || ciphertext)
+func sealVars(vars map[string]string) (string, error) {
+	aead, err := newSealingAEAD()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openVars(sealed string) (map[string]string, error) {
+	aead, err := newSealingAEAD()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("sealed vars are too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sensitive extra_vars: %v", err)
+	}
+	var vars map[string]string
+	err = json.Unmarshal(plaintext, &vars)
+	return vars, err
+}
+
+// validateSensitiveVars проверяет sensitive_vars запроса до постановки в очередь
+func validateSensitiveVars(req PlaybookRequest) error {
+	for _, key := range req.SensitiveVars {
+		if _, ok := req.ExtraVars[key]; !ok {
+			return fmt.Errorf("sensitive var %q is not in extra_vars", key)
+		}
+	}
+	if sealed := sensitiveVarKeys(req.ExtraVars, req.SensitiveVars); len(sealed) > 0 {
+		if _, err := sealingKey(); err != nil {
+			return fmt.Errorf("%v (extra_vars %s)", err, strings.Join(sealed, ", "))
+		}
+	}
+	return nil
+}
+
+// sensitiveVarKeys - ключи extra_vars, которые не хранятся открыто: из
+// sensitive_vars запроса и подходящие под secrets.sensitive_keys
+func sensitiveVarKeys(extraVars map[string]string, sensitive []string) []string {
+	var keys []string
+	for key := range extraVars {
+		if isSensitiveKey(key) || slices.Contains(sensitive, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitSensitiveVars заменяет значения помеченных ключей и ключей по
+// secrets.sensitive_keys на [redacted] и возвращает зашифрованные настоящие
+// значения для исполнителя
+func splitSensitiveVars(extraVars map[string]string, sensitive []string) (JSONMap, string, error) {
+	stored := make(JSONMap, len(extraVars))
+	for k, v := range extraVars {
+		stored[k] = v
+	}
+	for _, key := range sensitive {
+		if _, ok := extraVars[key]; !ok {
+			return nil, "", fmt.Errorf("sensitive var %q is not in extra_vars", key)
+		}
+	}
+	keys := sensitiveVarKeys(extraVars, sensitive)
+	if len(keys) == 0 {
+		return stored, "", nil
+	}
+
+	secret := make(map[string]string, len(keys))
+	for _, key := range keys {
+		secret[key] = extraVars[key]
+		stored[key] = redactedValue
+	}
+
+	sealed, err := sealVars(secret)
+	if err != nil {
+		return nil, "", err
+	}
+	return stored, sealed, nil
+}
+
+// revealJobVars подставляет в запуск настоящие значения помеченных extra_vars
+func revealJobVars(job *runJob) error {
+	if job.SealedVars == "" {
+		return nil
+	}
+	secret, err := openVars(job.SealedVars)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]string, len(job.Request.ExtraVars))
+	for k, v := range job.Request.ExtraVars {
+		vars[k] = v
+	}
+	sensitive := make([]string, 0, len(secret))
+	for k, v := range secret {
+		vars[k] = v
+		sensitive = append(sensitive, k)
+	}
+	job.Request.ExtraVars = vars
+	job.Request.SensitiveVars = sensitive
+	return nil
+}
@@ -0,0 +1,88 @@
+package ansibleapi
+
+import (
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const maskedValue = "********"
+
+// Более короткие значения не маскируются: замена "1" или "yes" испортит весь вывод
+const minSecretLength = 4
+
+// secretMasker заменяет известные секретные значения в тексте
+type secretMasker struct {
+	replacer *strings.Replacer
+}
+
+func newSecretMasker(values []string) *secretMasker {
+	unique := make(map[string]bool)
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			unique[v] = true
+		}
+	}
+	if len(unique) == 0 {
+		return &secretMasker{}
+	}
+
+	// Длинные значения первыми, чтобы секрет, содержащий другой секрет, маскировался целиком
+	sorted := make([]string, 0, len(unique))
+	for v := range unique {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		pairs = append(pairs, v, maskedValue)
+	}
+	return &secretMasker{replacer: strings.NewReplacer(pairs...)}
+}
+
+func (m *secretMasker) Mask(s string) string {
+	if m == nil || m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+// isSensitiveKey проверяет имя extra_var по шаблонам secrets.sensitive_keys
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range cfg.Secrets.SensitiveKeys {
+		if ok, _ := path.Match(strings.ToLower(pattern), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runSecrets собирает значения, которые нужно скрыть в выводе запуска:
+// секретные extra_vars, переменные окружения из secrets.env_vars и пароль vault
+func runSecrets(req PlaybookRequest) []string {
+	var values []string
+	for key, value := range req.ExtraVars {
+		if isSensitiveKey(key) || slices.Contains(req.SensitiveVars, key) {
+			values = append(values, value)
+		}
+	}
+	for _, name := range cfg.Secrets.EnvVars {
+		if value := os.Getenv(name); value != "" {
+			values = append(values, value)
+		}
+	}
+	if cfg.Ansible.VaultPasswordFile != "" {
+		content, err := os.ReadFile(cfg.Ansible.VaultPasswordFile)
+		if err != nil {
+			log.Printf("Failed to read vault password file for masking: %v", err)
+		} else if password := strings.TrimSpace(string(content)); password != "" {
+			values = append(values, password)
+		}
+	}
+	return values
+}
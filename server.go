@@ -0,0 +1,328 @@
+// Package ansibleapi - HTTP API для запуска Ansible playbooks и хранения
+// инвентарей. Сервер можно запустить командой cmd/ansible-api или встроить
+// в другую программу на Go:
+//
+//	srv, err := ansibleapi.New(cfg, ansibleapi.WithSQLDB(pool), ansibleapi.WithCron(scheduler))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	srv.Start()
+//	mux.Handle("/", srv.Handler())
+package ansibleapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+
+	"ansible-api/config"
+)
+
+var (
+	cfg      *config.Config
+	db       *gorm.DB
+	runQueue = newDispatcher()
+)
+
+// Состояние сервера хранится в пакете: кроме cfg, db и runQueue это
+// liveEvents, activeRuns, agentRuns, breaker, readOnly, арендаторы и политики
+// из конфигурации. Подключение к БД и планировщик можно передать в New, но
+// пакеты api/executor/store/scheduler не выделены, поэтому в процессе может
+// быть только один сервер или агент.
+var created atomic.Bool
+
+// Server - сервер API с подключенной и мигрированной БД
+type Server struct {
+	router *mux.Router
+	start  sync.Once
+	http   atomic.Pointer[http.Server]
+
+	// Подключение встраивающей программы; nil - открывается по database.*
+	sqlDB *sql.DB
+	// Планировщик фоновых задач и его задачи; ownCron - создан сервером
+	cron        *cron.Cron
+	ownCron     bool
+	cronEntries []cron.EntryID
+	cronJobs    sync.WaitGroup
+}
+
+// Option передает серверу зависимость вместо создаваемой по конфигурации
+type Option func(*Server)
+
+// WithSQLDB - подключение к PostgreSQL вместо открываемого по database.*:
+// встраивающая программа делит с сервером свой пул. Настройки пула
+// (database.max_open_conns и др.), search_path и statement_timeout
+// подключения остаются за программой; таймаут запросов сервера действует.
+func WithSQLDB(conn *sql.DB) Option {
+	return func(s *Server) { s.sqlDB = conn }
+}
+
+// WithCron - планировщик встраивающей программы для фоновых задач сервера.
+// Запускает и останавливает его программа, Shutdown только снимает задачи
+// сервера, дождавшись выполняющихся.
+func WithCron(c *cron.Cron) Option {
+	return func(s *Server) { s.cron = c }
+}
+
+// New подключается к БД, мигрирует схемы арендаторов и восстанавливает
+// прерванные запуски. Фоновые задачи запускает Start.
+func New(c *config.Config, opts ...Option) (*Server, error) {
+	if !created.CompareAndSwap(false, true) {
+		return nil, errors.New("ansible-api server already created in this process")
+	}
+	cfg = c
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := loadTenants(); err != nil {
+		return nil, fmt.Errorf("invalid tenants configuration: %v", err)
+	}
+	if err := loadTrustedProxies(); err != nil {
+		return nil, err
+	}
+	if err := loadAnsibleInstallations(); err != nil {
+		return nil, err
+	}
+	if err := loadRemoteRunners(); err != nil {
+		return nil, err
+	}
+	if err := validatePythonInterpreter(cfg.Ansible.DefaultPython); err != nil {
+		return nil, fmt.Errorf("ansible.default_python: %v", err)
+	}
+	if err := loadPolicy(); err != nil {
+		return nil, err
+	}
+	if err := validateMetricsPush(); err != nil {
+		return nil, err
+	}
+	if err := loadExpectedSchedules(); err != nil {
+		return nil, err
+	}
+	if err := loadRetryPolicies(); err != nil {
+		return nil, err
+	}
+	if err := loadRollbackPolicies(); err != nil {
+		return nil, err
+	}
+	if err := loadRunWindows(); err != nil {
+		return nil, err
+	}
+	if err := loadCheckSchedules(); err != nil {
+		return nil, err
+	}
+	if err := validateMissingDependencies(); err != nil {
+		return nil, err
+	}
+	readOnly.Store(cfg.Server.ReadOnly)
+	if err := initDB(s.sqlDB); err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	for _, t := range tenantList {
+		if err := migrateTenant(t); err != nil {
+			return nil, fmt.Errorf("failed to migrate schema %s of tenant %s: %v", t.Schema, t.Name, err)
+		}
+	}
+
+	ensurePartitions()
+
+	// Реплика подключается после миграций, чтобы проверки схемы шли в основную БД
+	if err := useReadReplica(); err != nil {
+		return nil, fmt.Errorf("failed to configure read replica: %v", err)
+	}
+
+	recoverInterruptedRuns()
+	restoreCleanupMetrics()
+
+	s.router = newRouter()
+	return s, nil
+}
+
+// Start запускает выполнение очереди, проверку БД и задачи по расписанию.
+// Повторные вызовы ничего не делают.
+func (s *Server) Start() {
+	s.start.Do(func() {
+		if s.cron == nil {
+			s.cron, s.ownCron = cron.New(), true
+		}
+		schedule := func(spec string, job func(), name string) {
+			id, err := s.cron.AddFunc(spec, func() {
+				s.cronJobs.Add(1)
+				defer s.cronJobs.Done()
+				job()
+			})
+			if err != nil {
+				log.Fatalf("Failed to schedule %s: %v", name, err)
+			}
+			s.cronEntries = append(s.cronEntries, id)
+		}
+		schedule("@daily", cleanupOldLogs, "log cleanup")
+		schedule("@every 1m", detectLostRuns, "run watchdog")
+		schedule("@every 1m", checkExpectedSchedules, "schedule watchdog")
+		schedule("@every 30s", runScheduledChecks, "scheduled inventory checks")
+		schedule("@hourly", cleanupTempFiles, "temp file cleanup")
+		schedule("@hourly", archiveWorkDirs, "work directory archiving")
+		schedule("@daily", ensurePartitions, "partition maintenance")
+		schedule("@daily", rollupStats, "stats rollup")
+		if s.ownCron {
+			s.cron.Start()
+		}
+
+		go breaker.probe()
+		go runQueue.Run(executeRun)
+	})
+}
+
+// Handler возвращает обработчик всех маршрутов API и сервиса агентов по
+// gRPC. Для gRPC без TLS http.Server должен принимать HTTP/2 без TLS
+// (Protocols.SetUnencryptedHTTP2).
+func (s *Server) Handler() http.Handler {
+	return withAgentGRPC(s.router)
+}
+
+// ListenAndServe запускает фоновые задачи и HTTP-сервер на server.port.
+// После Shutdown возвращает http.ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	s.Start()
+
+	// HTTP/2 без TLS нужен агентам с agent.transport: grpc
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      s.Handler(),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		Protocols:    &protocols,
+	}
+	s.http.Store(server)
+
+	log.Printf("Server started on :%s", cfg.Server.Port)
+	return server.ListenAndServe()
+}
+
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(gzipMiddleware)
+	r.Use(authMiddleware)
+	r.Use(tenantMiddleware)
+	r.Use(rbacMiddleware)
+	r.Use(readOnlyMiddleware)
+
+	// System endpoints
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	r.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{})).Methods("GET")
+	// WebSocket не оборачивается в standardRoute: TimeoutHandler не поддерживает Hijack
+	r.HandleFunc("/api/events", eventsHandler).Methods("GET")
+	r.HandleFunc("/api/system/status", standardRoute(systemStatusHandler)).Methods("GET")
+	r.HandleFunc("/api/system/drain", standardRoute(requireAdmin(drainHandler))).Methods("POST")
+	r.HandleFunc("/api/system/resume", standardRoute(requireAdmin(resumeHandler))).Methods("POST")
+	r.HandleFunc("/api/system/read-only", standardRoute(requireAdmin(readOnlyHandler))).Methods("POST")
+	r.HandleFunc("/api/system/read-write", standardRoute(requireAdmin(readWriteHandler))).Methods("POST")
+
+	// Auth endpoints: вход пользователей и управление ими
+	r.HandleFunc("/api/auth/login", standardRoute(loginHandler)).Methods("POST")
+	r.HandleFunc("/api/users", standardRoute(requireAdmin(listUsersHandler))).Methods("GET")
+	r.HandleFunc("/api/users", standardRoute(requireAdmin(createUserHandler))).Methods("POST")
+	r.HandleFunc("/api/users/{name}", standardRoute(requireAdmin(updateUserHandler))).Methods("PUT")
+	r.HandleFunc("/api/users/{name}", standardRoute(requireAdmin(deleteUserHandler))).Methods("DELETE")
+	r.HandleFunc("/api/role-bindings", standardRoute(requireAdmin(listRoleBindingsHandler))).Methods("GET")
+	r.HandleFunc("/api/role-bindings", standardRoute(requireAdmin(createRoleBindingHandler))).Methods("POST")
+	r.HandleFunc("/api/role-bindings/{id}", standardRoute(requireAdmin(deleteRoleBindingHandler))).Methods("DELETE")
+
+	// Playbook endpoints
+	r.HandleFunc("/api/run", waitRoute(signedRoute(runPlaybookHandler))).Methods("POST")
+	r.HandleFunc("/api/playbooks", standardRoute(listPlaybooksHandler)).Methods("GET")
+	r.HandleFunc("/api/playbooks/{name}/meta", standardRoute(playbookMetaHandler)).Methods("GET")
+	r.HandleFunc("/api/playbook-lifecycle", standardRoute(listPlaybookLifecyclesHandler)).Methods("GET")
+	r.HandleFunc("/api/playbooks/{name}/lifecycle", standardRoute(getPlaybookLifecycleHandler)).Methods("GET")
+	r.HandleFunc("/api/playbooks/{name}/lifecycle", standardRoute(requirePlaybookAdmin(setPlaybookLifecycleHandler))).Methods("PUT")
+	r.HandleFunc("/api/playbooks/{name}/lifecycle", standardRoute(requirePlaybookAdmin(deletePlaybookLifecycleHandler))).Methods("DELETE")
+	r.HandleFunc("/api/schedules", standardRoute(listSchedulesHandler)).Methods("GET")
+
+	// Log endpoints
+	r.HandleFunc("/api/logs", standardRoute(withETag(listLogsHandler))).Methods("GET")
+	r.HandleFunc("/api/logs", standardRoute(requireAdmin(deleteLogsHandler))).Methods("DELETE")
+	r.HandleFunc("/api/logs/{id}", standardRoute(withETag(getLogHandler))).Methods("GET")
+
+	// Run endpoints
+	r.HandleFunc("/api/runs", standardRoute(withETag(getPlaybookRunsHandler))).Methods("GET")
+	r.HandleFunc("/api/runs", standardRoute(requireAdmin(deleteRunsHandler))).Methods("DELETE")
+	r.HandleFunc("/api/runs/batch", uploadRoute(runBatchHandler)).Methods("POST")
+	r.HandleFunc("/api/rollouts", standardRoute(listRolloutsHandler)).Methods("GET")
+	r.HandleFunc("/api/rollouts/{id}", standardRoute(getRolloutHandler)).Methods("GET")
+	r.HandleFunc("/api/rollouts/{id}/approve", standardRoute(approveRolloutHandler)).Methods("POST")
+	r.HandleFunc("/api/rollouts/{id}/cancel", standardRoute(cancelRolloutHandler)).Methods("POST")
+	r.HandleFunc("/api/runs/batches/{id}", standardRoute(getRunBatchHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{id}", standardRoute(withETag(getPlaybookRunDetailsHandler))).Methods("GET")
+	r.HandleFunc("/api/stats", standardRoute(withETag(statsHandler))).Methods("GET")
+	r.HandleFunc("/api/stats/trends", standardRoute(withETag(statsTrendsHandler))).Methods("GET")
+	r.HandleFunc("/api/stats/rollup", standardRoute(requireAdmin(rollupStatsHandler))).Methods("POST")
+	r.HandleFunc("/api/stats/concurrency", standardRoute(concurrencyHandler)).Methods("GET")
+	r.HandleFunc("/api/stats/eta", standardRoute(etaAccuracyHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{id}/summary", standardRoute(runSummaryHandler)).Methods("GET")
+	// WebSocket живет дольше handler_timeout, поэтому без standardRoute, как /api/events
+	r.HandleFunc("/api/runs/{id}/stream", runStreamHandler).Methods("GET")
+	// Выгрузка пишется по частям: TimeoutHandler копил бы ответ целиком
+	r.HandleFunc("/api/runs/{id}/events/export", withLimits(exportRunEventsHandler, 0, 0)).Methods("GET")
+	r.HandleFunc("/api/runs/{id}/share", standardRoute(shareRunHandler)).Methods("POST")
+	r.HandleFunc("/api/shared/{token}", standardRoute(sharedRunHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{id}/cancel", standardRoute(cancelRunHandler)).Methods("POST")
+	r.HandleFunc("/api/runs/{id}/rollback", standardRoute(approveRollbackHandler)).Methods("POST")
+	r.HandleFunc("/api/runs/{id}/artifacts", uploadRoute(uploadArtifactHandler)).Methods("POST")
+	r.HandleFunc("/api/runs/{id}/artifacts", standardRoute(listArtifactsHandler)).Methods("GET")
+	r.HandleFunc("/api/runs/{id}/artifacts/{name}", uploadRoute(downloadArtifactHandler)).Methods("GET")
+
+	// Agent endpoints: регистрация и обмен с агентами по X-Agent-Token
+	r.HandleFunc("/api/runners", standardRoute(listRunnersHandler)).Methods("GET")
+	r.HandleFunc("/api/agents", standardRoute(listAgentsHandler)).Methods("GET")
+	r.HandleFunc("/api/agents/{name}", standardRoute(requireAdmin(deleteAgentHandler))).Methods("DELETE")
+	r.HandleFunc("/api/agents/register", standardRoute(requireAgent(registerAgentHandler))).Methods("POST")
+	r.HandleFunc("/api/agents/{name}/claim", standardRoute(requireAgent(claimAgentRunHandler))).Methods("POST")
+	r.HandleFunc("/api/agents/{name}/runs/{tenant}/{id}/output", standardRoute(requireAgent(agentOutputHandler))).Methods("POST")
+	r.HandleFunc("/api/agents/{name}/runs/{tenant}/{id}/finish", uploadRoute(requireAgent(finishAgentRunHandler))).Methods("POST")
+	r.HandleFunc("/api/agents/{name}/runs/{tenant}/{id}/bundle", uploadRoute(requireAgent(agentBundleHandler))).Methods("GET")
+
+	// Inventory endpoints
+	r.HandleFunc("/api/inventories", standardRoute(listInventoriesHandler)).Methods("GET")
+	r.HandleFunc("/api/inventories", uploadRoute(createInventoryHandler)).Methods("POST")
+	r.HandleFunc("/api/inventories/{name}", standardRoute(getInventoryHandler)).Methods("GET")
+	r.HandleFunc("/api/inventories/{name}", uploadRoute(updateInventoryHandler)).Methods("PUT")
+	r.HandleFunc("/api/inventories/{name}", standardRoute(deleteInventoryHandler)).Methods("DELETE")
+	r.HandleFunc("/api/inventories/{name}/restore", standardRoute(restoreInventoryHandler)).Methods("POST")
+	r.HandleFunc("/api/inventories/{name}/check", standardRoute(checkInventoryHandler)).Methods("POST")
+	r.HandleFunc("/api/inventories/{name}/check-history", standardRoute(withETag(checkHistoryHandler))).Methods("GET")
+	r.HandleFunc("/api/inventories/{name}/check-schedule", standardRoute(checkScheduleHandler)).Methods("GET")
+
+	r.HandleFunc("/api/ping", standardRoute(requireOperator(pingHandler))).Methods("POST")
+	r.HandleFunc("/api/credentials", standardRoute(listCredentialsHandler)).Methods("GET")
+	r.HandleFunc("/api/credentials", standardRoute(requireAdmin(createCredentialHandler))).Methods("POST")
+	r.HandleFunc("/api/credentials/{id}", standardRoute(requireAdmin(deleteCredentialHandler))).Methods("DELETE")
+	r.HandleFunc("/api/credentials/{id}/validate", standardRoute(requireOperator(validateCredentialHandler))).Methods("POST")
+	r.HandleFunc("/api/hosts", standardRoute(listHostMetadataHandler)).Methods("GET")
+	r.HandleFunc("/api/hosts/{host}", standardRoute(getHostMetadataHandler)).Methods("GET")
+	r.HandleFunc("/api/hosts/{host}", standardRoute(requireAdmin(setHostMetadataHandler))).Methods("PUT")
+	r.HandleFunc("/api/hosts/{host}", standardRoute(requireAdmin(deleteHostMetadataHandler))).Methods("DELETE")
+	r.HandleFunc("/api/host-quarantine", standardRoute(listHostQuarantineHandler)).Methods("GET")
+	r.HandleFunc("/api/hosts/{host}/quarantine", standardRoute(getHostQuarantineHandler)).Methods("GET")
+	r.HandleFunc("/api/hosts/{host}/quarantine", standardRoute(requireAdmin(setHostQuarantineHandler))).Methods("PUT")
+	r.HandleFunc("/api/hosts/{host}/quarantine", standardRoute(requireAdmin(deleteHostQuarantineHandler))).Methods("DELETE")
+
+	// Inventory check endpoints
+	r.HandleFunc("/api/inventory-checks", standardRoute(listInventoryChecksHandler)).Methods("GET")
+	r.HandleFunc("/api/inventory-checks/{id}", standardRoute(getInventoryCheckHandler)).Methods("GET")
+
+	return r
+}
@@ -0,0 +1,169 @@
+package ansibleapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RunShare - ссылка на запуск для доступа без аутентификации
+type RunShare struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signRunShare подписывает токен ссылки: <арендатор>.<id запуска>.<срок unix>.<hmac>
+func signRunShare(tenantName string, runID uint, expires time.Time) string {
+	payload := fmt.Sprintf("%s.%d.%d", tenantName, runID, expires.Unix())
+	mac := hmac.New(sha256.New, []byte(cfg.Auth.ShareSecret))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyRunShare проверяет подпись и срок токена и возвращает арендатора и запуск
+func verifyRunShare(token string) (*tenant, uint, bool) {
+	parts := strings.Split(token, ".")
+	if cfg.Auth.ShareSecret == "" || len(parts) < 4 {
+		return nil, 0, false
+	}
+	// Имя арендатора может содержать точки, остальные части - нет
+	n := len(parts)
+	tenantName := strings.Join(parts[:n-3], ".")
+	runID, err := strconv.ParseUint(parts[n-3], 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+	expires, err := strconv.ParseInt(parts[n-2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, 0, false
+	}
+	expected := signRunShare(tenantName, uint(runID), time.Unix(expires, 0))
+	if !hmac.Equal([]byte(token), []byte(expected)) {
+		return nil, 0, false
+	}
+	t := tenants[tenantName]
+	return t, uint(runID), t != nil
+}
+
+// shareRunHandler выдает ссылку на детали и вывод запуска с ограниченным сроком
+// действия, например для подрядчика без токена API. ttl - секунды или формат 24h.
+func shareRunHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.Auth.ShareSecret == "" {
+		http.Error(w, "Share links are disabled: auth.share_secret is not set", http.StatusNotImplemented)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	ttl := cfg.Auth.ShareTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		seconds, ok := parseDurationParam(value)
+		if !ok || seconds <= 0 {
+			http.Error(w, "ttl must be a positive number of seconds or a duration", http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds * float64(time.Second))
+	}
+	if ttl > cfg.Auth.ShareMaxTTL {
+		http.Error(w, fmt.Sprintf("ttl must not exceed %s", cfg.Auth.ShareMaxTTL), http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	run, err := t.store().GetRun(uint(id))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	// Ссылка открывает запуск без аутентификации: без RBAC ее выдает только администратор
+	if !isAdmin(r) && !(rbacEnabled() && hasRole(r, roleOperator, run.Playbook)) {
+		http.Error(w, fmt.Sprintf("Role operator on playbook %s required", run.Playbook), http.StatusForbidden)
+		return
+	}
+
+	share := RunShare{ExpiresAt: time.Now().Add(ttl).Truncate(time.Second).UTC()}
+	share.Token = signRunShare(t.Name, uint(id), share.ExpiresAt)
+	share.URL = strings.TrimRight(cfg.Server.PublicURL, "/") + "/api/shared/" + share.Token
+	log.Printf("Share link for run %d of tenant %s issued to %s, expires %s",
+		id, t.Name, currentPrincipal(r).Name, share.ExpiresAt.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(share)
+}
+
+// SharedRun - запуск, как его видно по ссылке: статус, время и вывод с
+// замаскированными секретами. Переменные, хосты и сведения о том, откуда и
+// на каком узле запуск выполнен, по ссылке не отдаются.
+type SharedRun struct {
+	ID          uint              `json:"id"`
+	Playbook    string            `json:"playbook"`
+	Status      PlaybookRunStatus `json:"status"`
+	StartTime   time.Time         `json:"start_time"`
+	EndTime     *time.Time        `json:"end_time,omitempty"`
+	Duration    *float64          `json:"duration,omitempty"`
+	Progress    float64           `json:"progress"`
+	Output      string            `json:"output,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	FailedTasks []FailedTask      `json:"failed_tasks,omitempty"`
+}
+
+// sharedRunHandler отдает по ссылке статус, время и вывод запуска
+func sharedRunHandler(w http.ResponseWriter, r *http.Request) {
+	t, id, ok := verifyRunShare(mux.Vars(r)["token"])
+	if !ok {
+		http.Error(w, "Share link is invalid or expired", http.StatusNotFound)
+		return
+	}
+	run, err := t.store().GetRun(id)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+
+	run.fillProgress()
+	if run.Status == RunStatusStarted && run.Output == "" {
+		output, err := runOutputSoFar(t, run.ID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		run.Output = output
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SharedRun{
+		ID:          run.ID,
+		Playbook:    run.Playbook,
+		Status:      run.Status,
+		StartTime:   run.StartTime,
+		EndTime:     run.EndTime,
+		Duration:    run.Duration,
+		Progress:    run.Progress,
+		Output:      run.Output,
+		Error:       run.Error,
+		FailedTasks: parseFailedTasks(run.Output),
+	})
+}
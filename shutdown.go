@@ -0,0 +1,88 @@
+package ansibleapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Сколько после отмены ждать, пока запуски сохранят итог: отмененный
+// ansible-playbook получает SIGKILL через processKillGrace
+const shutdownCancelWait = processKillGrace + 20*time.Second
+
+// Интервал проверки завершения запусков при остановке
+const shutdownPollInterval = 200 * time.Millisecond
+
+// Shutdown останавливает сервер: новые запуски больше не забираются из очереди
+// (поставленные останутся в ней для других узлов или следующего старта),
+// HTTP-сервер перестает принимать соединения, и Shutdown ждет завершения
+// выполняющихся запусков. Когда ctx истекает, оставшиеся запуски отменяются
+// и Shutdown ждет, пока их итог будет сохранен в БД.
+func (s *Server) Shutdown(ctx context.Context) error {
+	runQueue.Drain()
+
+	httpDone := make(chan error, 1)
+	if server := s.http.Load(); server != nil {
+		go func() { httpDone <- server.Shutdown(ctx) }()
+	} else {
+		httpDone <- nil
+	}
+
+	var errs []error
+	if !waitRunsIdle(ctx) {
+		n := cancelActiveRuns(errServerShutdown)
+		log.Printf("Shutdown grace period expired, cancelled %d active run(s)", n)
+		cancelCtx, cancel := context.WithTimeout(context.Background(), shutdownCancelWait)
+		defer cancel()
+		if !waitRunsIdle(cancelCtx) {
+			errs = append(errs, fmt.Errorf("runs did not finish within %s after cancellation", shutdownCancelWait))
+		}
+	}
+
+	if s.cron != nil {
+		// Прерывать начатые задачи по расписанию нельзя, ждем их в пределах ctx
+		select {
+		case <-s.stopCron().Done():
+		case <-ctx.Done():
+		}
+	}
+	if err := <-httpDone; err != nil {
+		errs = append(errs, fmt.Errorf("http server: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// stopCron останавливает планировщик сервера, а у планировщика из WithCron
+// снимает задачи сервера. Контекст завершается, когда выполняющиеся задачи
+// сервера закончатся.
+func (s *Server) stopCron() context.Context {
+	if s.ownCron {
+		return s.cron.Stop()
+	}
+	for _, id := range s.cronEntries {
+		s.cron.Remove(id)
+	}
+	ctx, done := context.WithCancel(context.Background())
+	go func() {
+		s.cronJobs.Wait()
+		done()
+	}()
+	return ctx
+}
+
+// waitRunsIdle ждет, пока на узле не останется выполняющихся запусков;
+// false - раньше истек ctx
+func waitRunsIdle(ctx context.Context) bool {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !runQueue.idle() {
+		select {
+		case <-ctx.Done():
+			return runQueue.idle()
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -0,0 +1,311 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RunDailyStat - итоги завершенных запусков playbook за сутки (UTC). Таблица
+// пересчитывается фоновой задачей, чтобы статистика не читала сырые запуски.
+type RunDailyStat struct {
+	Day       time.Time `gorm:"type:date;primaryKey" json:"day"`
+	Playbook  string    `gorm:"type:text;primaryKey" json:"playbook"`
+	Runs      int64     `gorm:"not null" json:"runs"`
+	Completed int64     `gorm:"not null" json:"completed"`
+	Failed    int64     `gorm:"not null" json:"failed"`
+	// Отмененные, прерванные по таймауту, потерянные и прерванные перезапуском
+	Aborted int64 `gorm:"not null" json:"aborted"`
+	// Запуски, изменившие хотя бы один хост, и успешные, не изменившие ни одного
+	Changed       int64    `gorm:"not null;default:0" json:"changed"`
+	Unchanged     int64    `gorm:"not null;default:0" json:"unchanged"`
+	TotalDuration float64  `gorm:"not null" json:"total_duration"`
+	MinDuration   *float64 `json:"min_duration,omitempty"`
+	MaxDuration   *float64 `json:"max_duration,omitempty"`
+	// Процессорное время, размер вывода и наибольший RSS (КиБ) запусков
+	CPUSeconds  float64   `gorm:"not null;default:0" json:"cpu_seconds"`
+	OutputBytes int64     `gorm:"not null;default:0" json:"output_bytes"`
+	MaxRSSKB    *int64    `gorm:"column:max_rss_kb" json:"max_rss_kb,omitempty"`
+	UpdatedAt   time.Time `gorm:"type:timestamptz;not null" json:"updated_at"`
+}
+
+// RunDailyTeamStat - ресурсы запусков команды за сутки (UTC) для распределения
+// затрат; пустая команда - запуски без команды (IP-адреса, токены без team)
+type RunDailyTeamStat struct {
+	Day           time.Time `gorm:"type:date;primaryKey" json:"day"`
+	Team          string    `gorm:"type:text;primaryKey" json:"team"`
+	Runs          int64     `gorm:"not null" json:"runs"`
+	TotalDuration float64   `gorm:"not null" json:"total_duration"`
+	CPUSeconds    float64   `gorm:"not null" json:"cpu_seconds"`
+	OutputBytes   int64     `gorm:"not null" json:"output_bytes"`
+	MaxRSSKB      *int64    `gorm:"column:max_rss_kb" json:"max_rss_kb,omitempty"`
+	UpdatedAt     time.Time `gorm:"type:timestamptz;not null" json:"updated_at"`
+}
+
+// Сколько последних суток пересчитывает ночная задача: запуски, начатые
+// вчера, могли завершиться уже после предыдущего пересчета
+const statsRollupDays = 2
+
+// rollupStats пересчитывает итоги за последние сутки во всех схемах арендаторов
+func rollupStats() {
+	today := utcDay(time.Now())
+	from := today.AddDate(0, 0, -statsRollupDays+1)
+	forEachTenant(func(t *tenant) {
+		if err := rollupTenantStats(t, from, today.AddDate(0, 0, 1)); err != nil {
+			log.Printf("Failed to roll up stats of tenant %s: %v", t.Name, err)
+		}
+	})
+}
+
+// rollupTenantStats пересчитывает итоги за сутки [from, to). Сутки без
+// завершенных запусков не создаются, существующие строки перезаписываются.
+func rollupTenantStats(t *tenant, from, to time.Time) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (day, playbook, runs, completed, failed, aborted, changed, unchanged,
+			total_duration, min_duration, max_duration, cpu_seconds, output_bytes, max_rss_kb, updated_at)
+		SELECT (start_time AT TIME ZONE 'UTC')::date, playbook, COUNT(*),
+			COUNT(*) FILTER (WHERE status = ?),
+			COUNT(*) FILTER (WHERE status = ?),
+			COUNT(*) FILTER (WHERE status NOT IN (?, ?)),
+			COUNT(*) FILTER (WHERE changed_hosts > 0),
+			COUNT(*) FILTER (WHERE status = ? AND changed_hosts = 0),
+			COALESCE(SUM(duration), 0), MIN(duration), MAX(duration),
+			COALESCE(SUM(cpu_seconds), 0), COALESCE(SUM(output_bytes), 0), MAX(max_rss_kb), now()
+		FROM %s
+		WHERE start_time >= ? AND start_time < ? AND end_time IS NOT NULL AND deleted_at IS NULL
+		GROUP BY 1, 2
+		ON CONFLICT (day, playbook) DO UPDATE SET
+			runs = EXCLUDED.runs, completed = EXCLUDED.completed, failed = EXCLUDED.failed,
+			aborted = EXCLUDED.aborted, changed = EXCLUDED.changed, unchanged = EXCLUDED.unchanged,
+			total_duration = EXCLUDED.total_duration,
+			min_duration = EXCLUDED.min_duration, max_duration = EXCLUDED.max_duration,
+			cpu_seconds = EXCLUDED.cpu_seconds, output_bytes = EXCLUDED.output_bytes,
+			max_rss_kb = EXCLUDED.max_rss_kb, updated_at = EXCLUDED.updated_at`,
+		t.table("run_daily_stat"), t.table("playbook_run"))
+	teamQuery := fmt.Sprintf(`
+		INSERT INTO %s (day, team, runs, total_duration, cpu_seconds, output_bytes, max_rss_kb, updated_at)
+		SELECT (start_time AT TIME ZONE 'UTC')::date, team, COUNT(*), COALESCE(SUM(duration), 0),
+			COALESCE(SUM(cpu_seconds), 0), COALESCE(SUM(output_bytes), 0), MAX(max_rss_kb), now()
+		FROM %s
+		WHERE start_time >= ? AND start_time < ? AND end_time IS NOT NULL AND deleted_at IS NULL
+		GROUP BY 1, 2
+		ON CONFLICT (day, team) DO UPDATE SET
+			runs = EXCLUDED.runs, total_duration = EXCLUDED.total_duration,
+			cpu_seconds = EXCLUDED.cpu_seconds, output_bytes = EXCLUDED.output_bytes,
+			max_rss_kb = EXCLUDED.max_rss_kb, updated_at = EXCLUDED.updated_at`,
+		t.table("run_daily_team_stat"), t.table("playbook_run"))
+
+	started := time.Now()
+	result := primaryDB().Exec(query,
+		RunStatusCompleted, RunStatusFailed, RunStatusCompleted, RunStatusFailed, RunStatusCompleted,
+		from, to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if err := primaryDB().Exec(teamQuery, from, to).Error; err != nil {
+		return err
+	}
+	log.Printf("Rolled up stats of tenant %s for %s..%s: %d rows in %s",
+		t.Name, from.Format(time.DateOnly), to.AddDate(0, 0, -1).Format(time.DateOnly),
+		result.RowsAffected, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+func utcDay(at time.Time) time.Time {
+	y, m, d := at.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// parseDayRange разбирает from и to (YYYY-MM-DD, включительно); по умолчанию -
+// последние logging.retention_days суток
+func parseDayRange(r *http.Request) (from, to time.Time, err error) {
+	to = utcDay(time.Now())
+	from = to.AddDate(0, 0, -cfg.Logging.RetentionDays)
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, fmt.Errorf("invalid from, expected YYYY-MM-DD")
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, fmt.Errorf("invalid to, expected YYYY-MM-DD")
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to is before from")
+	}
+	return from, to, nil
+}
+
+// PlaybookStats - итоги playbook за период
+type PlaybookStats struct {
+	Playbook        string   `json:"playbook"`
+	Runs            int64    `json:"runs"`
+	Completed       int64    `json:"completed"`
+	Failed          int64    `json:"failed"`
+	Aborted         int64    `json:"aborted"`
+	Changed         int64    `json:"changed"`
+	Unchanged       int64    `json:"unchanged"`
+	SuccessRate     *float64 `json:"success_rate"`
+	AverageDuration *float64 `json:"average_duration"`
+	MinDuration     *float64 `json:"min_duration"`
+	MaxDuration     *float64 `json:"max_duration"`
+	CPUSeconds      float64  `json:"cpu_seconds"`
+	OutputBytes     int64    `json:"output_bytes"`
+	MaxRSSKB        *int64   `json:"max_rss_kb"`
+}
+
+// TeamStats - ресурсы запусков команды за период
+type TeamStats struct {
+	Team          string  `json:"team"`
+	Runs          int64   `json:"runs"`
+	TotalDuration float64 `json:"total_duration"`
+	CPUSeconds    float64 `json:"cpu_seconds"`
+	OutputBytes   int64   `json:"output_bytes"`
+	MaxRSSKB      *int64  `json:"max_rss_kb"`
+}
+
+type StatsResponse struct {
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Playbooks []PlaybookStats `json:"playbooks"`
+	// Итоги по командам; без фильтра playbook
+	Teams []TeamStats `json:"teams,omitempty"`
+	// Время последнего пересчета итогов; более поздние запуски в статистику не попали
+	RolledUpAt *time.Time `json:"rolled_up_at"`
+}
+
+// statsHandler отдает итоги по playbook за период из таблицы run_daily_stat
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDayRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := tenantOf(r)
+
+	query := t.db().Model(&RunDailyStat{}).
+		Select(`playbook, SUM(runs) AS runs, SUM(completed) AS completed, SUM(failed) AS failed, SUM(aborted) AS aborted,
+			SUM(changed) AS changed, SUM(unchanged) AS unchanged,
+			SUM(completed)::float / NULLIF(SUM(runs), 0) AS success_rate,
+			SUM(total_duration) / NULLIF(SUM(runs), 0) AS average_duration,
+			MIN(min_duration) AS min_duration, MAX(max_duration) AS max_duration,
+			SUM(cpu_seconds) AS cpu_seconds, SUM(output_bytes) AS output_bytes, MAX(max_rss_kb) AS max_rss_kb`).
+		Where("day BETWEEN ? AND ?", from, to).
+		Group("playbook").
+		Order("playbook")
+	playbook := r.URL.Query().Get("playbook")
+	if playbook != "" {
+		query = query.Where("playbook = ?", playbook)
+	}
+
+	response := StatsResponse{From: from.Format(time.DateOnly), To: to.Format(time.DateOnly), Playbooks: []PlaybookStats{}}
+	if err := query.Scan(&response.Playbooks).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if playbook == "" {
+		response.Teams = []TeamStats{}
+		if err := t.db().Model(&RunDailyTeamStat{}).
+			Select(`team, SUM(runs) AS runs, SUM(total_duration) AS total_duration, SUM(cpu_seconds) AS cpu_seconds,
+				SUM(output_bytes) AS output_bytes, MAX(max_rss_kb) AS max_rss_kb`).
+			Where("day BETWEEN ? AND ?", from, to).
+			Group("team").
+			Order("team").
+			Scan(&response.Teams).Error; err != nil {
+			writeDBError(w, err)
+			return
+		}
+	}
+	if response.RolledUpAt, err = lastRollup(t); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// TrendPoint - итоги за одни сутки
+type TrendPoint struct {
+	Day             string   `json:"day"`
+	Runs            int64    `json:"runs"`
+	Completed       int64    `json:"completed"`
+	Failed          int64    `json:"failed"`
+	Aborted         int64    `json:"aborted"`
+	Changed         int64    `json:"changed"`
+	Unchanged       int64    `json:"unchanged"`
+	AverageDuration *float64 `json:"average_duration"`
+}
+
+type TrendsResponse struct {
+	From       string       `json:"from"`
+	To         string       `json:"to"`
+	Playbook   string       `json:"playbook,omitempty"`
+	Days       []TrendPoint `json:"days"`
+	RolledUpAt *time.Time   `json:"rolled_up_at"`
+}
+
+// statsTrendsHandler отдает ряд по суткам, по всем playbook или по одному
+func statsTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDayRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := tenantOf(r)
+	playbook := r.URL.Query().Get("playbook")
+
+	query := t.db().Model(&RunDailyStat{}).
+		Select(`to_char(day, 'YYYY-MM-DD') AS day, SUM(runs) AS runs, SUM(completed) AS completed,
+			SUM(failed) AS failed, SUM(aborted) AS aborted, SUM(changed) AS changed, SUM(unchanged) AS unchanged,
+			SUM(total_duration) / NULLIF(SUM(runs), 0) AS average_duration`).
+		Where("day BETWEEN ? AND ?", from, to).
+		Group("day").
+		Order("day")
+	if playbook != "" {
+		query = query.Where("playbook = ?", playbook)
+	}
+
+	response := TrendsResponse{From: from.Format(time.DateOnly), To: to.Format(time.DateOnly), Playbook: playbook, Days: []TrendPoint{}}
+	if err := query.Scan(&response.Days).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	if response.RolledUpAt, err = lastRollup(t); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func lastRollup(t *tenant) (*time.Time, error) {
+	var at *time.Time
+	err := t.db().Model(&RunDailyStat{}).Select("MAX(updated_at)").Row().Scan(&at)
+	return at, err
+}
+
+// rollupStatsHandler пересчитывает итоги за период по требованию,
+// например после восстановления истории или при первом включении
+func rollupStatsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDayRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := rollupTenantStats(tenantOf(r), from, to.AddDate(0, 0, 1)); err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "rolled up",
+		"from":   from.Format(time.DateOnly),
+		"to":     to.Format(time.DateOnly),
+	})
+}
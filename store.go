@@ -0,0 +1,135 @@
+package ansibleapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Store - хранилище данных, с которым работают обработчики API. Обработчики
+// не обращаются к gorm напрямую, поэтому хранилище можно заменить другой
+// реализацией (другая СУБД, заглушка в тестах обработчиков).
+type Store interface {
+	RunStore
+	InventoryStore
+	CheckStore
+	LogStore
+}
+
+// RunStore - история запусков playbook
+type RunStore interface {
+	GetRun(id uint) (PlaybookRun, error)
+	// ListRuns возвращает страницу запусков, новые первыми; общее число
+	// подходящих записей сохраняется в page
+	ListRuns(f RunFilter, page *pagination) ([]PlaybookRun, error)
+	// ListRunsAfter - keyset-пагинация: до limit+1 запусков после курсора
+	ListRunsAfter(f RunFilter, cursor string, limit int) ([]PlaybookRun, error)
+	// DeleteRuns удаляет подходящие запуски, кроме стоящих в очереди и выполняющихся
+	DeleteRuns(f RunFilter) (int64, error)
+	// RecordRunProcess сохраняет PID процесса запуска (он же PGID его группы)
+	// и время старта процесса, если оно известно
+	RecordRunProcess(id uint, pid int, startedAt *time.Time) error
+	// RecordRunTeardown сохраняет итог завершения группы процессов после отмены
+	RecordRunTeardown(id uint, teardown string) error
+	// FinishRun сохраняет итог запуска, только пока он в статусе started.
+	// Запуску, уже помеченному lost, добавляет вывод и поздний итог и
+	// возвращает errRunNotStarted.
+	FinishRun(id uint, status PlaybookRunStatus, output, errorMsg string) error
+}
+
+// LogStore - логи завершенных запусков
+type LogStore interface {
+	GetLog(id uint) (PlaybookLog, error)
+	ListLogs(f LogFilter, page *pagination) ([]PlaybookLog, error)
+	ListLogsAfter(f LogFilter, cursor string, limit int) ([]PlaybookLog, error)
+	// DeleteLogs удаляет запуски, к которым относятся подходящие логи
+	DeleteLogs(f LogFilter) (int64, error)
+}
+
+// InventoryStore - инвентари и корзина удаленных инвентарей
+type InventoryStore interface {
+	GetInventory(name string) (Inventory, error)
+	ListInventories(f InventoryFilter, page *pagination) ([]Inventory, error)
+	CreateInventory(inv *Inventory) error
+	SaveInventory(inv *Inventory) error
+	// DeleteInventory переносит инвентарь и его проверки в корзину
+	DeleteInventory(inv *Inventory) error
+	// RestoreInventory возвращает из корзины последний удаленный инвентарь с этим
+	// именем; authorize вызывается до восстановления и может его запретить
+	RestoreInventory(name string, authorize func(Inventory) error) (Inventory, error)
+	// PurgeInventory безвозвратно удаляет все версии инвентаря, включая удаленные
+	PurgeInventory(name string) error
+	// InventoryContent читает содержимое с основной БД, для запуска ansible
+	InventoryContent(name string) (string, error)
+}
+
+// CheckStore - проверки доступности хостов инвентарей
+type CheckStore interface {
+	// GetCheck возвращает проверку с ее инвентарем, в том числе удаленным
+	GetCheck(id uint) (InventoryCheck, error)
+	// ListChecks возвращает страницу проверок, новые первыми
+	ListChecks(f CheckFilter, page *pagination) ([]InventoryCheck, error)
+	CreateCheck(check *InventoryCheck) error
+	// StartCheck переводит проверку в статус running
+	StartCheck(id uint) error
+	// SaveCheckResults сохраняет результаты по хостам, полученные к этому моменту
+	SaveCheckResults(id uint, results JSONMap) error
+	// FinishCheck сохраняет статус, время окончания и ошибку проверки, а
+	// результаты, ошибки хостов и факты - только непустые
+	FinishCheck(check InventoryCheck) error
+}
+
+var errNotFound = errors.New("record not found")
+
+// RunFilter - фильтры списка запусков, пустые поля не применяются
+type RunFilter struct {
+	Status      string
+	Playbook    string
+	TriggeredBy string // поддерживает шаблоны с *, например ci-*
+	Ticket      string
+	Batch       *uint
+	// По changed_hosts: true - запуск изменил хотя бы один хост, false - ни одного
+	Changed     *bool
+	MinDuration *float64
+	MaxDuration *float64
+	From        *time.Time
+	To          *time.Time
+}
+
+// LogFilter - фильтры списка логов
+type LogFilter struct {
+	Success  *bool
+	Playbook string
+	From     *time.Time
+	To       *time.Time
+}
+
+// CheckFilter - фильтры списка проверок, пустые поля не применяются
+type CheckFilter struct {
+	InventoryID *uint
+	// Имя инвентаря, в том числе удаленного
+	Inventory string
+	Status    string
+	// Проверки инвентарей, недоступных Principal, не показываются
+	Principal principal
+}
+
+// InventoryFilter - какие инвентари видны в списке
+type InventoryFilter struct {
+	Principal principal
+	// Deleted показывает корзину: только удаленные инвентари
+	Deleted bool
+}
+
+// newStore создает хранилище арендатора; переменная, чтобы подменить реализацию
+var newStore = func(t *tenant) Store {
+	return postgresStore{tenant: t}
+}
+
+func (t *tenant) store() Store {
+	return newStore(t)
+}
+
+func storeOf(r *http.Request) Store {
+	return tenantOf(r).store()
+}
@@ -0,0 +1,419 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// memoryStore - хранилище в памяти для тестов обработчиков без PostgreSQL.
+// Фильтры поддерживаются в объеме, нужном тестам; keyset-пагинации нет.
+type memoryStore struct {
+	mu sync.Mutex
+	// Последние id по таблицам, как последовательности PostgreSQL
+	lastInventoryID uint
+	lastCheckID     uint
+	runs            map[uint]PlaybookRun
+	inventories     map[uint]Inventory
+	checks          map[uint]InventoryCheck
+}
+
+var _ Store = (*memoryStore)(nil)
+
+var errCursorUnsupported = errors.New("cursor pagination is not supported by memoryStore")
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		runs:        make(map[uint]PlaybookRun),
+		inventories: make(map[uint]Inventory),
+		checks:      make(map[uint]InventoryCheck),
+	}
+}
+
+// page возвращает границы страницы из n записей и запоминает их число
+func page(p *pagination, n int) (int, int) {
+	offset := p.setTotal(int64(n))
+	return min(offset, n), min(offset+p.PerPage, n)
+}
+
+func (s *memoryStore) GetRun(id uint) (PlaybookRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return PlaybookRun{}, errNotFound
+	}
+	return run, nil
+}
+
+func (s *memoryStore) matchingRuns(f RunFilter) []PlaybookRun {
+	var runs []PlaybookRun
+	for _, run := range s.runs {
+		if f.Status != "" && string(run.Status) != f.Status {
+			continue
+		}
+		if f.Playbook != "" && run.Playbook != f.Playbook {
+			continue
+		}
+		if f.From != nil && run.StartTime.Before(*f.From) || f.To != nil && run.StartTime.After(*f.To) {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].StartTime.Equal(runs[j].StartTime) {
+			return runs[i].StartTime.After(runs[j].StartTime)
+		}
+		return runs[i].ID > runs[j].ID
+	})
+	return runs
+}
+
+func (s *memoryStore) ListRuns(f RunFilter, p *pagination) ([]PlaybookRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := s.matchingRuns(f)
+	from, to := page(p, len(runs))
+	return runs[from:to], nil
+}
+
+func (s *memoryStore) ListRunsAfter(f RunFilter, cursor string, limit int) ([]PlaybookRun, error) {
+	return nil, errCursorUnsupported
+}
+
+func (s *memoryStore) DeleteRuns(f RunFilter) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for _, run := range s.matchingRuns(f) {
+		if run.Status != RunStatusQueued && run.Status != RunStatusStarted {
+			delete(s.runs, run.ID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memoryStore) RecordRunProcess(id uint, pid int, startedAt *time.Time) error {
+	return s.updateRun(id, func(run *PlaybookRun) {
+		run.PID, run.PGID, run.ProcessStartedAt = pid, pid, startedAt
+	})
+}
+
+func (s *memoryStore) RecordRunTeardown(id uint, teardown string) error {
+	return s.updateRun(id, func(run *PlaybookRun) { run.Teardown = teardown })
+}
+
+func (s *memoryStore) FinishRun(id uint, status PlaybookRunStatus, output, errorMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return nil
+	}
+	switch run.Status {
+	case RunStatusStarted:
+		run.Status, run.Output, run.Error = status, output, errorMsg
+		if status != RunStatusStarted {
+			end := time.Now()
+			duration := end.Sub(run.StartTime).Seconds()
+			run.EndTime, run.Duration = &end, &duration
+		}
+		s.runs[id] = run
+		return nil
+	case RunStatusLost:
+		run.Output = output
+		run.Error += fmt.Sprintf("; process finished later with status %s", status)
+		s.runs[id] = run
+	}
+	return errRunNotStarted
+}
+
+func (s *memoryStore) updateRun(id uint, update func(*PlaybookRun)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if run, ok := s.runs[id]; ok {
+		update(&run)
+		s.runs[id] = run
+	}
+	return nil
+}
+
+// Логи - завершенные запуски, как представление playbook_log
+func (s *memoryStore) logs(f LogFilter) []PlaybookLog {
+	var logs []PlaybookLog
+	for _, run := range s.matchingRuns(RunFilter{Playbook: f.Playbook, From: f.From, To: f.To}) {
+		switch run.Status {
+		case RunStatusCompleted, RunStatusFailed, RunStatusTimedOut, RunStatusCancelled:
+		default:
+			continue
+		}
+		entry := PlaybookLog{
+			Model:     gorm.Model{ID: run.ID, CreatedAt: run.CreatedAt, UpdatedAt: run.UpdatedAt},
+			RunID:     run.ID,
+			Playbook:  run.Playbook,
+			Success:   run.Status == RunStatusCompleted,
+			Output:    run.Output,
+			Error:     run.Error,
+			StartTime: run.StartTime,
+		}
+		if run.LegacyLogID != nil {
+			entry.ID = *run.LegacyLogID
+		}
+		if run.EndTime != nil {
+			entry.EndTime = *run.EndTime
+		}
+		if run.Duration != nil {
+			entry.Duration = *run.Duration
+		}
+		if f.Success == nil || *f.Success == entry.Success {
+			logs = append(logs, entry)
+		}
+	}
+	return logs
+}
+
+func (s *memoryStore) GetLog(id uint) (PlaybookLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.logs(LogFilter{}) {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return PlaybookLog{}, errNotFound
+}
+
+func (s *memoryStore) ListLogs(f LogFilter, p *pagination) ([]PlaybookLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logs := s.logs(f)
+	from, to := page(p, len(logs))
+	return logs[from:to], nil
+}
+
+func (s *memoryStore) ListLogsAfter(f LogFilter, cursor string, limit int) ([]PlaybookLog, error) {
+	return nil, errCursorUnsupported
+}
+
+func (s *memoryStore) DeleteLogs(f LogFilter) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logs := s.logs(f)
+	for _, entry := range logs {
+		delete(s.runs, entry.RunID)
+	}
+	return int64(len(logs)), nil
+}
+
+func (s *memoryStore) GetInventory(name string) (Inventory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, inv := range s.inventories {
+		if inv.Name == name && !inv.DeletedAt.Valid {
+			return inv, nil
+		}
+	}
+	return Inventory{}, errNotFound
+}
+
+func (s *memoryStore) ListInventories(f InventoryFilter, p *pagination) ([]Inventory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inventories []Inventory
+	for _, inv := range s.inventories {
+		if inv.DeletedAt.Valid == f.Deleted && f.Principal.canUse(inv) {
+			inventories = append(inventories, inv)
+		}
+	}
+	sort.Slice(inventories, func(i, j int) bool { return inventories[i].Name < inventories[j].Name })
+	from, to := page(p, len(inventories))
+	return inventories[from:to], nil
+}
+
+func (s *memoryStore) CreateInventory(inv *Inventory) error {
+	if _, err := s.GetInventory(inv.Name); err == nil {
+		return fmt.Errorf("inventory %s already exists", inv.Name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastInventoryID++
+	inv.ID = s.lastInventoryID
+	inv.CreatedAt, inv.UpdatedAt = time.Now(), time.Now()
+	s.inventories[inv.ID] = *inv
+	return nil
+}
+
+func (s *memoryStore) SaveInventory(inv *Inventory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inv.ID == 0 {
+		s.lastInventoryID++
+		inv.ID = s.lastInventoryID
+	}
+	inv.UpdatedAt = time.Now()
+	s.inventories[inv.ID] = *inv
+	return nil
+}
+
+func (s *memoryStore) DeleteInventory(inv *Inventory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	for id, check := range s.checks {
+		if check.InventoryID == inv.ID {
+			check.DeletedAt = deleted
+			s.checks[id] = check
+		}
+	}
+	inv.DeletedAt = deleted
+	s.inventories[inv.ID] = *inv
+	return nil
+}
+
+func (s *memoryStore) RestoreInventory(name string, authorize func(Inventory) error) (Inventory, error) {
+	if _, err := s.GetInventory(name); err == nil {
+		return Inventory{}, errInventoryExists
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *Inventory
+	for _, inv := range s.inventories {
+		if inv.Name == name && inv.DeletedAt.Valid && (latest == nil || inv.DeletedAt.Time.After(latest.DeletedAt.Time)) {
+			latest = &inv
+		}
+	}
+	if latest == nil {
+		return Inventory{}, errNotFound
+	}
+	if err := authorize(*latest); err != nil {
+		return Inventory{}, err
+	}
+	for id, check := range s.checks {
+		if check.InventoryID == latest.ID && check.DeletedAt == latest.DeletedAt {
+			check.DeletedAt = gorm.DeletedAt{}
+			s.checks[id] = check
+		}
+	}
+	latest.DeletedAt = gorm.DeletedAt{}
+	s.inventories[latest.ID] = *latest
+	return *latest, nil
+}
+
+func (s *memoryStore) PurgeInventory(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, inv := range s.inventories {
+		if inv.Name != name {
+			continue
+		}
+		delete(s.inventories, id)
+		for checkID, check := range s.checks {
+			if check.InventoryID == id {
+				delete(s.checks, checkID)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) InventoryContent(name string) (string, error) {
+	inv, err := s.GetInventory(name)
+	return inv.Content, err
+}
+
+// withInventory добавляет к проверке ее инвентарь, как preloadInventory
+func (s *memoryStore) withInventory(check InventoryCheck) InventoryCheck {
+	if inv, ok := s.inventories[check.InventoryID]; ok {
+		check.Inventory = &inv
+		check.InventoryName = inv.Name
+	}
+	return check
+}
+
+func (s *memoryStore) GetCheck(id uint) (InventoryCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check, ok := s.checks[id]
+	if !ok || check.DeletedAt.Valid {
+		return InventoryCheck{}, errNotFound
+	}
+	return s.withInventory(check), nil
+}
+
+func (s *memoryStore) ListChecks(f CheckFilter, p *pagination) ([]InventoryCheck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var checks []InventoryCheck
+	for _, check := range s.checks {
+		check = s.withInventory(check)
+		switch {
+		case check.DeletedAt.Valid:
+		case f.InventoryID != nil && check.InventoryID != *f.InventoryID:
+		case f.Inventory != "" && check.InventoryName != f.Inventory:
+		case f.Status != "" && !strings.EqualFold(string(check.Status), f.Status):
+		case check.Inventory != nil && !f.Principal.canUse(*check.Inventory):
+		default:
+			checks = append(checks, check)
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		if !checks[i].StartedAt.Equal(checks[j].StartedAt) {
+			return checks[i].StartedAt.After(checks[j].StartedAt)
+		}
+		return checks[i].ID > checks[j].ID
+	})
+	from, to := page(p, len(checks))
+	return checks[from:to], nil
+}
+
+func (s *memoryStore) CreateCheck(check *InventoryCheck) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheckID++
+	check.ID = s.lastCheckID
+	check.CreatedAt, check.UpdatedAt = time.Now(), time.Now()
+	s.checks[check.ID] = *check
+	return nil
+}
+
+func (s *memoryStore) StartCheck(id uint) error {
+	return s.updateCheck(id, func(check *InventoryCheck) { check.Status = CheckStatusRunning })
+}
+
+func (s *memoryStore) SaveCheckResults(id uint, results JSONMap) error {
+	return s.updateCheck(id, func(check *InventoryCheck) { check.Results = results })
+}
+
+func (s *memoryStore) FinishCheck(finished InventoryCheck) error {
+	return s.updateCheck(finished.ID, func(check *InventoryCheck) {
+		check.Status, check.CompletedAt = finished.Status, finished.CompletedAt
+		if finished.Error != "" {
+			check.Error = finished.Error
+		}
+		if len(finished.Results) > 0 {
+			check.Results = finished.Results
+		}
+		if len(finished.HostErrors) > 0 {
+			check.HostErrors = finished.HostErrors
+		}
+		if len(finished.Facts) > 0 {
+			check.Facts = finished.Facts
+		}
+	})
+}
+
+func (s *memoryStore) updateCheck(id uint, update func(*InventoryCheck)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if check, ok := s.checks[id]; ok {
+		update(&check)
+		s.checks[id] = check
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// postgresStore - хранилище в схеме арендатора PostgreSQL
+type postgresStore struct {
+	tenant *tenant
+}
+
+// notFound переводит ошибку gorm об отсутствии записи в errNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errNotFound
+	}
+	return err
+}
+
+func (s postgresStore) GetRun(id uint) (PlaybookRun, error) {
+	var run PlaybookRun
+	err := s.tenant.db().First(&run, id).Error
+	return run, notFound(err)
+}
+
+func (s postgresStore) ListRuns(f RunFilter, page *pagination) ([]PlaybookRun, error) {
+	var runs []PlaybookRun
+	err := listPage(s.runsQuery(f), page, "start_time DESC, id DESC", &runs)
+	return runs, err
+}
+
+func (s postgresStore) ListRunsAfter(f RunFilter, cursor string, limit int) ([]PlaybookRun, error) {
+	var runs []PlaybookRun
+	err := listAfter(s.runsQuery(f), cursor, limit, &runs)
+	return runs, err
+}
+
+func (s postgresStore) DeleteRuns(f RunFilter) (int64, error) {
+	result := s.runsQuery(f).
+		Where("status NOT IN ?", []PlaybookRunStatus{RunStatusQueued, RunStatusStarted}).
+		Unscoped().
+		Delete(&PlaybookRun{})
+	return result.RowsAffected, result.Error
+}
+
+func (s postgresStore) RecordRunProcess(id uint, pid int, startedAt *time.Time) error {
+	updates := map[string]interface{}{
+		"pid":  pid,
+		"pgid": pid,
+	}
+	if startedAt != nil {
+		updates["process_started_at"] = *startedAt
+	}
+	return s.tenant.db().Model(&PlaybookRun{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (s postgresStore) RecordRunTeardown(id uint, teardown string) error {
+	return s.tenant.db().Model(&PlaybookRun{}).Where("id = ?", id).Update("teardown", teardown).Error
+}
+
+func (s postgresStore) FinishRun(id uint, status PlaybookRunStatus, output, errorMsg string) error {
+	updates := map[string]interface{}{
+		"status": status,
+		"output": output,
+		"error":  errorMsg,
+	}
+
+	if status != RunStatusStarted {
+		endTime := time.Now()
+		var startTime time.Time
+		if err := s.tenant.primaryDB().Model(&PlaybookRun{}).Where("id = ?", id).Pluck("start_time", &startTime).Error; err != nil {
+			return err
+		}
+		duration := endTime.Sub(startTime).Seconds()
+
+		updates["end_time"] = endTime
+		updates["duration"] = duration
+		updates["output_bytes"] = len(output)
+
+		results, hostResults := parseRunResults(output)
+		updates["results"] = results
+		updates["host_results"] = hostResults
+		if counts := recapHostCounts(output); counts.Total > 0 {
+			updates["changed_hosts"] = counts.Changed
+		}
+	}
+	if status == RunStatusCompleted {
+		updates["tasks_completed"] = gorm.Expr("tasks_total")
+		updates["current_task"] = ""
+	}
+
+	result := s.tenant.db().Model(&PlaybookRun{}).Where("id = ? AND status = ?", id, RunStatusStarted).Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+
+	late := fmt.Sprintf("; process finished later with status %s", status)
+	if errorMsg != "" {
+		late += ": " + errorMsg
+	}
+	err := s.tenant.db().Model(&PlaybookRun{}).Where("id = ? AND status = ?", id, RunStatusLost).
+		Updates(map[string]interface{}{
+			"output":       output,
+			"output_bytes": len(output),
+			"error":        gorm.Expr("COALESCE(error, '') || ?", late),
+		}).Error
+	if err != nil {
+		return err
+	}
+	return errRunNotStarted
+}
+
+func (s postgresStore) runsQuery(f RunFilter) *gorm.DB {
+	query := s.tenant.db().Model(&PlaybookRun{})
+
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.Playbook != "" {
+		query = query.Where("playbook = ?", f.Playbook)
+	}
+	if f.Ticket != "" {
+		query = query.Where("ticket_id = ?", f.Ticket)
+	}
+	if f.Changed != nil {
+		if *f.Changed {
+			query = query.Where("changed_hosts > 0")
+		} else {
+			query = query.Where("changed_hosts = 0")
+		}
+	}
+	if f.Batch != nil {
+		query = query.Where("batch_id = ?", *f.Batch)
+	}
+	if f.TriggeredBy != "" {
+		if strings.Contains(f.TriggeredBy, "*") {
+			query = query.Where("triggered_by LIKE ?", strings.ReplaceAll(f.TriggeredBy, "*", "%"))
+		} else {
+			query = query.Where("triggered_by = ?", f.TriggeredBy)
+		}
+	}
+	if f.MinDuration != nil {
+		query = query.Where("duration >= ?", *f.MinDuration)
+	}
+	if f.MaxDuration != nil {
+		query = query.Where("duration <= ?", *f.MaxDuration)
+	}
+	return whereStartTime(query, f.From, f.To)
+}
+
+func (s postgresStore) GetLog(id uint) (PlaybookLog, error) {
+	var logEntry PlaybookLog
+	err := s.tenant.db().First(&logEntry, id).Error
+	return logEntry, notFound(err)
+}
+
+func (s postgresStore) ListLogs(f LogFilter, page *pagination) ([]PlaybookLog, error) {
+	var logs []PlaybookLog
+	err := listPage(s.logsQuery(f), page, "start_time DESC, id DESC", &logs)
+	return logs, err
+}
+
+func (s postgresStore) ListLogsAfter(f LogFilter, cursor string, limit int) ([]PlaybookLog, error) {
+	var logs []PlaybookLog
+	err := listAfter(s.logsQuery(f), cursor, limit, &logs)
+	return logs, err
+}
+
+// DeleteLogs удаляет сами запуски: логи - представление над ними
+func (s postgresStore) DeleteLogs(f LogFilter) (int64, error) {
+	result := s.tenant.db().Unscoped().
+		Where("id IN (?)", s.logsQuery(f).Select("run_id")).
+		Delete(&PlaybookRun{})
+	return result.RowsAffected, result.Error
+}
+
+func (s postgresStore) logsQuery(f LogFilter) *gorm.DB {
+	query := s.tenant.db().Model(&PlaybookLog{})
+
+	if f.Success != nil {
+		query = query.Where("success = ?", *f.Success)
+	}
+	if f.Playbook != "" {
+		query = query.Where("playbook = ?", f.Playbook)
+	}
+	return whereStartTime(query, f.From, f.To)
+}
+
+func (s postgresStore) GetCheck(id uint) (InventoryCheck, error) {
+	var check InventoryCheck
+	err := preloadInventory(s.tenant.db()).First(&check, id).Error
+	return check, notFound(err)
+}
+
+func (s postgresStore) ListChecks(f CheckFilter, page *pagination) ([]InventoryCheck, error) {
+	query := s.tenant.db().Model(&InventoryCheck{})
+	if f.InventoryID != nil {
+		query = query.Where("inventory_id = ?", *f.InventoryID)
+	}
+	if f.Inventory != "" {
+		query = query.Where("inventory_id IN (?)", s.tenant.db().Unscoped().Model(&Inventory{}).Select("id").Where("name = ?", f.Inventory))
+	}
+	if !f.Principal.unrestricted() {
+		query = query.Where("inventory_id IN (?)", visibleInventories(s.tenant.db().Unscoped().Model(&Inventory{}).Select("id"), f.Principal))
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	offset := page.setTotal(total)
+
+	// Preload не сочетается с Count, поэтому подключается только для выборки
+	var checks []InventoryCheck
+	err := preloadInventory(query).
+		Order("started_at DESC, id DESC").
+		Limit(page.PerPage).
+		Offset(offset).
+		Find(&checks).Error
+	return checks, err
+}
+
+func (s postgresStore) CreateCheck(check *InventoryCheck) error {
+	return s.tenant.db().Create(check).Error
+}
+
+func (s postgresStore) StartCheck(id uint) error {
+	return s.tenant.db().Model(&InventoryCheck{}).Where("id = ?", id).Update("status", CheckStatusRunning).Error
+}
+
+func (s postgresStore) SaveCheckResults(id uint, results JSONMap) error {
+	return s.tenant.db().Model(&InventoryCheck{}).Where("id = ?", id).Update("results", results).Error
+}
+
+func (s postgresStore) FinishCheck(check InventoryCheck) error {
+	updates := map[string]interface{}{
+		"status":       check.Status,
+		"completed_at": check.CompletedAt,
+	}
+	if check.Error != "" {
+		updates["error"] = check.Error
+	}
+	// Результаты, полученные до сбоя, сохраняются в любом случае
+	if len(check.Results) > 0 {
+		updates["results"] = check.Results
+	}
+	if len(check.HostErrors) > 0 {
+		updates["host_errors"] = check.HostErrors
+	}
+	if len(check.Facts) > 0 {
+		updates["facts"] = check.Facts
+	}
+	return s.tenant.db().Model(&InventoryCheck{}).Where("id = ?", check.ID).Updates(updates).Error
+}
+
+func whereStartTime(query *gorm.DB, from, to *time.Time) *gorm.DB {
+	if from != nil {
+		query = query.Where("start_time >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("start_time <= ?", *to)
+	}
+	return query
+}
+
+// listPage считает подходящие записи и выбирает страницу page
+func listPage(query *gorm.DB, page *pagination, order string, dest interface{}) error {
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+	offset := page.setTotal(total)
+
+	return query.Order(order).
+		Limit(page.PerPage).
+		Offset(offset).
+		Find(dest).Error
+}
+
+func listAfter(query *gorm.DB, cursor string, limit int, dest interface{}) error {
+	query, err := applyCursor(query, cursor, limit)
+	if err != nil {
+		return err
+	}
+	return query.Find(dest).Error
+}
+
+func (s postgresStore) GetInventory(name string) (Inventory, error) {
+	var inv Inventory
+	err := s.tenant.db().Where("name = ?", name).First(&inv).Error
+	return inv, notFound(err)
+}
+
+func (s postgresStore) ListInventories(f InventoryFilter, page *pagination) ([]Inventory, error) {
+	query := visibleInventories(s.tenant.db().Model(&Inventory{}), f.Principal)
+	if f.Deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	var inventories []Inventory
+	err := listPage(query, page, "name ASC", &inventories)
+	return inventories, err
+}
+
+func (s postgresStore) CreateInventory(inv *Inventory) error {
+	return s.tenant.db().Create(inv).Error
+}
+
+func (s postgresStore) SaveInventory(inv *Inventory) error {
+	return s.tenant.db().Save(inv).Error
+}
+
+// DeleteInventory: мягкое удаление не запускает каскад в БД, поэтому проверки
+// удаляем сами. Общая метка времени позволяет восстановить их вместе с инвентарем.
+func (s postgresStore) DeleteInventory(inv *Inventory) error {
+	return s.tenant.db().Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&InventoryCheck{}).Where("inventory_id = ?", inv.ID).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(inv).Update("deleted_at", now).Error
+	})
+}
+
+func (s postgresStore) RestoreInventory(name string, authorize func(Inventory) error) (Inventory, error) {
+	var inv Inventory
+	err := s.tenant.db().Transaction(func(tx *gorm.DB) error {
+		var active int64
+		if err := tx.Model(&Inventory{}).Where("name = ?", name).Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return errInventoryExists
+		}
+
+		if err := tx.Unscoped().
+			Where("name = ? AND deleted_at IS NOT NULL", name).
+			Order("deleted_at DESC").
+			First(&inv).Error; err != nil {
+			return err
+		}
+		if err := authorize(inv); err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&InventoryCheck{}).
+			Where("inventory_id = ? AND deleted_at = ?", inv.ID, inv.DeletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&inv).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		inv.DeletedAt = gorm.DeletedAt{}
+		return nil
+	})
+	return inv, notFound(err)
+}
+
+// PurgeInventory: проверки удаляются каскадом в БД
+func (s postgresStore) PurgeInventory(name string) error {
+	return s.tenant.db().Unscoped().Where("name = ?", name).Delete(&Inventory{}).Error
+}
+
+func (s postgresStore) InventoryContent(name string) (string, error) {
+	var inv Inventory
+	if err := s.tenant.primaryDB().Where("name = ?", name).First(&inv).Error; err != nil {
+		return "", notFound(err)
+	}
+	return inv.Content, nil
+}
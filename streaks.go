@@ -0,0 +1,106 @@
+package ansibleapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Как долго отдавать посчитанные значения, не обращаясь к БД: несколько
+// Prometheus, опрашивающих все узлы, не должны нагружать БД на каждом опросе
+const playbookHealthCacheTTL = 30 * time.Second
+
+var (
+	playbookFailureStreakDesc = prometheus.NewDesc(
+		"ansible_api_playbook_failure_streak",
+		"Failed or timed out runs of the playbook since its last successful run.",
+		[]string{"tenant", "playbook"}, nil)
+	playbookSinceSuccessDesc = prometheus.NewDesc(
+		"ansible_api_playbook_seconds_since_last_success",
+		"Seconds since the last successful run of the playbook finished; absent if it never succeeded.",
+		[]string{"tenant", "playbook"}, nil)
+)
+
+// playbookHealth - серия неудач и последний успех playbook
+type playbookHealth struct {
+	Tenant      string
+	Playbook    string
+	Streak      int
+	LastSuccess *time.Time
+}
+
+// playbookHealthCollector считает метрики из БД при опросе, поэтому все узлы
+// отдают одинаковые значения и они переживают перезапуск. Отмененные запуски
+// серию не прерывают и не продолжают.
+type playbookHealthCollector struct {
+	mu        sync.Mutex
+	health    []playbookHealth
+	updatedAt time.Time
+}
+
+func (c *playbookHealthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- playbookFailureStreakDesc
+	ch <- playbookSinceSuccessDesc
+}
+
+func (c *playbookHealthCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, h := range c.load(now) {
+		ch <- prometheus.MustNewConstMetric(playbookFailureStreakDesc, prometheus.GaugeValue, float64(h.Streak), h.Tenant, h.Playbook)
+		if h.LastSuccess != nil {
+			ch <- prometheus.MustNewConstMetric(playbookSinceSuccessDesc, prometheus.GaugeValue,
+				now.Sub(*h.LastSuccess).Seconds(), h.Tenant, h.Playbook)
+		}
+	}
+}
+
+// load возвращает значения не старше playbookHealthCacheTTL. До подключения
+// к БД (встраивание без New) метрик нет.
+func (c *playbookHealthCollector) load(now time.Time) []playbookHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if db == nil || now.Sub(c.updatedAt) < playbookHealthCacheTTL {
+		return c.health
+	}
+	var health []playbookHealth
+	for _, t := range tenantList {
+		tenantHealth, err := loadPlaybookHealth(t)
+		if err != nil {
+			// До следующей попытки отдаются прежние значения
+			log.Printf("Failed to load playbook failure streaks of tenant %s: %v", t.Name, err)
+			return c.health
+		}
+		health = append(health, tenantHealth...)
+	}
+	c.health, c.updatedAt = health, now
+	return health
+}
+
+func loadPlaybookHealth(t *tenant) ([]playbookHealth, error) {
+	query := fmt.Sprintf(`
+		SELECT r.playbook, s.last_success,
+			COUNT(*) FILTER (WHERE r.status IN (?, ?) AND (s.last_success IS NULL OR r.end_time > s.last_success)) AS streak
+		FROM %[1]s r
+		JOIN (
+			SELECT playbook, MAX(end_time) FILTER (WHERE status = ?) AS last_success
+			FROM %[1]s
+			WHERE end_time IS NOT NULL AND deleted_at IS NULL
+			GROUP BY playbook
+		) s ON s.playbook = r.playbook
+		WHERE r.end_time IS NOT NULL AND r.deleted_at IS NULL
+		GROUP BY r.playbook, s.last_success
+		ORDER BY r.playbook`, t.table("playbook_run"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var health []playbookHealth
+	err := db.WithContext(ctx).Raw(query, RunStatusFailed, RunStatusTimedOut, RunStatusCompleted).Scan(&health).Error
+	for i := range health {
+		health[i].Tenant = t.Name
+	}
+	return health, err
+}
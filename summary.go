@@ -0,0 +1,216 @@
+package ansibleapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Сколько хостов показывать в сводке; хосты с ошибками идут первыми
+const summaryHostLimit = 30
+
+// Порядок счетчиков в строке PLAY RECAP
+var recapFields = []string{"ok", "changed", "unreachable", "failed", "skipped", "rescued", "ignored"}
+
+// runSummaryHandler отдает короткую сводку запуска для заявки или чата:
+// markdown по умолчанию, format=text - обычный текст
+func runSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+	var markdown bool
+	switch r.URL.Query().Get("format") {
+	case "", "markdown":
+		markdown = true
+	case "text":
+	default:
+		http.Error(w, "format must be markdown or text", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	run, err := t.store().GetRun(uint(id))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Run not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	if run.Status == RunStatusStarted && run.Output == "" {
+		output, err := runOutputSoFar(t, run.ID)
+		if err != nil {
+			writeDBError(w, err)
+			return
+		}
+		run.Output = output
+	}
+
+	if markdown {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	fmt.Fprint(w, runSummary(t, run, markdown))
+}
+
+// runSummary собирает сводку из сохраненного запуска: playbook, кто и когда
+// запустил, длительность, PLAY RECAP по хостам и первая ошибка
+func runSummary(t *tenant, run PlaybookRun, markdown bool) string {
+	bold, code := "", ""
+	if markdown {
+		bold, code = "**", "`"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sPlaybook %s%s%s: %s%s (run %d)\n\n", bold, code, run.Playbook, code, run.Status, bold, run.ID)
+
+	item := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(&b, "- %s: %s\n", name, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case run.Inventory != "":
+		item("Inventory", "%s", run.Inventory)
+	case len(run.HostTags) > 0:
+		item("Hosts", "tagged %s", formatTags(run.HostTags))
+	case len(run.Hosts) > 0:
+		item("Hosts", "%s", strings.Join(run.Hosts, ", "))
+	case run.Localhost:
+		item("Hosts", "localhost")
+	}
+	if run.Limit != "" {
+		item("Limit", "%s", run.Limit)
+	}
+	if len(run.QuarantinedHosts) > 0 {
+		item("Quarantined", "%s (skipped)", strings.Join(run.QuarantinedHosts, ", "))
+	}
+	if run.TriggeredBy != "" {
+		item("Triggered by", "%s", run.TriggeredBy)
+	}
+	item("Started", "%s", run.StartTime.UTC().Format(time.DateTime+" MST"))
+	if run.EndTime != nil {
+		item("Finished", "%s", run.EndTime.UTC().Format(time.DateTime+" MST"))
+	}
+	if run.Duration != nil {
+		item("Duration", "%s", time.Duration(*run.Duration*float64(time.Second)).Round(time.Second))
+	} else if run.Status == RunStatusStarted {
+		item("Running for", "%s", time.Since(run.StartTime).Round(time.Second))
+	}
+	if run.Attempt > 1 {
+		item("Attempt", "%d", run.Attempt)
+	}
+	if run.Ticket != nil {
+		ticket := run.Ticket.System + " " + run.Ticket.ID
+		if run.Ticket.URL != "" {
+			ticket += " " + run.Ticket.URL
+		}
+		item("Ticket", "%s", ticket)
+	}
+	if link := runLink(run.ID); link != "" {
+		item("Details", "%s", link)
+	}
+
+	recaps := hostRecaps(run.Output)
+	failed := parseFailedTasks(run.Output)
+	hosts := make([]string, 0, len(recaps))
+	for host := range recaps {
+		hosts = append(hosts, host)
+	}
+	lookup := hosts
+	if len(failed) > 0 {
+		lookup = append(append([]string{}, hosts...), failed[0].Host)
+	}
+	labels := describeHosts(t, lookup)
+	hostName := func(host string) string {
+		m, ok := labels[host]
+		switch {
+		case !ok:
+			return host
+		case m.DisplayName != "" && m.DisplayName != host:
+			return host + " - " + m.label()
+		}
+		return m.label()
+	}
+
+	if len(recaps) > 0 {
+		// Хосты с ошибками первыми, чтобы не потеряться при обрезке списка
+		problem := func(host string) bool {
+			return recaps[host]["failed"] > 0 || recaps[host]["unreachable"] > 0
+		}
+		sort.Slice(hosts, func(i, j int) bool {
+			if pi, pj := problem(hosts[i]), problem(hosts[j]); pi != pj {
+				return pi
+			}
+			return hosts[i] < hosts[j]
+		})
+		counts := recapHostCounts(run.Output)
+		fmt.Fprintf(&b, "\n%sHosts%s: %d total, %d ok, %d changed, %d failed, %d unreachable\n",
+			bold, bold, counts.Total, counts.OK, counts.Changed, counts.Failed, counts.Unreachable)
+		if markdown {
+			b.WriteString("```\n")
+		}
+		for _, host := range hosts[:min(len(hosts), summaryHostLimit)] {
+			fields := make([]string, 0, len(recapFields))
+			for _, field := range recapFields {
+				fields = append(fields, fmt.Sprintf("%s=%d", field, recaps[host][field]))
+			}
+			fmt.Fprintf(&b, "%s : %s\n", hostName(host), strings.Join(fields, " "))
+		}
+		if len(hosts) > summaryHostLimit {
+			fmt.Fprintf(&b, "... and %d more hosts\n", len(hosts)-summaryHostLimit)
+		}
+		if markdown {
+			b.WriteString("```\n")
+		}
+	}
+
+	if len(failed) > 0 {
+		first := failed[0]
+		what := "failed"
+		if first.Unreachable {
+			what = "unreachable"
+		}
+		fmt.Fprintf(&b, "\n%sFirst failure%s: task %s%s%s %s on %s", bold, bold, code, first.Task, code, what, hostName(first.Host))
+		if len(failed) > 1 {
+			fmt.Fprintf(&b, " (%d more failures)", len(failed)-1)
+		}
+		b.WriteString("\n")
+		if first.Message != "" {
+			writeSummaryBlock(&b, first.Message, markdown)
+		}
+	} else if run.Error != "" {
+		fmt.Fprintf(&b, "\n%sError%s:\n", bold, bold)
+		writeSummaryBlock(&b, failureMessage(run.Error), markdown)
+	}
+	return b.String()
+}
+
+// writeSummaryBlock пишет текст ошибки: в markdown - блоком кода, иначе с отступом
+func writeSummaryBlock(b *strings.Builder, text string, markdown bool) {
+	text = strings.TrimRight(text, "\n")
+	if markdown {
+		fmt.Fprintf(b, "```\n%s\n```\n", strings.ReplaceAll(text, "```", "'''"))
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(b, "    %s\n", line)
+	}
+}
+
+// formatTags - метки в виде "env=prod, role=web"
+func formatTags(tags JSONMap) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
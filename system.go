@@ -0,0 +1,64 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+var startedAt = time.Now()
+
+type SystemStatusResponse struct {
+	Status    string           `json:"status"`
+	ReadOnly  bool             `json:"read_only"`
+	StartedAt time.Time        `json:"started_at"`
+	Runs      dispatcherStatus `json:"runs"`
+	Disk      []DiskUsage      `json:"disk"`
+	Database  BreakerStatus    `json:"database"`
+}
+
+func systemState() string {
+	if breaker.Degraded() {
+		return "degraded"
+	}
+	if runQueue.Status().Draining {
+		return "draining"
+	}
+	return "ready"
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	state := systemState()
+	if state != "ready" {
+		http.Error(w, state, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte(state))
+}
+
+func systemStatusHandler(w http.ResponseWriter, r *http.Request) {
+	response := SystemStatusResponse{
+		Status:    systemState(),
+		ReadOnly:  readOnly.Load(),
+		StartedAt: startedAt,
+		Runs:      runQueue.Status(),
+		Disk:      diskUsage(),
+		Database:  breaker.Status(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	runQueue.Drain()
+	log.Printf("Drain mode enabled, new runs will not be dequeued")
+	systemStatusHandler(w, r)
+}
+
+func resumeHandler(w http.ResponseWriter, r *http.Request) {
+	runQueue.Resume()
+	log.Printf("Drain mode disabled, resuming run execution")
+	systemStatusHandler(w, r)
+}
@@ -0,0 +1,174 @@
+package ansibleapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+const defaultTenantName = "default"
+
+// tenant - арендатор со своей схемой БД и каталогом playbooks. Данные
+// арендаторов не пересекаются: запросы к моделям направляются в схему
+// арендатора из контекста (см. registerTenantCallbacks).
+type tenant struct {
+	Name         string
+	Schema       string
+	PlaybooksDir string
+}
+
+var (
+	tenants = make(map[string]*tenant)
+	// Порядок обхода арендаторов фоновыми задачами, арендатор по умолчанию первый
+	tenantList []*tenant
+)
+
+var schemaNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// loadTenants строит список арендаторов из конфигурации
+func loadTenants() error {
+	list := []*tenant{{Name: defaultTenantName, Schema: cfg.Database.Schema, PlaybooksDir: cfg.Server.PlaybooksDir}}
+	for _, t := range cfg.Tenants {
+		if t.Name == "" || t.Name == defaultTenantName {
+			return fmt.Errorf("invalid tenant name %q", t.Name)
+		}
+		schema := t.Schema
+		if schema == "" {
+			schema = cfg.Database.Schema + "_" + strings.ToLower(strings.ReplaceAll(t.Name, "-", "_"))
+		}
+		dir := t.PlaybooksDir
+		if dir == "" {
+			dir = filepath.Join(cfg.Server.PlaybooksDir, t.Name)
+		}
+		list = append(list, &tenant{Name: t.Name, Schema: schema, PlaybooksDir: dir})
+	}
+
+	schemas := make(map[string]bool)
+	for _, t := range list {
+		if !schemaNameRe.MatchString(t.Schema) {
+			return fmt.Errorf("tenant %s: invalid schema name %q", t.Name, t.Schema)
+		}
+		if tenants[t.Name] != nil || schemas[t.Schema] {
+			return fmt.Errorf("tenant %s: duplicate name or schema", t.Name)
+		}
+		tenants[t.Name] = t
+		schemas[t.Schema] = true
+	}
+	tenantList = list
+
+	for _, token := range cfg.Auth.Tokens {
+		if token.Tenant != "" && tenants[token.Tenant] == nil {
+			return fmt.Errorf("token %s refers to unknown tenant %q", token.Name, token.Tenant)
+		}
+	}
+	return nil
+}
+
+func defaultTenant() *tenant {
+	return tenants[defaultTenantName]
+}
+
+type tenantKey struct{}
+
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// tenantFrom возвращает арендатора из контекста или арендатора по умолчанию
+func tenantFrom(ctx context.Context) *tenant {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok {
+		return t
+	}
+	return defaultTenant()
+}
+
+func tenantOf(r *http.Request) *tenant {
+	return tenantFrom(r.Context())
+}
+
+// db возвращает сессию, запросы которой идут в схему арендатора
+func (t *tenant) db() *gorm.DB {
+	return db.WithContext(withTenant(context.Background(), t))
+}
+
+// primaryDB - то же, что db, но с чтением с основной БД
+func (t *tenant) primaryDB() *gorm.DB {
+	return primaryDB().WithContext(withTenant(context.Background(), t))
+}
+
+// table возвращает полное имя таблицы арендатора для сырого SQL
+func (t *tenant) table(name string) string {
+	return t.Schema + "." + name
+}
+
+// forEachTenant выполняет фоновую задачу для каждого арендатора по очереди
+func forEachTenant(fn func(t *tenant)) {
+	for _, t := range tenantList {
+		fn(t)
+	}
+}
+
+// С какого арендатора начинать поиск следующего запуска в очереди
+var claimCursor atomic.Uint64
+
+// tenantsRoundRobin возвращает арендаторов, начиная со следующего после
+// предыдущего вызова, чтобы очередь одного арендатора не задерживала остальных
+func tenantsRoundRobin() []*tenant {
+	start := int(claimCursor.Add(1) % uint64(len(tenantList)))
+	return append(append([]*tenant{}, tenantList[start:]...), tenantList[:start]...)
+}
+
+// tenantMiddleware определяет арендатора по токену запроса. Администратор
+// выбирает арендатора заголовком X-Tenant.
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := currentPrincipal(r)
+		name := p.Tenant
+		if p.Admin && r.Header.Get("X-Tenant") != "" {
+			name = r.Header.Get("X-Tenant")
+		}
+		t := defaultTenant()
+		if name != "" {
+			if t = tenants[name]; t == nil {
+				http.Error(w, "Unknown tenant", http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), t)))
+	})
+}
+
+// registerTenantCallbacks перенаправляет запросы к моделям из схемы по
+// умолчанию (TablePrefix) в схему арендатора из контекста запроса
+func registerTenantCallbacks(gdb *gorm.DB) error {
+	rewrite := func(tx *gorm.DB) {
+		t, ok := tx.Statement.Context.Value(tenantKey{}).(*tenant)
+		if !ok || t.Schema == cfg.Database.Schema {
+			return
+		}
+		prefix := cfg.Database.Schema + "."
+		if strings.HasPrefix(tx.Statement.Table, prefix) {
+			tx.Statement.Table = t.table(strings.TrimPrefix(tx.Statement.Table, prefix))
+		}
+	}
+
+	if err := gdb.Callback().Create().Before("gorm:create").Register("tenant:create", rewrite); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Query().Before("gorm:query").Register("tenant:query", rewrite); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Update().Before("gorm:update").Register("tenant:update", rewrite); err != nil {
+		return err
+	}
+	if err := gdb.Callback().Delete().Before("gorm:delete").Register("tenant:delete", rewrite); err != nil {
+		return err
+	}
+	return gdb.Callback().Row().Before("gorm:row").Register("tenant:row", rewrite)
+}
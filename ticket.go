@@ -0,0 +1,29 @@
+package ansibleapi
+
+import (
+	"errors"
+	"net/url"
+)
+
+// TicketRef - ссылка на заявку в системе управления изменениями
+type TicketRef struct {
+	System string `gorm:"type:text" json:"system"`
+	ID     string `gorm:"type:text" json:"id"`
+	URL    string `gorm:"type:text" json:"url,omitempty"`
+}
+
+func (t *TicketRef) validate() error {
+	if t == nil {
+		return nil
+	}
+	if t.System == "" || t.ID == "" {
+		return errors.New("ticket system and id are required")
+	}
+	if t.URL != "" {
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("ticket url must be an absolute http(s) URL")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"os"
+)
+
+// runUsage - ресурсы, израсходованные ansible-playbook вместе с дочерними
+// процессами (python, ssh), по rusage завершившегося процесса
+type runUsage struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// Наибольший RSS одного процесса в КиБ; 0 - платформа его не сообщает
+	MaxRSSKB int64 `json:"max_rss_kb,omitempty"`
+}
+
+func processUsage(state *os.ProcessState) runUsage {
+	return runUsage{
+		CPUSeconds: (state.UserTime() + state.SystemTime()).Seconds(),
+		MaxRSSKB:   maxRSSKB(state),
+	}
+}
+
+// recordRunUsage сохраняет ресурсы, израсходованные запуском
+func recordRunUsage(t *tenant, runID uint, usage runUsage) {
+	updates := map[string]interface{}{"cpu_seconds": usage.CPUSeconds}
+	if usage.MaxRSSKB > 0 {
+		updates["max_rss_kb"] = usage.MaxRSSKB
+	}
+	persist(fmt.Sprintf("record resource usage of run %d", runID), func() error {
+		return t.db().Model(&PlaybookRun{}).Where("id = ?", runID).Updates(updates).Error
+	})
+}
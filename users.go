@@ -0,0 +1,296 @@
+package ansibleapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Параметры хеша паролей PBKDF2-HMAC-SHA256
+const (
+	passwordIterations = 210000
+	passwordSaltBytes  = 16
+	passwordMinLength  = 8
+)
+
+var usernameRe = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// User - учетная запись для входа через POST /api/auth/login. Пользователи
+// общие для всех арендаторов и хранятся в схеме арендатора по умолчанию.
+type User struct {
+	ID           uint   `gorm:"primaryKey" json:"-"`
+	Username     string `gorm:"type:text;not null;uniqueIndex" json:"username"`
+	PasswordHash string `gorm:"type:text;not null" json:"-"`
+	Team         string `gorm:"type:text" json:"team,omitempty"`
+	// Арендатор, к данным которого дает доступ вход; пустой - арендатор по умолчанию
+	Tenant string `gorm:"type:text" json:"tenant,omitempty"`
+	// Права администратора, как у X-Admin-Token
+	Admin       bool       `gorm:"not null;default:false" json:"admin"`
+	Disabled    bool       `gorm:"not null;default:false" json:"disabled"`
+	LastLoginAt *time.Time `gorm:"type:timestamptz" json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"type:timestamptz;not null" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"type:timestamptz;not null" json:"updated_at"`
+}
+
+// UserRequest - создание или изменение пользователя; при изменении пустые поля не меняются
+type UserRequest struct {
+	Username string  `json:"username"`
+	Password string  `json:"password"`
+	Team     *string `json:"team"`
+	Tenant   *string `json:"tenant"`
+	Admin    *bool   `json:"admin"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// LoginResponse - выданный JWT
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// hashPassword - pbkdf2-sha256$<итерации>$<соль>$<хеш> в base64
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(pbkdf2SHA256([]byte(password), salt, iterations), expected)
+}
+
+// pbkdf2SHA256 - PBKDF2 (RFC 8018) с HMAC-SHA256 и ключом длиной в один блок
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// Хеш для сравнения с паролем несуществующего пользователя, чтобы время ответа
+// не выдавало, есть ли такой пользователь
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("ansible-api")
+	return hash
+})
+
+// loginHandler проверяет имя и пароль и выдает JWT на auth.jwt_ttl
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !jwtEnabled() {
+		http.Error(w, "Login is disabled: auth.jwt_secret is not set", http.StatusNotImplemented)
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	var user User
+	err := defaultTenant().db().Where("username = ?", req.Username).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		writeDBError(w, err)
+		return
+	}
+	hash := user.PasswordHash
+	if user.ID == 0 {
+		hash = dummyPasswordHash()
+	}
+	if !checkPassword(hash, req.Password) || user.ID == 0 || user.Disabled {
+		log.Printf("Failed login of user %q from %s", req.Username, clientIP(r))
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(cfg.Auth.JWTTTL).Truncate(time.Second)
+	token, err := signJWT(userClaims{
+		Subject:   user.Username,
+		Team:      user.Team,
+		Tenant:    user.Tenant,
+		Admin:     user.Admin,
+		Issuer:    jwtIssuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Вход возможен и в режиме только для чтения, время входа тогда не сохраняется
+	if err := defaultTenant().db().Model(&user).Update("last_login_at", now).Error; err != nil {
+		log.Printf("Failed to record login of user %s: %v", user.Username, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResponse{Token: token, TokenType: "Bearer", ExpiresAt: expires.UTC()})
+}
+
+func listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users := []User{}
+	if err := defaultTenant().db().Order("username").Find(&users).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// applyUserRequest переносит в пользователя заданные поля запроса
+func applyUserRequest(user *User, req UserRequest) error {
+	if req.Password != "" {
+		if len(req.Password) < passwordMinLength {
+			return fmt.Errorf("password must be at least %d characters", passwordMinLength)
+		}
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	}
+	if req.Team != nil {
+		user.Team = *req.Team
+	}
+	if req.Tenant != nil {
+		if *req.Tenant != "" && tenants[*req.Tenant] == nil {
+			return fmt.Errorf("unknown tenant %q", *req.Tenant)
+		}
+		user.Tenant = *req.Tenant
+	}
+	if req.Admin != nil {
+		user.Admin = *req.Admin
+	}
+	if req.Disabled != nil {
+		user.Disabled = *req.Disabled
+	}
+	return nil
+}
+
+func createUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req UserRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !usernameRe.MatchString(req.Username) {
+		http.Error(w, "username must be 1-64 letters, digits and ._@-", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		http.Error(w, "password is required", http.StatusBadRequest)
+		return
+	}
+	user := User{Username: req.Username}
+	if err := applyUserRequest(&user, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := defaultTenant().db().Where("username = ?", user.Username).FirstOrCreate(&user)
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("User %s created by %s", user.Username, currentPrincipal(r).Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// updateUserHandler меняет пароль, команду, арендатора и права пользователя.
+// Выданные токены действуют со старыми правами до окончания срока.
+func updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var req UserRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	var user User
+	if err := defaultTenant().db().Where("username = ?", mux.Vars(r)["name"]).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, err)
+		}
+		return
+	}
+	if err := applyUserRequest(&user, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := defaultTenant().db().Save(&user).Error; err != nil {
+		writeDBError(w, err)
+		return
+	}
+	log.Printf("User %s updated by %s", user.Username, currentPrincipal(r).Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	result := defaultTenant().db().Where("username = ?", name).Delete(&User{})
+	if result.Error != nil {
+		writeDBError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("User %s deleted by %s", name, currentPrincipal(r).Name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,200 @@
+package ansibleapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Как часто проверять статус запуска при wait=true. Запуск может выполняться
+// на другом узле, поэтому статус читается из основной БД, а не из событий узла.
+const waitPollInterval = time.Second
+
+// waitRoute - маршрут, обработчик которого при wait=true ждет дольше
+// handler_timeout: вместо TimeoutHandler время ожидания ограничивает server.max_wait
+func waitRoute(next http.HandlerFunc) http.HandlerFunc {
+	standard := standardRoute(next)
+	waiting := withLimits(next, cfg.Server.MaxBodyBytes, 0)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+			waiting(w, r)
+			return
+		}
+		standard(w, r)
+	}
+}
+
+// parseMaxWait разбирает wait и max_wait запроса. 0 - ждать не нужно.
+// max_wait по умолчанию и наибольший - server.max_wait.
+func parseMaxWait(r *http.Request) (time.Duration, bool) {
+	query := r.URL.Query()
+	if wait, _ := strconv.ParseBool(query.Get("wait")); !wait {
+		return 0, true
+	}
+	maxWait := cfg.Server.MaxWait
+	if value := query.Get("max_wait"); value != "" {
+		seconds, ok := parseDurationParam(value)
+		if !ok || seconds <= 0 {
+			return 0, false
+		}
+		maxWait = min(time.Duration(seconds*float64(time.Second)), cfg.Server.MaxWait)
+	}
+	return maxWait, true
+}
+
+// waitForRun ждет завершения запуска не дольше maxWait и отвечает его деталями.
+// Если запуск не завершился (или клиент отключился), отвечает 202 с run_id.
+func waitForRun(w http.ResponseWriter, r *http.Request, t *tenant, run PlaybookRun, maxWait time.Duration) {
+	// Ответ пишется после ожидания: общий write_timeout сервера его бы оборвал
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(maxWait + cfg.Server.WriteTimeout))
+
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "accepted",
+				"message": "playbook is still running",
+				"run_id":  run.ID,
+			})
+			return
+		case <-ticker.C:
+		}
+
+		var status PlaybookRunStatus
+		if err := t.primaryDB().Model(&PlaybookRun{}).Where("id = ?", run.ID).Pluck("status", &status).Error; err != nil {
+			writeDBError(w, err)
+			return
+		}
+		if status == RunStatusQueued || status == RunStatusStarted {
+			continue
+		}
+
+		var finished PlaybookRun
+		if err := t.primaryDB().First(&finished, run.ID).Error; err != nil {
+			writeDBError(w, err)
+			return
+		}
+		finished.fillProgress()
+		finished.FailedTasks = parseFailedTasks(finished.Output)
+		finished.fillHostMetadata(t)
+		if run.Warning != "" {
+			setDeprecationHeaders(w, run.Warning)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(finished)
+		return
+	}
+}
+
+// wantsOutputStream - клиент с wait=true просит вывод потоком: Accept: text/plain
+func wantsOutputStream(r *http.Request) bool {
+	if !cfg.Server.StreamOutput {
+		return false
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/plain" {
+			return true
+		}
+	}
+	return false
+}
+
+// streamRunOutput отдает вывод запуска по мере выполнения, как при локальном
+// ansible-playbook в логах CI. Статус ответа отправлен до завершения, поэтому
+// итог - в последней строке и в трейлере X-Run-Status; X-Run-ID - в заголовке.
+func streamRunOutput(w http.ResponseWriter, r *http.Request, t *tenant, run PlaybookRun, maxWait time.Duration) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(maxWait + cfg.Server.WriteTimeout))
+
+	if run.Warning != "" {
+		setDeprecationHeaders(w, run.Warning)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Прокси вроде nginx не должны копить поток целиком
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("X-Run-ID", strconv.FormatUint(uint64(run.ID), 10))
+	w.Header().Set("Trailer", "X-Run-Status")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	var seq int
+	// streamLines дописывает строки, сохраненные после уже отправленных
+	streamLines := func() bool {
+		var chunks []RunOutputChunk
+		if err := t.primaryDB().Where("run_id = ? AND seq > ?", run.ID, seq).Order("seq").Find(&chunks).Error; err != nil {
+			fmt.Fprintf(w, "ansible-api: failed to read output: %v\n", err)
+			return false
+		}
+		for _, chunk := range chunks {
+			fmt.Fprintln(w, chunk.Line)
+			seq = chunk.Seq
+		}
+		if len(chunks) > 0 {
+			rc.Flush()
+		}
+		return true
+	}
+	finish := func(status PlaybookRunStatus, message string) {
+		fmt.Fprintf(w, "\nansible-api: %s\n", message)
+		if status != "" {
+			w.Header().Set("X-Run-Status", string(status))
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			streamLines()
+			finish(RunStatusStarted, fmt.Sprintf("run %d is still running, see GET /api/runs/%d", run.ID, run.ID))
+			return
+		case <-ticker.C:
+		}
+
+		if !streamLines() {
+			finish("", fmt.Sprintf("output interrupted, see GET /api/runs/%d", run.ID))
+			return
+		}
+		var finished PlaybookRun
+		if err := t.primaryDB().Select("id", "status", "error", "output").First(&finished, run.ID).Error; err != nil {
+			finish("", fmt.Sprintf("failed to read run status: %v, see GET /api/runs/%d", err, run.ID))
+			return
+		}
+		if finished.Status == RunStatusQueued || finished.Status == RunStatusStarted {
+			continue
+		}
+
+		// Строки, сохраненные между чтением вывода и статуса; запуск, не
+		// дошедший до ansible, вывода по строкам не имеет
+		streamLines()
+		if seq == 0 && finished.Output != "" {
+			fmt.Fprint(w, strings.TrimRight(finished.Output, "\n")+"\n")
+		}
+		message := fmt.Sprintf("run %d %s", run.ID, finished.Status)
+		if finished.Error != "" {
+			message += ": " + finished.Error
+		}
+		finish(finished.Status, message)
+		return
+	}
+}
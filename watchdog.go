@@ -0,0 +1,114 @@
+package ansibleapi
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// startHeartbeat периодически обновляет heartbeat_at запуска, пока не закрыт stop
+func startHeartbeat(t *tenant, runID uint) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cfg.Watchdog.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := t.db().Model(&PlaybookRun{}).Where("id = ?", runID).Update("heartbeat_at", now).Error; err != nil {
+					log.Printf("Failed to update heartbeat for run %d: %v", runID, err)
+				}
+
+				var cancelRequested bool
+				if err := t.primaryDB().Model(&PlaybookRun{}).Where("id = ?", runID).Pluck("cancel_requested", &cancelRequested).Error; err == nil && cancelRequested {
+					cancelActiveRun(t, runID)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// detectLostRuns помечает как lost выполняющиеся запуски, чей heartbeat устарел
+func detectLostRuns() {
+	forEachTenant(detectTenantLostRuns)
+}
+
+func detectTenantLostRuns(t *tenant) {
+	staleBefore := time.Now().Add(-cfg.Watchdog.StaleAfter)
+
+	var runs []PlaybookRun
+	if err := t.primaryDB().Where("status = ? AND (heartbeat_at < ? OR (heartbeat_at IS NULL AND start_time < ?))",
+		RunStatusStarted, staleBefore, staleBefore).Find(&runs).Error; err != nil {
+		log.Printf("Failed to look up stale runs: %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		errorMsg := fmt.Sprintf("run lost: no heartbeat from node %s since %s", run.NodeID, lastHeartbeat(run).Format(time.RFC3339))
+
+		if cfg.Watchdog.KillOrphans && run.NodeID == cfg.Server.NodeID {
+			if err := killOrphan(run); err != nil {
+				log.Printf("Failed to kill orphaned process of run %d: %v", run.ID, err)
+			} else if run.PGID > 0 || run.PID > 0 {
+				errorMsg += "; orphaned processes killed"
+			}
+		}
+
+		endTime := time.Now()
+		result := t.db().Model(&PlaybookRun{}).
+			Where("id = ? AND status = ?", run.ID, RunStatusStarted).
+			Updates(map[string]interface{}{
+				"status":   RunStatusLost,
+				"error":    errorMsg,
+				"end_time": endTime,
+				"duration": endTime.Sub(run.StartTime).Seconds(),
+			})
+		if result.Error != nil {
+			log.Printf("Failed to mark run %d as lost: %v", run.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		if err := completeQueueEntry(t, run.ID); err != nil {
+			log.Printf("Failed to release queue entry of run %d: %v", run.ID, err)
+		}
+
+		run.Status = RunStatusLost
+		notify(runNotification(t, "run.lost", run, errorMsg))
+		publishRunEvent(t, "run.lost", run, errorMsg)
+		advanceRollout(t, run.ID, RunStatusLost, "")
+	}
+}
+
+func lastHeartbeat(run PlaybookRun) time.Time {
+	if run.HeartbeatAt != nil {
+		return *run.HeartbeatAt
+	}
+	return run.StartTime
+}
+
+// killOrphan убивает группу процессов потерянного запуска, а для старых записей без PGID - сам процесс.
+// Процесс с тем же PID, но другим временем старта не трогается.
+func killOrphan(run PlaybookRun) error {
+	pid := run.PGID
+	if pid <= 0 {
+		pid = run.PID
+	}
+	if run.ProcessStartedAt != nil && processAlive(pid) {
+		started, ok := processStartTime(pid)
+		if !ok || started.Sub(*run.ProcessStartedAt).Abs() >= time.Second {
+			return nil
+		}
+	}
+	if run.PGID > 0 && processGroupAlive(run.PGID) {
+		return killProcessGroup(run.PGID)
+	}
+	if processAlive(run.PID) {
+		return killProcess(run.PID)
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package ansibleapi
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Переменная, через которую playbook узнает свой рабочий каталог
+const workDirVar = "ansible_api_work_dir"
+
+const (
+	workDirsJob       = "work_dirs"
+	workDirArchiveExt = ".tar.zst"
+	// Префикс недописанного архива: под итоговым именем архив появляется целиком
+	workDirPartialPrefix = ".partial-"
+)
+
+// runWorkDir - рабочий каталог запуска: <disk.work_dir>/<арендатор>/<id>
+func runWorkDir(t *tenant, runID uint) string {
+	return filepath.Join(cfg.Disk.WorkDir, t.Name, strconv.FormatUint(uint64(runID), 10))
+}
+
+// prepareWorkDir создает рабочий каталог запуска и передает его playbook через
+// extra_vars; туда же пишется журнал ansible (ANSIBLE_LOG_PATH). Каталог есть
+// только у запусков на этом сервере без execution environment: узлу SSH и
+// контейнеру он недоступен.
+func prepareWorkDir(job *runJob, inst *ansibleInstallation, runner *remoteRunner) (string, error) {
+	if cfg.Disk.WorkDir == "" || runner != nil || inst.Image != "" {
+		return "", nil
+	}
+	dir := runWorkDir(job.Tenant, job.RunID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create run work directory: %v", err)
+	}
+
+	vars := make(map[string]string, len(job.Request.ExtraVars)+1)
+	for k, v := range job.Request.ExtraVars {
+		vars[k] = v
+	}
+	vars[workDirVar] = dir
+	job.Request.ExtraVars = vars
+	return dir, nil
+}
+
+// archiveWorkDirs сжимает рабочие каталоги запусков, завершенных раньше
+// disk.work_dir_compress_after, в <id>.tar.zst и удаляет каталоги и архивы
+// запусков, завершенных раньше disk.work_dir_retention или удаленных из истории
+func archiveWorkDirs() {
+	if cfg.Disk.WorkDir == "" {
+		return
+	}
+	forEachTenant(archiveTenantWorkDirs)
+}
+
+func archiveTenantWorkDirs(t *tenant) {
+	startedAt := time.Now()
+	root := filepath.Join(cfg.Disk.WorkDir, t.Name)
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list work directories of tenant %s: %v", t.Name, err)
+		return
+	}
+
+	// Каталоги и архивы по id запуска
+	dirs := make(map[uint]string)
+	archives := make(map[uint]string)
+	var ids []uint
+	counts := make(CleanupCounts)
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(root, name)
+		if strings.HasPrefix(name, workDirPartialPrefix) {
+			// Архив, недописанный из-за сбоя
+			if info, err := entry.Info(); err == nil && info.ModTime().Before(startedAt.Add(-time.Hour)) {
+				os.Remove(path)
+			}
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, workDirArchiveExt), 10, 0)
+		if err != nil {
+			continue
+		}
+		if entry.IsDir() {
+			dirs[uint(id)] = path
+		} else if strings.HasSuffix(name, workDirArchiveExt) {
+			archives[uint(id)] = path
+		} else {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+
+	ended, err := runEndTimes(t, ids)
+	if err != nil {
+		log.Printf("Failed to load runs of work directories of tenant %s: %v", t.Name, err)
+		return
+	}
+	// Каталог и архив удаляются вместе с запуском или по истечении хранения
+	expired := func(id uint) bool {
+		end, ok := ended[id]
+		return !ok || end != nil && end.Before(startedAt.Add(-cfg.Disk.WorkDirRetention))
+	}
+	remove := func(kind, path string) {
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Failed to remove %s: %v", path, err)
+			errs = append(errs, err)
+			return
+		}
+		counts[kind]++
+	}
+
+	for id, path := range archives {
+		if expired(id) {
+			remove("archives_removed", path)
+		}
+	}
+	for id, path := range dirs {
+		end, ok := ended[id]
+		switch {
+		case expired(id):
+			remove("dirs_removed", path)
+		case !ok || end == nil || end.After(startedAt.Add(-cfg.Disk.WorkDirCompressAfter)):
+			// Запуск еще выполняется или завершился недавно
+		default:
+			if err := compressWorkDir(path, path+workDirArchiveExt); err != nil {
+				log.Printf("Failed to compress work directory %s: %v", path, err)
+				errs = append(errs, err)
+				continue
+			}
+			counts["dirs_compressed"]++
+			remove("dirs_removed", path)
+		}
+	}
+	updateWorkDirMetrics(t, root)
+
+	finishedAt := time.Now()
+	record := HousekeepingRun{
+		Job:        workDirsJob,
+		NodeID:     cfg.Server.NodeID,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Success:    len(errs) == 0,
+		Deleted:    counts,
+	}
+	if err := errors.Join(errs...); err != nil {
+		record.Error = err.Error()
+	}
+	if len(counts) == 0 && record.Success {
+		return
+	}
+	if err := t.db().Create(&record).Error; err != nil {
+		log.Printf("Failed to record work directory archiving: %v", err)
+	}
+	log.Printf("Work directories of tenant %s archived in %s: %s", t.Name, finishedAt.Sub(startedAt).Round(time.Millisecond), counts)
+}
+
+// runEndTimes возвращает время завершения запусков с данными id; у
+// выполняющихся и стоящих в очереди оно nil, удаленных запусков в ответе нет
+func runEndTimes(t *tenant, ids []uint) (map[uint]*time.Time, error) {
+	ended := make(map[uint]*time.Time, len(ids))
+	if len(ids) == 0 {
+		return ended, nil
+	}
+	var runs []PlaybookRun
+	// С основной БД: запуск, только что созданный на реплике, не должен считаться удаленным
+	if err := t.primaryDB().Select("id", "status", "end_time", "updated_at").Where("id IN ?", ids).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		switch {
+		case run.Status == RunStatusQueued || run.Status == RunStatusStarted:
+			ended[run.ID] = nil
+		case run.EndTime != nil:
+			ended[run.ID] = run.EndTime
+		default:
+			// lost и прочие итоги без времени окончания
+			updatedAt := run.UpdatedAt
+			ended[run.ID] = &updatedAt
+		}
+	}
+	return ended, nil
+}
+
+// compressWorkDir упаковывает каталог в архив tar.zst. Архив пишется во
+// временный файл рядом и переименовывается, когда записан целиком.
+func compressWorkDir(dir, archive string) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(archive), workDirPartialPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	zw, err := zstd.NewWriter(tmp)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case !info.IsDir() && !info.Mode().IsRegular():
+			// Сокеты и каналы в архив не попадают
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		tw.Close()
+		zw.Close()
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), archive)
+}
+
+var (
+	workDirsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ansible_api_work_dirs",
+		Help: "Run work directories in disk.work_dir by state (dir or archive), as of the last archiving pass.",
+	}, []string{"tenant", "state"})
+	workDirsBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ansible_api_work_dirs_bytes",
+		Help: "Disk usage of run work directories in disk.work_dir by state (dir or archive), as of the last archiving pass.",
+	}, []string{"tenant", "state"})
+)
+
+// updateWorkDirMetrics пересчитывает число и размер каталогов и архивов арендатора
+func updateWorkDirMetrics(t *tenant, root string) {
+	count := map[string]float64{"dir": 0, "archive": 0}
+	size := map[string]float64{"dir": 0, "archive": 0}
+	entries, _ := os.ReadDir(root)
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		switch {
+		case entry.IsDir():
+			count["dir"]++
+			filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+				if err == nil && d.Type().IsRegular() {
+					if info, err := d.Info(); err == nil {
+						size["dir"] += float64(info.Size())
+					}
+				}
+				return nil
+			})
+		case strings.HasSuffix(entry.Name(), workDirArchiveExt):
+			count["archive"]++
+			if info, err := entry.Info(); err == nil {
+				size["archive"] += float64(info.Size())
+			}
+		}
+	}
+	for state := range count {
+		workDirsGauge.WithLabelValues(t.Name, state).Set(count[state])
+		workDirsBytes.WithLabelValues(t.Name, state).Set(size[state])
+	}
+}
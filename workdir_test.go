@@ -0,0 +1,72 @@
+package ansibleapi
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressWorkDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "42")
+	if err := os.MkdirAll(filepath.Join(dir, "reports"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"ansible.log":        "PLAY [all]\n",
+		"reports/hosts.json": `{"web1": "ok"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := dir + workDirArchiveExt
+	if err := compressWorkDir(dir, archive); err != nil {
+		t.Fatal(err)
+	}
+	if partial, _ := filepath.Glob(filepath.Join(root, workDirPartialPrefix+"*")); len(partial) > 0 {
+		t.Errorf("partial archives left: %v", partial)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	got := make(map[string]string)
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[header.Name] = string(content)
+	}
+	if _, ok := got["reports/"]; !ok {
+		t.Errorf("directory entry missing, got %v", got)
+	}
+	for name, content := range files {
+		if got[name] != content {
+			t.Errorf("%s: got %q, want %q", name, got[name], content)
+		}
+	}
+}